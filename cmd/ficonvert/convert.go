@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/transform"
+)
+
+const (
+	statusConverted = "converted"
+	statusPlanned   = "planned"
+	statusSkipped   = "skipped"
+	statusFailed    = "failed"
+)
+
+type options struct {
+	OutDir    string
+	Template  string
+	Format    freeimage.FREE_IMAGE_FORMAT
+	Spec      *transform.Spec
+	LoadFlags int32
+	SaveFlags int32
+	Strip     bool
+	DryRun    bool
+	Resume    bool
+}
+
+type job struct {
+	Input  string
+	Rel    string // input path relative to the walked directory
+	Output string
+}
+
+type result struct {
+	Input       string  `json:"input"`
+	Output      string  `json:"output,omitempty"`
+	Status      string  `json:"status"`
+	InputFormat string  `json:"input_format,omitempty"`
+	Width       int32   `json:"width,omitempty"`
+	Height      int32   `json:"height,omitempty"`
+	Bytes       int64   `json:"bytes,omitempty"`
+	Seconds     float64 `json:"seconds,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type summary struct {
+	Started   time.Time `json:"started"`
+	Seconds   float64   `json:"seconds"`
+	Format    string    `json:"format"`
+	Total     int       `json:"total"`
+	Converted int       `json:"converted"`
+	Skipped   int       `json:"skipped"`
+	Failed    int       `json:"failed"`
+	Files     []*result `json:"files"`
+}
+
+// plan expands the inputs into jobs with their output names. Directories are
+// walked recursively, anything else is a glob pattern.
+func plan(inputs []string, opts *options) ([]*job, error) {
+	var jobs []*job
+	for _, in := range inputs {
+		if st, err := os.Stat(in); err == nil && st.IsDir() {
+			err := filepath.WalkDir(in, func(p string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, _ := filepath.Rel(in, p)
+				jobs = append(jobs, &job{Input: p, Rel: rel})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		matches, err := filepath.Glob(in)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", in, err)
+		}
+		if matches == nil {
+			return nil, fmt.Errorf("%s: no such file or directory", in)
+		}
+		for _, m := range matches {
+			if st, err := os.Stat(m); err == nil && !st.IsDir() {
+				jobs = append(jobs, &job{Input: m, Rel: filepath.Base(m)})
+			}
+		}
+	}
+
+	ext := preferredExtension(opts.Format)
+	for _, j := range jobs {
+		j.Output = filepath.Join(opts.OutDir, outputName(opts.Template, j.Rel, ext, freeimage.GetFormatFromFIF(opts.Format)))
+	}
+	return jobs, nil
+}
+
+func preferredExtension(fif freeimage.FREE_IMAGE_FORMAT) string {
+	ext, _, _ := strings.Cut(freeimage.GetFIFExtensionList(fif), ",")
+	return ext
+}
+
+func outputName(template, rel, ext, format string) string {
+	dir, base := filepath.Split(rel)
+	return strings.NewReplacer(
+		"{dir}", filepath.Clean(dir),
+		"{name}", strings.TrimSuffix(base, filepath.Ext(base)),
+		"{ext}", ext,
+		"{format}", strings.ToLower(format),
+	).Replace(template)
+}
+
+// runJobs converts the jobs on n workers. Results are in job order; two
+// inputs mapping to the same output are both failed rather than overwriting
+// each other.
+func runJobs(jobs []*job, opts *options, n int) []*result {
+	results := make([]*result, len(jobs))
+	owner := map[string]int{}
+	for i, j := range jobs {
+		if k, dup := owner[j.Output]; dup {
+			results[i] = &result{Input: j.Input, Output: j.Output, Status: statusFailed, Error: "output name collides with " + jobs[k].Input}
+			continue
+		}
+		owner[j.Output] = i
+	}
+
+	if n < 1 {
+		n = 1
+	}
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				results[i] = convert(jobs[i], opts)
+			}
+		}()
+	}
+	for i := range jobs {
+		if results[i] == nil {
+			idx <- i
+		}
+	}
+	close(idx)
+	wg.Wait()
+	return results
+}
+
+func convert(j *job, opts *options) *result {
+	start := time.Now()
+	r := &result{Input: j.Input, Output: j.Output}
+	err := convertFile(j, opts, r)
+	r.Seconds = time.Since(start).Seconds()
+	switch {
+	case errors.Is(err, errSkip):
+		r.Status = statusSkipped
+		r.Error = err.Error()
+	case err != nil:
+		r.Status = statusFailed
+		r.Error = err.Error()
+	case opts.DryRun:
+		r.Status = statusPlanned
+	default:
+		r.Status = statusConverted
+	}
+	return r
+}
+
+var errSkip = errors.New("skipped")
+
+func convertFile(j *job, opts *options, r *result) error {
+	fif := freeimage.GetFileType(j.Input, 0)
+	if fif == freeimage.FIF_UNKNOWN {
+		fif = freeimage.GetFIFFromFilename(j.Input)
+	}
+	if fif == freeimage.FIF_UNKNOWN || !freeimage.FIFSupportsReading(fif) {
+		r.Output = ""
+		return fmt.Errorf("%w: not a readable image", errSkip)
+	}
+	r.InputFormat = freeimage.GetFormatFromFIF(fif)
+
+	if opts.Resume {
+		if st, err := os.Stat(j.Output); err == nil && st.Size() > 0 {
+			return fmt.Errorf("%w: output exists", errSkip)
+		}
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	dib := freeimage.Load(fif, j.Input, opts.LoadFlags)
+	if dib == nil {
+		return errors.New("can't load image")
+	}
+	defer dib.Unload()
+
+	p, err := opts.Spec.Pipeline(int32(dib.GetWidth()), int32(dib.GetHeight()), transform.Limits{})
+	if err != nil {
+		return err
+	}
+	out, err := p.Apply(dib)
+	if err != nil {
+		return err
+	}
+	defer func() { out.Unload() }()
+
+	if conv := exportable(out, opts.Format); conv != out {
+		if conv == nil {
+			return fmt.Errorf("%s can't store this image type", freeimage.GetFormatFromFIF(opts.Format))
+		}
+		out.Unload()
+		out = conv
+	}
+
+	if opts.Strip {
+		stripMetadata(out)
+	} else {
+		dib.CloneMetadataTo(out)
+	}
+	r.Width, r.Height = int32(out.GetWidth()), int32(out.GetHeight())
+
+	if err := os.MkdirAll(filepath.Dir(j.Output), 0o755); err != nil {
+		return err
+	}
+	// save next to the output and rename, so an interrupted run never
+	// leaves a truncated file that -resume would take as done
+	tmp := j.Output + ".tmp"
+	if !out.Save(opts.Format, tmp, opts.SaveFlags) {
+		os.Remove(tmp)
+		return errors.New("saving failed")
+	}
+	if err := os.Rename(tmp, j.Output); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if st, err := os.Stat(j.Output); err == nil {
+		r.Bytes = st.Size()
+	}
+	return nil
+}
+
+// exportable returns a bitmap the fif plugin can write, dib itself if no
+// conversion is needed or nil if no conversion helps.
+func exportable(dib *freeimage.BitMap, fif freeimage.FREE_IMAGE_FORMAT) *freeimage.BitMap {
+	if t := dib.GetImageType(); t != freeimage.FIT_BITMAP {
+		if freeimage.FIFSupportsExportType(fif, t) {
+			return dib
+		}
+		std := dib.ConvertToStandardType(true)
+		if std == nil {
+			return nil
+		}
+		if conv := exportable(std, fif); conv != std {
+			std.Unload()
+			return conv
+		}
+		return std
+	}
+
+	if freeimage.FIFSupportsExportBPP(fif, int32(dib.GetBPP())) {
+		return dib
+	}
+	bpps := []int32{24, 32, 8}
+	if dib.IsTransparent() {
+		bpps = []int32{32, 24, 8}
+	}
+	for _, bpp := range bpps {
+		if !freeimage.FIFSupportsExportBPP(fif, bpp) {
+			continue
+		}
+		switch bpp {
+		case 32:
+			return dib.ConvertTo32Bits()
+		case 24:
+			return dib.ConvertTo24Bits()
+		case 8:
+			return dib.ConvertTo8Bits()
+		}
+	}
+	return nil
+}
+
+func stripMetadata(dib *freeimage.BitMap) {
+	for model := freeimage.FIMD_COMMENTS; model <= freeimage.FIMD_EXIF_RAW; model++ {
+		if model != freeimage.FIMD_ANIMATION {
+			dib.ClearMetadata(model)
+		}
+	}
+	dib.DestroyICCProfile()
+}
+
+func summarize(results []*result, fif freeimage.FREE_IMAGE_FORMAT, start time.Time) *summary {
+	sum := &summary{
+		Started: start,
+		Seconds: time.Since(start).Seconds(),
+		Format:  freeimage.GetFormatFromFIF(fif),
+		Total:   len(results),
+		Files:   results,
+	}
+	for _, r := range results {
+		switch r.Status {
+		case statusConverted:
+			sum.Converted++
+		case statusSkipped:
+			sum.Skipped++
+		case statusFailed:
+			sum.Failed++
+		}
+	}
+	sort.SliceStable(sum.Files, func(i, k int) bool { return sum.Files[i].Input < sum.Files[k].Input })
+	return sum
+}
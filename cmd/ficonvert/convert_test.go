@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+	"github.com/jinzhongmin/gofreeimage/pkg/transform"
+)
+
+func TestOutputName(t *testing.T) {
+	for _, tc := range []struct {
+		template, rel, want string
+	}{
+		{"{dir}/{name}.{ext}", "a/b/cat.tif", "a/b/cat.png"},
+		{"{dir}/{name}.{ext}", "cat.tif", "./cat.png"},
+		{"{name}_{format}.{ext}", "a/cat.tar.gz", "cat.tar_png.png"},
+		{"{dir}/{name}_small.{ext}", "x/noext", "x/noext_small.png"},
+	} {
+		if got := outputName(tc.template, tc.rel, "png", "PNG"); got != tc.want {
+			t.Errorf("outputName(%q, %q) = %q, want %q", tc.template, tc.rel, got, tc.want)
+		}
+	}
+}
+
+func TestBuildSpec(t *testing.T) {
+	s, err := buildSpec("rs:fill:300:300/br:10", "", 85)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != "/rs:fill:300:300/br:10/q:85" {
+		t.Errorf("spec %s", s)
+	}
+	s, err = buildSpec("g:1.2", "800X0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&transform.Resize{Mode: transform.ResizeFit, Width: 800}); !reflect.DeepEqual(s.Resize, want) || s.Gamma != 1.2 {
+		t.Errorf("spec %s", s)
+	}
+	for _, tc := range []struct {
+		spec, resize string
+		quality      int
+	}{
+		{"rs:fit:1:1/cat.jpg", "", 0},
+		{"q:500", "", 0},
+		{"", "800", 0},
+		{"", "axb", 0},
+		{"", "0x0", 0},
+		{"", "", 101},
+	} {
+		if _, err := buildSpec(tc.spec, tc.resize, tc.quality); err == nil {
+			t.Errorf("buildSpec(%q, %q, %d) succeeded", tc.spec, tc.resize, tc.quality)
+		}
+	}
+}
+
+// writeTree writes the files of tree under dir, nil contents are STUB
+// images.
+func writeTree(t *testing.T, dir string, tree map[string][]byte) {
+	t.Helper()
+	for name, data := range tree {
+		if data == nil {
+			data = fitest.Bytes(3, 2, 24, func(x, y, ch int) byte { return byte(x + y + ch) })
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	fitest.NewStub(t)
+	in := t.TempDir()
+	writeTree(t, in, map[string][]byte{"a.stub": nil, "sub/b.stub": nil, "c.txt": []byte("text")})
+	opts := &options{OutDir: "out", Template: "{dir}/{name}.{ext}", Format: fitest.FIF_STUB}
+
+	jobs, err := plan([]string{in, filepath.Join(in, "*.txt")}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []job
+	for _, j := range jobs {
+		got = append(got, *j)
+	}
+	want := []job{
+		{filepath.Join(in, "a.stub"), "a.stub", filepath.Join("out", "a.stub")},
+		{filepath.Join(in, "c.txt"), "c.txt", filepath.Join("out", "c.stub")},
+		{filepath.Join(in, "sub", "b.stub"), filepath.Join("sub", "b.stub"), filepath.Join("out", "sub", "b.stub")},
+		{filepath.Join(in, "c.txt"), "c.txt", filepath.Join("out", "c.stub")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("plan = %+v, want %+v", got, want)
+	}
+
+	if _, err := plan([]string{filepath.Join(in, "missing")}, opts); err == nil {
+		t.Error("plan of a missing input succeeded")
+	}
+	if _, err := plan([]string{"[" + in}, opts); err == nil {
+		t.Error("plan of a bad pattern succeeded")
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	fitest.NewStub(t)
+	in, out := t.TempDir(), t.TempDir()
+	writeTree(t, in, map[string][]byte{"a.stub": nil, "sub/b.stub": nil, "c.txt": []byte("text"), "d.stub": []byte("FIS1")})
+	opts := &options{OutDir: out, Template: "{name}.{ext}", Format: fitest.FIF_STUB, Spec: &transform.Spec{}}
+	jobs, err := plan([]string{in}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a copy of a.stub collides with it
+	jobs = append(jobs, &job{Input: filepath.Join(in, "a.stub"), Rel: "a.stub", Output: jobs[0].Output})
+
+	check := func(results []*result, want map[string]string) {
+		t.Helper()
+		if len(results) != len(jobs) {
+			t.Fatalf("%d results for %d jobs", len(results), len(jobs))
+		}
+		for i, r := range results {
+			if r.Input != jobs[i].Input {
+				t.Errorf("result %d is for %s, want %s", i, r.Input, jobs[i].Input)
+			}
+			key := filepath.Base(r.Input)
+			if i == len(jobs)-1 {
+				key = "dup"
+			}
+			if r.Status != want[key] {
+				t.Errorf("%s: %s (%s), want %s", key, r.Status, r.Error, want[key])
+			}
+		}
+	}
+
+	opts.DryRun = true
+	check(runJobs(jobs, opts, 2), map[string]string{
+		"a.stub": statusPlanned, "b.stub": statusPlanned, "d.stub": statusPlanned,
+		"c.txt": statusSkipped, "dup": statusFailed,
+	})
+	if entries, _ := os.ReadDir(out); len(entries) != 0 {
+		t.Errorf("a dry run wrote %d files", len(entries))
+	}
+
+	opts.DryRun = false
+	results := runJobs(jobs, opts, 2)
+	check(results, map[string]string{
+		"a.stub": statusConverted, "b.stub": statusConverted, "d.stub": statusFailed,
+		"c.txt": statusSkipped, "dup": statusFailed,
+	})
+	if r := results[0]; r.Width != 3 || r.Height != 2 || r.InputFormat != "STUB" || r.Bytes == 0 {
+		t.Errorf("a.stub: %+v", r)
+	}
+	if !strings.Contains(results[len(results)-1].Error, "collides") {
+		t.Errorf("collision reported as %q", results[len(results)-1].Error)
+	}
+	if entries, _ := os.ReadDir(out); len(entries) != 2 {
+		t.Errorf("%d files written, want 2", len(entries))
+	}
+
+	opts.Resume = true
+	check(runJobs(jobs, opts, 1), map[string]string{
+		"a.stub": statusSkipped, "b.stub": statusSkipped, "d.stub": statusFailed,
+		"c.txt": statusSkipped, "dup": statusFailed,
+	})
+	opts.Resume, opts.Strip = false, true
+	check(runJobs(jobs, opts, 1), map[string]string{
+		"a.stub": statusConverted, "b.stub": statusConverted, "d.stub": statusFailed,
+		"c.txt": statusSkipped, "dup": statusFailed,
+	})
+
+	sum := summarize(results, fitest.FIF_STUB, time.Now())
+	if sum.Total != 5 || sum.Converted != 2 || sum.Skipped != 1 || sum.Failed != 2 || sum.Format != "STUB" {
+		t.Errorf("summary %+v", sum)
+	}
+	for i := 1; i < len(sum.Files); i++ {
+		if sum.Files[i-1].Input > sum.Files[i].Input {
+			t.Error("summary files not sorted by input")
+		}
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	fitest.NewStub(t)
+	for name, want := range map[string]freeimage.FREE_IMAGE_FORMAT{
+		"jpg":   freeimage.FIF_JPEG,
+		"WebP":  freeimage.FIF_WEBP,
+		"stub":  fitest.FIF_STUB,
+		"bogus": freeimage.FIF_UNKNOWN,
+	} {
+		if got := outputFormat(name); got != want {
+			t.Errorf("outputFormat(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
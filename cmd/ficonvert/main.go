@@ -0,0 +1,183 @@
+// Command ficonvert converts batches of images between the formats the
+// FreeImage library can read and write.
+//
+// usage:
+//
+//	ficonvert -f webp -o out ./photos '*.tif'
+//	ficonvert -f png -resize 800x600 -name '{dir}/{name}_small.{ext}' -o out ./photos
+//	ficonvert -f jpeg -t rs:fill:300:300/br:10 -q 85 -metadata strip -o out ./photos
+//
+// Inputs are directories, walked recursively, or glob patterns. Sources are
+// detected by content, files the library can't read are reported as skipped.
+// A failed image doesn't stop the batch; with -resume existing outputs are
+// kept, so an interrupted run can be restarted. The exit status is 1 if any
+// image failed.
+//
+// Output names are built from the -name template, placeholders:
+//
+//	{dir}     directory of the input relative to the walked directory
+//	{name}    input file name without extension
+//	{ext}     preferred extension of the output format
+//	{format}  output format name as reported by the library
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/transform"
+)
+
+func main() {
+	var (
+		lib       = flag.String("lib", "", "path of the FreeImage shared library (default: search the standard names)")
+		outDir    = flag.String("o", "", "output directory (required)")
+		format    = flag.String("f", "", "output format, e.g. png, jpeg, webp, tiff (required)")
+		name      = flag.String("name", "{dir}/{name}.{ext}", "output file name template")
+		spec      = flag.String("t", "", "transformation spec, e.g. rs:fit:800:0/g:1.2")
+		resize    = flag.String("resize", "", "fit the images into WxH, 0 keeps the aspect ratio")
+		quality   = flag.Int("q", 0, "output quality 1-100 for JPEG, WebP and JPEG-XR")
+		loadFlags = flag.Int("load-flags", 0, "FreeImage load flags")
+		saveFlags = flag.Int("save-flags", 0, "FreeImage save flags, or'ed with the quality")
+		metadata  = flag.String("metadata", "keep", "keep or strip the source metadata")
+		workers   = flag.Int("j", runtime.NumCPU(), "number of parallel conversions")
+		dryRun    = flag.Bool("n", false, "dry run, print what would be converted")
+		resume    = flag.Bool("resume", false, "skip inputs whose output already exists")
+		report    = flag.String("report", "", "write a JSON summary report to this file, - for stdout")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: ficonvert [flags] <dir|glob>...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("ficonvert: ")
+
+	if *outDir == "" || *format == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *metadata != "keep" && *metadata != "strip" {
+		log.Fatalf("-metadata must be keep or strip, got %q", *metadata)
+	}
+
+	s, err := buildSpec(*spec, *resize, *quality)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	l, err := freeimage.Open(*lib)
+	if err != nil {
+		log.Fatal(err)
+	}
+	freeimage.SetDefault(l)
+	freeimage.Initialise(false)
+	defer freeimage.DeInitialise()
+
+	fif := outputFormat(*format)
+	if fif == freeimage.FIF_UNKNOWN {
+		log.Fatalf("unknown output format %q", *format)
+	}
+	if !freeimage.FIFSupportsWriting(fif) {
+		log.Fatalf("the library can't write %s", freeimage.GetFormatFromFIF(fif))
+	}
+
+	opts := &options{
+		OutDir:    *outDir,
+		Template:  *name,
+		Format:    fif,
+		Spec:      s,
+		LoadFlags: int32(*loadFlags),
+		SaveFlags: int32(*saveFlags) | s.SaveFlags(fif),
+		Strip:     *metadata == "strip",
+		DryRun:    *dryRun,
+		Resume:    *resume,
+	}
+	jobs, err := plan(flag.Args(), opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	start := time.Now()
+	results := runJobs(jobs, opts, *workers)
+	sum := summarize(results, fif, start)
+
+	for _, r := range results {
+		switch r.Status {
+		case statusFailed:
+			log.Printf("%s: %s", r.Input, r.Error)
+		case statusPlanned:
+			fmt.Printf("%s -> %s\n", r.Input, r.Output)
+		}
+	}
+	if *report != "" {
+		if err := writeReport(*report, sum); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !*dryRun {
+		log.Printf("%d converted, %d skipped, %d failed in %s",
+			sum.Converted, sum.Skipped, sum.Failed, time.Since(start).Round(time.Millisecond))
+	}
+	if sum.Failed > 0 {
+		freeimage.DeInitialise()
+		os.Exit(1)
+	}
+}
+
+// buildSpec merges the -t spec with the -resize and -q shorthands.
+func buildSpec(spec, resize string, quality int) (*transform.Spec, error) {
+	s, rest, err := transform.Parse(spec, transform.Limits{})
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("bad transformation spec %q", spec)
+	}
+	if resize != "" {
+		ws, hs, ok := strings.Cut(strings.ToLower(resize), "x")
+		w, werr := strconv.Atoi(ws)
+		h, herr := strconv.Atoi(hs)
+		if !ok || werr != nil || herr != nil {
+			return nil, fmt.Errorf("bad -resize %q, want WxH", resize)
+		}
+		s.Resize = &transform.Resize{Mode: transform.ResizeFit, Width: int32(w), Height: int32(h)}
+	}
+	if quality != 0 {
+		s.Quality = quality
+	}
+	return s, s.Validate(transform.Limits{})
+}
+
+// outputFormat accepts the spec format names as well as any format name the
+// library knows, e.g. "PPM" or "ICO".
+func outputFormat(name string) freeimage.FREE_IMAGE_FORMAT {
+	if fif, ok := transform.ParseFormat(name); ok {
+		return fif
+	}
+	return freeimage.GetFIFFromFormat(strings.ToUpper(name))
+}
+
+func writeReport(name string, sum *summary) error {
+	var w io.Writer = os.Stdout
+	if name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sum)
+}
@@ -0,0 +1,59 @@
+// Command fihelper is the helper process of package sandbox: it decodes and
+// encodes images for a sandbox.Pool over its stdin and stdout, so a crash
+// in FreeImage only ends the helper. It is not meant to be run by hand.
+//
+// usage:
+//
+//	fihelper -lib /usr/lib/libfreeimage.so.3 -max-memory 1073741824 -qoi
+//
+// The Go plugins registered in the calling process don't exist in the
+// helper, -qoi registers the one of package qoi.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/qoi"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/sandbox"
+)
+
+func main() {
+	var (
+		lib       = flag.String("lib", "", "path of the FreeImage shared library (default: search the standard names)")
+		pluginDir = flag.String("plugin-dir", "", "register the FreeImage plugins in this directory")
+		maxMemory = flag.Uint64("max-memory", 0, "address space limit in bytes (default: no limit)")
+		useQOI    = flag.Bool("qoi", false, "register the QOI plugin")
+	)
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("fihelper: ")
+
+	if *maxMemory > 0 {
+		if err := sandbox.LimitMemory(*maxMemory); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var opts []freeimage.Option
+	if *pluginDir != "" {
+		opts = append(opts, freeimage.WithPluginDir(*pluginDir))
+	}
+	l, err := freeimage.Open(*lib, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	freeimage.SetDefault(l)
+	l.Initialise(false)
+	defer l.Close()
+	if *useQOI {
+		if _, err := qoi.Register(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := sandbox.Serve(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
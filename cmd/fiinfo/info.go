@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+var imageTypeNames = map[freeimage.FREE_IMAGE_TYPE]string{
+	freeimage.FIT_UNKNOWN: "FIT_UNKNOWN",
+	freeimage.FIT_BITMAP:  "FIT_BITMAP",
+	freeimage.FIT_UINT16:  "FIT_UINT16",
+	freeimage.FIT_INT16:   "FIT_INT16",
+	freeimage.FIT_UINT32:  "FIT_UINT32",
+	freeimage.FIT_INT32:   "FIT_INT32",
+	freeimage.FIT_FLOAT:   "FIT_FLOAT",
+	freeimage.FIT_DOUBLE:  "FIT_DOUBLE",
+	freeimage.FIT_COMPLEX: "FIT_COMPLEX",
+	freeimage.FIT_RGB16:   "FIT_RGB16",
+	freeimage.FIT_RGBA16:  "FIT_RGBA16",
+	freeimage.FIT_RGBF:    "FIT_RGBF",
+	freeimage.FIT_RGBAF:   "FIT_RGBAF",
+}
+
+var colorTypeNames = map[freeimage.FREE_IMAGE_COLOR_TYPE]string{
+	freeimage.FIC_MINISWHITE: "FIC_MINISWHITE",
+	freeimage.FIC_MINISBLACK: "FIC_MINISBLACK",
+	freeimage.FIC_RGB:        "FIC_RGB",
+	freeimage.FIC_PALETTE:    "FIC_PALETTE",
+	freeimage.FIC_RGBALPHA:   "FIC_RGBALPHA",
+	freeimage.FIC_CMYK:       "FIC_CMYK",
+}
+
+var modelNames = []struct {
+	model freeimage.FREE_IMAGE_MDMODEL
+	name  string
+}{
+	{freeimage.FIMD_COMMENTS, "Comments"},
+	{freeimage.FIMD_EXIF_MAIN, "EXIF-Main"},
+	{freeimage.FIMD_EXIF_EXIF, "EXIF-Exif"},
+	{freeimage.FIMD_EXIF_GPS, "EXIF-GPS"},
+	{freeimage.FIMD_EXIF_MAKERNOTE, "EXIF-MakerNote"},
+	{freeimage.FIMD_EXIF_INTEROP, "EXIF-Interop"},
+	{freeimage.FIMD_IPTC, "IPTC"},
+	{freeimage.FIMD_XMP, "XMP"},
+	{freeimage.FIMD_GEOTIFF, "GeoTIFF"},
+	{freeimage.FIMD_ANIMATION, "Animation"},
+	{freeimage.FIMD_CUSTOM, "Custom"},
+	{freeimage.FIMD_EXIF_RAW, "EXIF-Raw"},
+}
+
+var channelNames = map[freeimage.FREE_IMAGE_COLOR_CHANNEL]string{
+	freeimage.FICC_RED:   "red",
+	freeimage.FICC_GREEN: "green",
+	freeimage.FICC_BLUE:  "blue",
+	freeimage.FICC_BLACK: "grey",
+}
+
+type info struct {
+	File         string       `json:"file"`
+	Size         int64        `json:"size"`
+	Format       string       `json:"format"`
+	Description  string       `json:"description"`
+	MimeType     string       `json:"mime_type,omitempty"`
+	Pages        int32        `json:"pages,omitempty"`
+	Type         string       `json:"type"`
+	Width        uint32       `json:"width"`
+	Height       uint32       `json:"height"`
+	BPP          uint32       `json:"bpp"`
+	ColorType    string       `json:"color_type"`
+	ColorsUsed   uint32       `json:"colors_used,omitempty"`
+	Masks        *masks       `json:"masks,omitempty"`
+	DPI          [2]float64   `json:"dpi"`
+	Transparency *transparent `json:"transparency,omitempty"`
+	Background   string       `json:"background,omitempty"`
+	ICC          *iccSummary  `json:"icc,omitempty"`
+	Thumbnail    bool         `json:"thumbnail,omitempty"`
+	Metadata     []model      `json:"metadata,omitempty"`
+	Histogram    []histogram  `json:"histogram,omitempty"`
+}
+
+type masks struct {
+	Red   uint32 `json:"red"`
+	Green uint32 `json:"green"`
+	Blue  uint32 `json:"blue"`
+}
+
+type transparent struct {
+	Count uint32 `json:"count"`
+	Index int32  `json:"index"`
+}
+
+type iccSummary struct {
+	Size       uint32 `json:"size"`
+	CMYK       bool   `json:"cmyk"`
+	Version    string `json:"version,omitempty"`
+	Class      string `json:"class,omitempty"`
+	ColorSpace string `json:"color_space,omitempty"`
+	PCS        string `json:"pcs,omitempty"`
+}
+
+type tag struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+type model struct {
+	Model string `json:"model"`
+	Count uint32 `json:"count"`
+	Tags  []tag  `json:"tags,omitempty"`
+}
+
+type histogram struct {
+	Channel string      `json:"channel"`
+	Mean    float64     `json:"mean"`
+	Min     int         `json:"min"`
+	Max     int         `json:"max"`
+	Bins    [256]uint32 `json:"bins"`
+}
+
+type inspectOptions struct {
+	Flags      int32
+	HeaderOnly bool
+	Histogram  bool
+}
+
+func inspect(file string, opts inspectOptions) (*info, error) {
+	st, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	fif := freeimage.GetFileType(file, 0)
+	if fif == freeimage.FIF_UNKNOWN {
+		fif = freeimage.GetFIFFromFilename(file)
+	}
+	if fif == freeimage.FIF_UNKNOWN || !freeimage.FIFSupportsReading(fif) {
+		return nil, fmt.Errorf("%s: unknown or unreadable image format", file)
+	}
+
+	flags := opts.Flags
+	if opts.HeaderOnly && freeimage.FIFSupportsNoPixels(fif) {
+		flags |= freeimage.FIF_LOAD_NOPIXELS
+	}
+	dib := freeimage.Load(fif, file, flags)
+	if dib == nil {
+		return nil, fmt.Errorf("%s: can't load %s image", file, freeimage.GetFormatFromFIF(fif))
+	}
+	defer dib.Unload()
+
+	in := &info{
+		File:        file,
+		Size:        st.Size(),
+		Format:      freeimage.GetFormatFromFIF(fif),
+		Description: freeimage.GetFIFDescription(fif),
+		MimeType:    freeimage.GetFIFMimeType(fif),
+		Type:        imageTypeNames[dib.GetImageType()],
+		Width:       dib.GetWidth(),
+		Height:      dib.GetHeight(),
+		BPP:         dib.GetBPP(),
+		ColorType:   colorTypeNames[dib.GetColorType()],
+		ColorsUsed:  dib.GetColorsUsed(),
+		DPI:         [2]float64{dpi(dib.GetDotsPerMeterX()), dpi(dib.GetDotsPerMeterY())},
+		Thumbnail:   dib.GetThumbnail() != nil,
+	}
+	in.Pages = pageCount(fif, file)
+
+	if r, g, b := dib.GetRedMask(), dib.GetGreenMask(), dib.GetBlueMask(); r|g|b != 0 {
+		in.Masks = &masks{Red: r, Green: g, Blue: b}
+	}
+	if dib.IsTransparent() {
+		in.Transparency = &transparent{Count: dib.GetTransparencyCount(), Index: dib.GetTransparentIndex()}
+	}
+	if dib.HasBackgroundColor() {
+		if bk, ok := dib.GetBackgroundColor(); ok {
+			// RGBQUAD is stored as blue, green, red, reserved
+			in.Background = fmt.Sprintf("#%02X%02X%02X", bk[2], bk[1], bk[0])
+		}
+	}
+	in.ICC = icc(dib.GetICCProfile())
+	in.Metadata = metadata(dib)
+
+	if opts.Histogram && dib.HasPixels() {
+		in.Histogram = histograms(dib)
+	}
+	return in, nil
+}
+
+func dpi(dotsPerMeter uint32) float64 {
+	return math.Round(float64(dotsPerMeter)*0.0254*100) / 100
+}
+
+// pageCount returns the number of pages of multipage files, 0 for single
+// page images.
+func pageCount(fif freeimage.FREE_IMAGE_FORMAT, file string) int32 {
+	switch fif {
+	case freeimage.FIF_TIFF, freeimage.FIF_GIF, freeimage.FIF_ICO:
+	default:
+		return 0
+	}
+	mb := freeimage.OpenMultiBitmap(fif, file, false, true, false, 0)
+	if mb == nil {
+		return 0
+	}
+	defer mb.Close(0)
+	if n := mb.GetPageCount(); n > 1 {
+		return n
+	}
+	return 0
+}
+
+// icc summarizes the profile header, see ICC.1:2010 section 7.2.
+func icc(p *freeimage.ICCProfile) *iccSummary {
+	if p == nil || p.Size == 0 {
+		return nil
+	}
+	s := &iccSummary{Size: p.Size, CMYK: p.Flags&freeimage.FIICC_COLOR_IS_CMYK != 0}
+	if data := p.Data(); len(data) >= 128 {
+		s.Version = fmt.Sprintf("%d.%d.%d", data[8], data[9]>>4, data[9]&0xf)
+		s.Class = strings.TrimSpace(string(data[12:16]))
+		s.ColorSpace = strings.TrimSpace(string(data[16:20]))
+		s.PCS = strings.TrimSpace(string(data[20:24]))
+	}
+	return s
+}
+
+func metadata(dib *freeimage.BitMap) []model {
+	// maker notes are decoded according to the camera make
+	var camera []string
+	if t, ok := dib.GetMetadata(freeimage.FIMD_EXIF_MAIN, "Make"); ok && t != nil {
+		camera = []string{freeimage.TagToString(freeimage.FIMD_EXIF_MAIN, t)}
+	}
+
+	var models []model
+	for _, m := range modelNames {
+		n := dib.GetMetadataCount(m.model)
+		if n == 0 {
+			continue
+		}
+		md := model{Model: m.name, Count: n}
+		// the raw exif block is a single binary tag
+		if m.model != freeimage.FIMD_EXIF_RAW {
+			md.Tags = tags(dib, m.model, camera)
+		}
+		models = append(models, md)
+	}
+	return models
+}
+
+func tags(dib *freeimage.BitMap, model freeimage.FREE_IMAGE_MDMODEL, camera []string) []tag {
+	h, t := dib.FindFirstMetadata(model)
+	if h == nil {
+		return nil
+	}
+	defer h.FindCloseMetadata()
+
+	var tags []tag
+	for ok := true; ok && t != nil; t, ok = h.FindNextMetadata() {
+		tags = append(tags, tag{
+			Key:         t.GetTagKey(),
+			Value:       freeimage.TagToString(model, t, camera...),
+			Description: t.GetTagDescription(),
+		})
+	}
+	return tags
+}
+
+func histograms(dib *freeimage.BitMap) []histogram {
+	if dib.GetImageType() != freeimage.FIT_BITMAP {
+		return nil
+	}
+	var channels []freeimage.FREE_IMAGE_COLOR_CHANNEL
+	switch dib.GetBPP() {
+	case 8:
+		channels = []freeimage.FREE_IMAGE_COLOR_CHANNEL{freeimage.FICC_BLACK}
+	case 24, 32:
+		channels = []freeimage.FREE_IMAGE_COLOR_CHANNEL{freeimage.FICC_RED, freeimage.FICC_GREEN, freeimage.FICC_BLUE}
+	default:
+		return nil
+	}
+
+	var hs []histogram
+	for _, ch := range channels {
+		bins, ok := dib.GetHistogram(ch)
+		if !ok {
+			continue
+		}
+		h := histogram{Channel: channelNames[ch], Min: -1, Bins: bins}
+		var n, sum float64
+		for v, c := range bins {
+			if c == 0 {
+				continue
+			}
+			if h.Min < 0 {
+				h.Min = v
+			}
+			h.Max = v
+			n += float64(c)
+			sum += float64(c) * float64(v)
+		}
+		if n > 0 {
+			h.Mean = math.Round(sum/n*100) / 100
+		}
+		hs = append(hs, h)
+	}
+	return hs
+}
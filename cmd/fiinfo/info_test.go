@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func writeImage(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInspect(t *testing.T) {
+	s := fitest.NewStub(t)
+	// red x, green y, blue 7
+	data := fitest.Bytes(4, 2, 24, func(x, y, ch int) byte { return [...]byte{7, byte(y), byte(x)}[ch] })
+	path := writeImage(t, "a.stub", data)
+
+	in, err := inspect(path, inspectOptions{Histogram: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Format != "STUB" || in.Size != int64(len(data)) || in.Width != 4 || in.Height != 2 || in.BPP != 24 ||
+		in.Type != "FIT_BITMAP" || in.ColorType != "FIC_RGB" || in.DPI != [2]float64{72.01, 72.01} {
+		t.Errorf("info %+v", in)
+	}
+	if in.Masks == nil || in.Masks.Red != 0xff0000 || in.Transparency != nil || in.ICC != nil || in.Metadata != nil {
+		t.Errorf("masks %+v, transparency %+v, icc %+v, metadata %+v", in.Masks, in.Transparency, in.ICC, in.Metadata)
+	}
+	if len(in.Histogram) != 3 {
+		t.Fatalf("%d histograms", len(in.Histogram))
+	}
+	for _, tc := range []struct {
+		channel  string
+		min, max int
+		mean     float64
+	}{{"red", 0, 3, 1.5}, {"green", 0, 1, 0.5}, {"blue", 7, 7, 7}} {
+		h := in.Histogram[map[string]int{"red": 0, "green": 1, "blue": 2}[tc.channel]]
+		if h.Channel != tc.channel || h.Min != tc.min || h.Max != tc.max || h.Mean != tc.mean {
+			t.Errorf("%s histogram: %s min %d max %d mean %g", tc.channel, h.Channel, h.Min, h.Max, h.Mean)
+		}
+	}
+
+	// a header only load has no pixels to count
+	s.Reset()
+	in, err = inspect(path, inspectOptions{HeaderOnly: true, Histogram: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Width != 4 || in.Histogram != nil || s.Called("GetHistogram") != 0 {
+		t.Errorf("header only: %+v", in)
+	}
+	if s.Live() != 0 {
+		t.Errorf("%d bitmaps leaked", s.Live())
+	}
+
+	for _, bad := range []string{
+		writeImage(t, "b.txt", []byte("text")),
+		writeImage(t, "c.stub", data[:20]),
+		filepath.Join(t.TempDir(), "missing.stub"),
+	} {
+		if _, err := inspect(bad, inspectOptions{}); err == nil {
+			t.Errorf("inspect(%s) succeeded", filepath.Base(bad))
+		}
+	}
+}
+
+func TestPrintText(t *testing.T) {
+	var bins [256]uint32
+	bins[0], bins[255] = 1, 3
+	in := &info{
+		File: "a.png", Size: 1234, Format: "PNG", Description: "Portable Network Graphics",
+		MimeType: "image/png", Type: "FIT_BITMAP", Width: 640, Height: 480, BPP: 8,
+		ColorType: "FIC_PALETTE", ColorsUsed: 256, DPI: [2]float64{72, 72},
+		Transparency: &transparent{Count: 256, Index: 3},
+		ICC:          &iccSummary{Size: 3144, Version: "2.1.0", Class: "mntr", ColorSpace: "RGB", PCS: "XYZ"},
+		Metadata:     []model{{Model: "Comments", Count: 1, Tags: []tag{{Key: "Comment", Value: "hello"}}}},
+		Histogram:    []histogram{{Channel: "grey", Min: 0, Max: 255, Mean: 191.25, Bins: bins}},
+	}
+	var b bytes.Buffer
+	printText(&b, in)
+	out := b.String()
+	for _, want := range []string{
+		"File:            a.png (1234 bytes)\n",
+		"Format:          PNG, Portable Network Graphics\n",
+		"Dimensions:      640x480\n",
+		"Palette:         256 colors\n",
+		"Resolution:      72x72 dpi\n",
+		"Transparency:    256 entries, index 3\n",
+		"ICC profile:     3144 bytes, v2.1.0 mntr RGB -> XYZ\n",
+		"\n[Comments] 1 tags\n  Comment                        hello\n",
+		"  grey   min   0 max 255 mean 191.25  ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output misses %q:\n%s", want, out)
+		}
+	}
+	for _, absent := range []string{"Masks:", "Pages:", "Thumbnail:", "Background:"} {
+		if strings.Contains(out, absent) {
+			t.Errorf("output has %q:\n%s", absent, out)
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	var bins [256]uint32
+	if s := sparkline(bins); s != strings.Repeat(" ", 32) {
+		t.Errorf("empty histogram: %q", s)
+	}
+	for v := 0; v < 8; v++ {
+		bins[v] = 10 // bucket 0 is the peak
+	}
+	bins[8] = 40  // bucket 1 is half of it
+	bins[255] = 1 // bucket 31 rounds down to the lowest tick
+	s := sparkline(bins)
+	if n := utf8.RuneCountInString(s); n != 32 {
+		t.Fatalf("%d buckets", n)
+	}
+	r := []rune(s)
+	if r[0] != '█' || r[1] != '▄' || r[2] != ' ' || r[31] != ' ' {
+		t.Errorf("sparkline %q", s)
+	}
+}
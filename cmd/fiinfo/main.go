@@ -0,0 +1,164 @@
+// Command fiinfo prints what FreeImage knows about image files: format,
+// image and color type, masks, resolution, transparency, ICC profile, page
+// count, all metadata models and per channel histograms.
+//
+// usage:
+//
+//	fiinfo photo.jpg scan.tif
+//	fiinfo -json -histogram=false *.png
+//	fiinfo -header photo.jpg
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+func main() {
+	var (
+		lib       = flag.String("lib", "", "path of the FreeImage shared library (default: search the standard names)")
+		asJSON    = flag.Bool("json", false, "print JSON instead of text")
+		header    = flag.Bool("header", false, "only load the header when the format supports it, implies -histogram=false")
+		histo     = flag.Bool("histogram", true, "print per channel histograms")
+		loadFlags = flag.Int("load-flags", 0, "FreeImage load flags")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fiinfo [flags] <file>...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("fiinfo: ")
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	l, err := freeimage.Open(*lib)
+	if err != nil {
+		log.Fatal(err)
+	}
+	freeimage.SetDefault(l)
+	freeimage.Initialise(false)
+
+	opts := inspectOptions{Flags: int32(*loadFlags), HeaderOnly: *header, Histogram: *histo && !*header}
+	var infos []*info
+	failed := false
+	for _, file := range flag.Args() {
+		in, err := inspect(file, opts)
+		if err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		infos = append(infos, in)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(infos)
+	} else {
+		for i, in := range infos {
+			if i > 0 {
+				fmt.Println()
+			}
+			printText(os.Stdout, in)
+		}
+	}
+
+	freeimage.DeInitialise()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func printText(w io.Writer, in *info) {
+	p := func(key string, format string, args ...interface{}) {
+		fmt.Fprintf(w, "%-16s %s\n", key+":", fmt.Sprintf(format, args...))
+	}
+	p("File", "%s (%d bytes)", in.File, in.Size)
+	p("Format", "%s, %s", in.Format, in.Description)
+	if in.MimeType != "" {
+		p("MIME type", "%s", in.MimeType)
+	}
+	if in.Pages > 0 {
+		p("Pages", "%d", in.Pages)
+	}
+	p("Type", "%s", in.Type)
+	p("Dimensions", "%dx%d", in.Width, in.Height)
+	p("BPP", "%d", in.BPP)
+	p("Color type", "%s", in.ColorType)
+	if in.ColorsUsed > 0 {
+		p("Palette", "%d colors", in.ColorsUsed)
+	}
+	if m := in.Masks; m != nil {
+		p("Masks", "R %08X G %08X B %08X", m.Red, m.Green, m.Blue)
+	}
+	p("Resolution", "%gx%g dpi", in.DPI[0], in.DPI[1])
+	if t := in.Transparency; t != nil {
+		p("Transparency", "%d entries, index %d", t.Count, t.Index)
+	} else {
+		p("Transparency", "no")
+	}
+	if in.Background != "" {
+		p("Background", "%s", in.Background)
+	}
+	if icc := in.ICC; icc != nil {
+		s := fmt.Sprintf("%d bytes", icc.Size)
+		if icc.Version != "" {
+			s += fmt.Sprintf(", v%s %s %s -> %s", icc.Version, icc.Class, icc.ColorSpace, icc.PCS)
+		}
+		if icc.CMYK {
+			s += ", CMYK"
+		}
+		p("ICC profile", "%s", s)
+	}
+	if in.Thumbnail {
+		p("Thumbnail", "yes")
+	}
+
+	for _, m := range in.Metadata {
+		fmt.Fprintf(w, "\n[%s] %d tags\n", m.Model, m.Count)
+		for _, t := range m.Tags {
+			fmt.Fprintf(w, "  %-30s %s\n", t.Key, t.Value)
+		}
+	}
+
+	if len(in.Histogram) > 0 {
+		fmt.Fprintf(w, "\n[Histogram]\n")
+		for _, h := range in.Histogram {
+			fmt.Fprintf(w, "  %-6s min %3d max %3d mean %6.2f  %s\n", h.Channel, h.Min, h.Max, h.Mean, sparkline(h.Bins))
+		}
+	}
+}
+
+// sparkline draws the histogram in 32 buckets.
+func sparkline(bins [256]uint32) string {
+	const ticks = " ▁▂▃▄▅▆▇█"
+	levels := []rune(ticks)
+	var buckets [32]uint64
+	var peak uint64
+	for v, c := range bins {
+		buckets[v/8] += uint64(c)
+		if buckets[v/8] > peak {
+			peak = buckets[v/8]
+		}
+	}
+	var b strings.Builder
+	for _, c := range buckets {
+		i := 0
+		if peak > 0 {
+			i = int(c * uint64(len(levels)-1) / peak)
+		}
+		b.WriteRune(levels[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+// Command fiserve resizes and converts images on the fly over HTTP.
+//
+// usage:
+//
+//	fiserve -dir ./images -addr :8080
+//	fiserve -origin https://origin.example.com
+//	fiserve -dir ./images -cache-dir /var/cache/fiserve -cache-size 10737418240
+//
+// Requests use the transform spec grammar, e.g. GET /rs:fill:300:200/q:80/cat.jpg.
+// Metrics are served on /metrics.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/cache"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/transform"
+)
+
+func main() {
+	var (
+		lib          = flag.String("lib", "", "path of the FreeImage shared library (default: search the standard names)")
+		addr         = flag.String("addr", ":8080", "listen address")
+		dir          = flag.String("dir", "", "serve sources from this directory")
+		origin       = flag.String("origin", "", "fetch sources from this upstream HTTP origin")
+		maxSrcBytes  = flag.Int64("max-src-bytes", 32<<20, "largest accepted source file in bytes")
+		maxSrcPixels = flag.Int64("max-src-pixels", 50000000, "largest accepted source image in pixels")
+		maxWidth     = flag.Int("max-width", int(transform.DefaultLimits.MaxWidth), "largest output width")
+		maxHeight    = flag.Int("max-height", int(transform.DefaultLimits.MaxHeight), "largest output height")
+		maxPixels    = flag.Int64("max-pixels", transform.DefaultLimits.MaxPixels, "largest output image in pixels")
+		workers      = flag.Int("workers", 0, "concurrent image operations (default: number of CPUs)")
+		queueTimeout = flag.Duration("queue-timeout", 10*time.Second, "how long a request may wait for a worker")
+		cacheControl = flag.String("cache-control", "public, max-age=31536000, immutable", "Cache-Control header of responses")
+		cacheDir     = flag.String("cache-dir", "", "cache the encoded results in this directory (default: no cache)")
+		cacheSize    = flag.Int64("cache-size", 1<<30, "largest size of the result cache in bytes, 0 is unbounded")
+	)
+	flag.Parse()
+
+	var src Source
+	switch {
+	case *dir != "" && *origin != "":
+		log.Fatal("fiserve: -dir and -origin are exclusive")
+	case *dir != "":
+		src = newDirSource(*dir, *maxSrcBytes)
+	case *origin != "":
+		hs, err := newHTTPSource(*origin, &http.Client{Timeout: 30 * time.Second}, *maxSrcBytes)
+		if err != nil {
+			log.Fatal("fiserve: ", err)
+		}
+		src = hs
+	default:
+		log.Fatal("fiserve: one of -dir or -origin is required")
+	}
+
+	l, err := freeimage.Open(*lib)
+	if err != nil {
+		log.Fatal("fiserve: ", err)
+	}
+	freeimage.SetDefault(l)
+	freeimage.Initialise(false)
+	defer freeimage.DeInitialise()
+
+	s := newServer(src, config{
+		Limits:       transform.Limits{MaxWidth: int32(*maxWidth), MaxHeight: int32(*maxHeight), MaxPixels: *maxPixels},
+		MaxSrcPixels: *maxSrcPixels,
+		Workers:      *workers,
+		QueueTimeout: *queueTimeout,
+		CacheControl: *cacheControl,
+	})
+	if *cacheDir != "" {
+		if s.cache, err = cache.Open(cache.Options{Dir: *cacheDir, MaxBytes: *cacheSize}); err != nil {
+			log.Fatal("fiserve: ", err)
+		}
+	}
+	log.Printf("fiserve: FreeImage %s listening on %s", freeimage.GetVersion(), *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.routes()))
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/cache"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/transform"
+)
+
+var (
+	errUnsupported = errors.New("unsupported image format")
+	errBusy        = errors.New("server busy")
+)
+
+var mimeTypes = map[freeimage.FREE_IMAGE_FORMAT]string{
+	freeimage.FIF_JPEG:  "image/jpeg",
+	freeimage.FIF_PNG:   "image/png",
+	freeimage.FIF_WEBP:  "image/webp",
+	freeimage.FIF_JXR:   "image/jxr",
+	freeimage.FIF_GIF:   "image/gif",
+	freeimage.FIF_BMP:   "image/bmp",
+	freeimage.FIF_TIFF:  "image/tiff",
+	freeimage.FIF_J2K:   "image/j2k",
+	freeimage.FIF_JP2:   "image/jp2",
+	freeimage.FIF_EXR:   "image/x-exr",
+	freeimage.FIF_HDR:   "image/vnd.radiance",
+	freeimage.FIF_TARGA: "image/x-tga",
+}
+
+type config struct {
+	Limits       transform.Limits
+	MaxSrcPixels int64
+	Workers      int
+	QueueTimeout time.Duration
+	CacheControl string
+}
+
+type metrics struct {
+	requests     atomic.Int64
+	notModified  atomic.Int64
+	clientErrors atomic.Int64
+	serverErrors atomic.Int64
+	bytesOut     atomic.Int64
+	inFlight     atomic.Int64
+	queued       atomic.Int64
+	processNanos atomic.Int64
+	processed    atomic.Int64
+}
+
+// processFunc decodes src, applies spec and encodes the result as fif.
+type processFunc func(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT, cfg *config) ([]byte, error)
+
+type server struct {
+	cfg     config
+	source  Source
+	process processFunc
+	cache   *cache.Cache // of the encoded results, nil if disabled
+	workers chan struct{}
+	metrics metrics
+}
+
+func newServer(src Source, cfg config) *server {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	return &server{
+		cfg:     cfg,
+		source:  src,
+		process: processImage,
+		workers: make(chan struct{}, cfg.Workers),
+	}
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	mux.HandleFunc("/", s.serveImage)
+	return mux
+}
+
+// negotiate picks the output format: an explicit f: option wins, then the
+// formats announced in Accept, then the source format if browsers can show
+// it, then JPEG.
+func negotiate(spec *transform.Spec, accept string, srcFIF freeimage.FREE_IMAGE_FORMAT) freeimage.FREE_IMAGE_FORMAT {
+	if spec.Format != freeimage.FIF_UNKNOWN {
+		return spec.Format
+	}
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		q := 1.0
+		for _, p := range params[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		if q > 0 {
+			accepted[strings.ToLower(strings.TrimSpace(params[0]))] = true
+		}
+	}
+	switch {
+	case accepted["image/webp"]:
+		return freeimage.FIF_WEBP
+	case accepted["image/jxr"] || accepted["image/vnd.ms-photo"]:
+		return freeimage.FIF_JXR
+	case srcFIF == freeimage.FIF_PNG || srcFIF == freeimage.FIF_GIF:
+		return freeimage.FIF_PNG
+	}
+	return freeimage.FIF_JPEG
+}
+
+// sniffFormat recognizes the source formats without calling into the
+// library, so format negotiation and ETags work before decoding.
+func sniffFormat(data []byte) freeimage.FREE_IMAGE_FORMAT {
+	switch {
+	case len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF:
+		return freeimage.FIF_JPEG
+	case len(data) >= 8 && string(data[:8]) == "\x89PNG\r\n\x1a\n":
+		return freeimage.FIF_PNG
+	case len(data) >= 6 && (string(data[:6]) == "GIF87a" || string(data[:6]) == "GIF89a"):
+		return freeimage.FIF_GIF
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return freeimage.FIF_WEBP
+	}
+	return freeimage.FIF_UNKNOWN
+}
+
+func etag(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT) string {
+	h := sha256.New()
+	h.Write(src)
+	fmt.Fprintf(h, "\x00%s\x00%d", spec.String(), fif)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+func etagMatch(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) fail(w http.ResponseWriter, code int, err error) {
+	if code >= 500 {
+		s.metrics.serverErrors.Add(1)
+		log.Printf("fiserve: %v", err)
+	} else {
+		s.metrics.clientErrors.Add(1)
+	}
+	// errors are transient or depend on the limits, they must not be cached
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, err.Error(), code)
+}
+
+func (s *server) serveImage(w http.ResponseWriter, r *http.Request) {
+	s.metrics.requests.Add(1)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.fail(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	spec, name, err := transform.Parse(r.URL.Path, s.cfg.Limits)
+	switch {
+	case errors.Is(err, transform.ErrTooLarge):
+		s.fail(w, http.StatusRequestEntityTooLarge, err)
+		return
+	case err != nil:
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	case name == "":
+		s.fail(w, http.StatusBadRequest, errors.New("missing source path"))
+		return
+	}
+
+	src, err := s.source.Fetch(r.Context(), name)
+	switch {
+	case errors.Is(err, errNotFound):
+		s.fail(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, errTooBig):
+		s.fail(w, http.StatusRequestEntityTooLarge, err)
+		return
+	case err != nil:
+		s.fail(w, http.StatusBadGateway, err)
+		return
+	}
+
+	fif := negotiate(spec, r.Header.Get("Accept"), sniffFormat(src))
+	tag := etag(src, spec, fif)
+	if etagMatch(r.Header.Get("If-None-Match"), tag) {
+		s.metrics.notModified.Add(1)
+		s.cacheHeaders(w, tag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	out, err := s.render(r.Context(), src, spec, fif)
+	switch {
+	case errors.Is(err, errBusy):
+		w.Header().Set("Retry-After", "1")
+		s.fail(w, http.StatusServiceUnavailable, err)
+		return
+	case errors.Is(err, freeimage.ErrTooLarge), errors.Is(err, transform.ErrTooLarge):
+		s.fail(w, http.StatusRequestEntityTooLarge, err)
+		return
+	case errors.Is(err, errUnsupported), errors.Is(err, freeimage.ErrFormatNotAllowed):
+		s.fail(w, http.StatusUnsupportedMediaType, err)
+		return
+	case errors.Is(err, transform.ErrInvalid):
+		s.fail(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		s.fail(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.cacheHeaders(w, tag)
+	w.Header().Set("Content-Type", mimeTypes[fif])
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	n, _ := w.Write(out)
+	s.metrics.bytesOut.Add(int64(n))
+}
+
+// cacheHeaders sets the caching headers of the successful responses.
+func (s *server) cacheHeaders(w http.ResponseWriter, tag string) {
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", s.cfg.CacheControl)
+}
+
+// render returns the cached result for the request, or runs it and caches
+// the result. Identical concurrent requests run once, on a context of their
+// own bounded by QueueTimeout: the first request going away must not fail
+// the others waiting for the same result.
+func (s *server) render(ctx context.Context, src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT) ([]byte, error) {
+	if s.cache == nil {
+		return s.run(ctx, src, spec, fif)
+	}
+	key := s.cache.Key(src, spec, fif, spec.SaveFlags(fif))
+	return s.cache.Do(key, func() ([]byte, error) { return s.run(context.Background(), src, spec, fif) })
+}
+
+// run processes one image on the bounded worker pool.
+func (s *server) run(ctx context.Context, src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT) ([]byte, error) {
+	s.metrics.queued.Add(1)
+	var timeout <-chan time.Time
+	if s.cfg.QueueTimeout > 0 {
+		t := time.NewTimer(s.cfg.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case s.workers <- struct{}{}:
+		s.metrics.queued.Add(-1)
+	case <-timeout:
+		s.metrics.queued.Add(-1)
+		return nil, errBusy
+	case <-ctx.Done():
+		s.metrics.queued.Add(-1)
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.workers }()
+
+	s.metrics.inFlight.Add(1)
+	defer s.metrics.inFlight.Add(-1)
+
+	start := time.Now()
+	out, err := s.process(src, spec, fif, &s.cfg)
+	s.metrics.processNanos.Add(int64(time.Since(start)))
+	s.metrics.processed.Add(1)
+	return out, err
+}
+
+func (s *server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m := &s.metrics
+	fmt.Fprintf(w, "# TYPE fiserve_requests_total counter\nfiserve_requests_total %d\n", m.requests.Load())
+	fmt.Fprintf(w, "# TYPE fiserve_not_modified_total counter\nfiserve_not_modified_total %d\n", m.notModified.Load())
+	fmt.Fprintf(w, "# TYPE fiserve_errors_total counter\nfiserve_errors_total{class=\"client\"} %d\nfiserve_errors_total{class=\"server\"} %d\n", m.clientErrors.Load(), m.serverErrors.Load())
+	fmt.Fprintf(w, "# TYPE fiserve_response_bytes_total counter\nfiserve_response_bytes_total %d\n", m.bytesOut.Load())
+	fmt.Fprintf(w, "# TYPE fiserve_in_flight gauge\nfiserve_in_flight %d\n", m.inFlight.Load())
+	fmt.Fprintf(w, "# TYPE fiserve_queued gauge\nfiserve_queued %d\n", m.queued.Load())
+	fmt.Fprintf(w, "# TYPE fiserve_workers gauge\nfiserve_workers %d\n", cap(s.workers))
+	fmt.Fprintf(w, "# TYPE fiserve_process_seconds summary\nfiserve_process_seconds_sum %g\nfiserve_process_seconds_count %d\n", time.Duration(m.processNanos.Load()).Seconds(), m.processed.Load())
+	if s.cache != nil {
+		cs := s.cache.Stats()
+		fmt.Fprintf(w, "# TYPE fiserve_cache_requests_total counter\nfiserve_cache_requests_total{result=\"hit\"} %d\nfiserve_cache_requests_total{result=\"miss\"} %d\nfiserve_cache_requests_total{result=\"shared\"} %d\n", cs.Hits, cs.Misses, cs.Shared)
+		fmt.Fprintf(w, "# TYPE fiserve_cache_evictions_total counter\nfiserve_cache_evictions_total %d\n", cs.Evictions)
+		fmt.Fprintf(w, "# TYPE fiserve_cache_corrupt_total counter\nfiserve_cache_corrupt_total %d\n", cs.Corrupt)
+		fmt.Fprintf(w, "# TYPE fiserve_cache_entries gauge\nfiserve_cache_entries %d\n", cs.Entries)
+		fmt.Fprintf(w, "# TYPE fiserve_cache_bytes gauge\nfiserve_cache_bytes %d\n", cs.Bytes)
+	}
+}
+
+// freeimage processing ------------------------------------------------------
+
+func processImage(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT, cfg *config) ([]byte, error) {
+	// the header is probed first so huge images are rejected before
+	// decoding, formats that can't load headers only are not decoded
+	limits := freeimage.DefaultLoadLimits
+	limits.MaxPixels = cfg.MaxSrcPixels
+	dib, err := freeimage.Decode(src, &freeimage.DecodeOptions{Limits: &limits})
+	if errors.Is(err, freeimage.ErrDecode) || errors.Is(err, freeimage.ErrUnknownFormat) {
+		return nil, errUnsupported
+	} else if err != nil {
+		return nil, err
+	}
+	defer dib.Unload()
+
+	p, err := spec.Pipeline(int32(dib.GetWidth()), int32(dib.GetHeight()), cfg.Limits)
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.Apply(dib)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { out.Unload() }()
+
+	if conv := convertForFormat(out, fif); conv != out {
+		if conv == nil {
+			return nil, fmt.Errorf("can't convert image for %s", mimeTypes[fif])
+		}
+		out.Unload()
+		out = conv
+	}
+	return freeimage.Encode(out, fif, &freeimage.EncodeOptions{Flags: spec.SaveFlags(fif)})
+}
+
+// convertForFormat returns a bitmap the output plugin can write, dib itself
+// if no conversion is needed.
+func convertForFormat(dib *freeimage.BitMap, fif freeimage.FREE_IMAGE_FORMAT) *freeimage.BitMap {
+	if dib.GetImageType() != freeimage.FIT_BITMAP {
+		switch fif {
+		case freeimage.FIF_TIFF, freeimage.FIF_EXR, freeimage.FIF_PNG, freeimage.FIF_JXR:
+			return dib
+		}
+		return dib.ConvertToStandardType(true)
+	}
+	bpp := dib.GetBPP()
+	switch fif {
+	case freeimage.FIF_JPEG:
+		if bpp != 8 && bpp != 24 {
+			return dib.ConvertTo24Bits()
+		}
+	case freeimage.FIF_WEBP, freeimage.FIF_JXR:
+		if bpp != 24 && bpp != 32 {
+			if dib.IsTransparent() {
+				return dib.ConvertTo32Bits()
+			}
+			return dib.ConvertTo24Bits()
+		}
+	}
+	return dib
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/cache"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/qoi"
+	"github.com/jinzhongmin/gofreeimage/pkg/transform"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\nfake image body")
+
+// newTestServer serves pngHeader as /cat.png from an httptest origin and
+// replaces the FreeImage processing by fake.
+func newTestServer(t *testing.T, fake processFunc) (*httptest.Server, *server) {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cat.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(pngHeader)
+	}))
+	t.Cleanup(origin.Close)
+
+	src, err := newHTTPSource(origin.URL, origin.Client(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(src, config{Limits: transform.DefaultLimits, Workers: 1, QueueTimeout: 50 * time.Millisecond, CacheControl: "public, max-age=60"})
+	s.process = fake
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(ts.Close)
+	return ts, s
+}
+
+func get(t *testing.T, url string, header map[string]string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestServeImage(t *testing.T) {
+	var gotFIF freeimage.FREE_IMAGE_FORMAT
+	ts, _ := newTestServer(t, func(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT, cfg *config) ([]byte, error) {
+		gotFIF = fif
+		return []byte("encoded"), nil
+	})
+
+	resp := get(t, ts.URL+"/rs:fit:100:0/cat.png", map[string]string{"Accept": "image/avif,image/webp,*/*"})
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "encoded" {
+		t.Fatalf("got %d %q", resp.StatusCode, body)
+	}
+	if gotFIF != freeimage.FIF_WEBP || resp.Header.Get("Content-Type") != "image/webp" {
+		t.Errorf("negotiated %d %q, want webp", gotFIF, resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("Cache-Control") != "public, max-age=60" || resp.Header.Get("Vary") != "Accept" {
+		t.Errorf("bad cache headers %v", resp.Header)
+	}
+
+	tag := resp.Header.Get("ETag")
+	resp = get(t, ts.URL+"/rs:fit:100:0/cat.png", map[string]string{"Accept": "image/webp", "If-None-Match": tag})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match: got %d, want 304", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") != tag || resp.Header.Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("304 cache headers %v", resp.Header)
+	}
+}
+
+func TestServeImageErrors(t *testing.T) {
+	ts, _ := newTestServer(t, func(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT, cfg *config) ([]byte, error) {
+		if spec.Rotate != 0 {
+			return nil, &freeimage.LimitError{Limit: "MaxPixels", Value: 4, Max: 1}
+		}
+		return nil, errUnsupported
+	})
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/rs:bogus:1:1/cat.png", http.StatusBadRequest},
+		{"/rs:force:100000:10/cat.png", http.StatusRequestEntityTooLarge},
+		{"/q:80/", http.StatusBadRequest},
+		{"/q:80/dog.png", http.StatusNotFound},
+		{"/rot:90/cat.png", http.StatusRequestEntityTooLarge},
+		{"/q:80/cat.png", http.StatusUnsupportedMediaType},
+	} {
+		resp := get(t, ts.URL+tc.path, nil)
+		if resp.StatusCode != tc.code {
+			t.Errorf("%s: got %d, want %d", tc.path, resp.StatusCode, tc.code)
+		}
+		if cc, tag := resp.Header.Get("Cache-Control"), resp.Header.Get("ETag"); cc != "no-store" || tag != "" {
+			t.Errorf("%s: error cached with Cache-Control %q and ETag %q", tc.path, cc, tag)
+		}
+	}
+}
+
+func TestServeImageBusy(t *testing.T) {
+	release := make(chan struct{})
+	ts, s := newTestServer(t, func(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT, cfg *config) ([]byte, error) {
+		<-release
+		return []byte("x"), nil
+	})
+	defer close(release)
+
+	s.workers <- struct{}{} // occupy the only worker
+	defer func() { <-s.workers }()
+
+	resp := get(t, ts.URL+"/cat.png", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503", resp.StatusCode)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("503 sent with Cache-Control %q", cc)
+	}
+
+	resp = get(t, ts.URL+"/metrics", nil)
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `fiserve_errors_total{class="server"} 1`) {
+		t.Errorf("metrics missing server error:\n%s", body)
+	}
+}
+
+func TestServeImageCache(t *testing.T) {
+	calls := 0
+	ts, s := newTestServer(t, func(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT, cfg *config) ([]byte, error) {
+		calls++
+		return []byte("encoded"), nil
+	})
+	c, err := cache.Open(cache.Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.cache = c
+
+	for _, path := range []string{"/rs:fit:100:0/q:80/cat.png", "/quality:80/resize:fit:100:0/cat.png"} {
+		resp := get(t, ts.URL+path, nil)
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "encoded" {
+			t.Fatalf("%s: got %d %q", path, resp.StatusCode, body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("processed %d times, want 1", calls)
+	}
+	resp := get(t, ts.URL+"/metrics", nil)
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `fiserve_cache_requests_total{result="hit"} 1`) {
+		t.Errorf("metrics missing the cache hit:\n%s", body)
+	}
+}
+
+func TestServeImageCacheShared(t *testing.T) {
+	release := make(chan struct{})
+	_, s := newTestServer(t, func(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT, cfg *config) ([]byte, error) {
+		<-release
+		return []byte("encoded"), nil
+	})
+	s.cfg.QueueTimeout = 0
+	c, err := cache.Open(cache.Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.cache = c
+
+	type result struct {
+		out []byte
+		err error
+	}
+	render := func(ctx context.Context, done chan<- result) {
+		out, err := s.render(ctx, pngHeader, &transform.Spec{}, freeimage.FIF_PNG)
+		done <- result{out, err}
+	}
+	s.workers <- struct{}{} // the render waits in the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	first, second := make(chan result, 1), make(chan result, 1)
+	go render(ctx, first)
+	for s.metrics.queued.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go render(context.Background(), second)
+	for c.Stats().Shared == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the first request going away must not fail the second
+	cancel()
+	select {
+	case r := <-first:
+		t.Errorf("render returned %v when its request was cancelled, want it to keep running", r.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-s.workers
+	close(release)
+	if r := <-second; r.err != nil || string(r.out) != "encoded" {
+		t.Errorf("shared render = %q, %v", r.out, r.err)
+	}
+}
+
+// unprobed is QOI without header only loading.
+type unprobed struct{ qoi.Plugin }
+
+func (unprobed) Format() string         { return "QOI-UNPROBED" }
+func (unprobed) SupportsNoPixels() bool { return false }
+
+func TestProcessImageLimits(t *testing.T) {
+	s := fitest.NewStub(t)
+	cfg := &config{Limits: transform.DefaultLimits, MaxSrcPixels: 1000}
+	spec := &transform.Spec{}
+
+	src := fitest.Bytes(40, 30, 24, func(x, y, ch int) byte { return 1 })
+	if _, err := processImage(src, spec, fitest.FIF_STUB, cfg); !errors.Is(err, freeimage.ErrTooLarge) {
+		t.Errorf("processImage over MaxSrcPixels = %v, want ErrTooLarge", err)
+	}
+	if n := s.Called("LoadFromMemory"); n != 1 {
+		t.Errorf("LoadFromMemory called %d times, want only the header probe", n)
+	}
+
+	// formats that can't load headers only are not decoded at all
+	if _, err := freeimage.RegisterPlugin(unprobed{}); err != nil {
+		t.Fatal(err)
+	}
+	qoiSrc, err := qoi.Encode(4, 3, 3, 0, make([]byte, 4*3*3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Reset()
+	if _, err := processImage(qoiSrc, spec, fitest.FIF_STUB, cfg); !errors.Is(err, freeimage.ErrFormatNotAllowed) {
+		t.Errorf("processImage of an unprobed format = %v, want ErrFormatNotAllowed", err)
+	}
+	if n := s.Called("LoadFromMemory"); n != 0 {
+		t.Errorf("an unprobed format was loaded %d times", n)
+	}
+
+	for _, src := range [][]byte{nil, []byte("not an image")} {
+		if _, err := processImage(src, spec, fitest.FIF_STUB, cfg); !errors.Is(err, errUnsupported) {
+			t.Errorf("processImage(%q) = %v, want errUnsupported", src, err)
+		}
+	}
+	if s.Live() != 0 {
+		t.Errorf("Live() = %d", s.Live())
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	none := &transform.Spec{Format: freeimage.FIF_UNKNOWN}
+	for _, tc := range []struct {
+		accept string
+		src    freeimage.FREE_IMAGE_FORMAT
+		want   freeimage.FREE_IMAGE_FORMAT
+	}{
+		{"image/webp,*/*", freeimage.FIF_JPEG, freeimage.FIF_WEBP},
+		{"image/webp;q=0,image/jxr", freeimage.FIF_JPEG, freeimage.FIF_JXR},
+		{"*/*", freeimage.FIF_PNG, freeimage.FIF_PNG},
+		{"", freeimage.FIF_UNKNOWN, freeimage.FIF_JPEG},
+	} {
+		if got := negotiate(none, tc.accept, tc.src); got != tc.want {
+			t.Errorf("negotiate(%q, %d) = %d, want %d", tc.accept, tc.src, got, tc.want)
+		}
+	}
+	if got := negotiate(&transform.Spec{Format: freeimage.FIF_PNG}, "image/webp", freeimage.FIF_JPEG); got != freeimage.FIF_PNG {
+		t.Errorf("explicit format ignored, got %d", got)
+	}
+}
+
+func TestDirSourceTraversal(t *testing.T) {
+	s := newDirSource(t.TempDir(), 1<<20)
+	for _, name := range []string{"../etc/passwd", "/../../etc/passwd", ""} {
+		if _, err := s.Fetch(context.Background(), name); !errors.Is(err, errNotFound) {
+			t.Errorf("Fetch(%q) = %v, want not found", name, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	errNotFound = errors.New("source not found")
+	errTooBig   = errors.New("source too big")
+)
+
+// Source fetches the original image bytes for a request path.
+type Source interface {
+	Fetch(ctx context.Context, name string) ([]byte, error)
+}
+
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, errTooBig
+	}
+	return data, nil
+}
+
+// dirSource serves sources from a local directory.
+type dirSource struct {
+	fsys    fs.FS
+	maxSize int64
+}
+
+func newDirSource(dir string, maxSize int64) *dirSource {
+	return &dirSource{fsys: os.DirFS(dir), maxSize: maxSize}
+}
+
+func (s *dirSource) Fetch(ctx context.Context, name string) ([]byte, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if !fs.ValidPath(name) || name == "." {
+		return nil, errNotFound
+	}
+	f, err := s.fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		return nil, errNotFound
+	}
+	if st.Size() > s.maxSize {
+		return nil, errTooBig
+	}
+	return readLimited(f, s.maxSize)
+}
+
+// httpSource fetches sources from an upstream HTTP origin.
+type httpSource struct {
+	base    *url.URL
+	client  *http.Client
+	maxSize int64
+}
+
+func newHTTPSource(base string, client *http.Client, maxSize int64) (*httpSource, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("origin %q is not an http(s) url", base)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSource{base: u, client: client, maxSize: maxSize}, nil
+}
+
+func (s *httpSource) Fetch(ctx context.Context, name string) ([]byte, error) {
+	ref, err := url.Parse(strings.TrimPrefix(path.Clean("/"+name), "/"))
+	if err != nil {
+		return nil, errNotFound
+	}
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + ref.Path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("origin returned %s", resp.Status)
+	case resp.ContentLength > s.maxSize:
+		return nil, errTooBig
+	}
+	return readLimited(resp.Body, s.maxSize)
+}
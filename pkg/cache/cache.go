@@ -0,0 +1,378 @@
+// Package cache stores encoded derivatives of source images on local disk,
+// addressed by their content: the hash of the source, the canonical
+// transform spec and the output format and flags.
+//
+//	c, err := cache.Open(cache.Options{Dir: "/var/cache/fiserve", MaxBytes: 1 << 30})
+//	key := c.Key(src, spec, freeimage.FIF_WEBP, spec.SaveFlags(freeimage.FIF_WEBP))
+//	out, err := c.Do(key, func() ([]byte, error) { return render(src, spec) })
+//
+// Entries are evicted least recently used first when the cache grows over
+// MaxBytes. Concurrent Do calls for the same key render once, and every
+// entry carries a SHA-256 checksum verified on read, so a corrupt file is
+// a miss and is removed instead of being served.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/transform"
+)
+
+var ErrInvalidKey = errors.New("cache: invalid key")
+
+// SourceHash is the hash of the sources in the keys.
+type SourceHash int
+
+const (
+	// SHA256 is collision resistant, for sources from untrusted origins.
+	SHA256 SourceHash = iota
+	// CRC32 is the IEEE CRC-32 of FreeImage's zlib, cheaper, for trusted
+	// sources only: two sources with the same CRC share their derivatives.
+	CRC32
+)
+
+func (h SourceHash) String() string {
+	switch h {
+	case SHA256:
+		return "sha256"
+	case CRC32:
+		return "crc32"
+	}
+	return fmt.Sprintf("SourceHash(%d)", int(h))
+}
+
+func (h SourceHash) new() hash.Hash {
+	if h == CRC32 {
+		return freeimage.NewCRC32()
+	}
+	return sha256.New()
+}
+
+// Options configures a Cache.
+type Options struct {
+	// Dir holds the entries, it is created if missing.
+	Dir string
+	// MaxBytes bounds the size of the entries on disk, 0 is unbounded.
+	MaxBytes int64
+	// Hash hashes the sources, SHA256 by default.
+	Hash SourceHash
+}
+
+// Stats counts the cache operations since Open.
+type Stats struct {
+	Hits, Misses int64
+	// Shared counts the Do calls that waited for the same key.
+	Shared    int64
+	Evictions int64
+	// Corrupt counts the entries that failed verification.
+	Corrupt int64
+	Entries int
+	Bytes   int64
+}
+
+// Cache is a derivative cache in a directory. It is safe for concurrent
+// use, but one directory must only be used by one Cache at a time.
+type Cache struct {
+	dir  string
+	max  int64
+	hash SourceHash
+
+	mu      sync.Mutex
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+	size    int64
+	flights map[string]*flight
+
+	hits, misses, shared, evictions, corrupt atomic.Int64
+}
+
+type entry struct {
+	key  string
+	size int64 // on disk
+}
+
+type flight struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// The entry files are the magic, the SHA-256 of the data and the data.
+var magic = []byte("FIC1")
+
+const headerSize = 4 + sha256.Size
+
+// Open opens the cache in opts.Dir, with the entries left there by a
+// previous Cache ordered by modification time, and evicts the oldest if
+// they are over opts.MaxBytes.
+func Open(opts Options) (*Cache, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("cache: no directory")
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:     opts.Dir,
+		max:     opts.MaxBytes,
+		hash:    opts.Hash,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		flights: map[string]*flight{},
+	}
+
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []found
+	err := filepath.WalkDir(opts.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			os.Remove(path) // left by an interrupted Put
+			return nil
+		}
+		if !validKey(name) || path != c.path(name) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, found{name, info.Size(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushBack(&entry{f.key, f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Key returns the key of the derivative of src made by spec and encoded as
+// fif with the save flags. Specs that only differ in option order or
+// aliases have the same canonical form, see transform.Spec.String, and the
+// same key.
+func (c *Cache) Key(src []byte, spec *transform.Spec, fif freeimage.FREE_IMAGE_FORMAT, flags int32) string {
+	sh := c.hash.new()
+	sh.Write(src)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s:%x\x00%s\x00%d\x00%d", c.hash, sh.Sum(nil), spec.String(), fif, flags)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func validKey(key string) bool {
+	if len(key) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil && strings.ToLower(key) == key
+}
+
+// path shards the entries over 256 directories by the first key byte.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// Get returns the data stored under key. An entry that fails verification
+// is removed and reported as missing.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if !validKey(key) {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.mu.Lock()
+	_, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	path := c.path(key)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		c.remove(key)
+		c.misses.Add(1)
+		return nil, false
+	}
+	data, ok := verify(b)
+	if !ok {
+		c.corrupt.Add(1)
+		c.remove(key)
+		os.Remove(path)
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	now := time.Now()
+	os.Chtimes(path, now, now) // keeps the order across Open
+	c.hits.Add(1)
+	return data, true
+}
+
+func verify(b []byte) ([]byte, bool) {
+	if len(b) < headerSize || !bytes.Equal(b[:4], magic) {
+		return nil, false
+	}
+	data := b[headerSize:]
+	sum := sha256.Sum256(data)
+	return data, bytes.Equal(b[4:headerSize], sum[:])
+}
+
+// Put stores data under key and evicts the least recently used entries
+// over MaxBytes. Data larger than MaxBytes is not stored.
+func (c *Cache) Put(key string, data []byte) error {
+	if !validKey(key) {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	size := int64(headerSize + len(data))
+	if c.max > 0 && size > c.max {
+		return nil
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	_, err = f.Write(append(append(append([]byte(nil), magic...), sum[:]...), data...))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.Value.(*entry).size
+		c.lru.Remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key, size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used entries over the maximum size,
+// with c.mu held.
+func (c *Cache) evict() {
+	for c.max > 0 && c.size > c.max {
+		e := c.lru.Back()
+		ent := e.Value.(*entry)
+		c.lru.Remove(e)
+		delete(c.entries, ent.key)
+		c.size -= ent.size
+		os.Remove(c.path(ent.key))
+		c.evictions.Add(1)
+	}
+}
+
+// remove forgets key without touching its file.
+func (c *Cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.Value.(*entry).size
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+// Do returns the data stored under key, or the result of fn stored under
+// key. Concurrent calls for a key missing from the cache run fn once and
+// share its result. Errors are returned to the waiting calls but not
+// stored, and failing to store the result doesn't fail Do. A panic in fn
+// is returned as an error to every call.
+func (c *Cache) Do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	if data, ok := c.Get(key); ok {
+		return data, nil
+	}
+
+	c.mu.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.mu.Unlock()
+		c.shared.Add(1)
+		<-f.done
+		return f.data, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				f.data, f.err = nil, fmt.Errorf("cache: render of %s panicked: %v", key, r)
+			}
+		}()
+		f.data, f.err = fn()
+	}()
+	if f.err == nil {
+		c.Put(key, f.data)
+	}
+	return f.data, f.err
+}
+
+// Stats returns the counters of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	entries, size := len(c.entries), c.size
+	c.mu.Unlock()
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Shared:    c.shared.Load(),
+		Evictions: c.evictions.Load(),
+		Corrupt:   c.corrupt.Load(),
+		Entries:   entries,
+		Bytes:     size,
+	}
+}
@@ -0,0 +1,234 @@
+package cache_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/cache"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+	"github.com/jinzhongmin/gofreeimage/pkg/transform"
+)
+
+func open(t *testing.T, dir string, max int64) *cache.Cache {
+	t.Helper()
+	c, err := cache.Open(cache.Options{Dir: dir, MaxBytes: max})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func parse(t *testing.T, path string) *transform.Spec {
+	t.Helper()
+	spec, _, err := transform.Parse(path, transform.DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func key(c *cache.Cache, i int) string {
+	return c.Key([]byte{byte(i)}, &transform.Spec{}, freeimage.FIF_PNG, 0)
+}
+
+func TestKey(t *testing.T) {
+	c := open(t, t.TempDir(), 0)
+	src := []byte("source")
+	a := c.Key(src, parse(t, "/resize:fit:100:0/quality:80/x.jpg"), freeimage.FIF_JPEG, 80)
+	if b := c.Key(src, parse(t, "/q:80/rs:fit:100:0/x.jpg"), freeimage.FIF_JPEG, 80); a != b {
+		t.Errorf("equivalent specs have the keys %s and %s", a, b)
+	}
+	spec := parse(t, "/rs:fit:100:0/q:80/x.jpg")
+	for _, k := range []string{
+		c.Key([]byte("other"), spec, freeimage.FIF_JPEG, 80),
+		c.Key(src, parse(t, "/rs:fit:101:0/q:80/x.jpg"), freeimage.FIF_JPEG, 80),
+		c.Key(src, spec, freeimage.FIF_WEBP, 80),
+		c.Key(src, spec, freeimage.FIF_JPEG, 0),
+	} {
+		if k == a {
+			t.Errorf("different derivatives have the key %s", k)
+		}
+	}
+
+	fitest.NewStub(t)
+	crc, err := cache.Open(cache.Options{Dir: t.TempDir(), Hash: cache.CRC32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := crc.Key(src, spec, freeimage.FIF_JPEG, 80); k == c.Key(src, spec, freeimage.FIF_JPEG, 80) || len(k) != 64 {
+		t.Errorf("CRC32 key = %s", k)
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, 0)
+	k := key(c, 1)
+	if _, ok := c.Get(k); ok {
+		t.Fatal("Get on an empty cache hit")
+	}
+	if err := c.Put(k, []byte("derivative")); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := c.Get(k); !ok || string(data) != "derivative" {
+		t.Fatalf("Get = %q, %v", data, ok)
+	}
+	if err := c.Put("../../etc/passwd", nil); err == nil {
+		t.Error("Put with a path as key succeeded")
+	}
+
+	// the entries survive reopening
+	if data, ok := open(t, dir, 0).Get(k); !ok || string(data) != "derivative" {
+		t.Errorf("Get after Open = %q, %v", data, ok)
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 1 || s.Entries != 1 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	c := open(t, dir, 0)
+	k := key(c, 1)
+	c.Put(k, []byte("derivative"))
+	path := filepath.Join(dir, k[:2], k)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 1
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := c.Get(k); ok {
+		t.Fatalf("Get of a corrupt entry = %q", data)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the corrupt entry was not removed: %v", err)
+	}
+	if s := c.Stats(); s.Corrupt != 1 || s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte{1}, 64)
+	entry := int64(4 + 32 + len(data))
+	c := open(t, dir, 3*entry)
+	for i := 0; i < 3; i++ {
+		c.Put(key(c, i), data)
+	}
+	c.Get(key(c, 0)) // 1 is now the least recently used
+	c.Put(key(c, 3), data)
+	for i, want := range []bool{true, false, true, true} {
+		if _, ok := c.Get(key(c, i)); ok != want {
+			t.Errorf("entry %d cached %v, want %v", i, ok, want)
+		}
+	}
+	if s := c.Stats(); s.Evictions != 1 || s.Bytes != 3*entry {
+		t.Errorf("Stats() = %+v", s)
+	}
+	if err := c.Put(key(c, 4), make([]byte, 3*entry)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(key(c, 4)); ok {
+		t.Error("an entry over MaxBytes was stored")
+	}
+
+	// reopening with a smaller size evicts the oldest files
+	old := time.Now().Add(-time.Hour)
+	p := filepath.Join(dir, key(c, 2)[:2], key(c, 2))
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatal(err)
+	}
+	c = open(t, dir, 2*entry)
+	if _, ok := c.Get(key(c, 2)); ok {
+		t.Error("the oldest entry survived reopening")
+	}
+	if s := c.Stats(); s.Entries != 2 {
+		t.Errorf("%d entries after reopening, want 2", s.Entries)
+	}
+}
+
+func TestDo(t *testing.T) {
+	c := open(t, t.TempDir(), 0)
+	k := key(c, 1)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	render := func() ([]byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte("derivative"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := c.Do(k, render); err != nil || string(data) != "derivative" {
+				t.Errorf("Do = %q, %v", data, err)
+			}
+		}()
+	}
+	for c.Stats().Shared < 7 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("rendered %d times, want 1", n)
+	}
+	if _, err := c.Do(k, render); err != nil || calls.Load() != 1 {
+		t.Errorf("Do of a cached key rendered, %v", err)
+	}
+
+	fail := fmt.Errorf("render failed")
+	k2 := key(c, 2)
+	if _, err := c.Do(k2, func() ([]byte, error) { return nil, fail }); err != fail {
+		t.Errorf("Do = %v, want the render error", err)
+	}
+	if _, ok := c.Get(k2); ok {
+		t.Error("a failed render was cached")
+	}
+
+	// a panicking render fails the waiting calls instead of returning nil
+	k3 := key(c, 3)
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := c.Do(k3, func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("render bug")
+		})
+		done <- err
+	}()
+	<-started
+	shared := c.Stats().Shared
+	go func() {
+		_, err := c.Do(k3, render)
+		done <- err
+	}()
+	for c.Stats().Shared == shared {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err == nil || !strings.Contains(err.Error(), "render bug") {
+			t.Errorf("Do of a panicking render = %v, want its panic", err)
+		}
+	}
+	if _, ok := c.Get(k3); ok {
+		t.Error("a panicking render was cached")
+	}
+}
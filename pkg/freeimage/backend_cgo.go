@@ -0,0 +1,109 @@
+//go:build freeimage_cgo
+
+// The freeimage_cgo build tag links FreeImage into the executable. The
+// backend is partial: only the pixel and bitmap info accessors are called
+// directly through cgo, the rest of the API keeps going through libffi but
+// resolves its symbols in the executable, so Open("") needs no shared
+// library search.
+//
+// Dynamic linking against libfreeimage is the default. Add the
+// freeimage_static tag to link libfreeimage.a instead, its symbols are
+// exported from the executable for the libffi calls. cgo doesn't allow the
+// whole archive linker flags this needs by default:
+//
+//	CGO_LDFLAGS_ALLOW='-Wl,--(no-)?whole-archive' go build -tags freeimage_cgo,freeimage_static
+//
+// Use CGO_CFLAGS and CGO_LDFLAGS for libraries outside the default paths.
+
+package freeimage
+
+/*
+#cgo !freeimage_static LDFLAGS: -lfreeimage
+#cgo freeimage_static LDFLAGS: -Wl,--export-dynamic -Wl,--whole-archive -l:libfreeimage.a -Wl,--no-whole-archive -lstdc++ -lm
+
+// declared here rather than including FreeImage.h, which may be missing
+// when only the library is installed
+#include <stdint.h>
+
+typedef struct FIBITMAP FIBITMAP;
+typedef int32_t BOOL;
+typedef uint8_t BYTE;
+typedef struct { BYTE rgbBlue, rgbGreen, rgbRed, rgbReserved; } RGBQUAD;
+
+BYTE *FreeImage_GetBits(FIBITMAP *dib);
+BYTE *FreeImage_GetScanLine(FIBITMAP *dib, int scanline);
+BOOL FreeImage_GetPixelIndex(FIBITMAP *dib, unsigned x, unsigned y, BYTE *value);
+BOOL FreeImage_GetPixelColor(FIBITMAP *dib, unsigned x, unsigned y, RGBQUAD *value);
+BOOL FreeImage_SetPixelIndex(FIBITMAP *dib, unsigned x, unsigned y, BYTE *value);
+BOOL FreeImage_SetPixelColor(FIBITMAP *dib, unsigned x, unsigned y, RGBQUAD *value);
+unsigned FreeImage_GetBPP(FIBITMAP *dib);
+unsigned FreeImage_GetWidth(FIBITMAP *dib);
+unsigned FreeImage_GetHeight(FIBITMAP *dib);
+unsigned FreeImage_GetLine(FIBITMAP *dib);
+unsigned FreeImage_GetPitch(FIBITMAP *dib);
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/jinzhongmin/goffi/pkg/c"
+)
+
+// Backend is how the package calls FreeImage: "ffi" through libffi on a
+// library loaded at run time, or "cgo" with the freeimage_cgo build tag,
+// which only calls the pixel and bitmap info accessors through cgo.
+const Backend = "cgo"
+
+const linkedPath = "(linked)"
+
+func init() {
+	// the package functions work without Open, as with a linked C library,
+	// and InitLib keeps the linked library for programs that load one
+	if l, err := Open(""); err == nil {
+		SetDefault(l)
+	}
+}
+
+// linkedLib returns the symbols of the executable, FreeImage is linked in.
+func linkedLib() *c.Lib { return c.NewLibDefault() }
+
+// direct reports whether the hot accessors may call the linked library
+// directly: only while it is the default, bitmaps of a library opened from
+// a path, e.g. the test stub, go through it.
+func direct() bool {
+	l := fiLib.p.Load()
+	return l != nil && l.linked
+}
+
+func fib(dib *BitMap) *C.FIBITMAP { return (*C.FIBITMAP)(unsafe.Pointer(dib)) }
+
+func cGetBits(dib *BitMap) unsafe.Pointer {
+	return unsafe.Pointer(C.FreeImage_GetBits(fib(dib)))
+}
+
+func cGetScanLine(dib *BitMap, scanline int32) unsafe.Pointer {
+	return unsafe.Pointer(C.FreeImage_GetScanLine(fib(dib), C.int(scanline)))
+}
+
+func cGetPixelIndex(dib *BitMap, x, y uint32, p unsafe.Pointer) bool {
+	return C.FreeImage_GetPixelIndex(fib(dib), C.unsigned(x), C.unsigned(y), (*C.BYTE)(p)) != 0
+}
+
+func cGetPixelColor(dib *BitMap, x, y uint32, value *RGBQUAD) bool {
+	return C.FreeImage_GetPixelColor(fib(dib), C.unsigned(x), C.unsigned(y), (*C.RGBQUAD)(unsafe.Pointer(value))) != 0
+}
+
+func cSetPixelIndex(dib *BitMap, x, y uint32, p unsafe.Pointer) bool {
+	return C.FreeImage_SetPixelIndex(fib(dib), C.unsigned(x), C.unsigned(y), (*C.BYTE)(p)) != 0
+}
+
+func cSetPixelColor(dib *BitMap, x, y uint32, value *RGBQUAD) bool {
+	return C.FreeImage_SetPixelColor(fib(dib), C.unsigned(x), C.unsigned(y), (*C.RGBQUAD)(unsafe.Pointer(value))) != 0
+}
+
+func cGetBPP(dib *BitMap) uint32    { return uint32(C.FreeImage_GetBPP(fib(dib))) }
+func cGetWidth(dib *BitMap) uint32  { return uint32(C.FreeImage_GetWidth(fib(dib))) }
+func cGetHeight(dib *BitMap) uint32 { return uint32(C.FreeImage_GetHeight(fib(dib))) }
+func cGetLine(dib *BitMap) uint32   { return uint32(C.FreeImage_GetLine(fib(dib))) }
+func cGetPitch(dib *BitMap) uint32  { return uint32(C.FreeImage_GetPitch(fib(dib))) }
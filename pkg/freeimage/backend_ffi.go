@@ -0,0 +1,36 @@
+//go:build !freeimage_cgo
+
+package freeimage
+
+import (
+	"unsafe"
+
+	"github.com/jinzhongmin/goffi/pkg/c"
+)
+
+// Backend is how the package calls FreeImage: "ffi" through libffi on a
+// library loaded at run time, or "cgo" with the freeimage_cgo build tag,
+// which only calls the pixel and bitmap info accessors through cgo.
+const Backend = "ffi"
+
+const linkedPath = ""
+
+// linkedLib returns the library linked into the executable, there is none
+// without cgo.
+func linkedLib() *c.Lib { return nil }
+
+// direct reports whether the hot accessors may call the linked library
+// directly, never without cgo. The c* functions are not reached.
+func direct() bool { return false }
+
+func cGetBits(dib *BitMap) unsafe.Pointer                            { return nil }
+func cGetScanLine(dib *BitMap, scanline int32) unsafe.Pointer        { return nil }
+func cGetPixelIndex(dib *BitMap, x, y uint32, p unsafe.Pointer) bool { return false }
+func cGetPixelColor(dib *BitMap, x, y uint32, value *RGBQUAD) bool   { return false }
+func cSetPixelIndex(dib *BitMap, x, y uint32, p unsafe.Pointer) bool { return false }
+func cSetPixelColor(dib *BitMap, x, y uint32, value *RGBQUAD) bool   { return false }
+func cGetBPP(dib *BitMap) uint32                                     { return 0 }
+func cGetWidth(dib *BitMap) uint32                                   { return 0 }
+func cGetHeight(dib *BitMap) uint32                                  { return 0 }
+func cGetLine(dib *BitMap) uint32                                    { return 0 }
+func cGetPitch(dib *BitMap) uint32                                   { return 0 }
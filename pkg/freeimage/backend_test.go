@@ -0,0 +1,34 @@
+package freeimage_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestCgoBackendVet type checks the package with the freeimage_cgo tag,
+// which the default build never compiles. It needs cgo and a C compiler,
+// but not libfreeimage, since vet doesn't link.
+func TestCgoBackendVet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go vet")
+	}
+	goTool := filepath.Join(runtime.GOROOT(), "bin", "go")
+	if _, err := exec.LookPath(goTool); err != nil {
+		t.Skip(err)
+	}
+	if out, err := exec.Command(goTool, "env", "CGO_ENABLED").Output(); err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Skip("cgo is disabled")
+	}
+	for _, tags := range []string{"freeimage_cgo", "freeimage_cgo,freeimage_static"} {
+		cmd := exec.Command(goTool, "vet", "-tags", tags, ".")
+		cmd.Env = append(os.Environ(), "CGO_LDFLAGS_ALLOW=-Wl,--(no-)?whole-archive")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("go vet -tags %s: %v\n%s", tags, err, out)
+		}
+	}
+}
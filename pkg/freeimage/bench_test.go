@@ -0,0 +1,109 @@
+package freeimage_test
+
+import (
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+// The benchmarks compare the backends, run them with and without the
+// freeimage_cgo tag and feed both outputs to benchstat:
+//
+//	go test -run - -bench . -count 10 > ffi.txt
+//	go test -tags freeimage_cgo -run - -bench . -count 10 > cgo.txt
+//	benchstat ffi.txt cgo.txt
+
+func benchBitmap(b *testing.B) *fi.BitMap {
+	b.Helper()
+	fitest.Auto(b)
+	dib := fi.Allocate(256, 256, 32, 0, 0, 0)
+	if dib == nil {
+		b.Fatal("Allocate returned nil")
+	}
+	b.Cleanup(dib.Unload)
+	b.ResetTimer()
+	return dib
+}
+
+func BenchmarkGetWidth(b *testing.B) {
+	dib := benchBitmap(b)
+	for i := 0; i < b.N; i++ {
+		dib.GetWidth()
+	}
+}
+
+func BenchmarkGetScanLine(b *testing.B) {
+	dib := benchBitmap(b)
+	for i := 0; i < b.N; i++ {
+		dib.GetScanLine(int32(i & 255))
+	}
+}
+
+func BenchmarkGetPixelColor(b *testing.B) {
+	dib := benchBitmap(b)
+	for i := 0; i < b.N; i++ {
+		dib.GetPixelColor(uint32(i&255), uint32(i>>8&255))
+	}
+}
+
+func BenchmarkSetPixelColor(b *testing.B) {
+	dib := benchBitmap(b)
+	c := fi.RGBQUAD{1, 2, 3, 4}
+	for i := 0; i < b.N; i++ {
+		dib.SetPixelColor(uint32(i&255), uint32(i>>8&255), &c)
+	}
+}
+
+// BenchmarkImage reads every pixel of the bitmap, per op.
+func BenchmarkImage(b *testing.B) {
+	dib := benchBitmap(b)
+	for i := 0; i < b.N; i++ {
+		w, h := dib.GetWidth(), dib.GetHeight()
+		for y := uint32(0); y < h; y++ {
+			for x := uint32(0); x < w; x++ {
+				dib.GetPixelColor(x, y)
+			}
+		}
+	}
+}
+
+// The region benchmarks do the work of BenchmarkImage in Go on the pixel
+// buffer.
+
+func BenchmarkReadRegion(b *testing.B) {
+	dib := benchBitmap(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := dib.ReadRegion(dib.Bounds()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkForEachPixel(b *testing.B) {
+	dib := benchBitmap(b)
+	for i := 0; i < b.N; i++ {
+		dib.ForEachPixel(func(x, y int, px []byte) {})
+	}
+}
+
+func BenchmarkFillRect(b *testing.B) {
+	dib := benchBitmap(b)
+	for i := 0; i < b.N; i++ {
+		dib.FillRect(dib.Bounds(), fi.RGBQUAD{1, 2, 3, 4})
+	}
+}
+
+// BenchmarkSetPixelColorImage is FillRect with a call per pixel.
+func BenchmarkSetPixelColorImage(b *testing.B) {
+	dib := benchBitmap(b)
+	c := fi.RGBQUAD{1, 2, 3, 4}
+	for i := 0; i < b.N; i++ {
+		w, h := dib.GetWidth(), dib.GetHeight()
+		for y := uint32(0); y < h; y++ {
+			for x := uint32(0); x < w; x++ {
+				dib.SetPixelColor(x, y, &c)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package freeimage
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/jinzhongmin/usf"
+)
+
+var (
+	ErrUnknownFormat = errors.New("freeimage: unknown image format")
+	ErrDecode        = errors.New("freeimage: decode failed")
+	ErrEncode        = errors.New("freeimage: encode failed")
+)
+
+// DecodeOptions configures Decode. The zero options detect the format from
+// the data.
+type DecodeOptions struct {
+	Format FREE_IMAGE_FORMAT // used instead of detection if Force is set
+	Force  bool
+	Flags  int32
+	Limits *LoadLimits // nil accepts any image
+}
+
+// format returns the forced format, FIF_UNKNOWN to detect it.
+func (o *DecodeOptions) format() FREE_IMAGE_FORMAT {
+	if !o.Force {
+		return FIF_UNKNOWN
+	}
+	return o.Format
+}
+
+// EncodeOptions configures Encode.
+type EncodeOptions struct {
+	Flags int32
+}
+
+// cMemory is a memory stream over a C copy of a Go slice, so no Go memory
+// is referenced by C while the stream is open.
+type cMemory struct {
+	*Memory
+	buf unsafe.Pointer
+}
+
+func openCMemory(data []byte) *cMemory {
+	buf := usf.Malloc(uint64(len(data)))
+	copy(unsafe.Slice((*byte)(buf), len(data)), data)
+	size := uint32(len(data))
+	mem := (*Memory)(fiLib.Call(_func_FreeImage_OpenMemory_, inArgs{&buf, &size}).PtrFree())
+	if mem == nil {
+		usf.Free(buf)
+		return nil
+	}
+	return &cMemory{Memory: mem, buf: buf}
+}
+
+func (m *cMemory) close() {
+	m.CloseMemory()
+	usf.Free(m.buf)
+}
+
+// DetectFormat returns the format of an encoded image, FIF_UNKNOWN if the
+// library doesn't recognize it.
+func DetectFormat(data []byte) FREE_IMAGE_FORMAT {
+	if len(data) == 0 {
+		return FIF_UNKNOWN
+	}
+	mem := openCMemory(data)
+	if mem == nil {
+		return FIF_UNKNOWN
+	}
+	defer mem.close()
+	return mem.GetFileType()
+}
+
+// Decode decodes an image from data. The data is copied to C memory for
+// the decode, so data can be reused as soon as Decode returns. A nil opts
+// detects the format and loads with default flags. With opts.Limits, images
+// over the limits fail with a *LimitError or a *FormatError, see
+// LoadLimits.
+func Decode(data []byte, opts *DecodeOptions) (*BitMap, error) {
+	if opts == nil {
+		opts = &DecodeOptions{}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty data", ErrDecode)
+	}
+	mem := openCMemory(data)
+	if mem == nil {
+		return nil, fmt.Errorf("%w: can't open memory stream", ErrDecode)
+	}
+	defer mem.close()
+
+	fif := opts.format()
+	if fif == FIF_UNKNOWN {
+		if fif = mem.GetFileType(); fif == FIF_UNKNOWN {
+			return nil, ErrUnknownFormat
+		}
+	}
+	if !FIFSupportsReading(fif) {
+		return nil, fmt.Errorf("%w: %s can't be read", ErrUnknownFormat, GetFormatFromFIF(fif))
+	}
+	probed, err := opts.Limits.probe(fif, func(f int32) *BitMap {
+		defer mem.SeekMemory(0, SEEK_SET)
+		return LoadFromMemory(fif, mem.Memory, opts.Flags|f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	dib := LoadFromMemory(fif, mem.Memory, opts.Flags)
+	if dib == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, GetFormatFromFIF(fif))
+	}
+	return opts.Limits.loaded(dib, probed)
+}
+
+// Encode encodes dib as fif and returns the encoded bytes. opts may be nil.
+func Encode(dib *BitMap, fif FREE_IMAGE_FORMAT, opts *EncodeOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if dib == nil {
+		return nil, fmt.Errorf("%w: nil bitmap", ErrEncode)
+	}
+	if !FIFSupportsWriting(fif) {
+		return nil, fmt.Errorf("%w: %s can't be written", ErrEncode, GetFormatFromFIF(fif))
+	}
+	if t := dib.GetImageType(); t == FIT_BITMAP {
+		if !FIFSupportsExportBPP(fif, int32(dib.GetBPP())) {
+			return nil, fmt.Errorf("%w: %s can't store %d bpp images", ErrEncode, GetFormatFromFIF(fif), dib.GetBPP())
+		}
+	} else if !FIFSupportsExportType(fif, t) {
+		return nil, fmt.Errorf("%w: %s can't store image type %d", ErrEncode, GetFormatFromFIF(fif), t)
+	}
+
+	mem := OpenMemory(nil)
+	if mem == nil {
+		return nil, fmt.Errorf("%w: can't open memory stream", ErrEncode)
+	}
+	defer mem.CloseMemory()
+	if !dib.SaveToMemory(fif, mem, opts.Flags) {
+		return nil, fmt.Errorf("%w: %s", ErrEncode, GetFormatFromFIF(fif))
+	}
+	return mem.Bytes(), nil
+}
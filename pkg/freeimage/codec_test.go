@@ -0,0 +1,96 @@
+package freeimage_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func TestDecode(t *testing.T) {
+	s := fitest.NewStub(t)
+	data := fitest.Bytes(3, 2, 24, func(x, y, ch int) byte { return byte(x + y + ch) })
+
+	if fif := fi.DetectFormat(data); fif != fitest.FIF_STUB {
+		t.Errorf("DetectFormat = %d", fif)
+	}
+	if fif := fi.DetectFormat(nil); fif != fi.FIF_UNKNOWN {
+		t.Errorf("DetectFormat(nil) = %d", fif)
+	}
+
+	for name, opts := range map[string]*fi.DecodeOptions{
+		"nil":    nil,
+		"zero":   {},
+		"forced": {Format: fitest.FIF_STUB, Force: true},
+		"unused": {Format: fi.FIF_PNG}, // ignored without Force
+	} {
+		dib, err := fi.Decode(data, opts)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if dib.GetWidth() != 3 || dib.GetHeight() != 2 || dib.GetBPP() != 24 {
+			t.Errorf("%s: %dx%dx%d", name, dib.GetWidth(), dib.GetHeight(), dib.GetBPP())
+		}
+		dib.Unload()
+	}
+
+	for name, tc := range map[string]struct {
+		data []byte
+		opts *fi.DecodeOptions
+		err  error
+	}{
+		"empty":      {nil, nil, fi.ErrDecode},
+		"unknown":    {[]byte("not an image"), nil, fi.ErrUnknownFormat},
+		"truncated":  {data[:20], nil, fi.ErrDecode},
+		"forced bmp": {data, &fi.DecodeOptions{Format: fi.FIF_BMP, Force: true}, fi.ErrUnknownFormat},
+		"limits":     {data, &fi.DecodeOptions{Limits: &fi.LoadLimits{MaxWidth: 2}}, fi.ErrTooLarge},
+	} {
+		if dib, err := fi.Decode(tc.data, tc.opts); !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v, %v, want %v", name, dib, err, tc.err)
+		}
+	}
+	if s.Live() != 0 {
+		t.Errorf("%d live", s.Live())
+	}
+}
+
+func TestEncode(t *testing.T) {
+	s := fitest.NewStub(t)
+	data := fitest.Bytes(3, 2, 24, func(x, y, ch int) byte { return byte(x + y + ch) })
+	dib, err := fi.Decode(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dib.Unload()
+
+	b, err := fi.Encode(dib, fitest.FIF_STUB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("Encode = % x, want % x", b, data)
+	}
+
+	for name, tc := range map[string]struct {
+		dib *fi.BitMap
+		fif fi.FREE_IMAGE_FORMAT
+	}{
+		"nil":     {nil, fitest.FIF_STUB},
+		"no save": {dib, fi.FIF_PNG},
+	} {
+		if _, err := fi.Encode(tc.dib, tc.fif, nil); !errors.Is(err, fi.ErrEncode) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	s.Fail("SaveToMemory")
+	if _, err := fi.Encode(dib, fitest.FIF_STUB, &fi.EncodeOptions{}); !errors.Is(err, fi.ErrEncode) {
+		t.Errorf("failed save: %v", err)
+	}
+	if s.Live() != 1 {
+		t.Errorf("%d live, want the bitmap only", s.Live())
+	}
+}
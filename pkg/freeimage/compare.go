@@ -0,0 +1,408 @@
+package freeimage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+var (
+	ErrSizeMismatch = errors.New("freeimage: images have different sizes")
+	ErrQuality      = errors.New("freeimage: no quality reaches the target")
+)
+
+// jndDeltaE is the CIELAB distance of a just noticeable difference.
+const jndDeltaE = 2.3
+
+// ChannelMetrics are the differences of one channel, on values scaled to
+// [0, 1].
+type ChannelMetrics struct {
+	MSE  float64
+	PSNR float64 // dB, +Inf for identical channels
+	SSIM float64 // 1 for identical channels
+
+	// Perceptual is the largest local difference relative to what the
+	// local contrast of the first image masks, below 1 it should not be
+	// visible.
+	Perceptual float64
+}
+
+// Comparison is the result of Compare.
+type Comparison struct {
+	Red, Green, Blue, Alpha ChannelMetrics
+
+	// Over the color channels: MSE and PSNR of all of them, SSIM weighted
+	// by the luma contribution of each channel.
+	MSE  float64
+	PSNR float64
+	SSIM float64
+
+	// Perceptual approximates butteraugli: the largest CIELAB distance of
+	// the 3x3 blurred images in just noticeable differences. Below 1 the
+	// images should look the same, above 3 the difference is obvious.
+	Perceptual float64
+}
+
+// Compare measures the difference between two images of the same size.
+// Both are converted to FIT_RGBAF with ConvertToType first, so any bitmap
+// type and depth ConvertToType supports can be compared, including a
+// FIT_BITMAP against a FIT_RGB16 or FIT_RGBF version of it. Float images
+// are compared as they are, values above 1 are not clipped.
+func Compare(a, b *BitMap) (*Comparison, error) {
+	pa, pb, err := comparePlanes(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := &Comparison{}
+	channels := []*ChannelMetrics{&cmp.Red, &cmp.Green, &cmp.Blue, &cmp.Alpha}
+	for i, m := range channels {
+		x, y := pa.ch[i], pb.ch[i]
+		m.MSE = mse(x, y)
+		m.PSNR = psnr(m.MSE)
+		m.SSIM = ssim(x, y, pa.w, pa.h)
+		m.Perceptual = maskedDiff(x, y, pa.w, pa.h)
+	}
+	cmp.MSE = (cmp.Red.MSE + cmp.Green.MSE + cmp.Blue.MSE) / 3
+	cmp.PSNR = psnr(cmp.MSE)
+	cmp.SSIM = 0.299*cmp.Red.SSIM + 0.587*cmp.Green.SSIM + 0.114*cmp.Blue.SSIM
+	for _, d := range blur3(deltaE(pa, pb), pa.w, pa.h) {
+		cmp.Perceptual = math.Max(cmp.Perceptual, d/jndDeltaE)
+	}
+	return cmp, nil
+}
+
+// DiffBitMap returns a 24 bit visualization of the difference between a
+// and b: a dimmed grey version of a, tinted green where the difference is
+// below a just noticeable difference and red, brighter with the distance,
+// where it is visible. The caller owns the returned bitmap.
+func DiffBitMap(a, b *BitMap) (*BitMap, error) {
+	pa, pb, err := comparePlanes(a, b)
+	if err != nil {
+		return nil, err
+	}
+	d := blur3(deltaE(pa, pb), pa.w, pa.h)
+
+	out := AllocateT(FIT_RGB16, int32(pa.w), int32(pa.h), 48, 0, 0, 0)
+	if out == nil {
+		return nil, fmt.Errorf("%w: AllocateT", ErrOpFailed)
+	}
+	defer out.Unload()
+	for y := 0; y < pa.h; y++ {
+		line := unsafe.Slice((*uint16)(out.GetScanLine(int32(y))), 3*pa.w)
+		for x := 0; x < pa.w; x++ {
+			i := y*pa.w + x
+			grey := 0.3 * clamp01(0.299*pa.ch[0][i]+0.587*pa.ch[1][i]+0.114*pa.ch[2][i])
+			r, g, b := grey, grey, grey
+			if jnd := d[i] / jndDeltaE; jnd >= 1 {
+				r = clamp01(0.5 + jnd/6)
+			} else if jnd > 0.1 {
+				g = clamp01(grey + 0.5*jnd)
+			}
+			line[3*x], line[3*x+1], line[3*x+2] = uint16(r*65535), uint16(g*65535), uint16(b*65535)
+		}
+	}
+	dib := out.ConvertTo24Bits()
+	if dib == nil {
+		return nil, fmt.Errorf("%w: ConvertTo24Bits", ErrOpFailed)
+	}
+	return dib, nil
+}
+
+// QualityOptions configures SearchQuality.
+type QualityOptions struct {
+	Min, Max int   // quality range, 1 and 100 if 0
+	Flags    int32 // extra save flags, or'ed with the quality
+}
+
+// QualityResult is the outcome of SearchQuality.
+type QualityResult struct {
+	Quality    int
+	Data       []byte // dib encoded at Quality
+	Comparison *Comparison
+}
+
+// SearchQuality binary searches the lowest save quality of fif whose
+// decoded result has an SSIM of at least target against dib, e.g. 0.98.
+// It is meant for the formats taking the quality as save flags, JPEG,
+// WebP and JPEG-XR, and assumes the SSIM grows with the quality. It fails
+// with ErrQuality if even the highest quality misses the target.
+func SearchQuality(dib *BitMap, fif FREE_IMAGE_FORMAT, target float64, opts *QualityOptions) (*QualityResult, error) {
+	if opts == nil {
+		opts = &QualityOptions{}
+	}
+	lo, hi := opts.Min, opts.Max
+	if lo <= 0 {
+		lo = 1
+	}
+	if hi <= 0 || hi > 100 {
+		hi = 100
+	}
+	if lo > hi {
+		return nil, fmt.Errorf("freeimage: bad quality range %d-%d", lo, hi)
+	}
+
+	try := func(q int) (*QualityResult, error) {
+		data, err := Encode(dib, fif, &EncodeOptions{Flags: opts.Flags | int32(q)})
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := Decode(data, &DecodeOptions{Format: fif, Force: true})
+		if err != nil {
+			return nil, err
+		}
+		defer decoded.Unload()
+		cmp, err := Compare(dib, decoded)
+		if err != nil {
+			return nil, err
+		}
+		return &QualityResult{Quality: q, Data: data, Comparison: cmp}, nil
+	}
+
+	best, err := try(hi)
+	if err != nil {
+		return nil, err
+	}
+	if best.Comparison.SSIM < target {
+		return best, fmt.Errorf("%w: SSIM %.4f at quality %d, want %.4f", ErrQuality, best.Comparison.SSIM, hi, target)
+	}
+	hi--
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		r, err := try(mid)
+		if err != nil {
+			return nil, err
+		}
+		if r.Comparison.SSIM >= target {
+			best, hi = r, mid-1
+		} else {
+			lo = mid + 1
+		}
+	}
+	return best, nil
+}
+
+// planes --------------------------------------------------------------------
+
+// planes holds the R, G, B and A channels of an image, row by row.
+type planes struct {
+	w, h int
+	ch   [4][]float64
+}
+
+func comparePlanes(a, b *BitMap) (*planes, *planes, error) {
+	if a == nil || b == nil {
+		return nil, nil, fmt.Errorf("%w: nil bitmap", ErrOpFailed)
+	}
+	if a.GetWidth() != b.GetWidth() || a.GetHeight() != b.GetHeight() {
+		return nil, nil, fmt.Errorf("%w: %dx%d and %dx%d", ErrSizeMismatch, a.GetWidth(), a.GetHeight(), b.GetWidth(), b.GetHeight())
+	}
+	pa, err := toPlanes(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	pb, err := toPlanes(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pa, pb, nil
+}
+
+// toPlanes reads dib through a FIT_RGBAF copy, low depth bitmaps are
+// expanded to 32 bit first since ConvertToType only takes 24 and 32 bit.
+func toPlanes(dib *BitMap) (*planes, error) {
+	src := dib
+	if dib.GetImageType() == FIT_BITMAP && dib.GetBPP() < 24 {
+		if src = dib.ConvertTo32Bits(); src == nil {
+			return nil, fmt.Errorf("%w: ConvertTo32Bits", ErrOpFailed)
+		}
+		defer src.Unload()
+	}
+	f := src.ConvertToType(FIT_RGBAF, true)
+	if f == nil {
+		return nil, fmt.Errorf("%w: can't convert image type %d to FIT_RGBAF", ErrOpFailed, dib.GetImageType())
+	}
+	defer f.Unload()
+
+	w, h := int(f.GetWidth()), int(f.GetHeight())
+	p := &planes{w: w, h: h}
+	for i := range p.ch {
+		p.ch[i] = make([]float64, w*h)
+	}
+	for y := 0; y < h; y++ {
+		line := unsafe.Slice((*float32)(f.GetScanLine(int32(y))), 4*w)
+		for x := 0; x < w; x++ {
+			for i := range p.ch {
+				p.ch[i][y*w+x] = float64(line[4*x+i])
+			}
+		}
+	}
+	return p, nil
+}
+
+// metrics -------------------------------------------------------------------
+
+func mse(x, y []float64) float64 {
+	if len(x) == 0 {
+		return 0
+	}
+	var sum float64
+	for i := range x {
+		d := x[i] - y[i]
+		sum += d * d
+	}
+	return sum / float64(len(x))
+}
+
+func psnr(mse float64) float64 {
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(1/mse)
+}
+
+// ssim is the mean SSIM of 8x8 windows every 4 pixels, the whole image
+// being one window when it is smaller.
+func ssim(x, y []float64, w, h int) float64 {
+	const (
+		c1 = 0.01 * 0.01
+		c2 = 0.03 * 0.03
+	)
+	win, step := 8, 4
+	if w < win || h < win {
+		win, step = minInt(w, h), 1<<30
+	}
+	if win == 0 {
+		return 1
+	}
+
+	var sum float64
+	var n int
+	for top := 0; top+win <= h; top += step {
+		for left := 0; left+win <= w; left += step {
+			var mx, my float64
+			for j := top; j < top+win; j++ {
+				for i := left; i < left+win; i++ {
+					mx += x[j*w+i]
+					my += y[j*w+i]
+				}
+			}
+			k := float64(win * win)
+			mx, my = mx/k, my/k
+			var vx, vy, cov float64
+			for j := top; j < top+win; j++ {
+				for i := left; i < left+win; i++ {
+					dx, dy := x[j*w+i]-mx, y[j*w+i]-my
+					vx += dx * dx
+					vy += dy * dy
+					cov += dx * dy
+				}
+			}
+			if k > 1 {
+				vx, vy, cov = vx/(k-1), vy/(k-1), cov/(k-1)
+			}
+			sum += (2*mx*my + c1) * (2*cov + c2) / ((mx*mx + my*my + c1) * (vx + vy + c2))
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+// maskedDiff is the largest 3x3 blurred difference over the local
+// contrast threshold of x, 0.02 on flat areas.
+func maskedDiff(x, y []float64, w, h int) float64 {
+	d := make([]float64, len(x))
+	for i := range x {
+		d[i] = math.Abs(x[i] - y[i])
+	}
+	d = blur3(d, w, h)
+	mean := blur3(x, w, h)
+	sq := make([]float64, len(x))
+	for i := range x {
+		sq[i] = x[i] * x[i]
+	}
+	sq = blur3(sq, w, h)
+
+	var worst float64
+	for i := range d {
+		std := math.Sqrt(math.Max(0, sq[i]-mean[i]*mean[i]))
+		worst = math.Max(worst, d[i]/(0.02+0.5*std))
+	}
+	return worst
+}
+
+// blur3 is a 3x3 box blur, clamped at the edges.
+func blur3(v []float64, w, h int) []float64 {
+	out := make([]float64, len(v))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum float64
+			var n int
+			for j := maxInt(0, y-1); j <= minInt(h-1, y+1); j++ {
+				for i := maxInt(0, x-1); i <= minInt(w-1, x+1); i++ {
+					sum += v[j*w+i]
+					n++
+				}
+			}
+			out[y*w+x] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+// deltaE returns the CIE76 distance of every pixel, both images seen over
+// a white background so that the alpha differences count.
+func deltaE(a, b *planes) []float64 {
+	d := make([]float64, a.w*a.h)
+	for i := range d {
+		l1, a1, b1 := lab(a, i)
+		l2, a2, b2 := lab(b, i)
+		d[i] = math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (b1-b2)*(b1-b2))
+	}
+	return d
+}
+
+// lab converts the sRGB pixel i to CIELAB with a D65 white point.
+func lab(p *planes, i int) (float64, float64, float64) {
+	alpha := clamp01(p.ch[3][i])
+	var lin [3]float64
+	for c := range lin {
+		v := p.ch[c][i]*alpha + 1 - alpha
+		if v <= 0.04045 {
+			lin[c] = v / 12.92
+		} else {
+			lin[c] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	x := (0.4124*lin[0] + 0.3576*lin[1] + 0.1805*lin[2]) / 0.95047
+	y := 0.2126*lin[0] + 0.7152*lin[1] + 0.0722*lin[2]
+	z := (0.0193*lin[0] + 0.1192*lin[1] + 0.9505*lin[2]) / 1.08883
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
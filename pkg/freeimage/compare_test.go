@@ -0,0 +1,66 @@
+package freeimage
+
+import (
+	"math"
+	"testing"
+)
+
+func flat(w, h int, r, g, b, a float64) *planes {
+	p := &planes{w: w, h: h}
+	for i, v := range []float64{r, g, b, a} {
+		p.ch[i] = make([]float64, w*h)
+		for j := range p.ch[i] {
+			p.ch[i][j] = v
+		}
+	}
+	return p
+}
+
+func TestMetrics(t *testing.T) {
+	const w, h = 16, 12
+	x := make([]float64, w*h)
+	for i := range x {
+		x[i] = float64(i%w) / w
+	}
+	noisy := make([]float64, len(x))
+	for i := range x {
+		noisy[i] = x[i] + 0.05*float64(i%3-1)
+	}
+
+	if got := ssim(x, x, w, h); math.Abs(got-1) > 1e-9 {
+		t.Errorf("ssim(x, x) = %v, want 1", got)
+	}
+	if got := ssim(x, noisy, w, h); got >= 1 || got < 0.5 {
+		t.Errorf("ssim(x, noisy) = %v, want in [0.5, 1)", got)
+	}
+	if got := ssim(x[:4], x[:4], 2, 2); math.Abs(got-1) > 1e-9 {
+		t.Errorf("ssim of a 2x2 image = %v, want 1", got)
+	}
+	if got := psnr(mse(x, x)); !math.IsInf(got, 1) {
+		t.Errorf("psnr of identical data = %v", got)
+	}
+	if got := psnr(mse(x, noisy)); math.Abs(got-27.78) > 0.1 {
+		t.Errorf("psnr(x, noisy) = %.2f, want about 27.78", got)
+	}
+	if got := maskedDiff(x, x, w, h); got != 0 {
+		t.Errorf("maskedDiff(x, x) = %v", got)
+	}
+}
+
+func TestDeltaE(t *testing.T) {
+	white, black := flat(2, 2, 1, 1, 1, 1), flat(2, 2, 0, 0, 0, 1)
+	if d := deltaE(white, black)[0]; math.Abs(d-100) > 0.1 {
+		t.Errorf("deltaE(white, black) = %.2f, want 100", d)
+	}
+	if d := deltaE(white, white)[0]; d > 1e-6 {
+		t.Errorf("deltaE(white, white) = %v", d)
+	}
+	// transparent black over white is white
+	if d := deltaE(white, flat(2, 2, 0, 0, 0, 0))[0]; d > 1e-6 {
+		t.Errorf("deltaE(white, transparent) = %v", d)
+	}
+	l, a, b := lab(flat(1, 1, 0.5, 0.5, 0.5, 1), 0)
+	if math.Abs(l-53.39) > 0.1 || math.Abs(a) > 0.01 || math.Abs(b) > 0.01 {
+		t.Errorf("lab(grey 0.5) = %.2f %.2f %.2f, want 53.39 0 0", l, a, b)
+	}
+}
@@ -0,0 +1,151 @@
+package freeimage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+var ErrUnsupportedConversion = errors.New("freeimage: unsupported scanline conversion")
+
+// ConvertLineOptions configures ConvertLine.
+type ConvertLineOptions struct {
+	// Src565 and Dst565 select the RGB565 layout for 16 bit lines, RGB555
+	// is used otherwise.
+	Src565, Dst565 bool
+
+	// Transparency is the alpha of each palette entry for the 1, 4 and 8
+	// to 32 bit conversions, see SetTransparencyTable. Entries past its
+	// end are opaque.
+	Transparency []byte
+}
+
+// lineFunc is the common shape of the ConvertLine* routines, palette is
+// ignored by the ones without it.
+type lineFunc func(target, source *byte, width_in_pixels int32, palette *RGBQUAD)
+
+type lineKey struct {
+	dst, src       int
+	dst565, src565 bool
+}
+
+func noPalette(fn func(target, source *byte, width_in_pixels int32)) lineFunc {
+	return func(target, source *byte, width_in_pixels int32, _ *RGBQUAD) {
+		fn(target, source, width_in_pixels)
+	}
+}
+
+// lineFuncs maps the conversions to their routine, 16 bit keys list the
+// 555 and 565 variants.
+var lineFuncs = map[lineKey]lineFunc{
+	{4, 1, false, false}:  noPalette(ConvertLine1To4),
+	{4, 8, false, false}:  ConvertLine8To4,
+	{4, 16, false, false}: noPalette(ConvertLine16To4_555),
+	{4, 16, false, true}:  noPalette(ConvertLine16To4_565),
+	{4, 24, false, false}: noPalette(ConvertLine24To4),
+	{4, 32, false, false}: noPalette(ConvertLine32To4),
+
+	{8, 1, false, false}:  noPalette(ConvertLine1To8),
+	{8, 4, false, false}:  noPalette(ConvertLine4To8),
+	{8, 16, false, false}: noPalette(ConvertLine16To8_555),
+	{8, 16, false, true}:  noPalette(ConvertLine16To8_565),
+	{8, 24, false, false}: noPalette(ConvertLine24To8),
+	{8, 32, false, false}: noPalette(ConvertLine32To8),
+
+	{16, 1, false, false}:  ConvertLine1To16_555,
+	{16, 4, false, false}:  ConvertLine4To16_555,
+	{16, 8, false, false}:  ConvertLine8To16_555,
+	{16, 16, false, true}:  noPalette(ConvertLine16_565_To16_555),
+	{16, 24, false, false}: noPalette(ConvertLine24To16_555),
+	{16, 32, false, false}: noPalette(ConvertLine32To16_555),
+	{16, 1, true, false}:   ConvertLine1To16_565,
+	{16, 4, true, false}:   ConvertLine4To16_565,
+	{16, 8, true, false}:   ConvertLine8To16_565,
+	{16, 16, true, false}:  noPalette(ConvertLine16_555_To16_565),
+	{16, 24, true, false}:  noPalette(ConvertLine24To16_565),
+	{16, 32, true, false}:  noPalette(ConvertLine32To16_565),
+
+	{24, 1, false, false}:  ConvertLine1To24,
+	{24, 4, false, false}:  ConvertLine4To24,
+	{24, 8, false, false}:  ConvertLine8To24,
+	{24, 16, false, false}: noPalette(ConvertLine16To24_555),
+	{24, 16, false, true}:  noPalette(ConvertLine16To24_565),
+	{24, 32, false, false}: noPalette(ConvertLine32To24),
+
+	{32, 1, false, false}:  ConvertLine1To32,
+	{32, 4, false, false}:  ConvertLine4To32,
+	{32, 8, false, false}:  ConvertLine8To32,
+	{32, 16, false, false}: noPalette(ConvertLine16To32_555),
+	{32, 16, false, true}:  noPalette(ConvertLine16To32_565),
+	{32, 24, false, false}: noPalette(ConvertLine24To32),
+}
+
+// transparencyFuncs are the 32 bit conversions applying a transparency
+// table.
+var transparencyFuncs = map[int]func(target, source *byte, width_in_pixels int32, palette *RGBQUAD, table *byte, transparent_pixels int32){
+	1: ConvertLine1To32MapTransparency,
+	4: ConvertLine4To32MapTransparency,
+	8: ConvertLine8To32MapTransparency,
+}
+
+// LineSize returns the bytes a scanline of width pixels at bpp bits per
+// pixel uses, without the padding of bitmap pitches.
+func LineSize(bpp, width int) int {
+	return (width*bpp + 7) / 8
+}
+
+// ConvertLine converts one scanline of width pixels from srcBPP to dstBPP
+// with the FreeImage_ConvertLine* routines, without allocating a bitmap.
+//
+// Depths are 1, 4, 8, 16, 24 or 32; colors are in the FreeImage byte order
+// and 16 bit lines are RGB555 unless opts says otherwise. palette is
+// required, with an entry per index, when converting 1, 4 or 8 bit lines
+// to 16, 24 or 32 bit and 8 bit ones to 4 bit; it is ignored otherwise.
+// Converting to the same format copies the line. opts may be nil.
+func ConvertLine(dstBPP, srcBPP int, dst, src []byte, width int, palette []RGBQUAD, opts *ConvertLineOptions) error {
+	if opts == nil {
+		opts = &ConvertLineOptions{}
+	}
+	if width <= 0 {
+		return fmt.Errorf("freeimage: bad line width %d", width)
+	}
+	key := lineKey{dst: dstBPP, src: srcBPP, dst565: opts.Dst565 && dstBPP == 16, src565: opts.Src565 && srcBPP == 16}
+	fn, ok := lineFuncs[key]
+	same := dstBPP == srcBPP && key.dst565 == key.src565
+	if !ok && !same {
+		return fmt.Errorf("%w: %d to %d bit", ErrUnsupportedConversion, srcBPP, dstBPP)
+	}
+
+	srcLen, dstLen := LineSize(srcBPP, width), LineSize(dstBPP, width)
+	if len(src) < srcLen {
+		return fmt.Errorf("%w: source line has %d bytes, %d pixels at %d bit need %d", io.ErrShortBuffer, len(src), width, srcBPP, srcLen)
+	}
+	if len(dst) < dstLen {
+		return fmt.Errorf("%w: target line has %d bytes, %d pixels at %d bit need %d", io.ErrShortBuffer, len(dst), width, dstBPP, dstLen)
+	}
+	if same {
+		copy(dst[:dstLen], src[:srcLen])
+		return nil
+	}
+
+	var pal *RGBQUAD
+	if srcBPP <= 8 && (dstBPP >= 16 || srcBPP == 8) {
+		if need := 1 << srcBPP; len(palette) < need {
+			return fmt.Errorf("freeimage: %d bit to %d bit needs a %d entry palette, got %d", srcBPP, dstBPP, need, len(palette))
+		}
+		pal = &palette[0]
+	}
+
+	w := int32(width)
+	if dstBPP == 32 && len(opts.Transparency) > 0 && srcBPP <= 8 {
+		transparencyFuncs[srcBPP](&dst[0], &src[0], w, pal, &opts.Transparency[0], int32(len(opts.Transparency)))
+	} else {
+		fn(&dst[0], &src[0], w, pal)
+	}
+	runtime.KeepAlive(dst)
+	runtime.KeepAlive(src)
+	runtime.KeepAlive(palette)
+	runtime.KeepAlive(opts.Transparency)
+	return nil
+}
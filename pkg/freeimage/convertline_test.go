@@ -0,0 +1,59 @@
+package freeimage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestConvertLineValidation(t *testing.T) {
+	line := make([]byte, 64)
+	palette := make([]RGBQUAD, 256)
+	tests := []struct {
+		name           string
+		dstBPP, srcBPP int
+		dst, src       []byte
+		palette        []RGBQUAD
+		want           error
+	}{
+		{"unsupported", 24, 48, line, line, nil, ErrUnsupportedConversion},
+		{"short source", 32, 24, line, line[:29], nil, io.ErrShortBuffer},
+		{"short target", 32, 24, line[:39], line, nil, io.ErrShortBuffer},
+		{"short 1 bit source", 8, 1, line, line[:1], nil, io.ErrShortBuffer},
+		{"missing palette", 24, 8, line, line, palette[:16], nil},
+	}
+	for _, tt := range tests {
+		err := ConvertLine(tt.dstBPP, tt.srcBPP, tt.dst, tt.src, 10, tt.palette, nil)
+		if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: ConvertLine = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	src := []byte{1, 2, 3, 4, 5, 6, 7}
+	dst := make([]byte, 6)
+	if err := ConvertLine(24, 24, dst, src, 2, nil, nil); err != nil || !bytes.Equal(dst, src[:6]) {
+		t.Errorf("same format ConvertLine = %v, %v", dst, err)
+	}
+}
+
+func TestConvertLine(t *testing.T) {
+	requireLibrary(t)
+	src := []byte{10, 20, 30, 40, 50, 60}
+	dst := make([]byte, 8)
+	if err := ConvertLine(32, 24, dst, src, 2, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{10, 20, 30, 255, 40, 50, 60, 255}; !bytes.Equal(dst, want) {
+		t.Errorf("24 to 32 bit = %v, want %v", dst, want)
+	}
+
+	palette := []RGBQUAD{{0, 0, 0, 0}, {1, 2, 3, 0}}
+	dst = make([]byte, 32)
+	if err := ConvertLine(32, 1, dst, []byte{0x80}, 8, palette, &ConvertLineOptions{Transparency: []byte{0, 200}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3, 200, 0, 0, 0, 0}; !bytes.Equal(dst[:8], want) {
+		t.Errorf("1 to 32 bit with transparency = %v, want %v", dst[:8], want)
+	}
+}
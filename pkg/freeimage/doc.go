@@ -0,0 +1,6 @@
+// Package freeimage binds the FreeImage library. Calls go through libffi
+// to a library loaded at run time, see Open. The freeimage_cgo build tag
+// links the library instead, a partial cgo backend that calls the pixel
+// and bitmap info accessors through cgo and the rest through libffi, see
+// Backend.
+package freeimage
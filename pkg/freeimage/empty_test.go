@@ -0,0 +1,36 @@
+package freeimage_test
+
+import (
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+// Empty slices must not panic taking the address of their first element.
+func TestEmptySlices(t *testing.T) {
+	s := fitest.NewStub(t)
+	mem := fi.OpenMemory([]byte{})
+	if mem == nil {
+		t.Fatal("OpenMemory of an empty slice returned nil")
+	}
+	if n := mem.Len(); n != 0 {
+		t.Errorf("Len() = %d", n)
+	}
+	mem.CloseMemory()
+
+	dib := fi.AllocateEx(2, 2, 8, &fi.RGBQUAD{9, 9, 9, 0}, 0, nil, 0, 0, 0)
+	defer dib.Unload()
+	if n := dib.ApplyColorMapping(nil, []fi.RGBQUAD{}, false, false); n != 0 {
+		t.Errorf("ApplyColorMapping of no colors = %d", n)
+	}
+	if n := dib.ApplyPaletteIndexMapping([]byte{1}, nil, false); n != 0 {
+		t.Errorf("ApplyPaletteIndexMapping of no indices = %d", n)
+	}
+	if v := *(*byte)(dib.GetBits()); v != 9 {
+		t.Errorf("AllocateEx filled the bitmap with %d, want 9", v)
+	}
+	if s.Live() != 1 {
+		t.Errorf("Live() = %d, want the bitmap only", s.Live())
+	}
+}
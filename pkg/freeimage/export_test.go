@@ -0,0 +1,6 @@
+package freeimage
+
+import "unsafe"
+
+// CLongSize is the size of a C long, the offset type of memory streams.
+const CLongSize = unsafe.Sizeof(cLong(0))
@@ -0,0 +1,270 @@
+// Package fitest loads a FreeImage library for tests: the real one when it
+// is installed, or a small stub built from testdata/stub.c with the C
+// compiler used by cgo.
+//
+// The stub implements Allocate and AllocateEx, the bitmap accessors, memory
+// streams, a single format, FIF_STUB, with header only loading and read
+// only multipage files, empty metadata, the zlib functions with stored
+// blocks only, an output message for truncated files, and local plugins,
+// registered after it, enough to exercise wrappers, error paths and object
+// lifetimes without libfreeimage.
+// It logs every call and can make the next call of a function fail.
+//
+// Both helpers replace the package default library for the test, so tests
+// using them must not run in parallel. Recorder records the logs, spans
+// and metrics of WithInstrumentation in memory.
+package fitest
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jinzhongmin/goffi/pkg/c"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+// FIF_STUB is the only format the stub reads and writes, its files use the
+// "stub" extension.
+const FIF_STUB freeimage.FREE_IMAGE_FORMAT = 100
+
+//go:embed testdata/stub.c
+var stubSource []byte
+
+// Real makes the installed FreeImage the default library until the test
+// ends, and skips the test if it can't be loaded.
+func Real(t testing.TB) *freeimage.Library {
+	t.Helper()
+	l, err := freeimage.Open("")
+	if err != nil {
+		t.Skip(err)
+	}
+	use(t, l)
+	return l
+}
+
+// Auto returns the real library if it is installed, the stub otherwise.
+// The stub is nil with the real library.
+func Auto(t testing.TB) (*freeimage.Library, *Stub) {
+	t.Helper()
+	if l, err := freeimage.Open(""); err == nil {
+		use(t, l)
+		return l, nil
+	}
+	s := NewStub(t)
+	return s.Library, s
+}
+
+func use(t testing.TB, l *freeimage.Library) {
+	l.Initialise(false)
+	prev := freeimage.SetDefault(l)
+	t.Cleanup(func() {
+		freeimage.SetDefault(prev)
+		l.Close()
+	})
+}
+
+// Stub is the stub library, the default library for the test.
+type Stub struct {
+	Library *freeimage.Library
+
+	lib                                             *c.Lib
+	fnCalls, fnReset, fnFail, fnLive, fnInitialised *c.FuncPrototype
+}
+
+// NewStub builds the stub if needed and makes it the default library until
+// the test ends, opened with opts. The test is skipped if there is no C
+// compiler and fails if the stub doesn't build, set FREEIMAGE_STUB to use a
+// stub built beforehand.
+func NewStub(t testing.TB, opts ...freeimage.Option) *Stub {
+	t.Helper()
+	path, err := stubPath()
+	if errors.Is(err, errNoCompiler) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := freeimage.Open(path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib, err := c.NewLib(path, c.ModeNow)
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	s := &Stub{
+		Library: l,
+		lib:     lib,
+		fnCalls: &c.FuncPrototype{Name: "fistub_calls", OutType: c.Pointer},
+		fnReset: &c.FuncPrototype{Name: "fistub_reset", OutType: c.Void},
+		fnFail:  &c.FuncPrototype{Name: "fistub_fail", OutType: c.Void, InTypes: []c.Type{c.Pointer}},
+		fnLive:  &c.FuncPrototype{Name: "fistub_live", OutType: c.I32},
+
+		fnInitialised: &c.FuncPrototype{Name: "fistub_initialised", OutType: c.I32},
+	}
+	use(t, l)
+	t.Cleanup(lib.UnLoad)
+	s.Reset()
+	return s
+}
+
+// Calls returns the functions called since the last Reset, without the
+// FreeImage_ prefix, e.g. "Allocate".
+func (s *Stub) Calls() []string {
+	log := c.GoStr(s.lib.Call(s.fnCalls, nil).PtrFree())
+	if log == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(log, "\n"), "\n")
+}
+
+// Called returns how many times fn was called since the last Reset.
+func (s *Stub) Called(fn string) int {
+	n := 0
+	for _, call := range s.Calls() {
+		if call == fn {
+			n++
+		}
+	}
+	return n
+}
+
+// Reset clears the call log and a pending Fail.
+func (s *Stub) Reset() {
+	s.lib.Call(s.fnReset, nil)
+}
+
+// Fail makes the next call of fn, e.g. "Load", fail: it returns NULL,
+// FALSE, 0 or FIF_UNKNOWN without doing anything. Release functions never
+// fail.
+func (s *Stub) Fail(fn string) {
+	name := c.CStr(fn)
+	defer c.Free(name)
+	s.lib.Call(s.fnFail, []interface{}{&name})
+}
+
+// Live returns the number of bitmaps and memory streams not released.
+func (s *Stub) Live() int {
+	return int(s.lib.Call(s.fnLive, nil).I32Free())
+}
+
+// Initialised returns how many native FreeImage_Initialise calls are not
+// matched by a FreeImage_DeInitialise.
+func (s *Stub) Initialised() int {
+	return int(s.lib.Call(s.fnInitialised, nil).I32Free())
+}
+
+// Bytes encodes a w x h image in the STUB format, with every byte of the
+// scanlines set by pix(x, y, channel), for test input files.
+func Bytes(w, h, bpp int, pix func(x, y, channel int) byte) []byte {
+	channels := bpp / 8
+	pitch := (w*channels + 3) &^ 3
+	buf := make([]byte, 16, 16+pitch*h)
+	copy(buf, "FIS1")
+	for i, v := range []int{w, h, bpp} {
+		binary.LittleEndian.PutUint32(buf[4+4*i:], uint32(v))
+	}
+	for y := 0; y < h; y++ {
+		line := make([]byte, pitch)
+		for x := 0; x < w; x++ {
+			for ch := 0; ch < channels; ch++ {
+				line[x*channels+ch] = pix(x, y, ch)
+			}
+		}
+		buf = append(buf, line...)
+	}
+	return buf
+}
+
+var (
+	buildOnce sync.Once
+	builtPath string
+	buildErr  error
+)
+
+// stubPath returns FREEIMAGE_STUB or builds the stub.
+func stubPath() (string, error) {
+	if p := os.Getenv("FREEIMAGE_STUB"); p != "" {
+		return p, nil
+	}
+	buildOnce.Do(func() { builtPath, buildErr = compile("libfreeimage_stub", stubSource) })
+	return builtPath, buildErr
+}
+
+// Compile builds the C source src into a shared library named after name
+// and returns its path, e.g. for test plugins. The test is skipped if there
+// is no C compiler.
+func Compile(t testing.TB, name string, src []byte) string {
+	t.Helper()
+	path, err := compile(name, src)
+	if errors.Is(err, errNoCompiler) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var errNoCompiler = errors.New("fitest: no C compiler")
+
+// compile builds src with $CC into the user cache directory, keyed by the
+// source hash so edits rebuild it.
+func compile(name string, src []byte) (string, error) {
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	if _, err := exec.LookPath(cc); err != nil {
+		return "", fmt.Errorf("%w to build %s: %v", errNoCompiler, name, err)
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(dir, "gofreeimage-fitest")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(src)
+	out := filepath.Join(dir, name+"_"+hex.EncodeToString(sum[:8])+".so")
+	if _, err := os.Stat(out); err == nil {
+		return out, nil
+	}
+
+	// temporary names, test binaries of several packages may build at once
+	f, err := os.CreateTemp(dir, name+"-*.c")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	tmp := strings.TrimSuffix(f.Name(), ".c") + ".so"
+	defer os.Remove(tmp)
+	cmd := exec.Command(cc, "-shared", "-fPIC", "-O1", "-o", tmp, f.Name())
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("fitest: building %s: %w\n%s", name, err, msg)
+	}
+	if err := os.Rename(tmp, out); err != nil && !errors.Is(err, os.ErrExist) {
+		return "", err
+	}
+	return out, nil
+}
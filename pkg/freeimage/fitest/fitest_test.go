@@ -0,0 +1,207 @@
+package fitest_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func TestStubBitmap(t *testing.T) {
+	s := fitest.NewStub(t)
+
+	dib := freeimage.Allocate(5, 3, 24, 0, 0, 0)
+	if dib == nil {
+		t.Fatal("Allocate returned nil")
+	}
+	if w, h, bpp, pitch := dib.GetWidth(), dib.GetHeight(), dib.GetBPP(), dib.GetPitch(); w != 5 || h != 3 || bpp != 24 || pitch != 16 {
+		t.Fatalf("got %dx%d %d bpp pitch %d, want 5x3 24 bpp pitch 16", w, h, bpp, pitch)
+	}
+	bits := unsafe.Slice((*byte)(dib.GetBits()), dib.GetPitch()*dib.GetHeight())
+	bits[16+3], bits[16+4], bits[16+5] = 10, 20, 30 // x 1, y 1
+	c, ok := dib.GetPixelColor(1, 1)
+	if !ok || c != (freeimage.RGBQUAD{10, 20, 30, 0}) {
+		t.Errorf("GetPixelColor(1, 1) = %+v, %v", c, ok)
+	}
+	if _, ok := dib.GetPixelColor(5, 0); ok {
+		t.Error("GetPixelColor out of bounds succeeded")
+	}
+
+	if s.Live() != 1 {
+		t.Errorf("Live() = %d, want 1", s.Live())
+	}
+	dib.Unload()
+	if s.Live() != 0 {
+		t.Errorf("Live() = %d after Unload, want 0", s.Live())
+	}
+	if n := s.Called("Allocate"); n != 1 {
+		t.Errorf("Allocate called %d times", n)
+	}
+}
+
+func TestStubFileRoundTrip(t *testing.T) {
+	s := fitest.NewStub(t)
+	name := filepath.Join(t.TempDir(), "in.stub")
+
+	dib := freeimage.Allocate(4, 4, 32, 0, 0, 0)
+	defer dib.Unload()
+	dib.SetPixelColor(2, 3, &freeimage.RGBQUAD{3, 2, 1, 4})
+	if fif := freeimage.GetFIFFromFilename(name); fif != fitest.FIF_STUB {
+		t.Fatalf("GetFIFFromFilename = %d", fif)
+	}
+	if !dib.Save(fitest.FIF_STUB, name, 0) {
+		t.Fatal("Save failed")
+	}
+	if fif := freeimage.GetFileType(name, 0); fif != fitest.FIF_STUB {
+		t.Fatalf("GetFileType = %d", fif)
+	}
+
+	got := freeimage.Load(fitest.FIF_STUB, name, 0)
+	if got == nil {
+		t.Fatal("Load returned nil")
+	}
+	defer got.Unload()
+	if c, _ := got.GetPixelColor(2, 3); c != (freeimage.RGBQUAD{3, 2, 1, 4}) {
+		t.Errorf("loaded pixel = %+v", c)
+	}
+
+	s.Fail("Load")
+	if dib := freeimage.Load(fitest.FIF_STUB, name, 0); dib != nil {
+		t.Error("Load succeeded after Fail")
+	}
+	if dib := freeimage.Load(fitest.FIF_STUB, name, 0); dib == nil {
+		t.Error("Fail affected the second call")
+	} else {
+		dib.Unload()
+	}
+}
+
+func TestStubCodec(t *testing.T) {
+	s := fitest.NewStub(t)
+	data := fitest.Bytes(3, 2, 8, func(x, y, _ int) byte { return byte(10*y + x) })
+
+	if fif := freeimage.DetectFormat(data); fif != fitest.FIF_STUB {
+		t.Fatalf("DetectFormat = %d", fif)
+	}
+	dib, err := freeimage.Decode(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := freeimage.Encode(dib, fitest.FIF_STUB, nil)
+	dib.Unload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Errorf("Encode(Decode(data)) = %x, want %x", out, data)
+	}
+
+	s.Fail("LoadFromMemory")
+	if _, err := freeimage.Decode(data, nil); !errors.Is(err, freeimage.ErrDecode) {
+		t.Errorf("Decode with a failing LoadFromMemory = %v, want ErrDecode", err)
+	}
+	if _, err := freeimage.Decode([]byte("not an image"), nil); !errors.Is(err, freeimage.ErrUnknownFormat) {
+		t.Errorf("Decode garbage = %v, want ErrUnknownFormat", err)
+	}
+	if s.Live() != 0 {
+		t.Errorf("Live() = %d, the codec leaks", s.Live())
+	}
+}
+
+func TestStubMemory(t *testing.T) {
+	fitest.NewStub(t)
+	mem := freeimage.OpenMemory(nil)
+	defer mem.Close()
+
+	io.WriteString(mem, "0123456789")
+	if pos, err := mem.Seek(-3, io.SeekEnd); err != nil || pos != 7 {
+		t.Fatalf("Seek(-3, SeekEnd) = %d, %v", pos, err)
+	}
+	rest, _ := io.ReadAll(mem)
+	if string(rest) != "789" {
+		t.Errorf("read %q, want %q", rest, "789")
+	}
+	if got := string(mem.Bytes()); got != "0123456789" {
+		t.Errorf("Bytes() = %q", got)
+	}
+}
+
+func TestStubMultiPage(t *testing.T) {
+	s := fitest.NewStub(t)
+	var data []byte
+	for bpp := 8; bpp <= 32; bpp += 8 {
+		if bpp != 16 {
+			data = append(data, fitest.Bytes(bpp/8, 2, bpp, func(x, y, ch int) byte { return byte(bpp) })...)
+		}
+	}
+	mem := freeimage.OpenMemory(data)
+	defer mem.Close()
+	mb := freeimage.LoadMultiBitmapFromMemory(fitest.FIF_STUB, mem, 0)
+	if mb == nil {
+		t.Fatal("LoadMultiBitmapFromMemory returned nil")
+	}
+	if n := mb.GetPageCount(); n != 3 {
+		t.Fatalf("GetPageCount = %d, want 3", n)
+	}
+	for i, bpp := range []uint32{8, 24, 32} {
+		page := mb.LockPage(int32(i))
+		if page == nil {
+			t.Fatalf("LockPage(%d) returned nil", i)
+		}
+		if page.GetBPP() != bpp || *(*byte)(page.GetBits()) != byte(bpp) {
+			t.Errorf("page %d is %d bit, want %d", i, page.GetBPP(), bpp)
+		}
+		mb.UnlockPage(page, false)
+	}
+	if mb.LockPage(3) != nil {
+		t.Error("LockPage past the last page succeeded")
+	}
+	mb.Close(0)
+	if s.Live() != 1 {
+		t.Errorf("Live() = %d, the pages leak", s.Live())
+	}
+}
+
+func TestStubTracking(t *testing.T) {
+	s := fitest.NewStub(t)
+	freeimage.EnableTracking(true)
+	defer freeimage.EnableTracking(false)
+
+	a := freeimage.Allocate(2, 2, 8, 0, 0, 0)
+	b := a.Clone()
+	a.Unload()
+	if err := freeimage.LeakCheck(); err == nil {
+		t.Error("LeakCheck found no leak with a live clone")
+	}
+	b.Unload()
+	if err := freeimage.LeakCheck(); err != nil {
+		t.Error(err)
+	}
+
+	// headers without pixels, as plugins allocate for FIF_LOAD_NOPIXELS
+	h := freeimage.AllocateHeader(true, 2, 2, 8, 0, 0, 0)
+	if live := freeimage.LiveResources(); len(live) != 1 || live[0].Func != "AllocateHeader" {
+		t.Errorf("LiveResources() = %+v, want the header", live)
+	}
+	h.Unload()
+	if err := freeimage.LeakCheck(); err != nil {
+		t.Error(err)
+	}
+	if s.Live() != 0 {
+		t.Errorf("Live() = %d", s.Live())
+	}
+}
+
+func TestStubInitialise(t *testing.T) {
+	s := fitest.NewStub(t)
+	s.Library.Initialise(false)
+	s.Library.DeInitialise()
+	if n := s.Called("Initialise") + s.Called("DeInitialise"); n != 0 {
+		t.Errorf("nested Initialise reached the library %d times", n)
+	}
+}
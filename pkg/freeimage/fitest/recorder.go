@@ -0,0 +1,126 @@
+package fitest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+// Recorder is an in-memory freeimage.Instrumentation: it keeps the log
+// records, ended spans and histogram values it receives.
+//
+//	rec := fitest.NewRecorder()
+//	s := fitest.NewStub(t, freeimage.WithInstrumentation(rec.Instrumentation()))
+type Recorder struct {
+	mu      sync.Mutex
+	records []Record
+	spans   []Span
+	values  map[string][]Measurement
+}
+
+// Record is a recorded log record.
+type Record struct {
+	Level freeimage.Level
+	Msg   string
+	Attrs []freeimage.Attr
+}
+
+// Span is a recorded span.
+type Span struct {
+	Name       string
+	Attrs      []freeimage.Attr
+	Err        error
+	Start, End time.Time
+}
+
+// Measurement is a recorded histogram value.
+type Measurement struct {
+	Value float64
+	Attrs []freeimage.Attr
+}
+
+// Attr returns the value of the attribute key, nil if it is missing.
+func Attr(attrs []freeimage.Attr, key string) interface{} {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{values: map[string][]Measurement{}}
+}
+
+// Instrumentation returns an Instrumentation recording to r.
+func (r *Recorder) Instrumentation() freeimage.Instrumentation {
+	return freeimage.Instrumentation{Logger: r, Tracer: r, Meter: r}
+}
+
+// Reset drops everything recorded.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records, r.spans, r.values = nil, nil, map[string][]Measurement{}
+}
+
+// Records returns the log records, oldest first.
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.records...)
+}
+
+// Spans returns the ended spans in the order they ended.
+func (r *Recorder) Spans() []Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Span(nil), r.spans...)
+}
+
+// Values returns the values of the histogram name.
+func (r *Recorder) Values(name string) []Measurement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Measurement(nil), r.values[name]...)
+}
+
+func (r *Recorder) Enabled(ctx context.Context, level freeimage.Level) bool { return true }
+
+func (r *Recorder) Log(ctx context.Context, level freeimage.Level, msg string, attrs ...freeimage.Attr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, Record{level, msg, attrs})
+}
+
+func (r *Recorder) Start(ctx context.Context, name string) (context.Context, freeimage.Span) {
+	return ctx, &recordedSpan{r: r, span: Span{Name: name, Start: time.Now()}}
+}
+
+func (r *Recorder) Record(ctx context.Context, name string, value float64, attrs ...freeimage.Attr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[name] = append(r.values[name], Measurement{value, attrs})
+}
+
+type recordedSpan struct {
+	r    *Recorder
+	span Span
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...freeimage.Attr) {
+	s.span.Attrs = append(s.span.Attrs, attrs...)
+}
+
+func (s *recordedSpan) RecordError(err error) { s.span.Err = err }
+
+func (s *recordedSpan) End() {
+	s.span.End = time.Now()
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.spans = append(s.r.spans, s.span)
+}
@@ -0,0 +1,1102 @@
+// A minimal FreeImage stand-in for tests, see package fitest.
+//
+// It implements the bitmap basics, memory streams, local plugins and one
+// image format, "STUB": the magic "FIS1", width, height and bpp as little
+// endian int32 and the scanlines bottom up, pitch bytes each. It loads
+// headers only with FIF_LOAD_NOPIXELS, and reads several images back to
+// back as a multipage file. Bitmaps have no metadata. Truncated images
+// are reported to the output message handler. A bpp of -1 crashes the
+// loader and -2 hangs it. Every exported call is appended to a log, and
+// fistub_fail makes the next call of a function fail. The zlib functions
+// use stored blocks only.
+
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <strings.h>
+#include <unistd.h>
+
+#define EXPORT __attribute__((visibility("default")))
+
+typedef int32_t BOOL;
+
+enum { FIF_UNKNOWN = -1, FIF_STUB = 100 };
+enum { FIT_UNKNOWN = 0, FIT_BITMAP = 1 };
+enum { FIC_MINISBLACK = 1, FIC_RGB = 2, FIC_RGBALPHA = 4 };
+enum { FIF_LOAD_NOPIXELS = 0x8000 };
+enum { FICC_RED = 1, FICC_GREEN = 2, FICC_BLUE = 3, FICC_BLACK = 5 };
+
+typedef struct {
+	uint8_t blue, green, red, reserved;
+} RGBQUAD;
+
+typedef struct {
+	int32_t width, height, bpp;
+	uint32_t pitch;
+	uint8_t *bits;
+	RGBQUAD palette[256]; // 1, 4 and 8 bit bitmaps
+	uint32_t dpm_x, dpm_y;
+	int32_t transparency_count;
+	BOOL transparent;
+	uint8_t transparency[256];
+} FIBITMAP;
+
+typedef struct {
+	uint16_t flags;
+	uint32_t size;
+	void *data;
+} FIICCPROFILE;
+
+typedef struct {
+	uint8_t *data;
+	long size, cap, pos;
+	int owned;
+} FIMEMORY;
+
+typedef void *fi_handle;
+
+typedef struct {
+	unsigned (*read_proc)(void *buffer, unsigned size, unsigned count, fi_handle handle);
+	unsigned (*write_proc)(void *buffer, unsigned size, unsigned count, fi_handle handle);
+	int (*seek_proc)(fi_handle handle, long offset, int origin);
+	long (*tell_proc)(fi_handle handle);
+} FreeImageIO;
+
+typedef struct {
+	const char *(*format_proc)(void);
+	const char *(*description_proc)(void);
+	const char *(*extension_proc)(void);
+	const char *(*regexpr_proc)(void);
+	void *(*open_proc)(FreeImageIO *io, fi_handle handle, BOOL read);
+	void (*close_proc)(FreeImageIO *io, fi_handle handle, void *data);
+	int (*pagecount_proc)(FreeImageIO *io, fi_handle handle, void *data);
+	int (*pagecapability_proc)(FreeImageIO *io, fi_handle handle, void *data);
+	FIBITMAP *(*load_proc)(FreeImageIO *io, fi_handle handle, int page, int flags, void *data);
+	BOOL (*save_proc)(FreeImageIO *io, FIBITMAP *dib, fi_handle handle, int page, int flags, void *data);
+	BOOL (*validate_proc)(FreeImageIO *io, fi_handle handle);
+	const char *(*mime_proc)(void);
+	BOOL (*supports_export_bpp_proc)(int bpp);
+	BOOL (*supports_export_type_proc)(int type);
+	BOOL (*supports_icc_profiles_proc)(void);
+	BOOL (*supports_no_pixels_proc)(void);
+} Plugin;
+
+typedef void (*FI_InitProc)(Plugin *plugin, int format_id);
+
+// call log -------------------------------------------------------------------
+
+static char calls[1 << 16];
+static size_t calls_len;
+static char fail_name[64];
+static int live_bitmaps, live_memory, live_multi, initialised;
+
+static int record(const char *fn) {
+	size_t n = strlen(fn);
+	if (calls_len + n + 2 < sizeof(calls)) {
+		memcpy(calls + calls_len, fn, n);
+		calls_len += n;
+		calls[calls_len++] = '\n';
+		calls[calls_len] = 0;
+	}
+	if (fail_name[0] && strcmp(fail_name, fn) == 0) {
+		fail_name[0] = 0;
+		return 1;
+	}
+	return 0;
+}
+
+#define CALL(fail) \
+	if (record(__func__ + sizeof("FreeImage_") - 1)) return fail
+
+EXPORT const char *fistub_calls(void) { return calls; }
+EXPORT int fistub_live(void) { return live_bitmaps + live_memory + live_multi; }
+EXPORT int fistub_initialised(void) { return initialised; }
+
+EXPORT void fistub_reset(void) {
+	calls_len = 0;
+	calls[0] = 0;
+	fail_name[0] = 0;
+}
+
+EXPORT void fistub_fail(const char *fn) {
+	snprintf(fail_name, sizeof(fail_name), "%s", fn);
+}
+
+// general --------------------------------------------------------------------
+
+// Initialise takes a millisecond, so racing callers overlap.
+EXPORT void FreeImage_Initialise(BOOL local_only) {
+	(void)local_only;
+	record("Initialise");
+	usleep(1000);
+	initialised++;
+}
+
+static void clear_plugins(void);
+
+EXPORT void FreeImage_DeInitialise(void) {
+	record("DeInitialise");
+	if (--initialised == 0) clear_plugins();
+}
+
+EXPORT const char *FreeImage_GetVersion(void) {
+	CALL("");
+	return "3.18.0";
+}
+
+EXPORT const char *FreeImage_GetCopyrightMessage(void) {
+	CALL("");
+	return "fitest stub";
+}
+
+typedef void (*FreeImage_OutputMessageFunction)(int fif, const char *msg);
+
+static FreeImage_OutputMessageFunction output_message;
+
+EXPORT void FreeImage_SetOutputMessage(FreeImage_OutputMessageFunction omf) {
+	record("SetOutputMessage");
+	output_message = omf;
+}
+
+static void message(int fif, const char *msg) {
+	if (output_message) output_message(fif, msg);
+}
+
+// bitmaps --------------------------------------------------------------------
+
+// new_bitmap returns a header only bitmap without pixels.
+static FIBITMAP *new_bitmap(int32_t width, int32_t height, int32_t bpp, int pixels) {
+	if (width <= 0 || height <= 0) return NULL;
+	switch (bpp) {
+	case 1: case 4: case 8: case 24: case 32: break;
+	default: return NULL;
+	}
+	FIBITMAP *dib = calloc(1, sizeof(FIBITMAP));
+	if (!dib) return NULL;
+	dib->width = width;
+	dib->height = height;
+	dib->bpp = bpp;
+	dib->pitch = ((uint64_t)width * bpp + 31) / 32 * 4;
+	dib->dpm_x = dib->dpm_y = 2835; // 72 dpi
+	for (int i = 0, n = 1 << bpp; bpp <= 8 && i < n; i++) {
+		uint8_t grey = i * 255 / (n - 1);
+		dib->palette[i] = (RGBQUAD){grey, grey, grey, 0};
+	}
+	if (pixels && !(dib->bits = calloc(height, dib->pitch))) {
+		free(dib);
+		return NULL;
+	}
+	live_bitmaps++;
+	return dib;
+}
+
+static FIBITMAP *allocate(int32_t width, int32_t height, int32_t bpp) {
+	return new_bitmap(width, height, bpp, 1);
+}
+
+EXPORT FIBITMAP *FreeImage_Allocate(int width, int height, int bpp, unsigned r, unsigned g, unsigned b) {
+	(void)r, (void)g, (void)b;
+	CALL(NULL);
+	return allocate(width, height, bpp);
+}
+
+EXPORT FIBITMAP *FreeImage_AllocateHeader(BOOL header_only, int width, int height, int bpp, unsigned r, unsigned g, unsigned b) {
+	(void)r, (void)g, (void)b;
+	CALL(NULL);
+	return new_bitmap(width, height, bpp, !header_only);
+}
+
+EXPORT FIBITMAP *FreeImage_AllocateT(int type, int width, int height, int bpp, unsigned r, unsigned g, unsigned b) {
+	(void)r, (void)g, (void)b;
+	CALL(NULL);
+	if (type != FIT_BITMAP) return NULL;
+	return allocate(width, height, bpp);
+}
+
+// AllocateEx fills the bitmap with color, 8 bit bitmaps with its grey level
+// in the grey palette, or palette if given. 1 and 4 bit bitmaps are left
+// at index 0.
+EXPORT FIBITMAP *FreeImage_AllocateEx(int width, int height, int bpp, const RGBQUAD *color, int options, const RGBQUAD *palette, unsigned r, unsigned g, unsigned b) {
+	(void)options, (void)r, (void)g, (void)b;
+	CALL(NULL);
+	FIBITMAP *dib = allocate(width, height, bpp);
+	if (!dib || !color) return dib;
+	if (bpp <= 8 && palette) memcpy(dib->palette, palette, sizeof(RGBQUAD) << bpp);
+	if (bpp < 8) return dib;
+	if (bpp == 8) {
+		memset(dib->bits, (color->red + color->green + color->blue) / 3, (size_t)dib->pitch * height);
+		return dib;
+	}
+	for (int y = 0; y < height; y++) {
+		uint8_t *p = dib->bits + (size_t)y * dib->pitch;
+		for (int x = 0; x < width; x++, p += bpp / 8) {
+			p[0] = color->blue;
+			p[1] = color->green;
+			p[2] = color->red;
+			if (bpp == 32) p[3] = color->reserved;
+		}
+	}
+	return dib;
+}
+
+EXPORT FIBITMAP *FreeImage_Clone(FIBITMAP *dib) {
+	CALL(NULL);
+	if (!dib) return NULL;
+	FIBITMAP *clone = new_bitmap(dib->width, dib->height, dib->bpp, dib->bits != NULL);
+	if (!clone) return NULL;
+	uint8_t *bits = clone->bits;
+	*clone = *dib;
+	clone->bits = bits;
+	if (bits) memcpy(clone->bits, dib->bits, (size_t)dib->pitch * dib->height);
+	return clone;
+}
+
+EXPORT void FreeImage_Unload(FIBITMAP *dib) {
+	record("Unload");
+	if (!dib) return;
+	free(dib->bits);
+	free(dib);
+	live_bitmaps--;
+}
+
+EXPORT BOOL FreeImage_HasPixels(FIBITMAP *dib) { CALL(0); return dib && dib->bits; }
+EXPORT int FreeImage_GetImageType(FIBITMAP *dib) { CALL(FIT_UNKNOWN); return dib ? FIT_BITMAP : FIT_UNKNOWN; }
+EXPORT uint8_t *FreeImage_GetBits(FIBITMAP *dib) { CALL(NULL); return dib ? dib->bits : NULL; }
+EXPORT unsigned FreeImage_GetBPP(FIBITMAP *dib) { CALL(0); return dib ? dib->bpp : 0; }
+EXPORT unsigned FreeImage_GetWidth(FIBITMAP *dib) { CALL(0); return dib ? dib->width : 0; }
+EXPORT unsigned FreeImage_GetHeight(FIBITMAP *dib) { CALL(0); return dib ? dib->height : 0; }
+EXPORT unsigned FreeImage_GetLine(FIBITMAP *dib) { CALL(0); return dib ? ((unsigned)dib->width * dib->bpp + 7) / 8 : 0; }
+EXPORT unsigned FreeImage_GetPitch(FIBITMAP *dib) { CALL(0); return dib ? dib->pitch : 0; }
+EXPORT unsigned FreeImage_GetDIBSize(FIBITMAP *dib) { CALL(0); return dib ? 40 + dib->pitch * dib->height : 0; }
+EXPORT unsigned FreeImage_GetColorsUsed(FIBITMAP *dib) { CALL(0); return dib && dib->bpp <= 8 ? 1u << dib->bpp : 0; }
+
+EXPORT unsigned FreeImage_GetMemorySize(FIBITMAP *dib) {
+	CALL(0);
+	return dib ? sizeof(FIBITMAP) + dib->pitch * dib->height : 0;
+}
+
+EXPORT RGBQUAD *FreeImage_GetPalette(FIBITMAP *dib) { CALL(NULL); return dib && dib->bpp <= 8 ? dib->palette : NULL; }
+EXPORT unsigned FreeImage_GetRedMask(FIBITMAP *dib) { CALL(0); return dib && dib->bpp >= 24 ? 0x00ff0000 : 0; }
+EXPORT unsigned FreeImage_GetGreenMask(FIBITMAP *dib) { CALL(0); return dib && dib->bpp >= 24 ? 0x0000ff00 : 0; }
+EXPORT unsigned FreeImage_GetBlueMask(FIBITMAP *dib) { CALL(0); return dib && dib->bpp >= 24 ? 0x000000ff : 0; }
+EXPORT unsigned FreeImage_GetDotsPerMeterX(FIBITMAP *dib) { CALL(0); return dib ? dib->dpm_x : 0; }
+EXPORT unsigned FreeImage_GetDotsPerMeterY(FIBITMAP *dib) { CALL(0); return dib ? dib->dpm_y : 0; }
+EXPORT void FreeImage_SetDotsPerMeterX(FIBITMAP *dib, unsigned res) { CALL(); if (dib) dib->dpm_x = res; }
+EXPORT void FreeImage_SetDotsPerMeterY(FIBITMAP *dib, unsigned res) { CALL(); if (dib) dib->dpm_y = res; }
+EXPORT BOOL FreeImage_IsTransparent(FIBITMAP *dib) { CALL(0); return dib ? dib->transparent : 0; }
+EXPORT void FreeImage_SetTransparent(FIBITMAP *dib, BOOL enabled) { CALL(); if (dib) dib->transparent = enabled; }
+EXPORT unsigned FreeImage_GetTransparencyCount(FIBITMAP *dib) { CALL(0); return dib ? dib->transparency_count : 0; }
+
+EXPORT uint8_t *FreeImage_GetTransparencyTable(FIBITMAP *dib) {
+	CALL(NULL);
+	return dib && dib->bpp <= 8 ? dib->transparency : NULL;
+}
+
+EXPORT void FreeImage_SetTransparencyTable(FIBITMAP *dib, uint8_t *table, int count) {
+	CALL();
+	if (!dib || dib->bpp > 8) return;
+	if (count < 0) count = 0;
+	if (count > 256) count = 256;
+	memcpy(dib->transparency, table, count);
+	dib->transparency_count = count;
+	dib->transparent = count > 0;
+}
+
+EXPORT int FreeImage_GetColorType(FIBITMAP *dib) {
+	CALL(0);
+	if (!dib) return 0;
+	switch (dib->bpp) {
+	case 1: case 4: case 8: return FIC_MINISBLACK;
+	case 32: return FIC_RGBALPHA;
+	default: return FIC_RGB;
+	}
+}
+
+EXPORT uint8_t *FreeImage_GetScanLine(FIBITMAP *dib, int scanline) {
+	CALL(NULL);
+	if (!dib || !dib->bits || scanline < 0 || scanline >= dib->height) return NULL;
+	return dib->bits + (size_t)scanline * dib->pitch;
+}
+
+static uint8_t *pixel(FIBITMAP *dib, unsigned x, unsigned y) {
+	if (!dib || dib->bpp < 24 || x >= (unsigned)dib->width || y >= (unsigned)dib->height) return NULL;
+	return dib->bits + (size_t)y * dib->pitch + x * (dib->bpp / 8);
+}
+
+// index_pixel returns the byte holding pixel x, y of a 1, 4 or 8 bit
+// bitmap, and the shift and mask of the pixel in it, most significant bits
+// first.
+static uint8_t *index_pixel(FIBITMAP *dib, unsigned x, unsigned y, int *shift, uint8_t *mask) {
+	if (!dib || dib->bpp > 8 || x >= (unsigned)dib->width || y >= (unsigned)dib->height) return NULL;
+	int per_byte = 8 / dib->bpp;
+	*shift = (per_byte - 1 - x % per_byte) * dib->bpp;
+	*mask = (1 << dib->bpp) - 1;
+	return dib->bits + (size_t)y * dib->pitch + x / per_byte;
+}
+
+EXPORT BOOL FreeImage_GetPixelIndex(FIBITMAP *dib, unsigned x, unsigned y, uint8_t *value) {
+	CALL(0);
+	int shift;
+	uint8_t mask;
+	uint8_t *p = index_pixel(dib, x, y, &shift, &mask);
+	if (!p || !value) return 0;
+	*value = *p >> shift & mask;
+	return 1;
+}
+
+EXPORT BOOL FreeImage_SetPixelIndex(FIBITMAP *dib, unsigned x, unsigned y, uint8_t *value) {
+	CALL(0);
+	int shift;
+	uint8_t mask;
+	uint8_t *p = index_pixel(dib, x, y, &shift, &mask);
+	if (!p || !value) return 0;
+	*p = (*p & ~(mask << shift)) | (*value & mask) << shift;
+	return 1;
+}
+
+EXPORT BOOL FreeImage_GetPixelColor(FIBITMAP *dib, unsigned x, unsigned y, RGBQUAD *value) {
+	CALL(0);
+	uint8_t *p = pixel(dib, x, y);
+	if (!p || !value) return 0;
+	value->blue = p[0];
+	value->green = p[1];
+	value->red = p[2];
+	value->reserved = dib->bpp == 32 ? p[3] : 0;
+	return 1;
+}
+
+EXPORT BOOL FreeImage_SetPixelColor(FIBITMAP *dib, unsigned x, unsigned y, RGBQUAD *value) {
+	CALL(0);
+	uint8_t *p = pixel(dib, x, y);
+	if (!p || !value) return 0;
+	p[0] = value->blue;
+	p[1] = value->green;
+	p[2] = value->red;
+	if (dib->bpp == 32) p[3] = value->reserved;
+	return 1;
+}
+
+// Bitmaps have no background color, thumbnail or ICC profile, and only
+// palette transparency.
+EXPORT int FreeImage_GetTransparentIndex(FIBITMAP *dib) { (void)dib; CALL(-1); return -1; }
+EXPORT BOOL FreeImage_HasBackgroundColor(FIBITMAP *dib) { (void)dib; CALL(0); return 0; }
+EXPORT BOOL FreeImage_GetBackgroundColor(FIBITMAP *dib, RGBQUAD *bkcolor) { (void)dib, (void)bkcolor; CALL(0); return 0; }
+EXPORT FIBITMAP *FreeImage_GetThumbnail(FIBITMAP *dib) { (void)dib; CALL(NULL); return NULL; }
+
+static FIICCPROFILE no_profile;
+
+EXPORT FIICCPROFILE *FreeImage_GetICCProfile(FIBITMAP *dib) { (void)dib; CALL(NULL); return &no_profile; }
+
+// GetHistogram counts the grey levels of 8 bit bitmaps, FICC_BLACK, and
+// the red, green and blue channels of 24 and 32 bit ones.
+EXPORT BOOL FreeImage_GetHistogram(FIBITMAP *dib, uint32_t *histo, int channel) {
+	CALL(0);
+	if (!dib || !dib->bits || !histo) return 0;
+	int offset;
+	if (dib->bpp == 8 && channel == FICC_BLACK) offset = 0;
+	else if (dib->bpp >= 24 && channel >= FICC_RED && channel <= FICC_BLUE) offset = FICC_BLUE - channel;
+	else return 0;
+	memset(histo, 0, 256 * sizeof(uint32_t));
+	for (int y = 0; y < dib->height; y++) {
+		const uint8_t *p = dib->bits + (size_t)y * dib->pitch + offset;
+		for (int x = 0; x < dib->width; x++, p += dib->bpp / 8) histo[*p]++;
+	}
+	return 1;
+}
+
+// memory streams -------------------------------------------------------------
+
+static int mem_reserve(FIMEMORY *mem, long size) {
+	if (size <= mem->cap) return 1;
+	if (!mem->owned) return 0;
+	long cap = mem->cap ? mem->cap : 256;
+	while (cap < size) cap *= 2;
+	uint8_t *data = realloc(mem->data, cap);
+	if (!data) return 0;
+	memset(data + mem->cap, 0, cap - mem->cap);
+	mem->data = data;
+	mem->cap = cap;
+	return 1;
+}
+
+static size_t mem_read(FIMEMORY *mem, void *buf, size_t n) {
+	if (mem->pos >= mem->size) return 0;
+	if ((long)n > mem->size - mem->pos) n = mem->size - mem->pos;
+	memcpy(buf, mem->data + mem->pos, n);
+	mem->pos += n;
+	return n;
+}
+
+static size_t mem_write(FIMEMORY *mem, const void *buf, size_t n) {
+	if (!mem_reserve(mem, mem->pos + (long)n)) return 0;
+	memcpy(mem->data + mem->pos, buf, n);
+	mem->pos += n;
+	if (mem->pos > mem->size) mem->size = mem->pos;
+	return n;
+}
+
+EXPORT FIMEMORY *FreeImage_OpenMemory(uint8_t *data, uint32_t size) {
+	CALL(NULL);
+	FIMEMORY *mem = calloc(1, sizeof(FIMEMORY));
+	if (!mem) return NULL;
+	if (data) {
+		mem->data = data;
+		mem->size = mem->cap = size;
+	} else {
+		mem->owned = 1;
+	}
+	live_memory++;
+	return mem;
+}
+
+EXPORT void FreeImage_CloseMemory(FIMEMORY *mem) {
+	record("CloseMemory");
+	if (!mem) return;
+	if (mem->owned) free(mem->data);
+	free(mem);
+	live_memory--;
+}
+
+EXPORT long FreeImage_TellMemory(FIMEMORY *mem) { CALL(-1); return mem ? mem->pos : -1; }
+
+EXPORT BOOL FreeImage_SeekMemory(FIMEMORY *mem, long offset, int origin) {
+	CALL(0);
+	long base;
+	switch (origin) {
+	case 0: base = 0; break;
+	case 1: base = mem->pos; break;
+	case 2: base = mem->size; break;
+	default: return 0;
+	}
+	if (base + offset < 0) return 0;
+	mem->pos = base + offset;
+	return 1;
+}
+
+EXPORT BOOL FreeImage_AcquireMemory(FIMEMORY *mem, uint8_t **data, uint32_t *size) {
+	CALL(0);
+	if (!mem || !data || !size) return 0;
+	*data = mem->data;
+	*size = (uint32_t)mem->size;
+	return 1;
+}
+
+EXPORT unsigned FreeImage_ReadMemory(void *buf, unsigned size, unsigned count, FIMEMORY *mem) {
+	CALL(0);
+	if (!size) return 0;
+	return mem_read(mem, buf, (size_t)size * count) / size;
+}
+
+EXPORT unsigned FreeImage_WriteMemory(const void *buf, unsigned size, unsigned count, FIMEMORY *mem) {
+	CALL(0);
+	if (!size) return 0;
+	return mem_write(mem, buf, (size_t)size * count) / size;
+}
+
+// plugins --------------------------------------------------------------------
+
+// Local plugins registered with FreeImage_RegisterLocalPlugin get the
+// formats after FIF_STUB and are called through FreeImageIO like in
+// FreeImage, with page -1 for single page loads and saves.
+
+#define MAX_LOCAL 8
+
+static struct local {
+	Plugin plugin;
+	const char *format, *description, *extension;
+	int disabled;
+} locals[MAX_LOCAL];
+static int stub_disabled;
+static int nlocals;
+
+static void clear_plugins(void) {
+	memset(locals, 0, sizeof(locals));
+	nlocals = 0;
+	stub_disabled = 0;
+}
+
+static struct local *local(int fif) {
+	int i = fif - FIF_STUB - 1;
+	return i >= 0 && i < nlocals ? &locals[i] : NULL;
+}
+
+// enabled_local returns the plugin of fif if it is enabled.
+static struct local *enabled_local(int fif) {
+	struct local *l = local(fif);
+	return l && !l->disabled ? l : NULL;
+}
+
+static const char *local_format(struct local *l) {
+	return l->format ? l->format : l->plugin.format_proc();
+}
+
+static const char *local_description(struct local *l) {
+	if (l->description) return l->description;
+	return l->plugin.description_proc ? l->plugin.description_proc() : NULL;
+}
+
+static const char *local_extension(struct local *l) {
+	if (l->extension) return l->extension;
+	return l->plugin.extension_proc ? l->plugin.extension_proc() : NULL;
+}
+
+static const char *local_mime(struct local *l) {
+	return l->plugin.mime_proc ? l->plugin.mime_proc() : NULL;
+}
+
+EXPORT int FreeImage_RegisterLocalPlugin(FI_InitProc proc_address, const char *format, const char *description, const char *extension, const char *regexpr) {
+	(void)regexpr;
+	CALL(FIF_UNKNOWN);
+	if (!proc_address || nlocals == MAX_LOCAL) return FIF_UNKNOWN;
+	struct local *l = &locals[nlocals];
+	memset(l, 0, sizeof(*l));
+	proc_address(&l->plugin, FIF_STUB + 1 + nlocals);
+	if (!l->plugin.format_proc && !format) return FIF_UNKNOWN;
+	l->format = format;
+	l->description = description;
+	l->extension = extension;
+	return FIF_STUB + 1 + nlocals++;
+}
+
+// Like FreeImage outside of Windows, external plugins are not supported.
+EXPORT int FreeImage_RegisterExternalPlugin(const char *path, const char *format, const char *description, const char *extension, const char *regexpr) {
+	(void)path, (void)format, (void)description, (void)extension, (void)regexpr;
+	CALL(FIF_UNKNOWN);
+	return FIF_UNKNOWN;
+}
+
+EXPORT int FreeImage_SetPluginEnabled(int fif, BOOL enable) {
+	CALL(-1);
+	int *disabled = fif == FIF_STUB ? &stub_disabled : local(fif) ? &local(fif)->disabled : NULL;
+	if (!disabled) return -1;
+	int was = !*disabled;
+	*disabled = !enable;
+	return was;
+}
+
+EXPORT int FreeImage_IsPluginEnabled(int fif) {
+	CALL(-1);
+	if (fif == FIF_STUB) return !stub_disabled;
+	struct local *l = local(fif);
+	return l ? !l->disabled : -1;
+}
+
+EXPORT int FreeImage_GetFIFCount(void) { CALL(0); return 1 + nlocals; }
+
+EXPORT BOOL FreeImage_FIFSupportsReading(int fif) {
+	CALL(0);
+	struct local *l = local(fif);
+	return fif == FIF_STUB || (l && l->plugin.load_proc);
+}
+
+EXPORT BOOL FreeImage_FIFSupportsWriting(int fif) {
+	CALL(0);
+	struct local *l = local(fif);
+	return fif == FIF_STUB || (l && l->plugin.save_proc);
+}
+
+EXPORT BOOL FreeImage_FIFSupportsNoPixels(int fif) {
+	CALL(0);
+	struct local *l = local(fif);
+	if (l) return l->plugin.supports_no_pixels_proc && l->plugin.supports_no_pixels_proc();
+	return fif == FIF_STUB;
+}
+
+EXPORT BOOL FreeImage_FIFSupportsExportType(int fif, int type) {
+	CALL(0);
+	struct local *l = local(fif);
+	if (l) return l->plugin.supports_export_type_proc && l->plugin.supports_export_type_proc(type);
+	return fif == FIF_STUB && type == FIT_BITMAP;
+}
+
+EXPORT BOOL FreeImage_FIFSupportsExportBPP(int fif, int bpp) {
+	CALL(0);
+	struct local *l = local(fif);
+	if (l) return l->plugin.supports_export_bpp_proc && l->plugin.supports_export_bpp_proc(bpp);
+	return fif == FIF_STUB && (bpp == 8 || bpp == 24 || bpp == 32);
+}
+
+EXPORT const char *FreeImage_GetFormatFromFIF(int fif) {
+	CALL(NULL);
+	struct local *l = local(fif);
+	if (l) return local_format(l);
+	return fif == FIF_STUB ? "STUB" : NULL;
+}
+
+EXPORT const char *FreeImage_GetFIFExtensionList(int fif) {
+	CALL(NULL);
+	struct local *l = local(fif);
+	if (l) return local_extension(l);
+	return fif == FIF_STUB ? "stub" : NULL;
+}
+
+EXPORT const char *FreeImage_GetFIFDescription(int fif) {
+	CALL(NULL);
+	struct local *l = local(fif);
+	if (l) return local_description(l);
+	return fif == FIF_STUB ? "fitest stub format" : NULL;
+}
+
+EXPORT const char *FreeImage_GetFIFMimeType(int fif) {
+	CALL(NULL);
+	struct local *l = local(fif);
+	if (l) return local_mime(l);
+	return fif == FIF_STUB ? "image/x-fitest-stub" : NULL;
+}
+
+EXPORT int FreeImage_GetFIFFromFormat(const char *format) {
+	CALL(FIF_UNKNOWN);
+	if (!format) return FIF_UNKNOWN;
+	for (int i = 0; i < nlocals; i++) {
+		if (!locals[i].disabled && strcasecmp(format, local_format(&locals[i])) == 0) return FIF_STUB + 1 + i;
+	}
+	return strcasecmp(format, "STUB") == 0 ? FIF_STUB : FIF_UNKNOWN;
+}
+
+EXPORT int FreeImage_GetFIFFromMime(const char *mime) {
+	CALL(FIF_UNKNOWN);
+	if (!mime) return FIF_UNKNOWN;
+	for (int i = 0; i < nlocals; i++) {
+		const char *m = local_mime(&locals[i]);
+		if (m && strcmp(mime, m) == 0) return FIF_STUB + 1 + i;
+	}
+	return strcmp(mime, "image/x-fitest-stub") == 0 ? FIF_STUB : FIF_UNKNOWN;
+}
+
+// has_extension reports whether the comma separated list has ext.
+static int has_extension(const char *list, const char *ext) {
+	size_t n = strlen(ext);
+	while (list && *list) {
+		const char *end = strchr(list, ',');
+		size_t len = end ? (size_t)(end - list) : strlen(list);
+		if (len == n && strncasecmp(list, ext, n) == 0) return 1;
+		list = end ? end + 1 : NULL;
+	}
+	return 0;
+}
+
+EXPORT int FreeImage_GetFIFFromFilename(const char *filename) {
+	CALL(FIF_UNKNOWN);
+	const char *ext = filename ? strrchr(filename, '.') : NULL;
+	if (!ext) return FIF_UNKNOWN;
+	for (int i = 0; i < nlocals; i++) {
+		if (has_extension(local_extension(&locals[i]), ext + 1)) return FIF_STUB + 1 + i;
+	}
+	return strcasecmp(ext, ".stub") == 0 ? FIF_STUB : FIF_UNKNOWN;
+}
+
+// FreeImageIO over files and memory streams
+
+static unsigned io_file_read(void *buf, unsigned size, unsigned count, fi_handle f) { return fread(buf, size, count, f); }
+static unsigned io_file_write(void *buf, unsigned size, unsigned count, fi_handle f) { return fwrite(buf, size, count, f); }
+static int io_file_seek(fi_handle f, long offset, int origin) { return fseek(f, offset, origin); }
+static long io_file_tell(fi_handle f) { return ftell(f); }
+
+static unsigned io_memory_read(void *buf, unsigned size, unsigned count, fi_handle m) {
+	return size ? mem_read(m, buf, (size_t)size * count) / size : 0;
+}
+
+static unsigned io_memory_write(void *buf, unsigned size, unsigned count, fi_handle m) {
+	return size ? mem_write(m, buf, (size_t)size * count) / size : 0;
+}
+
+static int io_memory_seek(fi_handle m, long offset, int origin) {
+	FIMEMORY *mem = m;
+	long base = origin == 0 ? 0 : origin == 1 ? mem->pos : mem->size;
+	if (origin < 0 || origin > 2 || base + offset < 0) return -1;
+	mem->pos = base + offset;
+	return 0;
+}
+
+static long io_memory_tell(fi_handle m) { return ((FIMEMORY *)m)->pos; }
+
+static FreeImageIO file_io = {io_file_read, io_file_write, io_file_seek, io_file_tell};
+static FreeImageIO memory_io = {io_memory_read, io_memory_write, io_memory_seek, io_memory_tell};
+
+static int local_validate(FreeImageIO *io, fi_handle handle) {
+	long pos = io->tell_proc(handle);
+	for (int i = 0; i < nlocals; i++) {
+		Plugin *p = &locals[i].plugin;
+		if (locals[i].disabled || !p->validate_proc) continue;
+		BOOL ok = p->validate_proc(io, handle);
+		io->seek_proc(handle, pos, 0);
+		if (ok) return FIF_STUB + 1 + i;
+	}
+	return FIF_UNKNOWN;
+}
+
+static FIBITMAP *local_load(int fif, FreeImageIO *io, fi_handle handle, int flags) {
+	struct local *l = enabled_local(fif);
+	if (!l || !l->plugin.load_proc) return NULL;
+	void *data = l->plugin.open_proc ? l->plugin.open_proc(io, handle, 1) : NULL;
+	FIBITMAP *dib = l->plugin.load_proc(io, handle, -1, flags, data);
+	if (l->plugin.close_proc) l->plugin.close_proc(io, handle, data);
+	return dib;
+}
+
+static BOOL local_save(int fif, FIBITMAP *dib, FreeImageIO *io, fi_handle handle, int flags) {
+	struct local *l = enabled_local(fif);
+	if (!l || !l->plugin.save_proc) return 0;
+	void *data = l->plugin.open_proc ? l->plugin.open_proc(io, handle, 0) : NULL;
+	BOOL ok = l->plugin.save_proc(io, dib, handle, -1, flags, data);
+	if (l->plugin.close_proc) l->plugin.close_proc(io, handle, data);
+	return ok;
+}
+
+// the STUB format ------------------------------------------------------------
+
+static const char magic[4] = {'F', 'I', 'S', '1'};
+
+// files with these bpp crash or hang the loader, for the sandbox tests
+enum { BPP_CRASH = -1, BPP_HANG = -2 };
+
+typedef size_t (*io_proc)(void *handle, void *buf, size_t n);
+
+static size_t file_read(void *f, void *buf, size_t n) { return fread(buf, 1, n, f); }
+static size_t file_write(void *f, void *buf, size_t n) { return fwrite(buf, 1, n, f); }
+static size_t memory_read(void *m, void *buf, size_t n) { return mem_read(m, buf, n); }
+static size_t memory_write(void *m, void *buf, size_t n) { return mem_write(m, buf, n); }
+
+static int read_header(io_proc read, void *handle, int32_t hdr[3]) {
+	char m[4];
+	if (read(handle, m, 4) != 4 || memcmp(m, magic, 4) != 0) return 0;
+	return read(handle, hdr, 12) == 12;
+}
+
+static FIBITMAP *load(io_proc read, void *handle, int flags) {
+	int32_t hdr[3];
+	if (!read_header(read, handle, hdr)) return NULL;
+	if (hdr[2] == BPP_CRASH) *(volatile int *)0 = 0;
+	if (hdr[2] == BPP_HANG) for (;;) pause();
+	if (flags & FIF_LOAD_NOPIXELS) return new_bitmap(hdr[0], hdr[1], hdr[2], 0);
+	FIBITMAP *dib = allocate(hdr[0], hdr[1], hdr[2]);
+	if (!dib) return NULL;
+	size_t n = (size_t)dib->pitch * dib->height;
+	if (read(handle, dib->bits, n) != n) {
+		message(FIF_STUB, "STUB: truncated image data");
+		free(dib->bits);
+		free(dib);
+		live_bitmaps--;
+		return NULL;
+	}
+	return dib;
+}
+
+static BOOL save(io_proc write, void *handle, FIBITMAP *dib) {
+	int32_t hdr[3] = {dib->width, dib->height, dib->bpp};
+	size_t n = (size_t)dib->pitch * dib->height;
+	return write(handle, (void *)magic, 4) == 4 && write(handle, hdr, 12) == 12 &&
+		write(handle, dib->bits, n) == n;
+}
+
+EXPORT int FreeImage_GetFileType(const char *filename, int size) {
+	(void)size;
+	CALL(FIF_UNKNOWN);
+	FILE *f = fopen(filename, "rb");
+	if (!f) return FIF_UNKNOWN;
+	int32_t hdr[3];
+	int fif = read_header(file_read, f, hdr) ? FIF_STUB : FIF_UNKNOWN;
+	if (fif == FIF_UNKNOWN) {
+		rewind(f);
+		fif = local_validate(&file_io, f);
+	}
+	fclose(f);
+	return fif;
+}
+
+EXPORT int FreeImage_GetFileTypeFromMemory(FIMEMORY *mem, int size) {
+	(void)size;
+	CALL(FIF_UNKNOWN);
+	long pos = mem->pos;
+	int32_t hdr[3];
+	int fif = read_header(memory_read, mem, hdr) ? FIF_STUB : FIF_UNKNOWN;
+	mem->pos = pos;
+	return fif == FIF_UNKNOWN ? local_validate(&memory_io, mem) : fif;
+}
+
+EXPORT FIBITMAP *FreeImage_Load(int fif, const char *filename, int flags) {
+	CALL(NULL);
+	if (fif != FIF_STUB && !local(fif)) return NULL;
+	FILE *f = fopen(filename, "rb");
+	if (!f) return NULL;
+	FIBITMAP *dib = fif == FIF_STUB ? load(file_read, f, flags) : local_load(fif, &file_io, f, flags);
+	fclose(f);
+	return dib;
+}
+
+EXPORT BOOL FreeImage_Save(int fif, FIBITMAP *dib, const char *filename, int flags) {
+	CALL(0);
+	if ((fif != FIF_STUB && !local(fif)) || !dib) return 0;
+	FILE *f = fopen(filename, "wb");
+	if (!f) return 0;
+	BOOL ok = fif == FIF_STUB ? save(file_write, f, dib) : local_save(fif, dib, &file_io, f, flags);
+	return fclose(f) == 0 && ok;
+}
+
+EXPORT FIBITMAP *FreeImage_LoadFromMemory(int fif, FIMEMORY *mem, int flags) {
+	CALL(NULL);
+	if (!mem) return NULL;
+	if (fif != FIF_STUB) return local_load(fif, &memory_io, mem, flags);
+	return load(memory_read, mem, flags);
+}
+
+EXPORT BOOL FreeImage_SaveToMemory(int fif, FIBITMAP *dib, FIMEMORY *mem, int flags) {
+	CALL(0);
+	if (!dib || !mem) return 0;
+	if (fif != FIF_STUB) return local_save(fif, dib, &memory_io, mem, flags);
+	return save(memory_write, mem, dib);
+}
+
+// multipage ------------------------------------------------------------------
+
+// A multipage STUB file is STUB images back to back. It is read into
+// memory when opened, and the pages are loaded from there when locked,
+// with the flags of the open, e.g. FIF_LOAD_NOPIXELS.
+typedef struct {
+	int pages, flags;
+	long *offsets; // of the pages in data
+	FIMEMORY data;
+} FIMULTIBITMAP;
+
+static void free_multi(FIMULTIBITMAP *mb) {
+	free(mb->data.data);
+	free(mb->offsets);
+	free(mb);
+}
+
+static FIMULTIBITMAP *open_multi(io_proc read, void *handle, int flags) {
+	FIMULTIBITMAP *mb = calloc(1, sizeof(FIMULTIBITMAP));
+	if (!mb) return NULL;
+	mb->data.owned = 1;
+	mb->flags = flags;
+	int32_t hdr[3];
+	while (read_header(read, handle, hdr)) {
+		if (hdr[0] <= 0 || hdr[1] <= 0 || (hdr[2] != 8 && hdr[2] != 24 && hdr[2] != 32)) break;
+		long *offsets = realloc(mb->offsets, (mb->pages + 1) * sizeof(long));
+		if (!offsets) goto fail;
+		mb->offsets = offsets;
+		mb->offsets[mb->pages] = mb->data.pos;
+		if (mem_write(&mb->data, magic, 4) != 4 || mem_write(&mb->data, hdr, 12) != 12) goto fail;
+		size_t n = (size_t)(((uint32_t)hdr[0] * hdr[2] / 8 + 3) & ~3u) * hdr[1];
+		uint8_t buf[4096];
+		while (n > 0) {
+			size_t k = n < sizeof(buf) ? n : sizeof(buf);
+			if (read(handle, buf, k) != k || mem_write(&mb->data, buf, k) != k) goto fail;
+			n -= k;
+		}
+		mb->pages++;
+	}
+	if (mb->pages == 0) goto fail;
+	live_multi++;
+	return mb;
+fail:
+	free_multi(mb);
+	return NULL;
+}
+
+EXPORT FIMULTIBITMAP *FreeImage_OpenMultiBitmap(int fif, const char *filename, BOOL create_new, BOOL read_only, BOOL keep_cache_in_memory, int flags) {
+	(void)keep_cache_in_memory;
+	CALL(NULL);
+	if (fif != FIF_STUB || create_new || !read_only) return NULL;
+	FILE *f = fopen(filename, "rb");
+	if (!f) return NULL;
+	FIMULTIBITMAP *mb = open_multi(file_read, f, flags);
+	fclose(f);
+	return mb;
+}
+
+EXPORT FIMULTIBITMAP *FreeImage_LoadMultiBitmapFromMemory(int fif, FIMEMORY *mem, int flags) {
+	CALL(NULL);
+	if (fif != FIF_STUB || !mem) return NULL;
+	return open_multi(memory_read, mem, flags);
+}
+
+EXPORT int FreeImage_GetPageCount(FIMULTIBITMAP *mb) { CALL(0); return mb ? mb->pages : 0; }
+
+EXPORT FIBITMAP *FreeImage_LockPage(FIMULTIBITMAP *mb, int page) {
+	CALL(NULL);
+	if (!mb || page < 0 || page >= mb->pages) return NULL;
+	mb->data.pos = mb->offsets[page];
+	return load(memory_read, &mb->data, mb->flags);
+}
+
+EXPORT void FreeImage_UnlockPage(FIMULTIBITMAP *mb, FIBITMAP *dib, BOOL changed) {
+	(void)mb, (void)changed;
+	FreeImage_Unload(dib);
+}
+
+EXPORT BOOL FreeImage_CloseMultiBitmap(FIMULTIBITMAP *mb, int flags) {
+	(void)flags;
+	record("CloseMultiBitmap");
+	if (!mb) return 0;
+	free_multi(mb);
+	live_multi--;
+	return 1;
+}
+
+// metadata -------------------------------------------------------------------
+
+// Bitmaps have no metadata, the searches find nothing.
+EXPORT void *FreeImage_FindFirstMetadata(int model, FIBITMAP *dib, void **tag) {
+	(void)model, (void)dib;
+	CALL(NULL);
+	if (tag) *tag = NULL;
+	return NULL;
+}
+
+EXPORT BOOL FreeImage_FindNextMetadata(void *mdhandle, void **tag) {
+	(void)mdhandle;
+	CALL(0);
+	if (tag) *tag = NULL;
+	return 0;
+}
+
+EXPORT void FreeImage_FindCloseMetadata(void *mdhandle) { (void)mdhandle; record("FindCloseMetadata"); }
+
+EXPORT unsigned FreeImage_GetMetadataCount(int model, FIBITMAP *dib) { (void)model, (void)dib; CALL(0); return 0; }
+
+EXPORT BOOL FreeImage_GetMetadata(int model, FIBITMAP *dib, const char *key, void **tag) {
+	(void)model, (void)dib, (void)key;
+	CALL(0);
+	if (tag) *tag = NULL;
+	return 0;
+}
+
+// Setting a tag is accepted and dropped, clearing a model and cloning
+// succeed.
+EXPORT BOOL FreeImage_SetMetadata(int model, FIBITMAP *dib, const char *key, void *tag) {
+	(void)model, (void)dib, (void)key, (void)tag;
+	CALL(0);
+	return 1;
+}
+
+EXPORT BOOL FreeImage_CloneMetadata(FIBITMAP *dst, FIBITMAP *src) {
+	CALL(0);
+	return dst != NULL && src != NULL;
+}
+
+EXPORT void FreeImage_DestroyICCProfile(FIBITMAP *dib) { (void)dib; record("DestroyICCProfile"); }
+
+// zlib -----------------------------------------------------------------------
+
+// The stub deflates to stored blocks only, and inflates what compress/flate
+// writes with NoCompression: stored blocks, ended by an empty fixed block.
+
+static uint32_t crc32_update(uint32_t crc, const uint8_t *p, uint32_t n) {
+	crc = ~crc;
+	for (uint32_t i = 0; i < n; i++) {
+		crc ^= p[i];
+		for (int k = 0; k < 8; k++) crc = crc >> 1 ^ (0xedb88320 & -(crc & 1));
+	}
+	return ~crc;
+}
+
+static uint32_t adler32(const uint8_t *p, uint32_t n) {
+	uint32_t a = 1, b = 0;
+	for (uint32_t i = 0; i < n; i++) {
+		a = (a + p[i]) % 65521;
+		b = (b + a) % 65521;
+	}
+	return b << 16 | a;
+}
+
+static uint32_t get_le32(const uint8_t *p) { return p[0] | p[1] << 8 | p[2] << 16 | (uint32_t)p[3] << 24; }
+static uint32_t get_be32(const uint8_t *p) { return (uint32_t)p[0] << 24 | p[1] << 16 | p[2] << 8 | p[3]; }
+
+static void put_le32(uint8_t *p, uint32_t v) {
+	for (int i = 0; i < 4; i++) p[i] = v >> 8 * i;
+}
+
+static void put_be32(uint8_t *p, uint32_t v) {
+	for (int i = 0; i < 4; i++) p[i] = v >> 8 * (3 - i);
+}
+
+// deflate_stored returns the size written to dst, 0 if it doesn't fit.
+static uint32_t deflate_stored(uint8_t *dst, uint32_t dst_size, const uint8_t *src, uint32_t n) {
+	uint32_t out = 0;
+	do {
+		uint32_t len = n > 0xffff ? 0xffff : n;
+		if ((uint64_t)out + 5 + len > dst_size) return 0;
+		dst[out++] = len == n; // BFINAL, BTYPE stored
+		dst[out++] = len;
+		dst[out++] = len >> 8;
+		dst[out++] = ~len;
+		dst[out++] = ~len >> 8;
+		memcpy(dst + out, src, len);
+		out += len;
+		src += len;
+		n -= len;
+	} while (n > 0);
+	return out;
+}
+
+// inflate_stored returns the size written to dst and sets *used to the
+// bytes read, 0 if src has other blocks or dst is too small.
+static uint32_t inflate_stored(uint8_t *dst, uint32_t dst_size, const uint8_t *src, uint32_t n, uint32_t *used) {
+	uint32_t in = 0, out = 0;
+	for (int final = 0; !final;) {
+		if ((uint64_t)in + 2 <= n && src[in] == 3 && src[in + 1] == 0) {
+			in += 2; // final empty fixed block
+			break;
+		}
+		if ((uint64_t)in + 5 > n || (src[in] & 6) != 0) return 0;
+		final = src[in] & 1;
+		uint32_t len = src[in + 1] | src[in + 2] << 8;
+		if ((len ^ (src[in + 3] | src[in + 4] << 8)) != 0xffff) return 0;
+		in += 5;
+		if ((uint64_t)in + len > n || (uint64_t)out + len > dst_size) return 0;
+		memcpy(dst + out, src + in, len);
+		in += len;
+		out += len;
+	}
+	*used = in;
+	return out;
+}
+
+EXPORT uint32_t FreeImage_ZLibCompress(uint8_t *target, uint32_t target_size, uint8_t *source, uint32_t source_size) {
+	CALL(0);
+	if (target_size < 6) return 0;
+	uint32_t n = deflate_stored(target + 2, target_size - 6, source, source_size);
+	if (n == 0) return 0;
+	target[0] = 0x78;
+	target[1] = 0x01;
+	put_be32(target + 2 + n, adler32(source, source_size));
+	return n + 6;
+}
+
+EXPORT uint32_t FreeImage_ZLibUncompress(uint8_t *target, uint32_t target_size, uint8_t *source, uint32_t source_size) {
+	CALL(0);
+	uint32_t used;
+	if (source_size < 6 || (source[0] & 0x0f) != 8 || (source[0] << 8 | source[1]) % 31 != 0 || source[1] & 0x20) return 0;
+	uint32_t n = inflate_stored(target, target_size, source + 2, source_size - 6, &used);
+	if (n == 0 || get_be32(source + 2 + used) != adler32(target, n)) return 0;
+	return n;
+}
+
+EXPORT uint32_t FreeImage_ZLibGZip(uint8_t *target, uint32_t target_size, uint8_t *source, uint32_t source_size) {
+	CALL(0);
+	static const uint8_t header[10] = {0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 0xff};
+	if (target_size < 18) return 0;
+	uint32_t n = deflate_stored(target + 10, target_size - 18, source, source_size);
+	if (n == 0) return 0;
+	memcpy(target, header, 10);
+	put_le32(target + 10 + n, crc32_update(0, source, source_size));
+	put_le32(target + 14 + n, source_size);
+	return n + 18;
+}
+
+// GUnzip reads gzip members without optional header fields.
+EXPORT uint32_t FreeImage_ZLibGUnzip(uint8_t *target, uint32_t target_size, uint8_t *source, uint32_t source_size) {
+	CALL(0);
+	uint32_t used;
+	if (source_size < 18 || source[0] != 0x1f || source[1] != 0x8b || source[2] != 8 || source[3] != 0) return 0;
+	uint32_t n = inflate_stored(target, target_size, source + 10, source_size - 18, &used);
+	if (n == 0 || get_le32(source + 10 + used) != crc32_update(0, target, n) || get_le32(source + 14 + used) != n) return 0;
+	return n;
+}
+
+EXPORT uint32_t FreeImage_ZLibCRC32(uint32_t crc, uint8_t *source, uint32_t source_size) {
+	CALL(crc);
+	return crc32_update(crc, source, source_size);
+}
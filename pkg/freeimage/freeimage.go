@@ -7,20 +7,6 @@ import (
 	"github.com/jinzhongmin/usf"
 )
 
-var fiLib *c.Lib
-
-// init library by shared library
-func InitLib(path string, mod c.LibMode) {
-	if fiLib != nil {
-		return
-	}
-	var err error
-	fiLib, err = c.NewLib(path, mod)
-	if err != nil {
-		panic(err)
-	}
-}
-
 type inArgs []interface{}
 type BitMap struct{}      //FI_STRUCT (FIBITMAP) { void *data; };
 type MultiBitMap struct{} //FI_STRUCT (FIMULTIBITMAP) { void *data; };
@@ -96,6 +82,11 @@ type ICCProfile struct {
 	data  unsafe.Pointer //! points to a block of contiguous memory containing the profile
 }
 
+const (
+	FIICC_DEFAULT       = 0x00
+	FIICC_COLOR_IS_CMYK = 0x01
+)
+
 type FREE_IMAGE_TYPE int32
 type FREE_IMAGE_FORMAT int32
 type FREE_IMAGE_COLOR_TYPE int32
@@ -111,9 +102,9 @@ type FREE_IMAGE_COLOR_CHANNEL int32
 type SeekOrigin int32
 
 const (
-	SEEK_CUR SeekOrigin = 1
-	SEEK_END SeekOrigin = 1
 	SEEK_SET SeekOrigin = 0
+	SEEK_CUR SeekOrigin = 1
+	SEEK_END SeekOrigin = 2
 )
 
 const (
@@ -255,21 +246,107 @@ const (
 	FIF_JXR     FREE_IMAGE_FORMAT = 36
 )
 
+// Load / Save flag constants -----------------------------------------------
+
+const (
+	FIF_LOAD_NOPIXELS = 0x8000 //! loading: load the image header only (not supported by all plugins, default to full loading)
+
+	BMP_DEFAULT               = 0
+	BMP_SAVE_RLE              = 1
+	EXR_DEFAULT               = 0      //! save data as half with piz-based wavelet compression
+	EXR_FLOAT                 = 0x0001 //! save data as float instead of as half (not recommended)
+	EXR_NONE                  = 0x0002 //! save with no compression
+	EXR_ZIP                   = 0x0004 //! save with zlib compression, in blocks of 16 scan lines
+	EXR_PIZ                   = 0x0008 //! save with piz-based wavelet compression
+	EXR_PXR24                 = 0x0010 //! save with lossy 24-bit float compression
+	EXR_B44                   = 0x0020 //! save with lossy 44% float compression - goes to 22% when combined with EXR_LC
+	EXR_LC                    = 0x0040 //! save images with one luminance and two chroma channels, rather than as RGB (lossy compression)
+	GIF_DEFAULT               = 0
+	GIF_LOAD256               = 1 //! load the image as a 256 color image with ununsed palette entries, if it's 16 or 2 color
+	GIF_PLAYBACK              = 2 //! 'Play' the GIF to generate each frame (as 32bpp) instead of returning raw frame data when loading
+	ICO_DEFAULT               = 0
+	ICO_MAKEALPHA             = 1       //! convert to 32bpp and create an alpha channel from the AND-mask when loading
+	J2K_DEFAULT               = 0       //! save with a 16:1 rate
+	JP2_DEFAULT               = 0       //! save with a 16:1 rate
+	JPEG_DEFAULT              = 0       //! loading (see JPEG_FAST); saving (see JPEG_QUALITYGOOD|JPEG_SUBSAMPLING_420)
+	JPEG_FAST                 = 0x0001  //! load the file as fast as possible, sacrificing some quality
+	JPEG_ACCURATE             = 0x0002  //! load the file with the best quality, sacrificing some speed
+	JPEG_CMYK                 = 0x0004  //! load separated CMYK "as is" (use | to combine with other load flags)
+	JPEG_EXIFROTATE           = 0x0008  //! load and rotate according to Exif 'Orientation' tag if available
+	JPEG_GREYSCALE            = 0x0010  //! load and convert to a 8-bit greyscale image
+	JPEG_QUALITYSUPERB        = 0x80    //! save with superb quality (100:1)
+	JPEG_QUALITYGOOD          = 0x0100  //! save with good quality (75:1)
+	JPEG_QUALITYNORMAL        = 0x0200  //! save with normal quality (50:1)
+	JPEG_QUALITYAVERAGE       = 0x0400  //! save with average quality (25:1)
+	JPEG_QUALITYBAD           = 0x0800  //! save with bad quality (10:1)
+	JPEG_PROGRESSIVE          = 0x2000  //! save as a progressive-JPEG (use | to combine with other save flags)
+	JPEG_SUBSAMPLING_411      = 0x1000  //! save with high 4x1 chroma subsampling (4:1:1)
+	JPEG_SUBSAMPLING_420      = 0x4000  //! save with medium 2x2 medium chroma subsampling (4:2:0) - default value
+	JPEG_SUBSAMPLING_422      = 0x8000  //! save with low 2x1 chroma subsampling (4:2:2)
+	JPEG_SUBSAMPLING_444      = 0x10000 //! save with no chroma subsampling (4:4:4)
+	JPEG_OPTIMIZE             = 0x20000 //! on saving, compute optimal Huffman coding tables (can reduce a few percent of file size)
+	JPEG_BASELINE             = 0x40000 //! save basic JPEG, without metadata or any markers
+	JXR_DEFAULT               = 0       //! save with quality 80 and no chroma subsampling (4:4:4)
+	JXR_LOSSLESS              = 0x0064  //! save lossless
+	JXR_PROGRESSIVE           = 0x2000  //! save as a progressive-JXR (use | to combine with other save flags)
+	PNG_DEFAULT               = 0
+	PNG_IGNOREGAMMA           = 1      //! loading: avoid gamma correction
+	PNG_Z_BEST_SPEED          = 0x0001 //! save using ZLib level 1 compression flag (default value is 6)
+	PNG_Z_DEFAULT_COMPRESSION = 0x0006 //! save using ZLib level 6 compression flag (default recommended value)
+	PNG_Z_BEST_COMPRESSION    = 0x0009 //! save using ZLib level 9 compression flag (default value is 6)
+	PNG_Z_NO_COMPRESSION      = 0x0100 //! save without ZLib compression
+	PNG_INTERLACED            = 0x0200 //! save using Adam7 interlacing (use | to combine with other save flags)
+	PSD_DEFAULT               = 0
+	PSD_CMYK                  = 1 //! reads tags for separated CMYK (default is conversion to RGB)
+	PSD_LAB                   = 2 //! reads tags for CIELab (default is conversion to RGB)
+	RAW_DEFAULT               = 0 //! load the file as linear RGB 48-bit
+	RAW_PREVIEW               = 1 //! try to load the embedded JPEG preview with included Exif Data or default to RGB 24-bit
+	RAW_DISPLAY               = 2 //! load the file as RGB 24-bit
+	RAW_HALFSIZE              = 4 //! output a half-size color image
+	RAW_UNPROCESSED           = 8 //! output a FIT_UINT16 raw Bayer image
+	TARGA_DEFAULT             = 0
+	TARGA_LOAD_RGB888         = 1 //! if set the loader converts RGB555 and ARGB8888 -> RGB888.
+	TARGA_SAVE_RLE            = 2 //! if set, the writer saves with RLE compression
+	TIFF_DEFAULT              = 0
+	TIFF_CMYK                 = 0x0001  //! reads/stores tags for separated CMYK (use | to combine with compression flags)
+	TIFF_PACKBITS             = 0x0100  //! save using PACKBITS compression
+	TIFF_DEFLATE              = 0x0200  //! save using DEFLATE compression (a.k.a. ZLIB compression)
+	TIFF_ADOBE_DEFLATE        = 0x0400  //! save using ADOBE DEFLATE compression
+	TIFF_NONE                 = 0x0800  //! save without any compression
+	TIFF_CCITTFAX3            = 0x1000  //! save using CCITT Group 3 fax encoding
+	TIFF_CCITTFAX4            = 0x2000  //! save using CCITT Group 4 fax encoding
+	TIFF_LZW                  = 0x4000  //! save using LZW compression
+	TIFF_JPEG                 = 0x8000  //! save using JPEG compression
+	TIFF_LOGLUV               = 0x10000 //! save using LogLuv compression
+	WEBP_DEFAULT              = 0       //! save with good quality (75:1)
+	WEBP_LOSSLESS             = 0x100   //! save in lossless mode
+
+	FI_COLOR_IS_RGB_COLOR        = 0x00 //! RGBQUAD color is a RGB color (contains no valid alpha channel)
+	FI_COLOR_IS_RGBA_COLOR       = 0x01 //! RGBQUAD color is a RGBA color (contains a valid alpha channel)
+	FI_COLOR_FIND_EQUAL_COLOR    = 0x02 //! For palettized images: lookup equal RGB color from palette
+	FI_COLOR_ALPHA_IS_INDEX      = 0x04 //! The color's rgbReserved member (alpha) contains the palette index to be used
+	FI_COLOR_PALETTE_SEARCH_MASK = FI_COLOR_FIND_EQUAL_COLOR | FI_COLOR_ALPHA_IS_INDEX
+	FI_RESCALE_DEFAULT           = 0x00 //! default options; none of the following other options apply
+	FI_RESCALE_TRUE_COLOR        = 0x01 //! for non-transparent greyscale images, convert to 24-bit if src bitdepth <= 8 (default is a 8-bit greyscale image).
+	FI_RESCALE_OMIT_METADATA     = 0x02 //! do not copy metadata to the rescaled image
+)
+
 // Init / Error routines ----------------------------------------------------
 
 var _func_FreeImage_Initialise_ = &c.FuncPrototype{Name: "FreeImage_Initialise", OutType: c.Void, InTypes: []c.Type{c.I32}}
 
 // DLL_API void DLL_CALLCONV FreeImage_Initialise(BOOL load_local_plugins_only FI_DEFAULT(FALSE));
+//
+// The call is reference counted, see Library.Initialise.
 func Initialise(load_local_plugins_only bool) {
-	b := c.CBool(load_local_plugins_only)
-	fiLib.Call(_func_FreeImage_Initialise_, inArgs{&b})
+	fiLib.get().Initialise(load_local_plugins_only)
 }
 
 var _func_FreeImage_DeInitialise_ = &c.FuncPrototype{Name: "FreeImage_DeInitialise", OutType: c.Void, InTypes: nil}
 
 // DLL_API void DLL_CALLCONV FreeImage_DeInitialise(void);
 func DeInitialise() {
-	fiLib.Call(_func_FreeImage_DeInitialise_, nil)
+	fiLib.get().DeInitialise()
 }
 
 // Version routines ---------------------------------------------------------
@@ -294,6 +371,10 @@ func GetCopyrightMessage() string {
 // typedef void (DLL_CALLCONV *FreeImage_OutputMessageFunctionStdCall)(FREE_IMAGE_FORMAT fif, const char *msg);
 
 // DLL_API void DLL_CALLCONV FreeImage_SetOutputMessageStdCall(FreeImage_OutputMessageFunctionStdCall omf);
+
+// set by Open with the Logger of WithInstrumentation
+var _func_FreeImage_SetOutputMessage_ = &c.FuncPrototype{Name: "FreeImage_SetOutputMessage", OutType: c.Void, InTypes: []c.Type{c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_SetOutputMessage(FreeImage_OutputMessageFunction omf);
 // DLL_API void DLL_CALLCONV FreeImage_OutputMessageProc(int fif, const char *fmt, ...);
 
@@ -315,6 +396,14 @@ func AllocateT(typ FREE_IMAGE_TYPE, width, height int32, bpp int32, red, green,
 	return (*BitMap)(fiLib.Call(_func_FreeImage_AllocateT_, inArgs{&typ, &width, &height, &bpp, &red, &green, &blue}).PtrFree())
 }
 
+var _func_FreeImage_AllocateHeader_ = &c.FuncPrototype{Name: "FreeImage_AllocateHeader", OutType: c.Pointer, InTypes: []c.Type{c.I32, c.I32, c.I32, c.I32, c.U32, c.U32, c.U32}}
+
+// DLL_API FIBITMAP *DLL_CALLCONV FreeImage_AllocateHeader(BOOL header_only, int width, int height, int bpp, unsigned red_mask FI_DEFAULT(0), unsigned green_mask FI_DEFAULT(0), unsigned blue_mask FI_DEFAULT(0));
+func AllocateHeader(header_only bool, width, height int32, bpp int32, red, green, blue uint32) *BitMap {
+	ho := c.CBool(header_only)
+	return (*BitMap)(fiLib.Call(_func_FreeImage_AllocateHeader_, inArgs{&ho, &width, &height, &bpp, &red, &green, &blue}).PtrFree())
+}
+
 var _func_FreeImage_Clone_ = &c.FuncPrototype{Name: "FreeImage_Clone", OutType: c.Pointer, InTypes: []c.Type{c.Pointer}}
 
 // DLL_API FIBITMAP * DLL_CALLCONV FreeImage_Clone(FIBITMAP *dib);
@@ -374,10 +463,7 @@ var _func_FreeImage_OpenMemory_ = &c.FuncPrototype{Name: "FreeImage_OpenMemory",
 
 // DLL_API FIMEMORY *DLL_CALLCONV FreeImage_OpenMemory(BYTE *data FI_DEFAULT(0), DWORD size_in_bytes FI_DEFAULT(0));
 func OpenMemory(data []byte) *Memory {
-	d, l := (*byte)(nil), uint32(0)
-	if data != nil {
-		d, l = &data[0], uint32(len(data))
-	}
+	d, l := bytesPtr(data), uint32(len(data))
 	return (*Memory)(fiLib.Call(_func_FreeImage_OpenMemory_, inArgs{&d, &l}).PtrFree())
 }
 
@@ -415,18 +501,26 @@ func (dib *BitMap) SaveToMemory(fif FREE_IMAGE_FORMAT, stream *Memory, flag int3
 	return fiLib.Call(_func_FreeImage_SaveToMemory_, inArgs{&fif, &dib, &stream, &flag}).BoolFree()
 }
 
-var _func_FreeImage_TellMemory_ = &c.FuncPrototype{Name: "FreeImage_TellMemory", OutType: c.I32, InTypes: []c.Type{c.Pointer}}
+var _func_FreeImage_TellMemory_ = &c.FuncPrototype{Name: "FreeImage_TellMemory", OutType: cLongType, InTypes: []c.Type{c.Pointer}}
 
 // DLL_API long DLL_CALLCONV FreeImage_TellMemory(FIMEMORY *stream);
-func (stream *Memory) Tell() int32 {
-	return fiLib.Call(_func_FreeImage_TellMemory_, inArgs{&stream}).I32Free()
+func (stream *Memory) Tell() int64 {
+	v := fiLib.Call(_func_FreeImage_TellMemory_, inArgs{&stream})
+	if cLongType == c.I32 {
+		return int64(v.I32Free())
+	}
+	return v.I64Free()
 }
 
-var _func_FreeImage_SeekMemory_ = &c.FuncPrototype{Name: "FreeImage_SeekMemory", OutType: c.I32, InTypes: []c.Type{c.Pointer, c.I32, c.I32}}
+var _func_FreeImage_SeekMemory_ = &c.FuncPrototype{Name: "FreeImage_SeekMemory", OutType: c.I32, InTypes: []c.Type{c.Pointer, cLongType, c.I32}}
 
 // DLL_API BOOL DLL_CALLCONV FreeImage_SeekMemory(FIMEMORY *stream, long offset, int origin);
-func (stream *Memory) SeekMemory(offset int32, origin SeekOrigin) bool {
-	return fiLib.Call(_func_FreeImage_SeekMemory_, inArgs{&stream, &offset, &origin}).BoolFree()
+//
+// offset must fit in a C long, 32 bit on Windows and 32 bit platforms, see
+// Memory.Seek for a checked version.
+func (stream *Memory) SeekMemory(offset int64, origin SeekOrigin) bool {
+	o := cLong(offset)
+	return fiLib.Call(_func_FreeImage_SeekMemory_, inArgs{&stream, &o, &origin}).BoolFree()
 }
 
 var _func_FreeImage_AcquireMemory_ = &c.FuncPrototype{Name: "FreeImage_AcquireMemory", OutType: c.I32, InTypes: []c.Type{c.Pointer, c.Pointer, c.Pointer}}
@@ -515,26 +609,171 @@ func (mb *MultiBitMap) SaveToMemory(fif FREE_IMAGE_FORMAT, stream *Memory, flag
 
 // Plugin Interface ---------------------------------------------------------
 
+var _func_FreeImage_RegisterLocalPlugin_ = &c.FuncPrototype{Name: "FreeImage_RegisterLocalPlugin", OutType: c.I32, InTypes: []c.Type{c.Pointer, c.Pointer, c.Pointer, c.Pointer, c.Pointer}}
+
 // DLL_API FREE_IMAGE_FORMAT DLL_CALLCONV FreeImage_RegisterLocalPlugin(FI_InitProc proc_address, const char *format FI_DEFAULT(0), const char *description FI_DEFAULT(0), const char *extension FI_DEFAULT(0), const char *regexpr FI_DEFAULT(0));
+//
+// FreeImage keeps the strings, the non empty ones are never freed. See
+// RegisterPlugin to implement a format in Go.
+func RegisterLocalPlugin(proc_address unsafe.Pointer, format, description, extension, regexpr string) FREE_IMAGE_FORMAT {
+	f, d, e, r := optCStr(format), optCStr(description), optCStr(extension), optCStr(regexpr)
+	return FREE_IMAGE_FORMAT(fiLib.Call(_func_FreeImage_RegisterLocalPlugin_, inArgs{&proc_address, &f, &d, &e, &r}).I32Free())
+}
+
+// optCStr returns s as a C string, NULL if empty.
+func optCStr(s string) unsafe.Pointer {
+	if s == "" {
+		return nil
+	}
+	return c.CStr(s)
+}
+
+var _func_FreeImage_RegisterExternalPlugin_ = &c.FuncPrototype{Name: "FreeImage_RegisterExternalPlugin", OutType: c.I32, InTypes: []c.Type{c.Pointer, c.Pointer, c.Pointer, c.Pointer, c.Pointer}}
+
 // DLL_API FREE_IMAGE_FORMAT DLL_CALLCONV FreeImage_RegisterExternalPlugin(const char *path, const char *format FI_DEFAULT(0), const char *description FI_DEFAULT(0), const char *extension FI_DEFAULT(0), const char *regexpr FI_DEFAULT(0));
+//
+// FreeImage only implements it on Windows, see WithPluginDir. The non
+// empty names are never freed, like with RegisterLocalPlugin.
+func RegisterExternalPlugin(path, format, description, extension, regexpr string) FREE_IMAGE_FORMAT {
+	p := c.CStr(path)
+	defer c.Free(p)
+	f, d, e, r := optCStr(format), optCStr(description), optCStr(extension), optCStr(regexpr)
+	return FREE_IMAGE_FORMAT(fiLib.Call(_func_FreeImage_RegisterExternalPlugin_, inArgs{&p, &f, &d, &e, &r}).I32Free())
+}
+
+var _func_FreeImage_GetFIFCount_ = &c.FuncPrototype{Name: "FreeImage_GetFIFCount", OutType: c.I32, InTypes: nil}
+
 // DLL_API int DLL_CALLCONV FreeImage_GetFIFCount(void);
+func GetFIFCount() int32 {
+	return fiLib.Call(_func_FreeImage_GetFIFCount_, nil).I32Free()
+}
+
+var _func_FreeImage_SetPluginEnabled_ = &c.FuncPrototype{Name: "FreeImage_SetPluginEnabled", OutType: c.I32, InTypes: []c.Type{c.I32, c.I32}}
+
 // DLL_API int DLL_CALLCONV FreeImage_SetPluginEnabled(FREE_IMAGE_FORMAT fif, BOOL enable);
+//
+// Returns the previous state, 1 or 0, or -1 if fif is not registered.
+func SetPluginEnabled(fif FREE_IMAGE_FORMAT, enable bool) int32 {
+	e := c.CBool(enable)
+	return fiLib.Call(_func_FreeImage_SetPluginEnabled_, inArgs{&fif, &e}).I32Free()
+}
+
+var _func_FreeImage_IsPluginEnabled_ = &c.FuncPrototype{Name: "FreeImage_IsPluginEnabled", OutType: c.I32, InTypes: []c.Type{c.I32}}
+
 // DLL_API int DLL_CALLCONV FreeImage_IsPluginEnabled(FREE_IMAGE_FORMAT fif);
+//
+// Returns 1 or 0, or -1 if fif is not registered.
+func IsPluginEnabled(fif FREE_IMAGE_FORMAT) int32 {
+	return fiLib.Call(_func_FreeImage_IsPluginEnabled_, inArgs{&fif}).I32Free()
+}
+
+var _func_FreeImage_GetFIFFromFormat_ = &c.FuncPrototype{Name: "FreeImage_GetFIFFromFormat", OutType: c.I32, InTypes: []c.Type{c.Pointer}}
+
 // DLL_API FREE_IMAGE_FORMAT DLL_CALLCONV FreeImage_GetFIFFromFormat(const char *format);
+func GetFIFFromFormat(format string) FREE_IMAGE_FORMAT {
+	f := c.CStr(format)
+	defer c.Free(f)
+	return FREE_IMAGE_FORMAT(fiLib.Call(_func_FreeImage_GetFIFFromFormat_, inArgs{&f}).I32Free())
+}
+
+var _func_FreeImage_GetFIFFromMime_ = &c.FuncPrototype{Name: "FreeImage_GetFIFFromMime", OutType: c.I32, InTypes: []c.Type{c.Pointer}}
+
 // DLL_API FREE_IMAGE_FORMAT DLL_CALLCONV FreeImage_GetFIFFromMime(const char *mime);
+func GetFIFFromMime(mime string) FREE_IMAGE_FORMAT {
+	m := c.CStr(mime)
+	defer c.Free(m)
+	return FREE_IMAGE_FORMAT(fiLib.Call(_func_FreeImage_GetFIFFromMime_, inArgs{&m}).I32Free())
+}
+
+var _func_FreeImage_GetFormatFromFIF_ = &c.FuncPrototype{Name: "FreeImage_GetFormatFromFIF", OutType: c.Pointer, InTypes: []c.Type{c.I32}}
+
 // DLL_API const char *DLL_CALLCONV FreeImage_GetFormatFromFIF(FREE_IMAGE_FORMAT fif);
+func GetFormatFromFIF(fif FREE_IMAGE_FORMAT) string {
+	return fiLib.Call(_func_FreeImage_GetFormatFromFIF_, inArgs{&fif}).StrFree()
+}
+
+var _func_FreeImage_GetFIFExtensionList_ = &c.FuncPrototype{Name: "FreeImage_GetFIFExtensionList", OutType: c.Pointer, InTypes: []c.Type{c.I32}}
+
 // DLL_API const char *DLL_CALLCONV FreeImage_GetFIFExtensionList(FREE_IMAGE_FORMAT fif);
+//
+// returns a comma-delimited file extension list, e.g., "jpg,jif,jpeg,jpe"
+func GetFIFExtensionList(fif FREE_IMAGE_FORMAT) string {
+	return fiLib.Call(_func_FreeImage_GetFIFExtensionList_, inArgs{&fif}).StrFree()
+}
+
+var _func_FreeImage_GetFIFDescription_ = &c.FuncPrototype{Name: "FreeImage_GetFIFDescription", OutType: c.Pointer, InTypes: []c.Type{c.I32}}
+
 // DLL_API const char *DLL_CALLCONV FreeImage_GetFIFDescription(FREE_IMAGE_FORMAT fif);
+func GetFIFDescription(fif FREE_IMAGE_FORMAT) string {
+	return fiLib.Call(_func_FreeImage_GetFIFDescription_, inArgs{&fif}).StrFree()
+}
+
+var _func_FreeImage_GetFIFRegExpr_ = &c.FuncPrototype{Name: "FreeImage_GetFIFRegExpr", OutType: c.Pointer, InTypes: []c.Type{c.I32}}
+
 // DLL_API const char *DLL_CALLCONV FreeImage_GetFIFRegExpr(FREE_IMAGE_FORMAT fif);
+func GetFIFRegExpr(fif FREE_IMAGE_FORMAT) string {
+	return fiLib.Call(_func_FreeImage_GetFIFRegExpr_, inArgs{&fif}).StrFree()
+}
+
+var _func_FreeImage_GetFIFMimeType_ = &c.FuncPrototype{Name: "FreeImage_GetFIFMimeType", OutType: c.Pointer, InTypes: []c.Type{c.I32}}
+
 // DLL_API const char *DLL_CALLCONV FreeImage_GetFIFMimeType(FREE_IMAGE_FORMAT fif);
+func GetFIFMimeType(fif FREE_IMAGE_FORMAT) string {
+	return fiLib.Call(_func_FreeImage_GetFIFMimeType_, inArgs{&fif}).StrFree()
+}
+
+var _func_FreeImage_GetFIFFromFilename_ = &c.FuncPrototype{Name: "FreeImage_GetFIFFromFilename", OutType: c.I32, InTypes: []c.Type{c.Pointer}}
+
 // DLL_API FREE_IMAGE_FORMAT DLL_CALLCONV FreeImage_GetFIFFromFilename(const char *filename);
+func GetFIFFromFilename(filename string) FREE_IMAGE_FORMAT {
+	fn := c.CStr(filename)
+	defer c.Free(fn)
+	return FREE_IMAGE_FORMAT(fiLib.Call(_func_FreeImage_GetFIFFromFilename_, inArgs{&fn}).I32Free())
+}
+
 // DLL_API FREE_IMAGE_FORMAT DLL_CALLCONV FreeImage_GetFIFFromFilenameU(const wchar_t *filename);
+
+var _func_FreeImage_FIFSupportsReading_ = &c.FuncPrototype{Name: "FreeImage_FIFSupportsReading", OutType: c.I32, InTypes: []c.Type{c.I32}}
+
 // DLL_API BOOL DLL_CALLCONV FreeImage_FIFSupportsReading(FREE_IMAGE_FORMAT fif);
+func FIFSupportsReading(fif FREE_IMAGE_FORMAT) bool {
+	return fiLib.Call(_func_FreeImage_FIFSupportsReading_, inArgs{&fif}).BoolFree()
+}
+
+var _func_FreeImage_FIFSupportsWriting_ = &c.FuncPrototype{Name: "FreeImage_FIFSupportsWriting", OutType: c.I32, InTypes: []c.Type{c.I32}}
+
 // DLL_API BOOL DLL_CALLCONV FreeImage_FIFSupportsWriting(FREE_IMAGE_FORMAT fif);
+func FIFSupportsWriting(fif FREE_IMAGE_FORMAT) bool {
+	return fiLib.Call(_func_FreeImage_FIFSupportsWriting_, inArgs{&fif}).BoolFree()
+}
+
+var _func_FreeImage_FIFSupportsExportBPP_ = &c.FuncPrototype{Name: "FreeImage_FIFSupportsExportBPP", OutType: c.I32, InTypes: []c.Type{c.I32, c.I32}}
+
 // DLL_API BOOL DLL_CALLCONV FreeImage_FIFSupportsExportBPP(FREE_IMAGE_FORMAT fif, int bpp);
+func FIFSupportsExportBPP(fif FREE_IMAGE_FORMAT, bpp int32) bool {
+	return fiLib.Call(_func_FreeImage_FIFSupportsExportBPP_, inArgs{&fif, &bpp}).BoolFree()
+}
+
+var _func_FreeImage_FIFSupportsExportType_ = &c.FuncPrototype{Name: "FreeImage_FIFSupportsExportType", OutType: c.I32, InTypes: []c.Type{c.I32, c.I32}}
+
 // DLL_API BOOL DLL_CALLCONV FreeImage_FIFSupportsExportType(FREE_IMAGE_FORMAT fif, FREE_IMAGE_TYPE type);
+func FIFSupportsExportType(fif FREE_IMAGE_FORMAT, typ FREE_IMAGE_TYPE) bool {
+	return fiLib.Call(_func_FreeImage_FIFSupportsExportType_, inArgs{&fif, &typ}).BoolFree()
+}
+
+var _func_FreeImage_FIFSupportsICCProfiles_ = &c.FuncPrototype{Name: "FreeImage_FIFSupportsICCProfiles", OutType: c.I32, InTypes: []c.Type{c.I32}}
+
 // DLL_API BOOL DLL_CALLCONV FreeImage_FIFSupportsICCProfiles(FREE_IMAGE_FORMAT fif);
+func FIFSupportsICCProfiles(fif FREE_IMAGE_FORMAT) bool {
+	return fiLib.Call(_func_FreeImage_FIFSupportsICCProfiles_, inArgs{&fif}).BoolFree()
+}
+
+var _func_FreeImage_FIFSupportsNoPixels_ = &c.FuncPrototype{Name: "FreeImage_FIFSupportsNoPixels", OutType: c.I32, InTypes: []c.Type{c.I32}}
+
 // DLL_API BOOL DLL_CALLCONV FreeImage_FIFSupportsNoPixels(FREE_IMAGE_FORMAT fif);
+func FIFSupportsNoPixels(fif FREE_IMAGE_FORMAT) bool {
+	return fiLib.Call(_func_FreeImage_FIFSupportsNoPixels_, inArgs{&fif}).BoolFree()
+}
 
 // Multipaging interface ----------------------------------------------------
 
@@ -544,7 +783,7 @@ var _func_FreeImage_OpenMultiBitmap_ = &c.FuncPrototype{Name: "FreeImage_OpenMul
 func OpenMultiBitmap(fif FREE_IMAGE_FORMAT, filename string, create_new, read_only, keep_cache_in_memory bool, flag int32) *MultiBitMap {
 	fn, cn, ro, kcm := c.CStr(filename), c.CBool(create_new), c.CBool(read_only), c.CBool(keep_cache_in_memory)
 	defer c.Free(fn)
-	return (*MultiBitMap)(fiLib.Call(_func_FreeImage_OpenMultiBitmap_, inArgs{&fif, &fn, &cn, &ro, kcm, &flag}).PtrFree())
+	return (*MultiBitMap)(fiLib.Call(_func_FreeImage_OpenMultiBitmap_, inArgs{&fif, &fn, &cn, &ro, &kcm, &flag}).PtrFree())
 }
 
 func NewMultiBitmapFromFile(fif FREE_IMAGE_FORMAT, filename string, create_new, read_only, keep_cache_in_memory bool, flag int32) *MultiBitMap {
@@ -729,6 +968,9 @@ var _func_FreeImage_GetBits_ = &c.FuncPrototype{Name: "FreeImage_GetBits", OutTy
 
 // DLL_API BYTE *DLL_CALLCONV FreeImage_GetBits(FIBITMAP *dib);
 func (dib *BitMap) GetBits() unsafe.Pointer {
+	if direct() {
+		return cGetBits(dib)
+	}
 	return fiLib.Call(_func_FreeImage_GetBits_, inArgs{&dib}).PtrFree()
 }
 
@@ -736,6 +978,9 @@ var _func_FreeImage_GetScanLine_ = &c.FuncPrototype{Name: "FreeImage_GetScanLine
 
 // DLL_API BYTE *DLL_CALLCONV FreeImage_GetScanLine(FIBITMAP *dib, int scanline);
 func (dib *BitMap) GetScanLine(scanline int32) unsafe.Pointer {
+	if direct() {
+		return cGetScanLine(dib, scanline)
+	}
 	return fiLib.Call(_func_FreeImage_GetScanLine_, inArgs{&dib, &scanline}).PtrFree()
 }
 
@@ -749,6 +994,9 @@ var _func_FreeImage_GetPixelIndex_ = &c.FuncPrototype{Name: "FreeImage_GetPixelI
 // dib.GetPixelIndex(1, 1, &buf[0])
 func (dib *BitMap) GetPixelIndex(x, y uint32, refAddr interface{}) (ok bool) {
 	p := usf.AddrOf(refAddr)
+	if direct() {
+		return cGetPixelIndex(dib, x, y, p)
+	}
 	return fiLib.Call(_func_FreeImage_GetPixelIndex_, inArgs{&dib, &x, &y, &p}).BoolFree()
 }
 
@@ -756,6 +1004,10 @@ var _func_FreeImage_GetPixelColor_ = &c.FuncPrototype{Name: "FreeImage_GetPixelC
 
 // DLL_API BOOL DLL_CALLCONV FreeImage_GetPixelColor(FIBITMAP *dib, unsigned x, unsigned y, RGBQUAD *value);
 func (dib *BitMap) GetPixelColor(x, y uint32) (value RGBQUAD, ok bool) {
+	if direct() {
+		ok = cGetPixelColor(dib, x, y, &value)
+		return
+	}
 	v := &value
 	ok = fiLib.Call(_func_FreeImage_GetPixelColor_, inArgs{&dib, &x, &y, &v}).BoolFree()
 	return
@@ -766,6 +1018,9 @@ var _func_FreeImage_SetPixelIndex_ = &c.FuncPrototype{Name: "FreeImage_SetPixelI
 // DLL_API BOOL DLL_CALLCONV FreeImage_SetPixelIndex(FIBITMAP *dib, unsigned x, unsigned y, BYTE *value);
 func (dib *BitMap) SetPixelIndex(x, y uint32, addr interface{}) (ok bool) {
 	p := usf.AddrOf(addr)
+	if direct() {
+		return cSetPixelIndex(dib, x, y, p)
+	}
 	return fiLib.Call(_func_FreeImage_SetPixelIndex_, inArgs{&dib, &x, &y, &p}).BoolFree()
 }
 
@@ -773,6 +1028,9 @@ var _func_FreeImage_SetPixelColor_ = &c.FuncPrototype{Name: "FreeImage_SetPixelC
 
 // DLL_API BOOL DLL_CALLCONV FreeImage_SetPixelColor(FIBITMAP *dib, unsigned x, unsigned y, RGBQUAD *value);
 func (dib *BitMap) SetPixelColor(x, y uint32, value *RGBQUAD) (ok bool) {
+	if direct() {
+		return cSetPixelColor(dib, x, y, value)
+	}
 	return fiLib.Call(_func_FreeImage_SetPixelColor_, inArgs{&dib, &x, &y, &value}).BoolFree()
 }
 
@@ -789,6 +1047,9 @@ var _func_FreeImage_GetBPP_ = &c.FuncPrototype{Name: "FreeImage_GetBPP", OutType
 
 // DLL_API unsigned DLL_CALLCONV FreeImage_GetBPP(FIBITMAP *dib);
 func (dib *BitMap) GetBPP() uint32 {
+	if direct() {
+		return cGetBPP(dib)
+	}
 	return fiLib.Call(_func_FreeImage_GetBPP_, inArgs{&dib}).U32Free()
 }
 
@@ -796,6 +1057,9 @@ var _func_FreeImage_GetWidth_ = &c.FuncPrototype{Name: "FreeImage_GetWidth", Out
 
 // DLL_API unsigned DLL_CALLCONV FreeImage_GetWidth(FIBITMAP *dib);
 func (dib *BitMap) GetWidth() uint32 {
+	if direct() {
+		return cGetWidth(dib)
+	}
 	return fiLib.Call(_func_FreeImage_GetWidth_, inArgs{&dib}).U32Free()
 }
 
@@ -803,6 +1067,9 @@ var _func_FreeImage_GetHeight_ = &c.FuncPrototype{Name: "FreeImage_GetHeight", O
 
 // DLL_API unsigned DLL_CALLCONV FreeImage_GetHeight(FIBITMAP *dib);
 func (dib *BitMap) GetHeight() uint32 {
+	if direct() {
+		return cGetHeight(dib)
+	}
 	return fiLib.Call(_func_FreeImage_GetHeight_, inArgs{&dib}).U32Free()
 }
 
@@ -810,6 +1077,9 @@ var _func_FreeImage_GetLine_ = &c.FuncPrototype{Name: "FreeImage_GetLine", OutTy
 
 // DLL_API unsigned DLL_CALLCONV FreeImage_GetLine(FIBITMAP *dib);
 func (dib *BitMap) GetLine() uint32 {
+	if direct() {
+		return cGetLine(dib)
+	}
 	return fiLib.Call(_func_FreeImage_GetLine_, inArgs{&dib}).U32Free()
 }
 
@@ -817,6 +1087,9 @@ var _func_FreeImage_GetPitch_ = &c.FuncPrototype{Name: "FreeImage_GetPitch", Out
 
 // DLL_API unsigned DLL_CALLCONV FreeImage_GetPitch(FIBITMAP *dib);
 func (dib *BitMap) GetPitch() uint32 {
+	if direct() {
+		return cGetPitch(dib)
+	}
 	return fiLib.Call(_func_FreeImage_GetPitch_, inArgs{&dib}).U32Free()
 }
 
@@ -834,11 +1107,11 @@ func (dib *BitMap) GetMemorySize() uint32 {
 	return fiLib.Call(_func_FreeImage_GetMemorySize_, inArgs{&dib}).U32Free()
 }
 
-var _func_FreeImage_GetPalette_ = &c.FuncPrototype{Name: "FreeImage_GetPalette", OutType: c.U32, InTypes: []c.Type{c.Pointer}}
+var _func_FreeImage_GetPalette_ = &c.FuncPrototype{Name: "FreeImage_GetPalette", OutType: c.Pointer, InTypes: []c.Type{c.Pointer}}
 
 // DLL_API RGBQUAD *DLL_CALLCONV FreeImage_GetPalette(FIBITMAP *dib);
-func (dib *BitMap) GetPalette() uint32 {
-	return fiLib.Call(_func_FreeImage_GetPalette_, inArgs{&dib}).U32Free()
+func (dib *BitMap) GetPalette() *RGBQUAD {
+	return (*RGBQUAD)(fiLib.Call(_func_FreeImage_GetPalette_, inArgs{&dib}).PtrFree())
 }
 
 var _func_FreeImage_GetDotsPerMeterX_ = &c.FuncPrototype{Name: "FreeImage_GetDotsPerMeterX", OutType: c.U32, InTypes: []c.Type{c.Pointer}}
@@ -936,8 +1209,11 @@ func (dib *BitMap) SetTransparent(enabled bool) {
 var _func_FreeImage_SetTransparencyTable_ = &c.FuncPrototype{Name: "FreeImage_SetTransparencyTable", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
 
 // DLL_API void DLL_CALLCONV FreeImage_SetTransparencyTable(FIBITMAP *dib, BYTE *table, int count);
-func (dib *BitMap) SetTransparencyTable(table []*byte) {
-	t := &table[0]
+func (dib *BitMap) SetTransparencyTable(table []byte) {
+	var t *byte
+	if len(table) > 0 {
+		t = &table[0]
+	}
 	l := int32(len(table))
 	fiLib.Call(_func_FreeImage_SetTransparencyTable_, inArgs{&dib, &t, &l})
 }
@@ -1010,6 +1286,15 @@ func (dib *BitMap) GetICCProfile() *ICCProfile {
 	return (*ICCProfile)(fiLib.Call(_func_FreeImage_GetICCProfile_, inArgs{&dib}).PtrFree())
 }
 
+// Data returns the raw profile, the slice is owned by the bitmap and is
+// valid until the profile is destroyed or the bitmap unloaded.
+func (profile *ICCProfile) Data() []byte {
+	if profile == nil || profile.data == nil || profile.Size == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(profile.data), profile.Size)
+}
+
 var _func_FreeImage_CreateICCProfile_ = &c.FuncPrototype{Name: "FreeImage_CreateICCProfile", OutType: c.Pointer, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
 
 // DLL_API FIICCPROFILE *DLL_CALLCONV FreeImage_CreateICCProfile(FIBITMAP *dib, void *data, long size);
@@ -1026,45 +1311,279 @@ func (dib *BitMap) DestroyICCProfile() {
 
 // Line conversion routines -------------------------------------------------
 
+var _func_FreeImage_ConvertLine1To4_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine1To4", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine1To4(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine1To4(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine1To4_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine8To4_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine8To4", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine8To4(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine8To4(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine8To4_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine16To4_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16To4_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16To4_555(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16To4_555(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16To4_555_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine16To4_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16To4_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16To4_565(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16To4_565(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16To4_565_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine24To4_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine24To4", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine24To4(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine24To4(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine24To4_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine32To4_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine32To4", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine32To4(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine32To4(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine32To4_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine1To8_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine1To8", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine1To8(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine1To8(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine1To8_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine4To8_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine4To8", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine4To8(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine4To8(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine4To8_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine16To8_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16To8_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16To8_555(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16To8_555(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16To8_555_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine16To8_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16To8_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16To8_565(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16To8_565(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16To8_565_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine24To8_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine24To8", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine24To8(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine24To8(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine24To8_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine32To8_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine32To8", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine32To8(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine32To8(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine32To8_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine1To16_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine1To16_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine1To16_555(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine1To16_555(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine1To16_555_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine4To16_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine4To16_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine4To16_555(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine4To16_555(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine4To16_555_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine8To16_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine8To16_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine8To16_555(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine8To16_555(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine8To16_555_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine16_565_To16_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16_565_To16_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16_565_To16_555(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16_565_To16_555(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16_565_To16_555_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine24To16_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine24To16_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine24To16_555(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine24To16_555(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine24To16_555_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine32To16_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine32To16_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine32To16_555(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine32To16_555(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine32To16_555_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine1To16_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine1To16_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine1To16_565(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine1To16_565(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine1To16_565_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine4To16_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine4To16_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine4To16_565(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine4To16_565(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine4To16_565_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine8To16_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine8To16_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine8To16_565(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine8To16_565(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine8To16_565_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine16_555_To16_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16_555_To16_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16_555_To16_565(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16_555_To16_565(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16_555_To16_565_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine24To16_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine24To16_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine24To16_565(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine24To16_565(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine24To16_565_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine32To16_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine32To16_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine32To16_565(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine32To16_565(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine32To16_565_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine1To24_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine1To24", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine1To24(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine1To24(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine1To24_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine4To24_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine4To24", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine4To24(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine4To24(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine4To24_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine8To24_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine8To24", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine8To24(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine8To24(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine8To24_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine16To24_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16To24_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16To24_555(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16To24_555(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16To24_555_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine16To24_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16To24_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16To24_565(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16To24_565(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16To24_565_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine32To24_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine32To24", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine32To24(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine32To24(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine32To24_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine1To32_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine1To32", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine1To32(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine1To32(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine1To32_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine1To32MapTransparency_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine1To32MapTransparency", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine1To32MapTransparency(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette, BYTE *table, int transparent_pixels);
+func ConvertLine1To32MapTransparency(target, source *byte, width_in_pixels int32, palette *RGBQUAD, table *byte, transparent_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine1To32MapTransparency_, inArgs{&target, &source, &width_in_pixels, &palette, &table, &transparent_pixels})
+}
+
+var _func_FreeImage_ConvertLine4To32_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine4To32", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine4To32(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine4To32(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine4To32_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine4To32MapTransparency_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine4To32MapTransparency", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine4To32MapTransparency(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette, BYTE *table, int transparent_pixels);
+func ConvertLine4To32MapTransparency(target, source *byte, width_in_pixels int32, palette *RGBQUAD, table *byte, transparent_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine4To32MapTransparency_, inArgs{&target, &source, &width_in_pixels, &palette, &table, &transparent_pixels})
+}
+
+var _func_FreeImage_ConvertLine8To32_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine8To32", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine8To32(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette);
+func ConvertLine8To32(target, source *byte, width_in_pixels int32, palette *RGBQUAD) {
+	fiLib.Call(_func_FreeImage_ConvertLine8To32_, inArgs{&target, &source, &width_in_pixels, &palette})
+}
+
+var _func_FreeImage_ConvertLine8To32MapTransparency_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine8To32MapTransparency", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32, c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine8To32MapTransparency(BYTE *target, BYTE *source, int width_in_pixels, RGBQUAD *palette, BYTE *table, int transparent_pixels);
+func ConvertLine8To32MapTransparency(target, source *byte, width_in_pixels int32, palette *RGBQUAD, table *byte, transparent_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine8To32MapTransparency_, inArgs{&target, &source, &width_in_pixels, &palette, &table, &transparent_pixels})
+}
+
+var _func_FreeImage_ConvertLine16To32_555_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16To32_555", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16To32_555(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16To32_555(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16To32_555_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine16To32_565_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine16To32_565", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine16To32_565(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine16To32_565(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine16To32_565_, inArgs{&target, &source, &width_in_pixels})
+}
+
+var _func_FreeImage_ConvertLine24To32_ = &c.FuncPrototype{Name: "FreeImage_ConvertLine24To32", OutType: c.Void, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
+
 // DLL_API void DLL_CALLCONV FreeImage_ConvertLine24To32(BYTE *target, BYTE *source, int width_in_pixels);
+func ConvertLine24To32(target, source *byte, width_in_pixels int32) {
+	fiLib.Call(_func_FreeImage_ConvertLine24To32_, inArgs{&target, &source, &width_in_pixels})
+}
+
 // Smart conversion routines ------------------------------------------------
 
 var _func_FreeImage_ConvertTo4Bits_ = &c.FuncPrototype{Name: "FreeImage_ConvertTo4Bits", OutType: c.Pointer, InTypes: []c.Type{c.Pointer}}
@@ -1265,11 +1784,30 @@ func (dib *BitMap) TmoFattal02(color_saturation, attenuation float64) *BitMap {
 
 // ZLib interface -----------------------------------------------------------
 
+// byteCount is size clamped to the length of b, so a wrong size can't make
+// the library read or write past b.
+func byteCount(b []byte, size uint32) uint32 {
+	if uint64(size) > uint64(len(b)) {
+		return uint32(len(b))
+	}
+	return size
+}
+
+// bytesPtr returns the address of the first byte of b, nil for an empty
+// slice.
+func bytesPtr(b []byte) *byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return &b[0]
+}
+
 var _func_FreeImage_ZLibCompress_ = &c.FuncPrototype{Name: "FreeImage_ZLibCompress", OutType: c.U32, InTypes: []c.Type{c.Pointer, c.U32, c.Pointer, c.U32}}
 
 // DLL_API DWORD DLL_CALLCONV FreeImage_ZLibCompress(BYTE *target, DWORD target_size, BYTE *source, DWORD source_size);
 func ZLibCompress(target []byte, target_size uint32, source []byte, source_size uint32) uint32 {
-	t, s := &target[0], &source[0]
+	t, s := bytesPtr(target), bytesPtr(source)
+	target_size, source_size = byteCount(target, target_size), byteCount(source, source_size)
 	return fiLib.Call(_func_FreeImage_ZLibCompress_, inArgs{&t, &target_size, &s, &source_size}).U32Free()
 }
 
@@ -1277,7 +1815,8 @@ var _func_FreeImage_ZLibUncompress_ = &c.FuncPrototype{Name: "FreeImage_ZLibUnco
 
 // DLL_API DWORD DLL_CALLCONV FreeImage_ZLibUncompress(BYTE *target, DWORD target_size, BYTE *source, DWORD source_size);
 func ZLibUncompress(target []byte, target_size uint32, source []byte, source_size uint32) uint32 {
-	t, s := &target[0], &source[0]
+	t, s := bytesPtr(target), bytesPtr(source)
+	target_size, source_size = byteCount(target, target_size), byteCount(source, source_size)
 	return fiLib.Call(_func_FreeImage_ZLibUncompress_, inArgs{&t, &target_size, &s, &source_size}).U32Free()
 }
 
@@ -1285,7 +1824,8 @@ var _func_FreeImage_ZLibGZip_ = &c.FuncPrototype{Name: "FreeImage_ZLibGZip", Out
 
 // DLL_API DWORD DLL_CALLCONV FreeImage_ZLibGZip(BYTE *target, DWORD target_size, BYTE *source, DWORD source_size);
 func ZLibGZip(target []byte, target_size uint32, source []byte, source_size uint32) uint32 {
-	t, s := &target[0], &source[0]
+	t, s := bytesPtr(target), bytesPtr(source)
+	target_size, source_size = byteCount(target, target_size), byteCount(source, source_size)
 	return fiLib.Call(_func_FreeImage_ZLibGZip_, inArgs{&t, &target_size, &s, &source_size}).U32Free()
 }
 
@@ -1293,7 +1833,8 @@ var _func_FreeImage_ZLibGUnzip_ = &c.FuncPrototype{Name: "FreeImage_ZLibGUnzip",
 
 // DLL_API DWORD DLL_CALLCONV FreeImage_ZLibGUnzip(BYTE *target, DWORD target_size, BYTE *source, DWORD source_size);
 func ZLibGUnzip(target []byte, target_size uint32, source []byte, source_size uint32) uint32 {
-	t, s := &target[0], &source[0]
+	t, s := bytesPtr(target), bytesPtr(source)
+	target_size, source_size = byteCount(target, target_size), byteCount(source, source_size)
 	return fiLib.Call(_func_FreeImage_ZLibGUnzip_, inArgs{&t, &target_size, &s, &source_size}).U32Free()
 }
 
@@ -1301,7 +1842,7 @@ var _func_FreeImage_ZLibCRC32_ = &c.FuncPrototype{Name: "FreeImage_ZLibCRC32", O
 
 // DLL_API DWORD DLL_CALLCONV FreeImage_ZLibCRC32(DWORD crc, BYTE *source, DWORD source_size);
 func ZLibCRC32(crc uint32, source []byte, source_size uint32) uint32 {
-	s := &source[0]
+	s, source_size := bytesPtr(source), byteCount(source, source_size)
 	return fiLib.Call(_func_FreeImage_ZLibCRC32_, inArgs{&crc, &s, &source_size}).U32Free()
 }
 
@@ -1508,6 +2049,13 @@ func (src *BitMap) CloneMetadataTo(dst *BitMap) bool {
 	return fiLib.Call(_func_FreeImage_CloneMetadata_, inArgs{&dst, &src}).BoolFree()
 }
 
+// ClearMetadata deletes all the tags of model, it calls FreeImage_SetMetadata
+// with a NULL key.
+func (dib *BitMap) ClearMetadata(model FREE_IMAGE_MDMODEL) bool {
+	k, t := unsafe.Pointer(nil), (*Tag)(nil)
+	return fiLib.Call(_func_FreeImage_SetMetadata_, inArgs{&model, &dib, &k, &t}).BoolFree()
+}
+
 // tag to C string conversion
 
 var _func_FreeImage_TagToString_ = &c.FuncPrototype{Name: "FreeImage_TagToString", OutType: c.Pointer, InTypes: []c.Type{c.I32, c.Pointer, c.Pointer}}
@@ -1515,7 +2063,7 @@ var _func_FreeImage_TagToString_ = &c.FuncPrototype{Name: "FreeImage_TagToString
 // DLL_API const char* DLL_CALLCONV FreeImage_TagToString(FREE_IMAGE_MDMODEL model, FITAG *tag, char *Make FI_DEFAULT(NULL));
 func TagToString(model FREE_IMAGE_MDMODEL, tag *Tag, Make ...string) string {
 	m := unsafe.Pointer(nil)
-	if Make != nil {
+	if len(Make) > 0 {
 		m = c.CStr(Make[0])
 		defer c.Free(m)
 	}
@@ -1630,7 +2178,7 @@ func (dib *BitMap) RescaleRect(dst_width, dst_height int32, left, top, right, bo
 
 // color manipulation routines (point operations)
 
-var _func_FreeImage_AdjustCurve_ = &c.FuncPrototype{Name: "FreeImage_AdjustCurve", OutType: c.I32, InTypes: []c.Type{c.Pointer, c.Pointer}}
+var _func_FreeImage_AdjustCurve_ = &c.FuncPrototype{Name: "FreeImage_AdjustCurve", OutType: c.I32, InTypes: []c.Type{c.Pointer, c.Pointer, c.I32}}
 
 // DLL_API BOOL DLL_CALLCONV FreeImage_AdjustCurve(FIBITMAP *dib, BYTE *LUT, FREE_IMAGE_COLOR_CHANNEL channel);
 func (dib *BitMap) AdjustCurve(Lut [256]byte, channel FREE_IMAGE_COLOR_CHANNEL) bool {
@@ -1696,11 +2244,14 @@ var _func_FreeImage_ApplyColorMapping_ = &c.FuncPrototype{Name: "FreeImage_Apply
 
 // DLL_API unsigned DLL_CALLCONV FreeImage_ApplyColorMapping(FIBITMAP *dib, RGBQUAD *srccolors, RGBQUAD *dstcolors, unsigned count, BOOL ignore_alpha, BOOL swap);
 func (dib *BitMap) ApplyColorMapping(srccolors, dstcolors []RGBQUAD, ignore_alpha, swap bool) uint32 {
-	sc, dc, ia, sw := &srccolors[0], &dstcolors[0], c.CBool(ignore_alpha), c.CBool(swap)
 	count := uint32(len(dstcolors))
 	if len(srccolors) < len(dstcolors) {
 		count = uint32(len(srccolors))
 	}
+	if count == 0 {
+		return 0
+	}
+	sc, dc, ia, sw := &srccolors[0], &dstcolors[0], c.CBool(ignore_alpha), c.CBool(swap)
 
 	return fiLib.Call(_func_FreeImage_ApplyColorMapping_, inArgs{&dib, &sc, &dc, &count, &ia, &sw}).U32Free()
 }
@@ -1717,11 +2268,14 @@ var _func_FreeImage_ApplyPaletteIndexMapping_ = &c.FuncPrototype{Name: "FreeImag
 
 // DLL_API unsigned DLL_CALLCONV FreeImage_ApplyPaletteIndexMapping(FIBITMAP *dib, BYTE *srcindices,    BYTE *dstindices, unsigned count, BOOL swap);
 func (dib *BitMap) ApplyPaletteIndexMapping(srcindices, dstindices []byte, swap bool) uint32 {
-	si, di, sw := &srcindices[0], &dstindices[0], c.CBool(swap)
 	count := uint32(len(dstindices))
 	if len(srcindices) < len(dstindices) {
 		count = uint32(len(srcindices))
 	}
+	if count == 0 {
+		return 0
+	}
+	si, di, sw := &srcindices[0], &dstindices[0], c.CBool(swap)
 	return fiLib.Call(_func_FreeImage_ApplyPaletteIndexMapping_, inArgs{&dib, &si, &di, &count, &sw}).U32Free()
 }
 
@@ -1789,7 +2343,7 @@ func (dib *BitMap) CreateView(left, top, right, bottom uint32) *BitMap {
 	return (*BitMap)(fiLib.Call(_func_FreeImage_CreateView_, inArgs{&dib, &left, &top, &right, &bottom}).PtrFree())
 }
 
-var _func_FreeImage_Composite_ = &c.FuncPrototype{Name: "FreeImage_Composite", OutType: c.Pointer, InTypes: []c.Type{c.Pointer, c.I32, c.Pointer, c.Pointer, c.U32}}
+var _func_FreeImage_Composite_ = &c.FuncPrototype{Name: "FreeImage_Composite", OutType: c.Pointer, InTypes: []c.Type{c.Pointer, c.I32, c.Pointer, c.Pointer}}
 
 // DLL_API FIBITMAP *DLL_CALLCONV FreeImage_Composite(FIBITMAP *fg, BOOL useFileBkg FI_DEFAULT(FALSE), RGBQUAD *appBkColor FI_DEFAULT(NULL), FIBITMAP *bg FI_DEFAULT(NULL));
 func Composite(fg *BitMap, useFileBkg bool, appBkColor *RGBQUAD, bg *BitMap) *BitMap {
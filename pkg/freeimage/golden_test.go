@@ -0,0 +1,299 @@
+package freeimage_test
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata/golden")
+
+const (
+	goldenW, goldenH = 64, 48
+	defaultPSNR      = 40 // dB
+)
+
+// source returns the synthetic test image: red and green ramps, a blue
+// diagonal and for 32 bpp an alpha ramp, over a white AllocateEx fill.
+func source(t *testing.T, bpp int32) *fi.BitMap {
+	t.Helper()
+	white := fi.RGBQUAD{255, 255, 255, 255}
+	dib := fi.AllocateEx(goldenW, goldenH, bpp, &white, fi.FI_COLOR_IS_RGBA_COLOR, nil, 0, 0, 0)
+	if dib == nil {
+		t.Fatalf("AllocateEx(%d bpp) failed", bpp)
+	}
+	for y := uint32(4); y < goldenH-4; y++ {
+		for x := uint32(4); x < goldenW-4; x++ {
+			c := fi.RGBQUAD{byte((x + y) * 2), byte(y * 5), byte(x * 4), byte(255 - x*3)}
+			dib.SetPixelColor(x, y, &c)
+		}
+	}
+	return dib
+}
+
+// keep runs an in-place operation on a clone of src.
+func keep(op func(dib *fi.BitMap) bool) func(*testing.T, *fi.BitMap) *fi.BitMap {
+	return func(t *testing.T, src *fi.BitMap) *fi.BitMap {
+		dib := src.Clone()
+		if !op(dib) {
+			dib.Unload()
+			return nil
+		}
+		return dib
+	}
+}
+
+// chain runs ops in order, unloading the intermediate bitmaps.
+func chain(ops ...func(*fi.BitMap) *fi.BitMap) func(*testing.T, *fi.BitMap) *fi.BitMap {
+	return func(t *testing.T, src *fi.BitMap) *fi.BitMap {
+		dib := src
+		for _, op := range ops {
+			next := op(dib)
+			if dib != src {
+				dib.Unload()
+			}
+			if next == nil {
+				return nil
+			}
+			dib = next
+		}
+		return dib
+	}
+}
+
+func TestGolden(t *testing.T) {
+	fitest.Real(t)
+
+	bg := fi.RGBQUAD{40, 80, 160, 255}
+	invert := [256]byte{}
+	for i := range invert {
+		invert[i] = byte(255 - i)
+	}
+
+	tests := []struct {
+		name string
+		bpp  int32
+		op   func(*testing.T, *fi.BitMap) *fi.BitMap
+		psnr float64 // minimum, defaultPSNR if 0
+	}{
+		// conversions
+		{"convert_4bits", 24, chain((*fi.BitMap).ConvertTo4Bits), 0},
+		{"convert_8bits", 24, chain((*fi.BitMap).ConvertTo8Bits), 0},
+		{"convert_greyscale", 32, chain((*fi.BitMap).ConvertToGreyscale), 0},
+		{"convert_16bits555", 24, chain((*fi.BitMap).ConvertTo16Bits555), 0},
+		{"convert_16bits565", 32, chain((*fi.BitMap).ConvertTo16Bits565), 0},
+		{"convert_24bits", 32, chain((*fi.BitMap).ConvertTo24Bits), 0},
+		{"convert_32bits", 24, chain((*fi.BitMap).ConvertTo32Bits), 0},
+		{"quantize_wu", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.ColorQuantize(fi.FIQ_WUQUANT) }), 30},
+		{"quantize_nn", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.ColorQuantize(fi.FIQ_NNQUANT) }), 30},
+		{"threshold", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.Threshold(128) }), 0},
+		{"dither_fs", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.Dither(fi.FID_FS) }), 0},
+		{"dither_bayer", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.Dither(fi.FID_BAYER8x8) }), 0},
+		{"convert_float", 24, chain((*fi.BitMap).ConvertToFloat, standard), 0},
+		{"convert_uint16", 24, chain((*fi.BitMap).ConvertToUINT16, standard), 0},
+		{"convert_rgb16", 24, chain((*fi.BitMap).ConvertToRGB16, standard), 0},
+		{"convert_rgba16", 32, chain((*fi.BitMap).ConvertToRGBA16, standard), 0},
+		{"convert_rgbaf", 32, chain((*fi.BitMap).ConvertToRGBAF, standard), 0},
+
+		// tone mapping
+		{"tonemap_drago", 24, chain((*fi.BitMap).ConvertToRGBF, func(d *fi.BitMap) *fi.BitMap { return d.ToneMapping(fi.FITMO_DRAGO03, 2.2, 0) }), 35},
+		{"tonemap_reinhard", 24, chain((*fi.BitMap).ConvertToRGBF, func(d *fi.BitMap) *fi.BitMap { return d.TmoReinhard05(0, 0) }), 35},
+		{"tonemap_fattal", 24, chain((*fi.BitMap).ConvertToRGBF, func(d *fi.BitMap) *fi.BitMap { return d.TmoFattal02(0.5, 0.85) }), 30},
+
+		// geometry
+		{"rescale_box", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.Rescale(32, 24, fi.FILTER_BOX) }), 0},
+		{"rescale_bilinear", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.Rescale(100, 75, fi.FILTER_BILINEAR) }), 0},
+		{"rescale_bicubic", 32, chain(func(d *fi.BitMap) *fi.BitMap { return d.Rescale(100, 75, fi.FILTER_BICUBIC) }), 0},
+		{"rescale_lanczos", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.Rescale(40, 30, fi.FILTER_LANCZOS3) }), 0},
+		{"rescale_rect", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.RescaleRect(48, 48, 8, 8, 40, 40, fi.FILTER_CATMULLROM, 0) }), 0},
+		{"thumbnail", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.MakeThumbnail(20, true) }), 0},
+		{"rotate_90", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.Rotate(90, nil) }), 0},
+		{"rotate_30", 32, chain(func(d *fi.BitMap) *fi.BitMap { return d.Rotate(30, unsafe.Pointer(&bg)) }), 35},
+		{"rotate_ex", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.RotateEx(15, 4, -2, 32, 24, true) }), 35},
+		{"flip_horizontal", 24, keep((*fi.BitMap).FlipHorizontal), 0},
+		{"flip_vertical", 32, keep((*fi.BitMap).FlipVertical), 0},
+		{"copy", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.Copy(8, 4, 40, 36) }), 0},
+		{"enlarge_canvas", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.EnlargeCanvas(8, 4, 16, 2, &bg, fi.FI_COLOR_IS_RGB_COLOR) }), 0},
+		{"shrink_canvas", 32, chain(func(d *fi.BitMap) *fi.BitMap { return d.EnlargeCanvas(-4, -4, -8, 0, &bg, fi.FI_COLOR_IS_RGBA_COLOR) }), 0},
+
+		// adjustments
+		{"adjust_curve", 24, keep(func(d *fi.BitMap) bool { return d.AdjustCurve(invert, fi.FICC_RED) }), 0},
+		{"adjust_gamma", 24, keep(func(d *fi.BitMap) bool { return d.AdjustGamma(2.2) }), 0},
+		{"adjust_brightness", 24, keep(func(d *fi.BitMap) bool { return d.AdjustBrightness(25) }), 0},
+		{"adjust_contrast", 24, keep(func(d *fi.BitMap) bool { return d.AdjustContrast(-40) }), 0},
+		{"adjust_colors", 32, keep(func(d *fi.BitMap) bool { return d.AdjustColors(10, 20, 0.8, false) }), 0},
+		{"invert", 24, keep(func(d *fi.BitMap) bool { return d.Invert(0) }), 0},
+		{"fill_background", 32, keep(func(d *fi.BitMap) bool { return d.FillBackground(&bg, fi.FI_COLOR_IS_RGBA_COLOR) }), 0},
+
+		// channels and compositing
+		{"channel_red", 24, chain(func(d *fi.BitMap) *fi.BitMap { return d.GetChannel(fi.FICC_RED) }), 0},
+		{"channel_alpha", 32, chain(func(d *fi.BitMap) *fi.BitMap { return d.GetChannel(fi.FICC_ALPHA) }), 0},
+		{"set_channel", 24, func(t *testing.T, src *fi.BitMap) *fi.BitMap {
+			green := src.GetChannel(fi.FICC_GREEN)
+			defer green.Unload()
+			return keep(func(d *fi.BitMap) bool { return d.SetChannel(green, fi.FICC_BLUE) })(t, src)
+		}, 0},
+		{"paste", 24, pasteOp(256), 0},
+		{"paste_blend", 24, pasteOp(128), 0},
+		{"composite_color", 32, chain(func(d *fi.BitMap) *fi.BitMap { return fi.Composite(d, false, &bg, nil) }), 0},
+		{"composite_bitmap", 32, func(t *testing.T, src *fi.BitMap) *fi.BitMap {
+			back := source(t, 24)
+			defer back.Unload()
+			if !back.FlipHorizontal() {
+				return nil
+			}
+			return fi.Composite(src, false, nil, back)
+		}, 0},
+		{"premultiply", 32, keep((*fi.BitMap).PreMultiplyWithAlpha), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := source(t, tt.bpp)
+			defer src.Unload()
+			dib := tt.op(t, src)
+			if dib == nil {
+				t.Fatal("operation failed")
+			}
+			got := toNRGBA(t, dib)
+			dib.Unload()
+
+			path := filepath.Join("testdata", "golden", tt.name+".png")
+			if *update {
+				writePNG(t, path, got)
+				return
+			}
+			want := readPNG(t, path)
+			if want == nil {
+				t.Skipf("no golden image %s, run go test -run TestGolden -update", path)
+			}
+			if got.Rect != want.Rect {
+				t.Fatalf("size %v, golden %v", got.Rect.Size(), want.Rect.Size())
+			}
+			minPSNR := tt.psnr
+			if minPSNR == 0 {
+				minPSNR = defaultPSNR
+			}
+			if p := perceptualPSNR(got, want); p < minPSNR {
+				t.Errorf("PSNR %.1f dB against %s, want >= %.1f", p, path, minPSNR)
+			}
+		})
+	}
+}
+
+func standard(dib *fi.BitMap) *fi.BitMap { return dib.ConvertToStandardType(true) }
+
+// pasteOp pastes a 20 x 16 crop of the source, flipped, at (30, 24).
+func pasteOp(alpha int32) func(*testing.T, *fi.BitMap) *fi.BitMap {
+	return func(t *testing.T, src *fi.BitMap) *fi.BitMap {
+		piece := src.Copy(4, 4, 24, 20)
+		if piece == nil {
+			return nil
+		}
+		defer piece.Unload()
+		piece.FlipVertical()
+		return keep(func(d *fi.BitMap) bool { return d.Paste(piece, 30, 24, alpha) })(t, src)
+	}
+}
+
+// toNRGBA returns the pixels of dib, converted to a standard 32 bit
+// bitmap first, top row first.
+func toNRGBA(t *testing.T, dib *fi.BitMap) *image.NRGBA {
+	t.Helper()
+	var tmp []*fi.BitMap
+	defer func() {
+		for _, d := range tmp {
+			d.Unload()
+		}
+	}()
+	if dib.GetImageType() != fi.FIT_BITMAP {
+		if dib = dib.ConvertToStandardType(true); dib == nil {
+			t.Fatal("ConvertToStandardType failed")
+		}
+		tmp = append(tmp, dib)
+	}
+	if dib.GetBPP() != 32 {
+		if dib = dib.ConvertTo32Bits(); dib == nil {
+			t.Fatal("ConvertTo32Bits failed")
+		}
+		tmp = append(tmp, dib)
+	}
+
+	w, h := int(dib.GetWidth()), int(dib.GetHeight())
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c, _ := dib.GetPixelColor(uint32(x), uint32(h-1-y))
+			img.SetNRGBA(x, y, color.NRGBA{R: c[2], G: c[1], B: c[0], A: c[3]})
+		}
+	}
+	return img
+}
+
+// perceptualPSNR is the PSNR of the luma weighted color difference, with
+// alpha weighted like green.
+func perceptualPSNR(a, b *image.NRGBA) float64 {
+	weights := [4]float64{0.299, 0.587, 0.114, 0.587}
+	var sum, total float64
+	for i := 0; i < len(a.Pix); i += 4 {
+		for ch, w := range weights {
+			d := float64(a.Pix[i+ch]) - float64(b.Pix[i+ch])
+			sum += w * d * d
+			total += w
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/total))
+}
+
+func readPNG(t *testing.T, path string) *image.NRGBA {
+	t.Helper()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	src, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	img := image.NewNRGBA(src.Bounds())
+	for y := src.Bounds().Min.Y; y < src.Bounds().Max.Y; y++ {
+		for x := src.Bounds().Min.X; x < src.Bounds().Max.X; x++ {
+			img.Set(x, y, src.At(x, y))
+		}
+	}
+	return img
+}
+
+func writePNG(t *testing.T, path string, img *image.NRGBA) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,20 @@
+package freeimage
+
+import "testing"
+
+// requireLibrary makes a real FreeImage the default library until the test
+// ends, skipping the test when none can be loaded. The internal tests can't
+// import fitest, and its stub has no ConvertLine routines anyway.
+func requireLibrary(t testing.TB) {
+	t.Helper()
+	l, err := Open("")
+	if err != nil {
+		t.Skip(err)
+	}
+	l.Initialise(false)
+	prev := SetDefault(l)
+	t.Cleanup(func() {
+		SetDefault(prev)
+		l.Close()
+	})
+}
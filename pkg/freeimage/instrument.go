@@ -0,0 +1,268 @@
+package freeimage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/jinzhongmin/goffi/pkg/c"
+	"github.com/jinzhongmin/usf"
+)
+
+// Instrumentation observes the image operations of a Library: loading,
+// saving, conversions, rescaling, rotation and tone mapping, see
+// WithInstrumentation. Nil fields are off.
+//
+// The interfaces mirror log/slog and the OpenTelemetry trace and metric
+// APIs, so adapters are a few lines; SlogLogger adapts a *slog.Logger,
+// the otelfi module adapts OpenTelemetry, and fitest.Recorder records
+// everything in memory for tests. The wrappers take no context, so the
+// spans are roots.
+type Instrumentation struct {
+	// Logger receives the messages of FreeImage_SetOutputMessage at
+	// LevelWarn, and a LevelDebug record per operation.
+	Logger Logger
+	// Tracer starts a span per operation, named "freeimage." and the
+	// function, e.g. "freeimage.Load".
+	Tracer Tracer
+	// Meter receives the MetricDuration and MetricAllocated histograms.
+	Meter Meter
+}
+
+// Histograms recorded by Instrumentation.Meter, with the operation, format
+// and ok attributes.
+const (
+	// MetricDuration is the duration of an operation in seconds.
+	MetricDuration = "freeimage.operation.duration"
+	// MetricAllocated is the native memory of the bitmap an operation
+	// returns in bytes, see GetMemorySize.
+	MetricAllocated = "freeimage.operation.allocated"
+)
+
+// Attributes of the spans, records and metrics of Instrumentation.
+const (
+	AttrOperation = "freeimage.operation" // function without the FreeImage_ prefix
+	AttrFormat    = "freeimage.format"    // e.g. "PNG", for loading and saving
+	AttrWidth     = "freeimage.width"     // of the bitmap returned or saved
+	AttrHeight    = "freeimage.height"
+	AttrBPP       = "freeimage.bpp"
+	AttrBytes     = "freeimage.bytes"    // native memory of the bitmap returned
+	AttrDuration  = "freeimage.duration" // in seconds
+	AttrOK        = "freeimage.ok"       // false when the function failed
+)
+
+// Level is the severity of a log record, with the values of slog.Level.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// Attr is an attribute of a log record, span or measurement. Value is a
+// string, int64, float64 or bool.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives log records, see SlogLogger.
+type Logger interface {
+	Enabled(ctx context.Context, level Level) bool
+	Log(ctx context.Context, level Level, msg string, attrs ...Attr)
+}
+
+// Tracer starts spans.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a started span.
+type Span interface {
+	SetAttributes(attrs ...Attr)
+	RecordError(err error)
+	End()
+}
+
+// Meter records histogram values.
+type Meter interface {
+	Record(ctx context.Context, name string, value float64, attrs ...Attr)
+}
+
+// WithInstrumentation makes the library report its operations to ins.
+// FreeImage has one output message handler per loaded copy of the
+// library, the last Library opened with a Logger gets the messages.
+func WithInstrumentation(ins Instrumentation) Option {
+	return func(cfg *openConfig) { cfg.inst = &ins }
+}
+
+// operation describes the arguments of an observed function: the index of
+// its format and of its input bitmap, -1 for none. multi marks functions
+// on multipage bitmaps, whose sizes aren't reported.
+type operation struct {
+	format, input int
+	multi         bool
+}
+
+var (
+	opLoad    = operation{format: 0, input: -1}
+	opSave    = operation{format: 0, input: 1}
+	opConvert = operation{format: -1, input: 0}
+)
+
+// observed lists the functions reported to the Instrumentation.
+var observed = map[string]operation{
+	"FreeImage_Load":                      opLoad,
+	"FreeImage_LoadFromMemory":            opLoad,
+	"FreeImage_Save":                      opSave,
+	"FreeImage_SaveToMemory":              opSave,
+	"FreeImage_OpenMultiBitmap":           {format: 0, input: -1, multi: true},
+	"FreeImage_LoadMultiBitmapFromMemory": {format: 0, input: -1, multi: true},
+	"FreeImage_SaveMultiBitmapToMemory":   {format: 0, input: -1, multi: true},
+	"FreeImage_ConvertTo4Bits":            opConvert,
+	"FreeImage_ConvertTo8Bits":            opConvert,
+	"FreeImage_ConvertToGreyscale":        opConvert,
+	"FreeImage_ConvertTo16Bits555":        opConvert,
+	"FreeImage_ConvertTo16Bits565":        opConvert,
+	"FreeImage_ConvertTo24Bits":           opConvert,
+	"FreeImage_ConvertTo32Bits":           opConvert,
+	"FreeImage_ColorQuantize":             opConvert,
+	"FreeImage_ColorQuantizeEx":           opConvert,
+	"FreeImage_Threshold":                 opConvert,
+	"FreeImage_Dither":                    opConvert,
+	"FreeImage_ConvertToFloat":            opConvert,
+	"FreeImage_ConvertToRGBF":             opConvert,
+	"FreeImage_ConvertToRGBAF":            opConvert,
+	"FreeImage_ConvertToUINT16":           opConvert,
+	"FreeImage_ConvertToRGB16":            opConvert,
+	"FreeImage_ConvertToRGBA16":           opConvert,
+	"FreeImage_ConvertToStandardType":     opConvert,
+	"FreeImage_ConvertToType":             opConvert,
+	"FreeImage_ToneMapping":               opConvert,
+	"FreeImage_TmoDrago03":                opConvert,
+	"FreeImage_TmoReinhard05":             opConvert,
+	"FreeImage_TmoReinhard05Ex":           opConvert,
+	"FreeImage_TmoFattal02":               opConvert,
+	"FreeImage_Rotate":                    opConvert,
+	"FreeImage_RotateEx":                  opConvert,
+	"FreeImage_Rescale":                   opConvert,
+	"FreeImage_RescaleRect":               opConvert,
+	"FreeImage_MakeThumbnail":             opConvert,
+	"FreeImage_Copy":                      opConvert,
+	"FreeImage_Composite":                 opConvert,
+	"FreeImage_EnlargeCanvas":             opConvert,
+}
+
+// argPtr returns the pointer an argument points to.
+func argPtr(arg interface{}) unsafe.Pointer {
+	return *(*unsafe.Pointer)(usf.AddrOf(arg))
+}
+
+// observe calls the observed function fp and reports it.
+func (l *Library) observe(fp *c.FuncPrototype, op operation, args []interface{}) *c.Value {
+	ins := l.inst
+	name := strings.TrimPrefix(fp.Name, "FreeImage_")
+	ctx := context.Background()
+	var span Span
+	if ins.Tracer != nil {
+		ctx, span = ins.Tracer.Start(ctx, "freeimage."+name)
+	}
+	start := time.Now()
+	v := l.invoke(fp, args)
+	d := time.Since(start)
+
+	attrs := []Attr{{AttrOperation, name}}
+	if op.format >= 0 {
+		fif := *(*FREE_IMAGE_FORMAT)(usf.AddrOf(args[op.format]))
+		if f := l.formatName(fif); f != "" {
+			attrs = append(attrs, Attr{AttrFormat, f})
+		}
+	}
+	var dib unsafe.Pointer
+	ok := true
+	if fp.OutType == c.Pointer {
+		dib = v.Ptr()
+		ok = dib != nil
+	} else {
+		ok = v.I32() != 0
+		if op.input >= 0 {
+			dib = argPtr(args[op.input])
+		}
+	}
+	attrs = append(attrs, Attr{AttrOK, ok})
+	metricAttrs := attrs
+
+	allocated := int64(-1)
+	if dib != nil && !op.multi {
+		attrs = append(attrs,
+			Attr{AttrWidth, int64(l.call(_func_FreeImage_GetWidth_, inArgs{&dib}).U32Free())},
+			Attr{AttrHeight, int64(l.call(_func_FreeImage_GetHeight_, inArgs{&dib}).U32Free())},
+			Attr{AttrBPP, int64(l.call(_func_FreeImage_GetBPP_, inArgs{&dib}).U32Free())})
+		if fp.OutType == c.Pointer {
+			allocated = int64(l.call(_func_FreeImage_GetMemorySize_, inArgs{&dib}).U32Free())
+			attrs = append(attrs, Attr{AttrBytes, allocated})
+		}
+	}
+	attrs = append(attrs, Attr{AttrDuration, d.Seconds()})
+
+	if span != nil {
+		span.SetAttributes(attrs...)
+		if !ok {
+			span.RecordError(fmt.Errorf("freeimage: %s failed", name))
+		}
+		span.End()
+	}
+	if ins.Meter != nil {
+		ins.Meter.Record(ctx, MetricDuration, d.Seconds(), metricAttrs...)
+		if allocated >= 0 {
+			ins.Meter.Record(ctx, MetricAllocated, float64(allocated), metricAttrs...)
+		}
+	}
+	if ins.Logger != nil && ins.Logger.Enabled(ctx, LevelDebug) {
+		ins.Logger.Log(ctx, LevelDebug, "freeimage: "+name, attrs...)
+	}
+	return v
+}
+
+func (l *Library) formatName(fif FREE_IMAGE_FORMAT) string {
+	if fif == FIF_UNKNOWN {
+		return ""
+	}
+	return l.call(_func_FreeImage_GetFormatFromFIF_, inArgs{&fif}).StrFree()
+}
+
+// FreeImage_OutputMessageFunction(FREE_IMAGE_FORMAT fif, const char *msg)
+var _proc_FreeImage_OutputMessageFunction_ = []c.Type{c.I32, c.Pointer}
+
+// routeOutputMessages sets the output message handler of FreeImage to log
+// the messages with the Logger.
+func (l *Library) routeOutputMessages() {
+	cb := c.NewCallback(c.AbiDefault, c.Void, _proc_FreeImage_OutputMessageFunction_)
+	cvt := func(_ *c.Callback, args []*c.Value, _ *c.Value) {
+		defer func() { recover() }()
+		var attrs []Attr
+		if f := l.formatName(FREE_IMAGE_FORMAT(args[0].I32())); f != "" {
+			attrs = append(attrs, Attr{AttrFormat, f})
+		}
+		l.inst.Logger.Log(context.Background(), LevelWarn, args[1].Str(), attrs...)
+	}
+	cb.CallbackCvt = cvt
+	l.outputCB, l.outputCvt = cb, cvt
+	fn := cb.FuncPtr()
+	l.call(_func_FreeImage_SetOutputMessage_, inArgs{&fn})
+}
+
+// stopOutputMessages unsets the handler of routeOutputMessages.
+func (l *Library) stopOutputMessages() {
+	if l.outputCB == nil {
+		return
+	}
+	fn := unsafe.Pointer(nil)
+	l.call(_func_FreeImage_SetOutputMessage_, inArgs{&fn})
+	l.outputCB.Free()
+	l.outputCB, l.outputCvt = nil, nil
+}
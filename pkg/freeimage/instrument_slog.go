@@ -0,0 +1,29 @@
+//go:build go1.21
+
+package freeimage
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger returns a Logger writing to l, for Instrumentation.Logger.
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Enabled(ctx context.Context, level Level) bool {
+	return s.l.Enabled(ctx, slog.Level(level))
+}
+
+func (s slogLogger) Log(ctx context.Context, level Level, msg string, attrs ...Attr) {
+	as := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		as[i] = slog.Any(a.Key, a.Value)
+	}
+	s.l.LogAttrs(ctx, slog.Level(level), msg, as...)
+}
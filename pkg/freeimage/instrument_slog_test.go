@@ -0,0 +1,33 @@
+//go:build go1.21
+
+package freeimage_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	fitest.NewStub(t, fi.WithInstrumentation(fi.Instrumentation{Logger: fi.SlogLogger(logger)}))
+
+	data := fitest.Bytes(3, 2, 24, func(x, y, ch int) byte { return 1 })
+	dib, err := fi.Decode(data, &fi.DecodeOptions{Format: fitest.FIF_STUB, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dib.Unload()
+	if buf.Len() != 0 {
+		t.Errorf("debug records written at LevelWarn: %s", buf.Bytes())
+	}
+	fi.Decode(data[:20], &fi.DecodeOptions{Format: fitest.FIF_STUB, Force: true})
+	if out := buf.String(); !strings.Contains(out, `level=WARN msg="STUB: truncated image data" freeimage.format=STUB`) {
+		t.Errorf("output message logged as %q", out)
+	}
+}
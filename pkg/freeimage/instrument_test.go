@@ -0,0 +1,81 @@
+package freeimage_test
+
+import (
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func TestInstrumentation(t *testing.T) {
+	rec := fitest.NewRecorder()
+	s := fitest.NewStub(t, fi.WithInstrumentation(rec.Instrumentation()))
+	dib, err := fi.Decode(fitest.Bytes(3, 2, 24, func(x, y, ch int) byte { return 1 }), &fi.DecodeOptions{Format: fitest.FIF_STUB, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fi.Encode(dib, fitest.FIF_STUB, nil); err != nil {
+		t.Fatal(err)
+	}
+	dib.Unload()
+
+	spans := rec.Spans()
+	if len(spans) != 2 || spans[0].Name != "freeimage.LoadFromMemory" || spans[1].Name != "freeimage.SaveToMemory" {
+		t.Fatalf("spans = %+v", spans)
+	}
+	for _, sp := range spans {
+		for key, want := range map[string]interface{}{
+			fi.AttrFormat: "STUB",
+			fi.AttrWidth:  int64(3),
+			fi.AttrHeight: int64(2),
+			fi.AttrBPP:    int64(24),
+			fi.AttrOK:     true,
+		} {
+			if v := fitest.Attr(sp.Attrs, key); v != want {
+				t.Errorf("%s: %s = %v, want %v", sp.Name, key, v, want)
+			}
+		}
+		if sp.Err != nil {
+			t.Errorf("%s: error %v", sp.Name, sp.Err)
+		}
+	}
+	if v := fitest.Attr(spans[0].Attrs, fi.AttrBytes); v == nil || v.(int64) <= 0 {
+		t.Errorf("LoadFromMemory: %s = %v", fi.AttrBytes, v)
+	}
+	if n := len(rec.Values(fi.MetricDuration)); n != 2 {
+		t.Errorf("%d %s values, want 2", n, fi.MetricDuration)
+	}
+	if m := rec.Values(fi.MetricAllocated); len(m) != 1 || fitest.Attr(m[0].Attrs, fi.AttrOperation) != "LoadFromMemory" {
+		t.Errorf("%s = %+v", fi.MetricAllocated, m)
+	}
+	if n := len(rec.Records()); n != 2 {
+		t.Errorf("%d log records, want one per operation", n)
+	}
+
+	// a failed load is an error on its span, and the message of the
+	// format is logged
+	rec.Reset()
+	truncated := fitest.Bytes(3, 2, 24, func(x, y, ch int) byte { return 1 })
+	if _, err := fi.Decode(truncated[:20], &fi.DecodeOptions{Format: fitest.FIF_STUB, Force: true}); err == nil {
+		t.Fatal("Decode of truncated data succeeded")
+	}
+	spans = rec.Spans()
+	if len(spans) != 1 || spans[0].Err == nil || fitest.Attr(spans[0].Attrs, fi.AttrOK) != false {
+		t.Errorf("spans = %+v", spans)
+	}
+	var warned bool
+	for _, r := range rec.Records() {
+		if r.Level == fi.LevelWarn && r.Msg == "STUB: truncated image data" && fitest.Attr(r.Attrs, fi.AttrFormat) == "STUB" {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Errorf("the output message wasn't logged: %+v", rec.Records())
+	}
+
+	s.Reset()
+	s.Library.Close()
+	if s.Called("SetOutputMessage") != 1 {
+		t.Error("Close didn't unset the output message handler")
+	}
+}
@@ -0,0 +1,350 @@
+package freeimage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jinzhongmin/goffi/pkg/c"
+)
+
+var (
+	ErrNotInitialised = errors.New("freeimage: library not loaded, call Open or InitLib first")
+	ErrLibNotFound    = errors.New("freeimage: shared library not found")
+	ErrVersion        = errors.New("freeimage: library version too old")
+	ErrClosed         = errors.New("freeimage: library closed")
+)
+
+// LibraryNames are the shared library names Open tries, in order, when no
+// path is given. The FREEIMAGE_LIBRARY environment variable, if set, is
+// tried first.
+var LibraryNames = []string{
+	"libfreeimage.so.3",
+	"libfreeimage.so",
+	"libfreeimageplus.so.3",
+	"libfreeimageplus.so",
+	"libfreeimage.3.dylib",
+	"libfreeimage.dylib",
+	"FreeImage.dll",
+}
+
+// Library is a loaded FreeImage shared library.
+//
+// Symbols are resolved per Library, so several instances can be open at
+// the same time, e.g. a stub and the real library in tests. Note that the
+// dynamic loader hands out the same copy for the same file, so two Library
+// values opened from one path still share FreeImage's global state.
+type Library struct {
+	path   string
+	lib    *c.Lib
+	linked bool // linked into the executable, see Backend
+
+	mu     sync.RWMutex
+	funcs  map[*c.FuncPrototype]*c.FuncPrototype
+	closed bool
+
+	// initMu is held across the native calls of Initialise, DeInitialise
+	// and Close, so FreeImage's state always matches refs.
+	initMu sync.Mutex
+	refs   int
+
+	pluginPaths []string         // from WithPluginDir
+	plugins     []ExternalPlugin // registered by the last Initialise
+	pluginLibs  []*c.Lib         // opened for plugins, unloaded by Close
+
+	inst *Instrumentation // from WithInstrumentation
+	// the output message handler routing to inst.Logger, and its
+	// converter kept from the garbage collector
+	outputCB  *c.Callback
+	outputCvt func(*c.Callback, []*c.Value, *c.Value)
+}
+
+type openConfig struct {
+	mode       c.LibMode
+	minVersion string
+	pluginDir  string
+	inst       *Instrumentation
+}
+
+// Option configures Open.
+type Option func(*openConfig)
+
+// WithMode sets the dlopen mode, c.ModeNow by default.
+func WithMode(mode c.LibMode) Option {
+	return func(cfg *openConfig) { cfg.mode = mode }
+}
+
+// WithMinVersion makes Open fail with ErrVersion if GetVersion reports an
+// older library, e.g. WithMinVersion("3.18.0").
+func WithMinVersion(version string) Option {
+	return func(cfg *openConfig) { cfg.minVersion = version }
+}
+
+// WithPluginDir makes Initialise register the FreeImage plugins in dir,
+// the shared libraries with the platform extension, e.g. ".so", exporting
+// the FI_InitProc Init. See Library.ExternalPlugins for their formats.
+func WithPluginDir(dir string) Option {
+	return func(cfg *openConfig) { cfg.pluginDir = dir }
+}
+
+// Open loads the FreeImage shared library at path. An empty path searches
+// FREEIMAGE_LIBRARY and LibraryNames, or returns the library linked into
+// the executable when built with the freeimage_cgo tag.
+func Open(path string, opts ...Option) (*Library, error) {
+	cfg := openConfig{mode: c.ModeNow}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var (
+		lib *c.Lib
+		err error
+	)
+	linked := false
+	if path != "" {
+		lib, err = c.NewLib(path, cfg.mode)
+	} else if lib = linkedLib(); lib != nil {
+		path, linked = linkedPath, true
+	} else {
+		path, lib, err = search(cfg.mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Library{path: path, lib: lib, linked: linked, funcs: map[*c.FuncPrototype]*c.FuncPrototype{}}
+	if cfg.pluginDir != "" {
+		if l.pluginPaths, err = scanPluginDir(cfg.pluginDir); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if cfg.minVersion != "" {
+		if err := l.RequireVersion(cfg.minVersion); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if l.inst = cfg.inst; l.inst != nil && l.inst.Logger != nil {
+		l.routeOutputMessages()
+	}
+	return l, nil
+}
+
+func search(mode c.LibMode) (string, *c.Lib, error) {
+	names := LibraryNames
+	if env := os.Getenv("FREEIMAGE_LIBRARY"); env != "" {
+		names = append([]string{env}, names...)
+	}
+	var errs []error
+	for _, name := range names {
+		lib, err := c.NewLib(name, mode)
+		if err == nil {
+			return name, lib, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", nil, fmt.Errorf("%w (tried %s): %v", ErrLibNotFound, strings.Join(names, ", "), errors.Join(errs...))
+}
+
+// Path returns the path or name the library was loaded from.
+func (l *Library) Path() string { return l.path }
+
+// call resolves fp in this library and calls it, reporting the observed
+// functions to the Instrumentation. Resolved prototypes are cached per
+// library, the shared fp is never modified.
+func (l *Library) call(fp *c.FuncPrototype, args []interface{}) *c.Value {
+	if l.inst != nil {
+		if op, ok := observed[fp.Name]; ok {
+			return l.observe(fp, op, args)
+		}
+	}
+	return l.invoke(fp, args)
+}
+
+// invoke calls fp, tracking the resources it creates and releases.
+func (l *Library) invoke(fp *c.FuncPrototype, args []interface{}) *c.Value {
+	if !tracking.Load() {
+		return l.resolve(fp).Call(args)
+	}
+	l.trackBefore(fp, args)
+	v := l.resolve(fp).Call(args)
+	l.trackAfter(fp, v)
+	return v
+}
+
+func (l *Library) resolve(fp *c.FuncPrototype) *c.FuncPrototype {
+	l.mu.RLock()
+	p, closed := l.funcs[fp], l.closed
+	l.mu.RUnlock()
+	if p != nil {
+		return p
+	}
+	if closed {
+		panic(ErrClosed)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if p = l.funcs[fp]; p != nil {
+		return p
+	}
+	p = &c.FuncPrototype{Name: fp.Name, OutType: fp.OutType, InTypes: fp.InTypes}
+	p.Ptr = l.lib.Symbol(fp.Name)
+	if err := p.Create(nil); err != nil {
+		panic(fmt.Errorf("freeimage: %s: %w", fp.Name, err))
+	}
+	l.funcs[fp] = p
+	return p
+}
+
+// Initialise calls FreeImage_Initialise on the first call and only counts
+// the following ones, every Initialise must be paired with a DeInitialise.
+// The first call also registers the plugins of WithPluginDir. Concurrent
+// calls return once the library is initialised.
+func (l *Library) Initialise(load_local_plugins_only bool) {
+	l.initMu.Lock()
+	defer l.initMu.Unlock()
+	l.refs++
+	if l.refs == 1 {
+		b := c.CBool(load_local_plugins_only)
+		l.call(_func_FreeImage_Initialise_, inArgs{&b})
+		l.registerPluginDir()
+	}
+}
+
+// DeInitialise calls FreeImage_DeInitialise when the last Initialise is
+// released. Extra calls are ignored.
+func (l *Library) DeInitialise() {
+	l.initMu.Lock()
+	defer l.initMu.Unlock()
+	if l.refs == 0 {
+		return
+	}
+	l.refs--
+	if l.refs == 0 {
+		l.call(_func_FreeImage_DeInitialise_, nil)
+		l.mu.Lock()
+		l.plugins = nil // FreeImage dropped them
+		l.mu.Unlock()
+	}
+}
+
+// Version returns the FreeImage version string, e.g. "3.18.0".
+func (l *Library) Version() string {
+	return l.call(_func_FreeImage_GetVersion_, nil).StrFree()
+}
+
+// RequireVersion returns ErrVersion if the library is older than min.
+func (l *Library) RequireVersion(min string) error {
+	v := l.Version()
+	if compareVersions(v, min) < 0 {
+		return fmt.Errorf("%w: %s is %s, need %s", ErrVersion, l.path, v, min)
+	}
+	return nil
+}
+
+// compareVersions compares dotted numeric versions, missing parts are 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(strings.TrimSpace(as[i]))
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(strings.TrimSpace(bs[i]))
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Close deinitialises the library if needed and unloads it. Closing the
+// default library resets the default.
+func (l *Library) Close() error {
+	l.initMu.Lock()
+	defer l.initMu.Unlock()
+	l.mu.RLock()
+	closed := l.closed
+	l.mu.RUnlock()
+	if closed {
+		return nil
+	}
+
+	if l.refs > 0 {
+		l.call(_func_FreeImage_DeInitialise_, nil)
+		l.refs = 0
+	}
+	l.stopOutputMessages()
+
+	l.mu.Lock()
+	l.closed = true
+	for _, p := range l.funcs {
+		p.Free()
+	}
+	l.funcs = nil
+	l.mu.Unlock()
+
+	fiLib.p.CompareAndSwap(l, nil)
+	for _, lib := range l.pluginLibs {
+		lib.UnLoad()
+	}
+	l.lib.UnLoad()
+	return nil
+}
+
+// default library -----------------------------------------------------------
+
+// defaultLibrary is the library used by the package level functions.
+type defaultLibrary struct {
+	p atomic.Pointer[Library]
+}
+
+var fiLib defaultLibrary
+
+func (d *defaultLibrary) get() *Library {
+	l := d.p.Load()
+	if l == nil {
+		panic(ErrNotInitialised)
+	}
+	return l
+}
+
+func (d *defaultLibrary) Call(fp *c.FuncPrototype, args []interface{}) *c.Value {
+	return d.get().call(fp, args)
+}
+
+// Default returns the library used by the package level functions, nil if
+// none is set.
+func Default() *Library { return fiLib.p.Load() }
+
+// SetDefault makes l the library used by the package level functions and
+// returns the previous one. Bitmaps must not be passed between libraries.
+func SetDefault(l *Library) (prev *Library) { return fiLib.p.Swap(l) }
+
+// InitLib loads the library at path and makes it the default. It does
+// nothing if a library is already the default, whatever its path, and
+// panics if loading fails; use Open to handle errors.
+func InitLib(path string, mod c.LibMode) {
+	if Default() != nil {
+		return
+	}
+	l, err := Open(path, WithMode(mod))
+	if err != nil {
+		panic(err)
+	}
+	if !fiLib.p.CompareAndSwap(nil, l) {
+		l.Close()
+		InitLib(path, mod)
+	}
+}
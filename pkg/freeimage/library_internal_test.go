@@ -0,0 +1,26 @@
+package freeimage
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"3.18.0", "3.18.0", 0},
+		{"3.18.0", "3.18", 0},
+		{"3.18", "3.18.1", -1},
+		{"3.9", "3.18", -1},
+		{"4", "3.99.9", 1},
+		{" 3 . 18 ", "3.18", 0},
+		{"3.18.0-beta", "3.18", 0}, // a non-numeric part counts as 0
+		{"", "0", 0},
+	} {
+		if got := compareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := compareVersions(tc.b, tc.a); got != -tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.b, tc.a, got, -tc.want)
+		}
+	}
+}
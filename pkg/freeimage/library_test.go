@@ -0,0 +1,137 @@
+package freeimage_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/jinzhongmin/goffi/pkg/c"
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+// openStub opens a second library on the stub, so the test controls every
+// Initialise. base is the native initialisation count of the stub's own.
+func openStub(t *testing.T) (l *fi.Library, s *fitest.Stub, base int) {
+	t.Helper()
+	s = fitest.NewStub(t)
+	l, err := fi.Open(s.Library.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, s, s.Initialised()
+}
+
+func TestInitLibLoaded(t *testing.T) {
+	s := fitest.NewStub(t)
+	// a library is the default, InitLib keeps it whatever the path
+	fi.InitLib("libfreeimage.so.3", c.ModeNow)
+	fi.InitLib(s.Library.Path(), c.ModeNow)
+	if fi.Default() != s.Library {
+		t.Error("InitLib replaced the default library")
+	}
+}
+
+func TestInitialiseRefcount(t *testing.T) {
+	l, s, base := openStub(t)
+	l.Initialise(false)
+	l.Initialise(false)
+	l.Initialise(true)
+	if n, got := s.Called("Initialise"), s.Initialised(); n != 1 || got != base+1 {
+		t.Errorf("3 Initialise: %d native calls, count %d", n, got)
+	}
+	l.DeInitialise()
+	l.DeInitialise()
+	if n := s.Called("DeInitialise"); n != 0 {
+		t.Errorf("DeInitialise reached the library with references left")
+	}
+	l.DeInitialise()
+	l.DeInitialise() // unmatched, ignored
+	if n, got := s.Called("DeInitialise"), s.Initialised(); n != 1 || got != base {
+		t.Errorf("last DeInitialise: %d native calls, count %d", n, got)
+	}
+
+	// Close deinitialises a library still in use, once
+	l.Initialise(false)
+	l.Initialise(false)
+	s.Reset()
+	l.Close()
+	if n, got := s.Called("DeInitialise"), s.Initialised(); n != 1 || got != base {
+		t.Errorf("Close: %d native calls, count %d", n, got)
+	}
+}
+
+func TestInitialiseConcurrent(t *testing.T) {
+	l, s, base := openStub(t)
+
+	var wg sync.WaitGroup
+	early := make(chan int, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Initialise(false)
+			// the library is ready when Initialise returns
+			if n := s.Initialised(); n != base+1 {
+				early <- n
+			}
+		}()
+	}
+	wg.Wait()
+	close(early)
+	for n := range early {
+		t.Errorf("Initialise returned with count %d, want %d", n, base+1)
+	}
+	if n := s.Called("Initialise"); n != 1 {
+		t.Errorf("%d native Initialise calls", n)
+	}
+
+	for i := 0; i < 8; i++ {
+		l.DeInitialise()
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				l.Initialise(false)
+				l.DeInitialise()
+			}
+		}()
+	}
+	wg.Wait()
+	if n, init, deinit := s.Initialised(), s.Called("Initialise"), s.Called("DeInitialise"); n != base || init != deinit {
+		t.Errorf("count %d after cycles, %d Initialise and %d DeInitialise", n, init, deinit)
+	}
+}
+
+func TestRequireVersion(t *testing.T) {
+	s := fitest.NewStub(t)
+	for min, ok := range map[string]bool{
+		"3.18.0": true,
+		"3.18":   true,
+		"1":      true,
+		"":       true,
+		"3.18.1": false,
+		"3.19":   false,
+		"99":     false,
+	} {
+		err := s.Library.RequireVersion(min)
+		if ok && err != nil || !ok && !errors.Is(err, fi.ErrVersion) {
+			t.Errorf("RequireVersion(%q) = %v", min, err)
+		}
+	}
+
+	if l, err := fi.Open(s.Library.Path(), fi.WithMinVersion("99")); !errors.Is(err, fi.ErrVersion) {
+		t.Errorf("Open with a newer minimum: %v", err)
+		if l != nil {
+			l.Close()
+		}
+	}
+	l, err := fi.Open(s.Library.Path(), fi.WithMinVersion("3.17"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
@@ -0,0 +1,330 @@
+package freeimage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrTooLarge         = errors.New("freeimage: image exceeds load limits")
+	ErrFormatNotAllowed = errors.New("freeimage: image format not allowed")
+)
+
+// LoadLimits bounds the images its loaders accept, to reject decompression
+// bombs before they are decoded. Zero fields are unlimited, and a nil
+// *LoadLimits accepts everything.
+//
+// The loaders first load the header with FIF_LOAD_NOPIXELS and check its
+// size, then load the image. Formats that can't load headers only are
+// loaded and checked afterwards, unless RequireProbe is set.
+type LoadLimits struct {
+	MaxWidth, MaxHeight int32
+	MaxPixels           int64
+	MaxPages            int32
+	// MaxMemoryBytes bounds the decoded size of a page, see EstimateSize.
+	MaxMemoryBytes int64
+	// AllowedFormats lists the accepted formats, nil allows all.
+	AllowedFormats []FREE_IMAGE_FORMAT
+	// RequireProbe rejects formats without header only loading, and images
+	// whose header fails to load, with a *FormatError instead of loading
+	// them fully to check them.
+	RequireProbe bool
+}
+
+// DefaultLoadLimits are limits for untrusted images. They reject the
+// formats that can't load headers only, rather than decoding them to check
+// their size.
+var DefaultLoadLimits = LoadLimits{
+	MaxWidth:       16384,
+	MaxHeight:      16384,
+	MaxPixels:      100000000,
+	MaxPages:       256,
+	MaxMemoryBytes: 1 << 30,
+	RequireProbe:   true,
+}
+
+// LimitError is returned for an image over a LoadLimits limit, it matches
+// ErrTooLarge.
+type LimitError struct {
+	Limit string // the LoadLimits field, e.g. "MaxPixels"
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %s is %d, over %s %d", ErrTooLarge, limitSubject[e.Limit], e.Value, e.Limit, e.Max)
+}
+
+func (e *LimitError) Unwrap() error { return ErrTooLarge }
+
+var limitSubject = map[string]string{
+	"MaxWidth":       "width",
+	"MaxHeight":      "height",
+	"MaxPixels":      "pixel count",
+	"MaxPages":       "page count",
+	"MaxMemoryBytes": "decoded size",
+}
+
+// FormatError is returned for an image format LoadLimits rejects, it
+// matches ErrFormatNotAllowed.
+type FormatError struct {
+	Format FREE_IMAGE_FORMAT
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%v: %s %s", ErrFormatNotAllowed, GetFormatFromFIF(e.Format), e.Reason)
+}
+
+func (e *FormatError) Unwrap() error { return ErrFormatNotAllowed }
+
+// CheckFormat returns a *FormatError if fif is not in AllowedFormats.
+func (l *LoadLimits) CheckFormat(fif FREE_IMAGE_FORMAT) error {
+	if l == nil || l.AllowedFormats == nil {
+		return nil
+	}
+	for _, f := range l.AllowedFormats {
+		if f == fif {
+			return nil
+		}
+	}
+	return &FormatError{Format: fif, Reason: "is not in AllowedFormats"}
+}
+
+// Check returns a *LimitError if a width x height image with bpp bits per
+// pixel is over the limits. The sizes take the uint32 of GetWidth and
+// GetHeight, products over math.MaxInt64 saturate.
+func (l *LoadLimits) Check(width, height, bpp uint32) error {
+	if l == nil {
+		return nil
+	}
+	for _, c := range []struct {
+		limit      string
+		value, max int64
+	}{
+		{"MaxWidth", int64(width), int64(l.MaxWidth)},
+		{"MaxHeight", int64(height), int64(l.MaxHeight)},
+		{"MaxPixels", mulSat(uint64(width), uint64(height)), l.MaxPixels},
+		{"MaxMemoryBytes", estimateSize(width, height, bpp), l.MaxMemoryBytes},
+	} {
+		if c.max > 0 && c.value > c.max {
+			return &LimitError{Limit: c.limit, Value: c.value, Max: c.max}
+		}
+	}
+	return nil
+}
+
+// mulSat returns a*b, or math.MaxInt64 - bitmapOverhead if it's larger.
+func mulSat(a, b uint64) int64 {
+	const max = math.MaxInt64 - bitmapOverhead
+	if a != 0 && b > max/a {
+		return max
+	}
+	return int64(a * b)
+}
+
+func (l *LoadLimits) checkBitmap(dib *BitMap) error {
+	return l.Check(dib.GetWidth(), dib.GetHeight(), dib.GetBPP())
+}
+
+func (l *LoadLimits) checkPages(n int32) error {
+	if l != nil && l.MaxPages > 0 && n > l.MaxPages {
+		return &LimitError{Limit: "MaxPages", Value: int64(n), Max: int64(l.MaxPages)}
+	}
+	return nil
+}
+
+// canProbe checks the format and reports whether its headers can be
+// loaded alone.
+func (l *LoadLimits) canProbe(fif FREE_IMAGE_FORMAT) (bool, error) {
+	if err := l.CheckFormat(fif); err != nil {
+		return false, err
+	}
+	if !FIFSupportsNoPixels(fif) {
+		return false, l.unprobed(fif, "can't load headers only")
+	}
+	return true, nil
+}
+
+// unprobed returns the *FormatError of an image whose header can't be
+// checked for reason if RequireProbe is set, nil otherwise.
+func (l *LoadLimits) unprobed(fif FREE_IMAGE_FORMAT, reason string) error {
+	if l.RequireProbe {
+		return &FormatError{Format: fif, Reason: reason}
+	}
+	return nil
+}
+
+// probe checks the format and the header loaded by load. It reports
+// whether the size was checked; if not, the loaded image must be.
+func (l *LoadLimits) probe(fif FREE_IMAGE_FORMAT, load func(flags int32) *BitMap) (bool, error) {
+	if l == nil {
+		return true, nil
+	}
+	if ok, err := l.canProbe(fif); !ok || err != nil {
+		return false, err
+	}
+	hdr := load(FIF_LOAD_NOPIXELS)
+	if hdr == nil {
+		// the full load fails too, or is checked
+		return false, l.unprobed(fif, "header failed to load")
+	}
+	defer hdr.Unload()
+	return true, l.checkBitmap(hdr)
+}
+
+// probePages checks the format, the page count and the header of every
+// page of the multipage image opened by open. It reports whether the
+// pages were checked; if not, they must be when locked, see LockPage.
+func (l *LoadLimits) probePages(fif FREE_IMAGE_FORMAT, open func(flags int32) *MultiBitMap) (bool, error) {
+	if l == nil {
+		return true, nil
+	}
+	if ok, err := l.canProbe(fif); !ok || err != nil {
+		return false, err
+	}
+	mb := open(FIF_LOAD_NOPIXELS)
+	if mb == nil {
+		return false, l.unprobed(fif, "header failed to load")
+	}
+	defer mb.Close(0)
+	n := mb.GetPageCount()
+	if err := l.checkPages(n); err != nil {
+		return false, err
+	}
+	for page := int32(0); page < n; page++ {
+		hdr := mb.LockPage(page)
+		if hdr == nil {
+			return false, l.unprobed(fif, fmt.Sprintf("header of page %d failed to load", page))
+		}
+		err := l.checkBitmap(hdr)
+		mb.UnlockPage(hdr, false)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// loaded returns dib, or unloads it and returns the limit error if it
+// wasn't probed and is over the limits.
+func (l *LoadLimits) loaded(dib *BitMap, probed bool) (*BitMap, error) {
+	if !probed {
+		if err := l.checkBitmap(dib); err != nil {
+			dib.Unload()
+			return nil, err
+		}
+	}
+	return dib, nil
+}
+
+// Load is Load within the limits, FIF_UNKNOWN detects the format.
+func (l *LoadLimits) Load(fif FREE_IMAGE_FORMAT, filename string, flags int32) (*BitMap, error) {
+	if fif == FIF_UNKNOWN {
+		if fif = GetFileType(filename, 0); fif == FIF_UNKNOWN {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, filename)
+		}
+	}
+	probed, err := l.probe(fif, func(f int32) *BitMap { return Load(fif, filename, flags|f) })
+	if err != nil {
+		return nil, err
+	}
+	dib := Load(fif, filename, flags)
+	if dib == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, filename)
+	}
+	return l.loaded(dib, probed)
+}
+
+// LoadFromMemory is LoadFromMemory within the limits, FIF_UNKNOWN detects
+// the format. The stream is read from its start.
+func (l *LoadLimits) LoadFromMemory(fif FREE_IMAGE_FORMAT, stream *Memory, flags int32) (*BitMap, error) {
+	stream.SeekMemory(0, SEEK_SET)
+	if fif == FIF_UNKNOWN {
+		if fif = stream.GetFileType(); fif == FIF_UNKNOWN {
+			return nil, ErrUnknownFormat
+		}
+	}
+	probed, err := l.probe(fif, func(f int32) *BitMap {
+		defer stream.SeekMemory(0, SEEK_SET)
+		return LoadFromMemory(fif, stream, flags|f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	dib := LoadFromMemory(fif, stream, flags)
+	if dib == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, GetFormatFromFIF(fif))
+	}
+	return l.loaded(dib, probed)
+}
+
+// OpenMultiBitmap opens filename read only, like OpenMultiBitmap, within
+// the limits. The file is opened with FIF_LOAD_NOPIXELS first to check
+// the header of every page. The pages of formats that can't load headers
+// only are not checked, lock them with LockPage.
+func (l *LoadLimits) OpenMultiBitmap(fif FREE_IMAGE_FORMAT, filename string, keep_cache_in_memory bool, flags int32) (*MultiBitMap, error) {
+	if fif == FIF_UNKNOWN {
+		if fif = GetFileType(filename, 0); fif == FIF_UNKNOWN {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, filename)
+		}
+	}
+	_, err := l.probePages(fif, func(f int32) *MultiBitMap {
+		return OpenMultiBitmap(fif, filename, false, true, false, flags|f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	mb := OpenMultiBitmap(fif, filename, false, true, keep_cache_in_memory, flags)
+	if mb == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, filename)
+	}
+	if err := l.checkPages(mb.GetPageCount()); err != nil {
+		mb.Close(0)
+		return nil, err
+	}
+	return mb, nil
+}
+
+// LoadMultiBitmapFromMemory is LoadMultiBitmapFromMemory within the limits,
+// see OpenMultiBitmap. The stream is read from its start.
+func (l *LoadLimits) LoadMultiBitmapFromMemory(fif FREE_IMAGE_FORMAT, stream *Memory, flags int32) (*MultiBitMap, error) {
+	stream.SeekMemory(0, SEEK_SET)
+	if fif == FIF_UNKNOWN {
+		if fif = stream.GetFileType(); fif == FIF_UNKNOWN {
+			return nil, ErrUnknownFormat
+		}
+	}
+	_, err := l.probePages(fif, func(f int32) *MultiBitMap {
+		return LoadMultiBitmapFromMemory(fif, stream, flags|f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	stream.SeekMemory(0, SEEK_SET) // the probe reads its pages when locked
+	mb := LoadMultiBitmapFromMemory(fif, stream, flags)
+	if mb == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, GetFormatFromFIF(fif))
+	}
+	if err := l.checkPages(mb.GetPageCount()); err != nil {
+		mb.Close(0)
+		return nil, err
+	}
+	return mb, nil
+}
+
+// LockPage is mb.LockPage within the limits: a page over them is unlocked
+// and a *LimitError returned. Use it for the pages of formats that can't
+// load headers only, which OpenMultiBitmap can't check.
+func (l *LoadLimits) LockPage(mb *MultiBitMap, page int32) (*BitMap, error) {
+	dib := mb.LockPage(page)
+	if dib == nil {
+		return nil, fmt.Errorf("%w: page %d", ErrDecode, page)
+	}
+	if err := l.checkBitmap(dib); err != nil {
+		mb.UnlockPage(dib, false)
+		return nil, err
+	}
+	return dib, nil
+}
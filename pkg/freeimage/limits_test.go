@@ -0,0 +1,267 @@
+package freeimage_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/qoi"
+)
+
+func stubImage(w, h, bpp int) []byte {
+	return fitest.Bytes(w, h, bpp, func(x, y, ch int) byte { return byte(x + y + ch) })
+}
+
+func TestLoadLimits(t *testing.T) {
+	s := fitest.NewStub(t)
+	data := stubImage(40, 30, 24)
+
+	for _, tc := range []struct {
+		name   string
+		limits freeimage.LoadLimits
+		limit  string
+	}{
+		{"width", freeimage.LoadLimits{MaxWidth: 39}, "MaxWidth"},
+		{"height", freeimage.LoadLimits{MaxWidth: 40, MaxHeight: 29}, "MaxHeight"},
+		{"pixels", freeimage.LoadLimits{MaxPixels: 1199}, "MaxPixels"},
+		{"memory", freeimage.LoadLimits{MaxMemoryBytes: 4000}, "MaxMemoryBytes"},
+	} {
+		s.Reset()
+		_, err := freeimage.Decode(data, &freeimage.DecodeOptions{Limits: &tc.limits})
+		var le *freeimage.LimitError
+		if !errors.As(err, &le) || le.Limit != tc.limit || !errors.Is(err, freeimage.ErrTooLarge) {
+			t.Errorf("%s: Decode = %v, want a %s LimitError", tc.name, err, tc.limit)
+		}
+		if n := s.Called("LoadFromMemory"); n != 1 {
+			t.Errorf("%s: LoadFromMemory called %d times, want only the header probe", tc.name, n)
+		}
+	}
+
+	// a 65536x65536 header is rejected without allocating its pixels
+	bomb := stubImage(1, 1, 32)
+	copy(bomb[4:], []byte{0, 0, 1, 0, 0, 0, 1, 0})
+	if _, err := freeimage.Decode(bomb, &freeimage.DecodeOptions{Limits: &freeimage.DefaultLoadLimits}); !errors.Is(err, freeimage.ErrTooLarge) {
+		t.Errorf("Decode(bomb) = %v, want ErrTooLarge", err)
+	}
+
+	allowPNG := &freeimage.LoadLimits{AllowedFormats: []freeimage.FREE_IMAGE_FORMAT{freeimage.FIF_PNG}}
+	s.Reset()
+	_, err := freeimage.Decode(data, &freeimage.DecodeOptions{Limits: allowPNG})
+	var fe *freeimage.FormatError
+	if !errors.As(err, &fe) || fe.Format != fitest.FIF_STUB || !errors.Is(err, freeimage.ErrFormatNotAllowed) {
+		t.Errorf("Decode of a format not allowed = %v, want a FormatError", err)
+	}
+	if s.Called("LoadFromMemory") != 0 {
+		t.Errorf("a format not allowed was loaded")
+	}
+
+	ok := &freeimage.LoadLimits{MaxWidth: 40, MaxHeight: 30, MaxPixels: 1200, AllowedFormats: []freeimage.FREE_IMAGE_FORMAT{fitest.FIF_STUB}}
+	for _, l := range []*freeimage.LoadLimits{ok, nil} {
+		dib, err := freeimage.Decode(data, &freeimage.DecodeOptions{Limits: l})
+		if err != nil {
+			t.Fatalf("Decode within %+v: %v", l, err)
+		}
+		if !dib.HasPixels() || dib.GetWidth() != 40 {
+			t.Errorf("Decode within limits returned a %dx%d bitmap, pixels %v", dib.GetWidth(), dib.GetHeight(), dib.HasPixels())
+		}
+		dib.Unload()
+	}
+
+	path := filepath.Join(t.TempDir(), "image.stub")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&freeimage.LoadLimits{MaxPixels: 100}).Load(freeimage.FIF_UNKNOWN, path, 0); !errors.Is(err, freeimage.ErrTooLarge) {
+		t.Errorf("Load = %v, want ErrTooLarge", err)
+	}
+	dib, err := ok.Load(freeimage.FIF_UNKNOWN, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dib.Unload()
+
+	if s.Live() != 0 {
+		t.Errorf("Live() = %d, the limits leak", s.Live())
+	}
+}
+
+// unprobed is QOI without header only loading.
+type unprobed struct{ qoi.Plugin }
+
+func (unprobed) Format() string         { return "QOI-UNPROBED" }
+func (unprobed) SupportsNoPixels() bool { return false }
+
+func TestLoadLimitsUnprobed(t *testing.T) {
+	s := fitest.NewStub(t)
+	fif, err := freeimage.RegisterPlugin(unprobed{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := qoi.Encode(4, 3, 3, 0, make([]byte, 4*3*3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the format can't load headers only, it is checked after loading
+	_, err = freeimage.Decode(data, &freeimage.DecodeOptions{Format: fif, Force: true, Limits: &freeimage.LoadLimits{MaxWidth: 3}})
+	var le *freeimage.LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxWidth" || le.Value != 4 {
+		t.Errorf("Decode = %v, want a MaxWidth LimitError", err)
+	}
+	_, err = freeimage.Decode(data, &freeimage.DecodeOptions{Format: fif, Force: true, Limits: &freeimage.LoadLimits{RequireProbe: true}})
+	if !errors.Is(err, freeimage.ErrFormatNotAllowed) {
+		t.Errorf("Decode with RequireProbe = %v, want ErrFormatNotAllowed", err)
+	}
+	_, err = freeimage.Decode(data, &freeimage.DecodeOptions{Format: fif, Force: true, Limits: &freeimage.DefaultLoadLimits})
+	if !errors.Is(err, freeimage.ErrFormatNotAllowed) {
+		t.Errorf("Decode with DefaultLoadLimits = %v, want ErrFormatNotAllowed", err)
+	}
+	if s.Live() != 0 {
+		t.Errorf("Live() = %d, the rejected bitmap leaks", s.Live())
+	}
+}
+
+func TestLoadLimitsProbeFails(t *testing.T) {
+	s := fitest.NewStub(t)
+	data := stubImage(4, 3, 24)
+	opts := func(l *freeimage.LoadLimits) *freeimage.DecodeOptions {
+		return &freeimage.DecodeOptions{Format: fitest.FIF_STUB, Force: true, Limits: l}
+	}
+
+	// the header load fails, the image is loaded and checked afterwards
+	s.Fail("LoadFromMemory")
+	_, err := freeimage.Decode(data, opts(&freeimage.LoadLimits{MaxWidth: 3}))
+	var le *freeimage.LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxWidth" {
+		t.Errorf("Decode after a failed probe = %v, want a MaxWidth LimitError", err)
+	}
+	// unless a probe is required
+	s.Reset()
+	s.Fail("LoadFromMemory")
+	_, err = freeimage.Decode(data, opts(&freeimage.LoadLimits{RequireProbe: true}))
+	var fe *freeimage.FormatError
+	if !errors.As(err, &fe) || fe.Format != fitest.FIF_STUB {
+		t.Errorf("Decode after a failed probe with RequireProbe = %v, want a FormatError", err)
+	}
+	if n := s.Called("LoadFromMemory"); n != 1 {
+		t.Errorf("LoadFromMemory called %d times, want only the header probe", n)
+	}
+	if s.Live() != 0 {
+		t.Errorf("Live() = %d", s.Live())
+	}
+}
+
+func TestLoadLimitsCheck(t *testing.T) {
+	for _, tc := range []struct {
+		width, height, bpp uint32
+		limits             freeimage.LoadLimits
+		limit              string
+	}{
+		// sizes over math.MaxInt32 must not wrap to negative and pass
+		{1 << 31, 1, 8, freeimage.LoadLimits{MaxWidth: 16384}, "MaxWidth"},
+		{1, 1<<32 - 1, 8, freeimage.LoadLimits{MaxHeight: 16384}, "MaxHeight"},
+		{1<<32 - 1, 1<<32 - 1, 8, freeimage.LoadLimits{MaxPixels: 1 << 62}, "MaxPixels"},
+		{1<<32 - 1, 1<<32 - 1, 128, freeimage.LoadLimits{MaxMemoryBytes: 1 << 62}, "MaxMemoryBytes"},
+	} {
+		err := tc.limits.Check(tc.width, tc.height, tc.bpp)
+		var le *freeimage.LimitError
+		if !errors.As(err, &le) || le.Limit != tc.limit || le.Value <= 0 {
+			t.Errorf("Check(%d, %d, %d) = %v, want a positive %s LimitError", tc.width, tc.height, tc.bpp, err, tc.limit)
+		}
+	}
+	if err := freeimage.DefaultLoadLimits.Check(16384, 16384, 32); !errors.Is(err, freeimage.ErrTooLarge) {
+		t.Errorf("DefaultLoadLimits.Check(16384, 16384, 32) = %v, want ErrTooLarge", err)
+	}
+	if err := freeimage.DefaultLoadLimits.Check(4096, 4096, 32); err != nil {
+		t.Errorf("DefaultLoadLimits.Check(4096, 4096, 32) = %v", err)
+	}
+}
+
+func TestLoadLimitsPages(t *testing.T) {
+	s := fitest.NewStub(t)
+	var data []byte
+	for i := 0; i < 3; i++ {
+		data = append(data, stubImage(4, 4, 8)...)
+	}
+	path := filepath.Join(t.TempDir(), "pages.stub")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mem := freeimage.OpenMemory(data)
+
+	two, three := &freeimage.LoadLimits{MaxPages: 2}, &freeimage.LoadLimits{MaxPages: 3}
+	if _, err := two.OpenMultiBitmap(freeimage.FIF_UNKNOWN, path, false, 0); !errors.Is(err, freeimage.ErrTooLarge) {
+		t.Errorf("OpenMultiBitmap over MaxPages = %v, want ErrTooLarge", err)
+	}
+	_, err := two.LoadMultiBitmapFromMemory(freeimage.FIF_UNKNOWN, mem, 0)
+	var le *freeimage.LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxPages" || le.Value != 3 {
+		t.Errorf("LoadMultiBitmapFromMemory over MaxPages = %v, want a MaxPages LimitError", err)
+	}
+	if _, err := (&freeimage.LoadLimits{MaxWidth: 3}).OpenMultiBitmap(fitest.FIF_STUB, path, false, 0); !errors.Is(err, freeimage.ErrTooLarge) {
+		t.Errorf("OpenMultiBitmap over MaxWidth = %v, want ErrTooLarge", err)
+	}
+
+	mb, err := three.OpenMultiBitmap(freeimage.FIF_UNKNOWN, path, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := mb.GetPageCount(); n != 3 {
+		t.Errorf("GetPageCount = %d", n)
+	}
+	mb.Close(0)
+	if mb, err = three.LoadMultiBitmapFromMemory(freeimage.FIF_UNKNOWN, mem, 0); err != nil {
+		t.Fatal(err)
+	}
+	mb.Close(0)
+
+	// every page is probed before the file is opened, not only the first
+	wide := append(append([]byte(nil), data...), stubImage(40, 4, 8)...)
+	widePath := filepath.Join(t.TempDir(), "wide.stub")
+	if err := os.WriteFile(widePath, wide, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	narrow := &freeimage.LoadLimits{MaxWidth: 10}
+	s.Reset()
+	_, err = narrow.OpenMultiBitmap(fitest.FIF_STUB, widePath, false, 0)
+	if !errors.As(err, &le) || le.Limit != "MaxWidth" || le.Value != 40 {
+		t.Errorf("OpenMultiBitmap with a wide last page = %v, want a MaxWidth LimitError", err)
+	}
+	if n := s.Called("OpenMultiBitmap"); n != 1 {
+		t.Errorf("OpenMultiBitmap called %d times, want only the probe", n)
+	}
+	wideMem := freeimage.OpenMemory(wide)
+	if _, err := narrow.LoadMultiBitmapFromMemory(fitest.FIF_STUB, wideMem, 0); !errors.As(err, &le) || le.Value != 40 {
+		t.Errorf("LoadMultiBitmapFromMemory with a wide last page = %v, want a MaxWidth LimitError", err)
+	}
+
+	// LockPage checks the pages of multipage bitmaps opened without probes
+	wideMem.SeekMemory(0, freeimage.SEEK_SET)
+	mb = freeimage.LoadMultiBitmapFromMemory(fitest.FIF_STUB, wideMem, 0)
+	if _, err := narrow.LockPage(mb, 3); !errors.As(err, &le) || le.Value != 40 {
+		t.Errorf("LockPage of the wide page = %v, want a MaxWidth LimitError", err)
+	}
+	if _, err := narrow.LockPage(mb, 4); !errors.Is(err, freeimage.ErrDecode) {
+		t.Errorf("LockPage of a missing page = %v, want ErrDecode", err)
+	}
+	page, err := narrow.LockPage(mb, 0)
+	if err != nil || page.GetWidth() != 4 {
+		t.Fatalf("LockPage(0) = %v", err)
+	}
+	mb.UnlockPage(page, false)
+	mb.Close(0)
+	wideMem.CloseMemory()
+
+	mem.CloseMemory()
+	garbage := freeimage.OpenMemory([]byte("garbage"))
+	if _, err := three.LoadMultiBitmapFromMemory(freeimage.FIF_UNKNOWN, garbage, 0); !errors.Is(err, freeimage.ErrUnknownFormat) {
+		t.Errorf("LoadMultiBitmapFromMemory(garbage) = %v, want ErrUnknownFormat", err)
+	}
+	garbage.CloseMemory()
+	if s.Live() != 0 {
+		t.Errorf("Live() = %d, the limits leak", s.Live())
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows || 386 || arm || mips || mipsle
+
+package freeimage
+
+import "github.com/jinzhongmin/goffi/pkg/c"
+
+// cLong is the Go type of the C long, 32 bit on Windows and on 32 bit
+// platforms.
+type cLong = int32
+
+var cLongType = c.I32
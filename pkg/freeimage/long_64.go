@@ -0,0 +1,10 @@
+//go:build !(windows || 386 || arm || mips || mipsle)
+
+package freeimage
+
+import "github.com/jinzhongmin/goffi/pkg/c"
+
+// cLong is the Go type of the C long, 64 bit on LP64 platforms.
+type cLong = int64
+
+var cLongType = c.I64
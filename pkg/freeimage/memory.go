@@ -0,0 +1,160 @@
+package freeimage
+
+import (
+	"errors"
+	"io"
+	"math"
+	"runtime"
+)
+
+var (
+	_ io.ReadWriteSeeker = (*Memory)(nil)
+	_ io.ReaderAt        = (*Memory)(nil)
+	_ io.WriterTo        = (*Memory)(nil)
+	_ io.ReaderFrom      = (*Memory)(nil)
+	_ io.Closer          = (*Memory)(nil)
+)
+
+var errSeekOffset = errors.New("freeimage: seek offset out of range")
+
+// acquire returns the whole stream buffer without copying it. The slice is
+// owned by the stream and is invalid after the next write or CloseMemory.
+func (stream *Memory) acquire() []byte {
+	ok, p := stream.AcquireMemory()
+	if !ok || p == nil {
+		return nil
+	}
+	return *(*[]byte)(p)
+}
+
+// Len returns the size of the stream in bytes.
+func (stream *Memory) Len() int {
+	return len(stream.acquire())
+}
+
+// Bytes returns a copy of the whole stream, whatever the current position.
+func (stream *Memory) Bytes() []byte {
+	return append([]byte(nil), stream.acquire()...)
+}
+
+// Read implements io.Reader, reading from the current position.
+func (stream *Memory) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if uint64(len(p)) > math.MaxUint32 {
+		p = p[:math.MaxUint32]
+	}
+	n := stream.WriteToSlice(&p[0], 1, uint32(len(p)))
+	runtime.KeepAlive(p)
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return int(n), nil
+}
+
+// Write implements io.Writer, writing at the current position and growing
+// the stream as needed. Streams opened on a Go slice with OpenMemory are
+// read only.
+func (stream *Memory) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if uint64(len(chunk)) > math.MaxUint32 {
+			chunk = chunk[:math.MaxUint32]
+		}
+		n := int(stream.ReadFromSlice(&chunk[0], 1, uint32(len(chunk))))
+		runtime.KeepAlive(chunk)
+		written += n
+		if n < len(chunk) {
+			return written, io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Seek implements io.Seeker with the io.SeekStart, io.SeekCurrent and
+// io.SeekEnd origins.
+func (stream *Memory) Seek(offset int64, whence int) (int64, error) {
+	var origin SeekOrigin
+	switch whence {
+	case io.SeekStart:
+		origin = SEEK_SET
+	case io.SeekCurrent:
+		origin = SEEK_CUR
+	case io.SeekEnd:
+		origin = SEEK_END
+	default:
+		return 0, errors.New("freeimage: invalid seek whence")
+	}
+	if int64(cLong(offset)) != offset {
+		return 0, errSeekOffset
+	}
+	if !stream.SeekMemory(offset, origin) {
+		return 0, errSeekOffset
+	}
+	return stream.Tell(), nil
+}
+
+// ReadAt implements io.ReaderAt. It reads the stream buffer directly and
+// leaves the current position unchanged.
+func (stream *Memory) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errSeekOffset
+	}
+	buf := stream.acquire()
+	if off >= int64(len(buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo, writing the stream from the current
+// position to its end to w.
+func (stream *Memory) WriteTo(w io.Writer) (int64, error) {
+	pos := stream.Tell()
+	buf := stream.acquire()
+	if pos >= int64(len(buf)) {
+		return 0, nil
+	}
+	n, err := w.Write(buf[pos:])
+	stream.Seek(pos+int64(n), io.SeekStart)
+	if err == nil && n < len(buf[pos:]) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+// ReadFrom implements io.ReaderFrom, writing everything read from r at
+// the current position.
+func (stream *Memory) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			m, werr := stream.Write(buf[:n])
+			total += int64(m)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Close implements io.Closer, it calls CloseMemory.
+func (stream *Memory) Close() error {
+	stream.CloseMemory()
+	return nil
+}
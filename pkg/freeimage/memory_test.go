@@ -0,0 +1,203 @@
+package freeimage_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func TestMemorySeekOrigins(t *testing.T) {
+	fitest.Auto(t)
+	mem := fi.OpenMemory(nil)
+	defer mem.Close()
+	if _, err := mem.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{2, io.SeekStart, 2},
+		{3, io.SeekCurrent, 5},
+		{-4, io.SeekEnd, 6},
+		{0, io.SeekEnd, 10},
+	}
+	for _, tt := range tests {
+		got, err := mem.Seek(tt.offset, tt.whence)
+		if err != nil || got != tt.want {
+			t.Errorf("Seek(%d, %d) = %d, %v, want %d", tt.offset, tt.whence, got, err, tt.want)
+		}
+	}
+	if _, err := mem.Seek(-11, io.SeekEnd); err == nil {
+		t.Error("Seek before the start succeeded")
+	}
+
+	mem.Seek(-3, io.SeekEnd)
+	buf, _ := io.ReadAll(mem)
+	if string(buf) != "789" {
+		t.Errorf("read %q after SEEK_END, want %q", buf, "789")
+	}
+}
+
+func TestMemoryReadWrite(t *testing.T) {
+	fitest.Auto(t)
+	mem := fi.OpenMemory(nil)
+	defer mem.Close()
+	if n := mem.Len(); n != 0 {
+		t.Errorf("Len() = %d of an empty stream", n)
+	}
+	if n, err := mem.Read(make([]byte, 4)); n != 0 || err != io.EOF {
+		t.Errorf("Read of an empty stream = %d, %v, want io.EOF", n, err)
+	}
+
+	if n, err := mem.Write([]byte("hello world")); err != nil || n != 11 {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	mem.Seek(6, io.SeekStart)
+	if n, err := mem.Write([]byte("gophers")); err != nil || n != 7 {
+		t.Fatalf("Write over the end = %d, %v", n, err)
+	}
+	if n, err := mem.Write(nil); err != nil || n != 0 {
+		t.Errorf("Write(nil) = %d, %v", n, err)
+	}
+	if got := string(mem.Bytes()); got != "hello gophers" || mem.Len() != len(got) {
+		t.Errorf("Bytes() = %q, Len() = %d", got, mem.Len())
+	}
+	// Bytes is a copy, whatever the position
+	b := mem.Bytes()
+	b[0] = 'J'
+	if got := mem.Bytes(); got[0] != 'h' {
+		t.Errorf("Bytes() shares the stream buffer: %q", got)
+	}
+	if pos, _ := mem.Seek(0, io.SeekCurrent); pos != 13 {
+		t.Errorf("Bytes moved the position to %d", pos)
+	}
+
+	mem.Seek(0, io.SeekStart)
+	buf := make([]byte, 5)
+	if n, err := mem.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("Read = %q, %v", buf[:n], err)
+	}
+	if n, err := mem.Read(nil); n != 0 || err != nil {
+		t.Errorf("Read(nil) = %d, %v", n, err)
+	}
+	rest, err := io.ReadAll(mem)
+	if err != nil || string(rest) != " gophers" {
+		t.Errorf("ReadAll = %q, %v", rest, err)
+	}
+}
+
+func TestMemoryCopy(t *testing.T) {
+	fitest.Auto(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 5000) // over the 32 KB ReadFrom buffer
+
+	// io.Copy uses ReadFrom into a Memory and WriteTo out of one
+	mem := fi.OpenMemory(nil)
+	defer mem.Close()
+	if n, err := io.Copy(mem, bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatalf("io.Copy into Memory = %d, %v", n, err)
+	}
+	if !bytes.Equal(mem.Bytes(), data) {
+		t.Fatal("ReadFrom wrote other bytes")
+	}
+	mem.Seek(100, io.SeekStart)
+	var out bytes.Buffer
+	if n, err := io.Copy(&out, mem); err != nil || n != int64(len(data)-100) || !bytes.Equal(out.Bytes(), data[100:]) {
+		t.Fatalf("io.Copy out of Memory = %d, %v", n, err)
+	}
+	if pos, _ := mem.Seek(0, io.SeekCurrent); pos != int64(len(data)) {
+		t.Errorf("WriteTo left the position at %d, want the end", pos)
+	}
+	if n, err := mem.WriteTo(&out); n != 0 || err != nil {
+		t.Errorf("WriteTo at the end = %d, %v", n, err)
+	}
+
+	// Read and Write alone, without the io.WriterTo and io.ReaderFrom
+	// shortcuts
+	mem2 := fi.OpenMemory(nil)
+	defer mem2.Close()
+	mem.Seek(0, io.SeekStart)
+	if n, err := io.Copy(struct{ io.Writer }{mem2}, struct{ io.Reader }{mem}); err != nil || n != int64(len(data)) {
+		t.Fatalf("io.Copy with Read and Write = %d, %v", n, err)
+	}
+	if !bytes.Equal(mem2.Bytes(), data) {
+		t.Error("Read and Write copied other bytes")
+	}
+
+	// a failing reader stops ReadFrom with its error
+	fail := errors.New("read failed")
+	mem3 := fi.OpenMemory(nil)
+	defer mem3.Close()
+	if n, err := mem3.ReadFrom(io.MultiReader(strings.NewReader("abc"), iotest.ErrReader(fail))); n != 3 || err != fail {
+		t.Errorf("ReadFrom = %d, %v, want 3 bytes and the reader's error", n, err)
+	}
+}
+
+func TestMemoryLargeOffsets(t *testing.T) {
+	fitest.Auto(t)
+	if fi.CLongSize < 8 {
+		t.Skip("C long is 32 bit on this platform")
+	}
+	mem := fi.OpenMemory(nil)
+	defer mem.Close()
+
+	// seeking past the end doesn't allocate, so offsets above 4 GB are cheap
+	for _, off := range []int64{1<<31 - 1, 1 << 31, 1<<32 + 5, 1 << 40} {
+		if !mem.SeekMemory(off, fi.SEEK_SET) {
+			t.Fatalf("SeekMemory(%d) failed", off)
+		}
+		if got := mem.Tell(); got != off {
+			t.Errorf("Tell() = %d after SeekMemory(%d)", got, off)
+		}
+	}
+	if pos, err := mem.Seek(-(1 << 40), io.SeekCurrent); err != nil || pos != 0 {
+		t.Errorf("Seek back to 0 = %d, %v", pos, err)
+	}
+}
+
+// TestMemoryLargeStream writes past 2 GB, it needs about 3 GB of memory and
+// only runs with FREEIMAGE_TEST_LARGE=1.
+func TestMemoryLargeStream(t *testing.T) {
+	if os.Getenv("FREEIMAGE_TEST_LARGE") == "" {
+		t.Skip("set FREEIMAGE_TEST_LARGE=1 to run")
+	}
+	fitest.Auto(t)
+	if fi.CLongSize < 8 {
+		t.Skip("C long is 32 bit on this platform")
+	}
+	mem := fi.OpenMemory(nil)
+	defer mem.Close()
+
+	const off = 1<<31 + 1<<20
+	marker := []byte("past 2 GB")
+	if _, err := mem.Seek(off, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := mem.Write(marker); err != nil || n != len(marker) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if got, want := mem.Tell(), int64(off+len(marker)); got != want {
+		t.Fatalf("Tell() = %d, want %d", got, want)
+	}
+	if got, err := mem.Seek(0, io.SeekEnd); err != nil || got != off+int64(len(marker)) {
+		t.Fatalf("Seek(0, SeekEnd) = %d, %v", got, err)
+	}
+
+	buf := make([]byte, len(marker))
+	if _, err := mem.ReadAt(buf, off); err != nil || !bytes.Equal(buf, marker) {
+		t.Fatalf("ReadAt(%d) = %q, %v", int64(off), buf, err)
+	}
+	mem.Seek(-int64(len(marker)), io.SeekEnd)
+	if _, err := io.ReadFull(mem, buf); err != nil || !bytes.Equal(buf, marker) {
+		t.Fatalf("Read at %d = %q, %v", int64(off), buf, err)
+	}
+}
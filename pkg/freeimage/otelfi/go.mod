@@ -0,0 +1,26 @@
+module github.com/jinzhongmin/gofreeimage/pkg/freeimage/otelfi
+
+// The OpenTelemetry v1.44.0 modules need go 1.25.0. otelfi is a module of
+// its own so that the root module can stay at go 1.20.
+go 1.25.0
+
+require (
+	github.com/jinzhongmin/gofreeimage v0.0.0-20261019031137-915001fbd4c5
+	go.opentelemetry.io/otel v1.44.0
+	go.opentelemetry.io/otel/metric v1.44.0
+	go.opentelemetry.io/otel/sdk v1.44.0
+	go.opentelemetry.io/otel/sdk/metric v1.44.0
+	go.opentelemetry.io/otel/trace v1.44.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/go-logr/logr v1.4.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/jinzhongmin/goffi v0.0.0-20230910061309-49b74c9172f4 // indirect
+	github.com/jinzhongmin/usf v0.0.0-20230831102133-dbf66a6888b9 // indirect
+	go.opentelemetry.io/auto/sdk v1.2.1 // indirect
+	golang.org/x/sys v0.45.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
+)
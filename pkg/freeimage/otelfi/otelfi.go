@@ -0,0 +1,110 @@
+// Package otelfi adapts OpenTelemetry to the Instrumentation of the
+// freeimage package. It is a module of its own, so that the freeimage
+// module doesn't depend on OpenTelemetry.
+//
+//	m, err := otelfi.Meter(otel.GetMeterProvider())
+//	l, err := freeimage.Open("", freeimage.WithInstrumentation(freeimage.Instrumentation{
+//		Tracer: otelfi.Tracer(otel.GetTracerProvider()),
+//		Meter:  m,
+//	}))
+package otelfi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Scope is the instrumentation scope of the tracer and meter.
+const Scope = "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+
+// Tracer returns a freeimage.Tracer starting the spans with the tracer of
+// tp, for Instrumentation.Tracer.
+func Tracer(tp trace.TracerProvider) freeimage.Tracer {
+	return tracer{tp.Tracer(Scope)}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t tracer) Start(ctx context.Context, name string) (context.Context, freeimage.Span) {
+	ctx, s := t.t.Start(ctx, name)
+	return ctx, span{s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) SetAttributes(attrs ...freeimage.Attr) {
+	s.s.SetAttributes(convert(attrs)...)
+}
+
+// RecordError records err and sets the status of the span to Error.
+func (s span) RecordError(err error) {
+	s.s.RecordError(err)
+	s.s.SetStatus(codes.Error, err.Error())
+}
+
+func (s span) End() {
+	s.s.End()
+}
+
+// histograms are the histograms of freeimage, with their units.
+var histograms = []struct {
+	name, unit, description string
+}{
+	{freeimage.MetricDuration, "s", "Duration of the FreeImage operations."},
+	{freeimage.MetricAllocated, "By", "Native memory of the bitmaps the FreeImage operations return."},
+}
+
+// Meter returns a freeimage.Meter recording to the histograms of the meter
+// of mp, for Instrumentation.Meter.
+func Meter(mp metric.MeterProvider) (freeimage.Meter, error) {
+	m := mp.Meter(Scope)
+	hs := make(meter, len(histograms))
+	for _, h := range histograms {
+		hist, err := m.Float64Histogram(h.name, metric.WithUnit(h.unit), metric.WithDescription(h.description))
+		if err != nil {
+			return nil, fmt.Errorf("otelfi: %s: %w", h.name, err)
+		}
+		hs[h.name] = hist
+	}
+	return hs, nil
+}
+
+type meter map[string]metric.Float64Histogram
+
+// Record drops the values of histograms it doesn't know.
+func (m meter) Record(ctx context.Context, name string, value float64, attrs ...freeimage.Attr) {
+	if h, ok := m[name]; ok {
+		h.Record(ctx, value, metric.WithAttributes(convert(attrs)...))
+	}
+}
+
+// convert returns the OpenTelemetry attributes of attrs. Values of other
+// types than those of freeimage.Attr are formatted as strings.
+func convert(attrs []freeimage.Attr) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, len(attrs))
+	for i, a := range attrs {
+		switch v := a.Value.(type) {
+		case string:
+			kvs[i] = attribute.String(a.Key, v)
+		case int64:
+			kvs[i] = attribute.Int64(a.Key, v)
+		case float64:
+			kvs[i] = attribute.Float64(a.Key, v)
+		case bool:
+			kvs[i] = attribute.Bool(a.Key, v)
+		default:
+			kvs[i] = attribute.String(a.Key, fmt.Sprint(v))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,110 @@
+package otelfi_test
+
+import (
+	"context"
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/otelfi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInstrumentation(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	m, err := otelfi.Meter(mp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fitest.NewStub(t, fi.WithInstrumentation(fi.Instrumentation{Tracer: otelfi.Tracer(tp), Meter: m}))
+
+	data := fitest.Bytes(3, 2, 24, func(x, y, ch int) byte { return 1 })
+	dib, err := fi.Decode(data, &fi.DecodeOptions{Format: fitest.FIF_STUB, Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dib.Unload()
+	if _, err := fi.Decode(data[:20], &fi.DecodeOptions{Format: fitest.FIF_STUB, Force: true}); err == nil {
+		t.Fatal("Decode of truncated data succeeded")
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("%d spans, want 2", len(ended))
+	}
+	for i, want := range []struct {
+		ok     bool
+		status codes.Code
+	}{{true, codes.Unset}, {false, codes.Error}} {
+		sp := ended[i]
+		if sp.Name() != "freeimage.LoadFromMemory" || sp.InstrumentationScope().Name != otelfi.Scope {
+			t.Errorf("span %d is %q of %q", i, sp.Name(), sp.InstrumentationScope().Name)
+		}
+		attrs := attribute.NewSet(sp.Attributes()...)
+		if v, _ := attrs.Value(fi.AttrFormat); v.AsString() != "STUB" {
+			t.Errorf("span %d: %s = %v", i, fi.AttrFormat, v.Emit())
+		}
+		if v, _ := attrs.Value(fi.AttrOK); v.Type() != attribute.BOOL || v.AsBool() != want.ok {
+			t.Errorf("span %d: %s = %v, want %v", i, fi.AttrOK, v.Emit(), want.ok)
+		}
+		if sp.Status().Code != want.status {
+			t.Errorf("span %d: status %v, want %v", i, sp.Status().Code, want.status)
+		}
+		if n := len(sp.Events()); want.ok == (n != 0) {
+			t.Errorf("span %d: %d events", i, n)
+		}
+	}
+	first := attribute.NewSet(ended[0].Attributes()...)
+	if v, _ := first.Value(fi.AttrWidth); v.Type() != attribute.INT64 || v.AsInt64() != 3 {
+		t.Errorf("%s = %v, want 3", fi.AttrWidth, v.Emit())
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm.ScopeMetrics) != 1 || rm.ScopeMetrics[0].Scope.Name != otelfi.Scope {
+		t.Fatalf("scope metrics = %+v", rm.ScopeMetrics)
+	}
+	got := map[string]metricdata.Metrics{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		got[m.Name] = m
+	}
+	for _, want := range []struct {
+		name, unit string
+		count      uint64 // of the successful loads
+	}{
+		{fi.MetricDuration, "s", 1},
+		{fi.MetricAllocated, "By", 1},
+	} {
+		m, ok := got[want.name]
+		if !ok {
+			t.Errorf("%s not recorded", want.name)
+			continue
+		}
+		if m.Unit != want.unit {
+			t.Errorf("%s: unit %q, want %q", want.name, m.Unit, want.unit)
+		}
+		hist, ok := m.Data.(metricdata.Histogram[float64])
+		if !ok {
+			t.Errorf("%s is a %T, want a histogram", want.name, m.Data)
+			continue
+		}
+		for _, dp := range hist.DataPoints {
+			if v, _ := dp.Attributes.Value(fi.AttrOK); v.AsBool() && dp.Count != want.count {
+				t.Errorf("%s: %d successful values, want %d", want.name, dp.Count, want.count)
+			}
+		}
+	}
+	if hist := got[fi.MetricDuration].Data.(metricdata.Histogram[float64]); len(hist.DataPoints) != 2 {
+		t.Errorf("%s has %d data points, want ok and failed", fi.MetricDuration, len(hist.DataPoints))
+	}
+}
@@ -0,0 +1,450 @@
+package freeimage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+var ErrOpFailed = errors.New("freeimage: operation failed")
+
+// Op is a single step of a Pipeline.
+//
+// Apply returns the bitmap for the next step. Ops working in place
+// (InPlace returns true) modify dib and return it, all other ops return a
+// new bitmap and leave dib untouched.
+type Op interface {
+	Kind() string
+	InPlace() bool
+	Apply(dib *BitMap) (*BitMap, error)
+}
+
+// StepError reports the pipeline step that failed.
+type StepError struct {
+	Step int
+	Kind string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("freeimage: pipeline step %d (%s): %v", e.Step, e.Kind, e.Err)
+}
+
+func (e *StepError) Unwrap() error { return e.Err }
+
+// Pipeline applies a list of ops to a bitmap, unloading every intermediate
+// bitmap it creates.
+type Pipeline struct {
+	Ops []Op
+}
+
+func NewPipeline(ops ...Op) *Pipeline {
+	return &Pipeline{Ops: ops}
+}
+
+// Then appends op to the pipeline and returns the pipeline.
+func (p *Pipeline) Then(op Op) *Pipeline {
+	p.Ops = append(p.Ops, op)
+	return p
+}
+
+// Apply runs all ops on src. src is never modified nor unloaded, the
+// returned bitmap is always a new one owned by the caller.
+func (p *Pipeline) Apply(src *BitMap) (*BitMap, error) {
+	return p.ApplyContext(context.Background(), src)
+}
+
+// ApplyContext is Apply checking ctx before every step. A native call
+// can't be interrupted, so cancellation takes effect between steps.
+func (p *Pipeline) ApplyContext(ctx context.Context, src *BitMap) (*BitMap, error) {
+	if src == nil {
+		return nil, &StepError{Step: -1, Kind: "source", Err: ErrOpFailed}
+	}
+
+	cur := src
+	for i, op := range p.Ops {
+		if err := ctx.Err(); err != nil {
+			if cur != src {
+				cur.Unload()
+			}
+			return nil, &StepError{Step: i, Kind: op.Kind(), Err: err}
+		}
+		if op.InPlace() && cur == src {
+			if cur = src.Clone(); cur == nil {
+				return nil, &StepError{Step: i, Kind: op.Kind(), Err: ErrOpFailed}
+			}
+		}
+
+		next, err := op.Apply(cur)
+		if err == nil && next == nil {
+			err = ErrOpFailed
+		}
+		if err != nil {
+			if cur != src {
+				cur.Unload()
+			}
+			return nil, &StepError{Step: i, Kind: op.Kind(), Err: err}
+		}
+
+		if next != cur && cur != src {
+			cur.Unload()
+		}
+		cur = next
+	}
+
+	if cur == src {
+		if cur = src.Clone(); cur == nil {
+			return nil, &StepError{Step: len(p.Ops), Kind: "clone", Err: ErrOpFailed}
+		}
+	}
+	return cur, nil
+}
+
+// ApplyFile loads filename, runs the pipeline and unloads the source.
+func (p *Pipeline) ApplyFile(format FREE_IMAGE_FORMAT, filename string, flags int32) (*BitMap, error) {
+	src := Load(format, filename, flags)
+	if src == nil {
+		return nil, &StepError{Step: -1, Kind: "load", Err: fmt.Errorf("%w: %s", ErrOpFailed, filename)}
+	}
+	defer src.Unload()
+	return p.Apply(src)
+}
+
+// pipeline json ------------------------------------------------------------
+
+var (
+	opMu       sync.RWMutex
+	opRegistry = map[string]func() Op{}
+)
+
+// RegisterOp makes an op kind available to Pipeline.UnmarshalJSON. It is
+// safe for concurrent use, also with decoding.
+func RegisterOp(kind string, newOp func() Op) {
+	opMu.Lock()
+	defer opMu.Unlock()
+	opRegistry[kind] = newOp
+}
+
+// OpKinds returns the registered op kinds, sorted.
+func OpKinds() []string {
+	opMu.RLock()
+	defer opMu.RUnlock()
+	kinds := make([]string, 0, len(opRegistry))
+	for k := range opRegistry {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// MarshalJSON encodes the pipeline as an array of objects, each holding the
+// op kind in "op" next to the op parameters.
+//
+// e.g.,
+//
+// [{"op":"rescale","width":300,"height":200,"filter":5},{"op":"convert","to":"24bits"}]
+func (p *Pipeline) MarshalJSON() ([]byte, error) {
+	steps := make([]map[string]interface{}, 0, len(p.Ops))
+	for _, op := range p.Ops {
+		b, err := json.Marshal(op)
+		if err != nil {
+			return nil, err
+		}
+		step := map[string]interface{}{}
+		if err := json.Unmarshal(b, &step); err != nil {
+			return nil, err
+		}
+		step["op"] = op.Kind()
+		steps = append(steps, step)
+	}
+	return json.Marshal(steps)
+}
+
+func (p *Pipeline) UnmarshalJSON(data []byte) error {
+	var steps []json.RawMessage
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return err
+	}
+
+	ops := make([]Op, 0, len(steps))
+	for i, raw := range steps {
+		var head struct {
+			Op string `json:"op"`
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return fmt.Errorf("freeimage: pipeline step %d: %w", i, err)
+		}
+		opMu.RLock()
+		newOp, ok := opRegistry[head.Op]
+		opMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("freeimage: pipeline step %d: unknown op %q", i, head.Op)
+		}
+		op := newOp()
+		if err := json.Unmarshal(raw, op); err != nil {
+			return fmt.Errorf("freeimage: pipeline step %d (%s): %w", i, head.Op, err)
+		}
+		ops = append(ops, op)
+	}
+	p.Ops = ops
+	return nil
+}
+
+// ops ----------------------------------------------------------------------
+
+func init() {
+	RegisterOp("rotate", func() Op { return &RotateOp{} })
+	RegisterOp("rescale", func() Op { return &RescaleOp{Filter: FILTER_CATMULLROM} })
+	RegisterOp("thumbnail", func() Op { return &ThumbnailOp{Convert: true} })
+	RegisterOp("copy", func() Op { return &CopyOp{} })
+	RegisterOp("enlarge_canvas", func() Op { return &EnlargeCanvasOp{} })
+	RegisterOp("flip_horizontal", func() Op { return &FlipHorizontalOp{} })
+	RegisterOp("flip_vertical", func() Op { return &FlipVerticalOp{} })
+	RegisterOp("gamma", func() Op { return &AdjustGammaOp{} })
+	RegisterOp("brightness", func() Op { return &AdjustBrightnessOp{} })
+	RegisterOp("contrast", func() Op { return &AdjustContrastOp{} })
+	RegisterOp("adjust_colors", func() Op { return &AdjustColorsOp{Gamma: 1} })
+	RegisterOp("threshold", func() Op { return &ThresholdOp{} })
+	RegisterOp("dither", func() Op { return &DitherOp{} })
+	RegisterOp("tone_mapping", func() Op { return &ToneMappingOp{} })
+	RegisterOp("composite", func() Op { return &CompositeOp{} })
+	RegisterOp("convert", func() Op { return &ConvertOp{} })
+}
+
+func okOp(dib *BitMap, ok bool) (*BitMap, error) {
+	if !ok {
+		return nil, ErrOpFailed
+	}
+	return dib, nil
+}
+
+// RotateOp wraps BitMap.Rotate, Background may be nil.
+type RotateOp struct {
+	Angle      float64  `json:"angle"`
+	Background *RGBQUAD `json:"background,omitempty"`
+}
+
+func (op *RotateOp) Kind() string  { return "rotate" }
+func (op *RotateOp) InPlace() bool { return false }
+func (op *RotateOp) Apply(dib *BitMap) (*BitMap, error) {
+	return dib.Rotate(op.Angle, unsafe.Pointer(op.Background)), nil
+}
+
+// RescaleOp wraps BitMap.Rescale.
+type RescaleOp struct {
+	Width  int32             `json:"width"`
+	Height int32             `json:"height"`
+	Filter FREE_IMAGE_FILTER `json:"filter"`
+}
+
+func (op *RescaleOp) Kind() string  { return "rescale" }
+func (op *RescaleOp) InPlace() bool { return false }
+func (op *RescaleOp) Apply(dib *BitMap) (*BitMap, error) {
+	if op.Width <= 0 || op.Height <= 0 {
+		return nil, fmt.Errorf("freeimage: invalid rescale size %dx%d", op.Width, op.Height)
+	}
+	return dib.Rescale(op.Width, op.Height, op.Filter), nil
+}
+
+// ThumbnailOp wraps BitMap.MakeThumbnail.
+type ThumbnailOp struct {
+	MaxPixelSize int32 `json:"max_pixel_size"`
+	Convert      bool  `json:"convert"`
+}
+
+func (op *ThumbnailOp) Kind() string  { return "thumbnail" }
+func (op *ThumbnailOp) InPlace() bool { return false }
+func (op *ThumbnailOp) Apply(dib *BitMap) (*BitMap, error) {
+	return dib.MakeThumbnail(op.MaxPixelSize, op.Convert), nil
+}
+
+// CopyOp wraps BitMap.Copy.
+type CopyOp struct {
+	Left   int32 `json:"left"`
+	Top    int32 `json:"top"`
+	Right  int32 `json:"right"`
+	Bottom int32 `json:"bottom"`
+}
+
+func (op *CopyOp) Kind() string  { return "copy" }
+func (op *CopyOp) InPlace() bool { return false }
+func (op *CopyOp) Apply(dib *BitMap) (*BitMap, error) {
+	return dib.Copy(op.Left, op.Top, op.Right, op.Bottom), nil
+}
+
+// EnlargeCanvasOp wraps BitMap.EnlargeCanvas, negative values crop.
+type EnlargeCanvasOp struct {
+	Left    int32   `json:"left"`
+	Top     int32   `json:"top"`
+	Right   int32   `json:"right"`
+	Bottom  int32   `json:"bottom"`
+	Color   RGBQUAD `json:"color"`
+	Options int32   `json:"options"`
+}
+
+func (op *EnlargeCanvasOp) Kind() string  { return "enlarge_canvas" }
+func (op *EnlargeCanvasOp) InPlace() bool { return false }
+func (op *EnlargeCanvasOp) Apply(dib *BitMap) (*BitMap, error) {
+	return dib.EnlargeCanvas(op.Left, op.Top, op.Right, op.Bottom, &op.Color, op.Options), nil
+}
+
+// FlipHorizontalOp wraps BitMap.FlipHorizontal.
+type FlipHorizontalOp struct{}
+
+func (op *FlipHorizontalOp) Kind() string  { return "flip_horizontal" }
+func (op *FlipHorizontalOp) InPlace() bool { return true }
+func (op *FlipHorizontalOp) Apply(dib *BitMap) (*BitMap, error) {
+	return okOp(dib, dib.FlipHorizontal())
+}
+
+// FlipVerticalOp wraps BitMap.FlipVertical.
+type FlipVerticalOp struct{}
+
+func (op *FlipVerticalOp) Kind() string  { return "flip_vertical" }
+func (op *FlipVerticalOp) InPlace() bool { return true }
+func (op *FlipVerticalOp) Apply(dib *BitMap) (*BitMap, error) {
+	return okOp(dib, dib.FlipVertical())
+}
+
+// AdjustGammaOp wraps BitMap.AdjustGamma.
+type AdjustGammaOp struct {
+	Gamma float64 `json:"gamma"`
+}
+
+func (op *AdjustGammaOp) Kind() string  { return "gamma" }
+func (op *AdjustGammaOp) InPlace() bool { return true }
+func (op *AdjustGammaOp) Apply(dib *BitMap) (*BitMap, error) {
+	return okOp(dib, dib.AdjustGamma(op.Gamma))
+}
+
+// AdjustBrightnessOp wraps BitMap.AdjustBrightness, Percentage is in [-100, 100].
+type AdjustBrightnessOp struct {
+	Percentage float64 `json:"percentage"`
+}
+
+func (op *AdjustBrightnessOp) Kind() string  { return "brightness" }
+func (op *AdjustBrightnessOp) InPlace() bool { return true }
+func (op *AdjustBrightnessOp) Apply(dib *BitMap) (*BitMap, error) {
+	return okOp(dib, dib.AdjustBrightness(op.Percentage))
+}
+
+// AdjustContrastOp wraps BitMap.AdjustContrast, Percentage is in [-100, 100].
+type AdjustContrastOp struct {
+	Percentage float64 `json:"percentage"`
+}
+
+func (op *AdjustContrastOp) Kind() string  { return "contrast" }
+func (op *AdjustContrastOp) InPlace() bool { return true }
+func (op *AdjustContrastOp) Apply(dib *BitMap) (*BitMap, error) {
+	return okOp(dib, dib.AdjustContrast(op.Percentage))
+}
+
+// AdjustColorsOp wraps BitMap.AdjustColors.
+type AdjustColorsOp struct {
+	Brightness float64 `json:"brightness"`
+	Contrast   float64 `json:"contrast"`
+	Gamma      float64 `json:"gamma"`
+	Invert     bool    `json:"invert"`
+}
+
+func (op *AdjustColorsOp) Kind() string  { return "adjust_colors" }
+func (op *AdjustColorsOp) InPlace() bool { return true }
+func (op *AdjustColorsOp) Apply(dib *BitMap) (*BitMap, error) {
+	return okOp(dib, dib.AdjustColors(op.Brightness, op.Contrast, op.Gamma, op.Invert))
+}
+
+// ThresholdOp wraps BitMap.Threshold.
+type ThresholdOp struct {
+	T byte `json:"t"`
+}
+
+func (op *ThresholdOp) Kind() string  { return "threshold" }
+func (op *ThresholdOp) InPlace() bool { return false }
+func (op *ThresholdOp) Apply(dib *BitMap) (*BitMap, error) {
+	return dib.Threshold(op.T), nil
+}
+
+// DitherOp wraps BitMap.Dither.
+type DitherOp struct {
+	Algorithm FREE_IMAGE_DITHER `json:"algorithm"`
+}
+
+func (op *DitherOp) Kind() string  { return "dither" }
+func (op *DitherOp) InPlace() bool { return false }
+func (op *DitherOp) Apply(dib *BitMap) (*BitMap, error) {
+	return dib.Dither(op.Algorithm), nil
+}
+
+// ToneMappingOp wraps BitMap.ToneMapping.
+type ToneMappingOp struct {
+	TMO         FREE_IMAGE_TMO `json:"tmo"`
+	FirstParam  float64        `json:"first_param"`
+	SecondParam float64        `json:"second_param"`
+}
+
+func (op *ToneMappingOp) Kind() string  { return "tone_mapping" }
+func (op *ToneMappingOp) InPlace() bool { return false }
+func (op *ToneMappingOp) Apply(dib *BitMap) (*BitMap, error) {
+	return dib.ToneMapping(op.TMO, op.FirstParam, op.SecondParam), nil
+}
+
+// CompositeOp wraps Composite, the bitmap is composited on the file
+// background color, on Background, or on a checkerboard if both are unset.
+type CompositeOp struct {
+	UseFileBkg bool     `json:"use_file_bkg"`
+	Background *RGBQUAD `json:"background,omitempty"`
+}
+
+func (op *CompositeOp) Kind() string  { return "composite" }
+func (op *CompositeOp) InPlace() bool { return false }
+func (op *CompositeOp) Apply(dib *BitMap) (*BitMap, error) {
+	return Composite(dib, op.UseFileBkg, op.Background, nil), nil
+}
+
+// ConvertOp wraps the smart conversion routines. To is one of
+// "4bits", "8bits", "greyscale", "16bits555", "16bits565", "24bits",
+// "32bits", "float", "rgbf", "rgbaf", "uint16", "rgb16", "rgba16" or
+// "standard".
+type ConvertOp struct {
+	To string `json:"to"`
+}
+
+func (op *ConvertOp) Kind() string  { return "convert" }
+func (op *ConvertOp) InPlace() bool { return false }
+func (op *ConvertOp) Apply(dib *BitMap) (*BitMap, error) {
+	switch op.To {
+	case "4bits":
+		return dib.ConvertTo4Bits(), nil
+	case "8bits":
+		return dib.ConvertTo8Bits(), nil
+	case "greyscale":
+		return dib.ConvertToGreyscale(), nil
+	case "16bits555":
+		return dib.ConvertTo16Bits555(), nil
+	case "16bits565":
+		return dib.ConvertTo16Bits565(), nil
+	case "24bits":
+		return dib.ConvertTo24Bits(), nil
+	case "32bits":
+		return dib.ConvertTo32Bits(), nil
+	case "float":
+		return dib.ConvertToFloat(), nil
+	case "rgbf":
+		return dib.ConvertToRGBF(), nil
+	case "rgbaf":
+		return dib.ConvertToRGBAF(), nil
+	case "uint16":
+		return dib.ConvertToUINT16(), nil
+	case "rgb16":
+		return dib.ConvertToRGB16(), nil
+	case "rgba16":
+		return dib.ConvertToRGBA16(), nil
+	case "standard":
+		return dib.ConvertToStandardType(true), nil
+	}
+	return nil, fmt.Errorf("freeimage: unknown conversion %q", op.To)
+}
@@ -0,0 +1,202 @@
+package freeimage_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+// widenOp returns a new bitmap one pixel wider, as the non in-place ops.
+type widenOp struct{}
+
+func (widenOp) Kind() string  { return "widen" }
+func (widenOp) InPlace() bool { return false }
+func (widenOp) Apply(dib *fi.BitMap) (*fi.BitMap, error) {
+	return fi.Allocate(int32(dib.GetWidth())+1, int32(dib.GetHeight()), int32(dib.GetBPP()), 0, 0, 0), nil
+}
+
+// fillOp sets the first byte of the bitmap in place.
+type fillOp struct{ v byte }
+
+func (fillOp) Kind() string  { return "fill" }
+func (fillOp) InPlace() bool { return true }
+func (op fillOp) Apply(dib *fi.BitMap) (*fi.BitMap, error) {
+	*(*byte)(dib.GetBits()) = op.v
+	return dib, nil
+}
+
+var errStep = errors.New("step failed")
+
+type failOp struct{}
+
+func (failOp) Kind() string                         { return "fail" }
+func (failOp) InPlace() bool                        { return false }
+func (failOp) Apply(*fi.BitMap) (*fi.BitMap, error) { return nil, errStep }
+
+type nilOp struct{}
+
+func (nilOp) Kind() string                         { return "nil" }
+func (nilOp) InPlace() bool                        { return false }
+func (nilOp) Apply(*fi.BitMap) (*fi.BitMap, error) { return nil, nil }
+
+func TestPipeline(t *testing.T) {
+	s := fitest.NewStub(t)
+	src := fi.Allocate(4, 3, 8, 0, 0, 0)
+	defer src.Unload()
+
+	for _, tc := range []struct {
+		name  string
+		ops   []fi.Op
+		width uint32
+		first byte
+	}{
+		{"empty", nil, 4, 0},
+		{"in place", []fi.Op{fillOp{7}}, 4, 7},
+		{"new", []fi.Op{widenOp{}, widenOp{}}, 6, 0},
+		{"mixed", []fi.Op{fillOp{1}, widenOp{}, fillOp{2}, fillOp{3}, widenOp{}}, 6, 0},
+		{"in place last", []fi.Op{widenOp{}, fillOp{5}}, 5, 5},
+	} {
+		dib, err := fi.NewPipeline(tc.ops...).Apply(src)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if dib == src {
+			t.Errorf("%s: Apply returned the source", tc.name)
+		}
+		if w, first := dib.GetWidth(), *(*byte)(dib.GetBits()); w != tc.width || first != tc.first {
+			t.Errorf("%s: width %d first byte %d, want %d and %d", tc.name, w, first, tc.width, tc.first)
+		}
+		// every intermediate bitmap is unloaded
+		if n := s.Live(); n != 2 {
+			t.Errorf("%s: %d live bitmaps, want the source and the result", tc.name, n)
+		}
+		dib.Unload()
+	}
+	if v := *(*byte)(src.GetBits()); v != 0 {
+		t.Errorf("the source was modified to %d", v)
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	s := fitest.NewStub(t)
+	src := fi.Allocate(4, 3, 8, 0, 0, 0)
+	defer src.Unload()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		ops  []fi.Op
+		step int
+		kind string
+		err  error
+	}{
+		{"first", context.Background(), []fi.Op{failOp{}, widenOp{}}, 0, "fail", errStep},
+		{"after new", context.Background(), []fi.Op{widenOp{}, fillOp{1}, failOp{}}, 2, "fail", errStep},
+		{"nil result", context.Background(), []fi.Op{fillOp{1}, nilOp{}}, 1, "nil", fi.ErrOpFailed},
+		{"canceled", canceled, []fi.Op{widenOp{}}, 0, "widen", context.Canceled},
+	} {
+		dib, err := fi.NewPipeline(tc.ops...).ApplyContext(tc.ctx, src)
+		var se *fi.StepError
+		if dib != nil || !errors.As(err, &se) {
+			t.Fatalf("%s: got %v, %v, want a StepError", tc.name, dib, err)
+		}
+		if se.Step != tc.step || se.Kind != tc.kind || !errors.Is(err, tc.err) {
+			t.Errorf("%s: step %d (%s): %v, want step %d (%s): %v", tc.name, se.Step, se.Kind, se.Err, tc.step, tc.kind, tc.err)
+		}
+		if n := s.Live(); n != 1 {
+			t.Errorf("%s: %d live bitmaps, want the source only", tc.name, n)
+		}
+	}
+
+	s.Fail("Clone")
+	if _, err := fi.NewPipeline().Apply(src); err == nil {
+		t.Error("Apply succeeded with Clone failing")
+	}
+	var se *fi.StepError
+	if _, err := fi.NewPipeline().Apply(nil); !errors.As(err, &se) || se.Step != -1 {
+		t.Errorf("Apply(nil) = %v", err)
+	}
+}
+
+func TestPipelineJSON(t *testing.T) {
+	preset := `[{"op":"rescale","width":300,"height":200,"filter":5},` +
+		`{"op":"rotate","angle":90,"background":[1,2,3,4]},` +
+		`{"op":"gamma","gamma":1.8},{"op":"convert","to":"24bits"}]`
+	var p fi.Pipeline
+	if err := json.Unmarshal([]byte(preset), &p); err != nil {
+		t.Fatal(err)
+	}
+	want := []fi.Op{
+		&fi.RescaleOp{Width: 300, Height: 200, Filter: fi.FILTER_LANCZOS3},
+		&fi.RotateOp{Angle: 90, Background: &fi.RGBQUAD{1, 2, 3, 4}},
+		&fi.AdjustGammaOp{Gamma: 1.8},
+		&fi.ConvertOp{To: "24bits"},
+	}
+	if !reflect.DeepEqual(p.Ops, want) {
+		t.Fatalf("decoded %#v", p.Ops)
+	}
+
+	b, err := json.Marshal(&p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again fi.Pipeline
+	if err := json.Unmarshal(b, &again); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Ops, want) {
+		t.Errorf("%s decoded to %#v", b, again.Ops)
+	}
+
+	// the defaults of the registry apply to missing parameters
+	if err := json.Unmarshal([]byte(`[{"op":"rescale","width":1,"height":1}]`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if op := p.Ops[0].(*fi.RescaleOp); op.Filter != fi.FILTER_CATMULLROM {
+		t.Errorf("default filter %d", op.Filter)
+	}
+
+	for preset, msg := range map[string]string{
+		`[{"op":"sharpen"}]`:                          "step 0: unknown op",
+		`[{"op":"gamma"},{"op":"gamma","gamma":"x"}]`: "step 1 (gamma)",
+		`{"op":"gamma"}`:                              "cannot unmarshal",
+	} {
+		if err := json.Unmarshal([]byte(preset), &p); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: %v, want %q", preset, err, msg)
+		}
+	}
+}
+
+func TestRegisterOp(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			fi.RegisterOp("widen", func() fi.Op { return widenOp{} })
+		}()
+		go func() {
+			defer wg.Done()
+			var p fi.Pipeline
+			json.Unmarshal([]byte(`[{"op":"convert","to":"8bits"}]`), &p)
+			fi.OpKinds()
+		}()
+	}
+	wg.Wait()
+	found := false
+	for _, k := range fi.OpKinds() {
+		found = found || k == "widen"
+	}
+	if !found {
+		t.Errorf("OpKinds() = %v", fi.OpKinds())
+	}
+}
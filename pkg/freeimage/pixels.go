@@ -0,0 +1,242 @@
+package freeimage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+var ErrPixelFormat = errors.New("freeimage: unsupported pixel format")
+
+// The region functions work in Go on the GetBits buffer, with a handful of
+// library calls per region instead of one per pixel. Coordinates are the
+// ones of GetPixelColor: x from the left, y from the bottom scanline.
+
+// pixelBuffer is the pixel memory of a bitmap.
+type pixelBuffer struct {
+	bits          []byte
+	width, height int
+	pitch, bpp    int
+}
+
+func (dib *BitMap) pixelBuffer() (*pixelBuffer, error) {
+	if dib == nil {
+		return nil, errors.New("freeimage: nil bitmap")
+	}
+	b := &pixelBuffer{
+		width:  int(dib.GetWidth()),
+		height: int(dib.GetHeight()),
+		pitch:  int(dib.GetPitch()),
+		bpp:    int(dib.GetBPP()),
+	}
+	p := dib.GetBits()
+	if p == nil {
+		return nil, errors.New("freeimage: bitmap has no pixels")
+	}
+	b.bits = unsafe.Slice((*byte)(p), b.pitch*b.height)
+	return b, nil
+}
+
+// colorBuffer is pixelBuffer for the RGBQUAD functions, 24 and 32 bit
+// standard bitmaps.
+func (dib *BitMap) colorBuffer() (*pixelBuffer, error) {
+	b, err := dib.pixelBuffer()
+	if err != nil {
+		return nil, err
+	}
+	if (b.bpp != 24 && b.bpp != 32) || dib.GetImageType() != FIT_BITMAP {
+		return nil, fmt.Errorf("%w: %d bit, need a 24 or 32 bit FIT_BITMAP", ErrPixelFormat, b.bpp)
+	}
+	return b, nil
+}
+
+// indexBuffer is pixelBuffer for the palette index functions, 1, 4 and 8
+// bit bitmaps.
+func (dib *BitMap) indexBuffer() (*pixelBuffer, error) {
+	b, err := dib.pixelBuffer()
+	if err != nil {
+		return nil, err
+	}
+	if b.bpp != 1 && b.bpp != 4 && b.bpp != 8 {
+		return nil, fmt.Errorf("%w: %d bit, need a 1, 4 or 8 bit palettized bitmap", ErrPixelFormat, b.bpp)
+	}
+	return b, nil
+}
+
+func (b *pixelBuffer) check(r image.Rectangle, n int) error {
+	if !r.In(image.Rect(0, 0, b.width, b.height)) || r.Empty() {
+		return fmt.Errorf("freeimage: region %v outside the %dx%d bitmap", r, b.width, b.height)
+	}
+	if n >= 0 && n < r.Dx()*r.Dy() {
+		return fmt.Errorf("freeimage: %d values for the %d pixels of region %v", n, r.Dx()*r.Dy(), r)
+	}
+	return nil
+}
+
+func (b *pixelBuffer) row(y int) []byte { return b.bits[y*b.pitch : (y+1)*b.pitch] }
+
+func (b *pixelBuffer) index(row []byte, x int) byte {
+	switch b.bpp {
+	case 1:
+		return row[x>>3] >> (7 - x&7) & 1
+	case 4:
+		return row[x>>1] >> (4 - 4*(x&1)) & 0xf
+	}
+	return row[x]
+}
+
+func (b *pixelBuffer) setIndex(row []byte, x int, v byte) {
+	switch b.bpp {
+	case 1:
+		s := 7 - x&7
+		row[x>>3] = row[x>>3]&^(1<<s) | (v&1)<<s
+	case 4:
+		s := 4 - 4*(x&1)
+		row[x>>1] = row[x>>1]&^(0xf<<s) | (v&0xf)<<s
+	default:
+		row[x] = v
+	}
+}
+
+// Bounds returns the rectangle of the bitmap, (0, 0) to its size.
+func (dib *BitMap) Bounds() image.Rectangle {
+	return image.Rect(0, 0, int(dib.GetWidth()), int(dib.GetHeight()))
+}
+
+// ReadRegion returns the colors of the pixels in r, row by row from
+// r.Min.Y, of a 24 or 32 bit bitmap. Alpha is 0 for 24 bit ones.
+func (dib *BitMap) ReadRegion(r image.Rectangle) ([]RGBQUAD, error) {
+	b, err := dib.colorBuffer()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.check(r, -1); err != nil {
+		return nil, err
+	}
+	out := make([]RGBQUAD, 0, r.Dx()*r.Dy())
+	n := b.bpp / 8
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := b.row(y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			var q RGBQUAD
+			copy(q[:], row[x*n:x*n+n])
+			out = append(out, q)
+		}
+	}
+	return out, nil
+}
+
+// WriteRegion sets the pixels in r of a 24 or 32 bit bitmap from colors,
+// in the order ReadRegion returns them. 24 bit bitmaps ignore the alpha.
+func (dib *BitMap) WriteRegion(r image.Rectangle, colors []RGBQUAD) error {
+	b, err := dib.colorBuffer()
+	if err != nil {
+		return err
+	}
+	if err := b.check(r, len(colors)); err != nil {
+		return err
+	}
+	n, i := b.bpp/8, 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := b.row(y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			copy(row[x*n:x*n+n], colors[i][:n])
+			i++
+		}
+	}
+	return nil
+}
+
+// ReadIndexRegion returns the palette indices of the pixels in r, row by
+// row from r.Min.Y, of a 1, 4 or 8 bit bitmap.
+func (dib *BitMap) ReadIndexRegion(r image.Rectangle) ([]byte, error) {
+	b, err := dib.indexBuffer()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.check(r, -1); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := b.row(y)
+		if b.bpp == 8 {
+			out = append(out, row[r.Min.X:r.Max.X]...)
+			continue
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			out = append(out, b.index(row, x))
+		}
+	}
+	return out, nil
+}
+
+// WriteIndexRegion sets the palette indices of the pixels in r of a 1, 4
+// or 8 bit bitmap, in the order ReadIndexRegion returns them. Indices are
+// truncated to the bit depth.
+func (dib *BitMap) WriteIndexRegion(r image.Rectangle, indices []byte) error {
+	b, err := dib.indexBuffer()
+	if err != nil {
+		return err
+	}
+	if err := b.check(r, len(indices)); err != nil {
+		return err
+	}
+	w := r.Dx()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row, line := b.row(y), indices[(y-r.Min.Y)*w:]
+		if b.bpp == 8 {
+			copy(row[r.Min.X:r.Max.X], line[:w])
+			continue
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			b.setIndex(row, x, line[x-r.Min.X])
+		}
+	}
+	return nil
+}
+
+// FillRect sets the pixels in r of a 24 or 32 bit bitmap to color.
+func (dib *BitMap) FillRect(r image.Rectangle, color RGBQUAD) error {
+	b, err := dib.colorBuffer()
+	if err != nil {
+		return err
+	}
+	if err := b.check(r, -1); err != nil {
+		return err
+	}
+	n := b.bpp / 8
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		// fill the first pixel, then double the filled part
+		span := b.row(y)[r.Min.X*n : r.Max.X*n]
+		copy(span, color[:n])
+		for k := n; k < len(span); k *= 2 {
+			copy(span[k:], span[:k])
+		}
+	}
+	return nil
+}
+
+// ForEachPixel calls fn for every pixel, row by row from the bottom one,
+// with its bytes in the bitmap: px aliases the pixel memory, so changes
+// write through, and is only valid during the call. Any image type with
+// whole byte pixels works, e.g. B, G, R and A bytes for a 32 bit FIT_BITMAP
+// or four float32 for FIT_RGBAF.
+func (dib *BitMap) ForEachPixel(fn func(x, y int, px []byte)) error {
+	b, err := dib.pixelBuffer()
+	if err != nil {
+		return err
+	}
+	if b.bpp < 8 || b.bpp%8 != 0 {
+		return fmt.Errorf("%w: %d bit pixels are not whole bytes", ErrPixelFormat, b.bpp)
+	}
+	n := b.bpp / 8
+	for y := 0; y < b.height; y++ {
+		row := b.row(y)
+		for x := 0; x < b.width; x++ {
+			fn(x, y, row[x*n:x*n+n:x*n+n])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package freeimage
+
+import "testing"
+
+func TestIndexPacking(t *testing.T) {
+	for _, tc := range []struct {
+		bpp  int
+		want []byte
+	}{
+		{1, []byte{0x42, 0x80}}, // 0100 0010 1
+		{4, []byte{0x01, 0x20, 0x00, 0x10, 0x10}},
+	} {
+		b := &pixelBuffer{bpp: tc.bpp}
+		row := make([]byte, len(tc.want))
+		for _, x := range []int{1, 3, 6, 8} {
+			b.setIndex(row, x, 1)
+		}
+		b.setIndex(row, 3, 0)
+		b.setIndex(row, 2, 2) // truncated to 0 at 1 bit
+		if string(row) != string(tc.want) {
+			t.Errorf("%d bit: row %x, want %x", tc.bpp, row, tc.want)
+		}
+		for x, want := range []byte{0, 1, 2, 0, 0, 0, 1, 0, 1} {
+			if tc.bpp == 1 {
+				want &= 1
+			}
+			if got := b.index(row, x); got != want {
+				t.Errorf("%d bit: index %d = %d, want %d", tc.bpp, x, got, want)
+			}
+		}
+	}
+}
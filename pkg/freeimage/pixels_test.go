@@ -0,0 +1,121 @@
+package freeimage_test
+
+import (
+	"errors"
+	"image"
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func TestRegion(t *testing.T) {
+	fitest.Auto(t)
+	for _, bpp := range []int32{24, 32} {
+		dib := fi.Allocate(7, 5, bpp, 0, 0, 0)
+		if dib == nil {
+			t.Fatalf("Allocate %d bit returned nil", bpp)
+		}
+		defer dib.Unload()
+
+		r := image.Rect(2, 1, 6, 4)
+		colors := make([]fi.RGBQUAD, r.Dx()*r.Dy())
+		for i := range colors {
+			colors[i] = fi.RGBQUAD{byte(i), byte(2 * i), byte(3 * i), 255}
+		}
+		if err := dib.WriteRegion(r, colors); err != nil {
+			t.Fatal(err)
+		}
+		// the region functions agree with the per pixel ones
+		c, _ := dib.GetPixelColor(3, 2) // row 1 of the region, column 1
+		if want := colors[1*r.Dx()+1]; c[0] != want[0] || c[1] != want[1] || c[2] != want[2] {
+			t.Errorf("%d bit: GetPixelColor(3, 2) = %v, want %v", bpp, c, want)
+		}
+		got, err := dib.ReadRegion(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range got {
+			want := colors[i]
+			if bpp == 24 {
+				want[3] = 0
+			}
+			if got[i] != want {
+				t.Fatalf("%d bit: pixel %d = %v, want %v", bpp, i, got[i], want)
+			}
+		}
+
+		fill := fi.RGBQUAD{9, 8, 7, 6}
+		if err := dib.FillRect(dib.Bounds(), fill); err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		err = dib.ForEachPixel(func(x, y int, px []byte) {
+			if len(px) != int(bpp/8) || px[0] != 9 || px[1] != 8 || px[2] != 7 {
+				t.Fatalf("%d bit: pixel %d,%d = %v after FillRect", bpp, x, y, px)
+			}
+			px[0] = byte(x)
+			n++
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 35 {
+			t.Errorf("%d bit: ForEachPixel visited %d pixels", bpp, n)
+		}
+		if c, _ := dib.GetPixelColor(6, 4); c[0] != 6 {
+			t.Errorf("%d bit: ForEachPixel did not write through, blue %d", bpp, c[0])
+		}
+	}
+}
+
+func TestIndexRegion(t *testing.T) {
+	fitest.Auto(t)
+	for _, bpp := range []int32{1, 4, 8} {
+		dib := fi.Allocate(13, 3, bpp, 0, 0, 0)
+		if dib == nil {
+			t.Fatalf("Allocate %d bit returned nil", bpp)
+		}
+		defer dib.Unload()
+
+		r := image.Rect(3, 0, 12, 3)
+		in := make([]byte, r.Dx()*r.Dy())
+		for i := range in {
+			in[i] = byte(i*7) & (1<<bpp - 1)
+		}
+		if err := dib.WriteIndexRegion(r, in); err != nil {
+			t.Fatal(err)
+		}
+		var v byte
+		dib.GetPixelIndex(4, 1, &v)
+		if want := in[r.Dx()+1]; v != want {
+			t.Errorf("%d bit: GetPixelIndex(4, 1) = %d, want %d", bpp, v, want)
+		}
+		dib.GetPixelIndex(2, 1, &v)
+		if v != 0 {
+			t.Errorf("%d bit: pixel left of the region changed to %d", bpp, v)
+		}
+		out, err := dib.ReadIndexRegion(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != string(in) {
+			t.Errorf("%d bit: ReadIndexRegion = %v, want %v", bpp, out, in)
+		}
+	}
+}
+
+func TestRegionErrors(t *testing.T) {
+	fitest.Auto(t)
+	dib := fi.Allocate(4, 4, 8, 0, 0, 0)
+	defer dib.Unload()
+	if _, err := dib.ReadRegion(dib.Bounds()); !errors.Is(err, fi.ErrPixelFormat) {
+		t.Errorf("ReadRegion of 8 bit = %v, want ErrPixelFormat", err)
+	}
+	if _, err := dib.ReadIndexRegion(image.Rect(2, 2, 5, 3)); err == nil {
+		t.Error("ReadIndexRegion outside the bitmap succeeded")
+	}
+	if err := dib.WriteIndexRegion(image.Rect(0, 0, 2, 2), []byte{1, 2, 3}); err == nil {
+		t.Error("WriteIndexRegion with too few indices succeeded")
+	}
+}
@@ -0,0 +1,411 @@
+package freeimage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/jinzhongmin/goffi/pkg/c"
+)
+
+// Plugin is an image format implemented in Go, see RegisterPlugin.
+//
+// The readers and writers wrap the FreeImageIO of the call, they read and
+// write the file or memory stream FreeImage was given, from the position
+// the image starts at.
+type Plugin interface {
+	// Format is the short name of the format, e.g. "QOI", used by
+	// GetFIFFromFormat.
+	Format() string
+	Description() string
+	// Extensions lists the file extensions without the dot, the first is
+	// the default one.
+	Extensions() []string
+	MIME() string
+
+	// Validate reports whether r holds an image of the format, for
+	// GetFileType. FreeImage rewinds r afterwards.
+	Validate(r io.ReadSeeker) bool
+	// Load decodes page of the image in r, page is -1 outside of the
+	// multipage functions. The bitmap is allocated with the package
+	// functions and returned to the caller of FreeImage.
+	Load(r io.ReadSeeker, page int, flags int32) (*BitMap, error)
+	// Save encodes dib to w, page is -1 outside of the multipage
+	// functions.
+	Save(w io.WriteSeeker, dib *BitMap, page int, flags int32) error
+	// PageCount returns the number of pages of the image in r.
+	PageCount(r io.ReadSeeker) int
+}
+
+// PluginExporter is implemented by plugins saving other bitmaps than 24
+// and 32 bit FIT_BITMAP ones, the default.
+type PluginExporter interface {
+	SupportsExportBPP(bpp int) bool
+	SupportsExportType(t FREE_IMAGE_TYPE) bool
+}
+
+// PluginNoPixels is implemented by plugins whose Load returns a header only
+// bitmap, see AllocateHeader, when flags has FIF_LOAD_NOPIXELS.
+type PluginNoPixels interface {
+	SupportsNoPixels() bool
+}
+
+// Parameters of the FI_*Proc callback types of FreeImage.h
+var (
+	_proc_FI_InitProc_      = []c.Type{c.Pointer, c.I32}
+	_proc_FI_LoadProc_      = []c.Type{c.Pointer, c.Pointer, c.I32, c.I32, c.Pointer}
+	_proc_FI_SaveProc_      = []c.Type{c.Pointer, c.Pointer, c.Pointer, c.I32, c.I32, c.Pointer}
+	_proc_FI_ValidateProc_  = []c.Type{c.Pointer, c.Pointer}
+	_proc_FI_PageCountProc_ = []c.Type{c.Pointer, c.Pointer, c.Pointer}
+	_proc_FI_SupportsProc_  = []c.Type{c.I32}
+	_proc_FI_ReadWriteProc_ = []c.Type{c.Pointer, c.U32, c.U32, c.Pointer}
+	_proc_FI_SeekProc_      = []c.Type{c.Pointer, cLongType, c.I32}
+	_proc_FI_TellProc_      = []c.Type{c.Pointer}
+	_proc_FI_StringProc_    = []c.Type{c.Pointer} // (void), see str
+	_proc_FI_BoolProc_      = []c.Type{c.Pointer} // (void), see str
+)
+
+var (
+	registeredPluginsMu sync.Mutex
+	registeredPlugins   []*goPlugin
+
+	// FreeImageIO procs by function pointer
+	ioProcsMu sync.Mutex
+	ioProcs   = map[unsafe.Pointer]*c.FuncPrototype{}
+)
+
+// FI_STRUCT (Plugin), the procs in order
+const (
+	procFormat = iota
+	procDescription
+	procExtension
+	procRegExpr
+	procOpen
+	procClose
+	procPageCount
+	procPageCapability
+	procLoad
+	procSave
+	procValidate
+	procMime
+	procSupportsExportBPP
+	procSupportsExportType
+	procSupportsICCProfiles
+	procSupportsNoPixels
+	procCount
+)
+
+// goPlugin is a registered Plugin. Its callbacks and strings live as long
+// as the process, FreeImage can't unregister plugins.
+type goPlugin struct {
+	p     Plugin
+	procs [procCount]unsafe.Pointer
+
+	// the callbacks keep their converters in C memory, these references
+	// keep them from the garbage collector
+	cbs  []*c.Callback
+	cvts []func(*c.Callback, []*c.Value, *c.Value)
+}
+
+// RegisterPlugin registers p with FreeImage_RegisterLocalPlugin in the
+// default library and returns its format. Load, Save, GetFileType, the
+// FIF queries and the multipage functions then handle the format like the
+// built in ones.
+//
+// Register plugins at start up, after Initialise: they can't be removed
+// and each costs a few libffi closures. Errors and panics of the plugin
+// make the FreeImage call fail, e.g. Load returns nil.
+func RegisterPlugin(p Plugin) (FREE_IMAGE_FORMAT, error) {
+	name := p.Format()
+	if name == "" {
+		return FIF_UNKNOWN, errors.New("freeimage: plugin without a format name")
+	}
+	gp := &goPlugin{p: p}
+	gp.procs[procMime] = gp.str(p.MIME())
+	gp.procs[procLoad] = gp.callback(c.Pointer, _proc_FI_LoadProc_, gp.load)
+	gp.procs[procSave] = gp.callback(c.I32, _proc_FI_SaveProc_, gp.save)
+	gp.procs[procValidate] = gp.callback(c.I32, _proc_FI_ValidateProc_, gp.validate)
+	gp.procs[procPageCount] = gp.callback(c.I32, _proc_FI_PageCountProc_, gp.pageCount)
+	gp.procs[procSupportsExportBPP] = gp.callback(c.I32, _proc_FI_SupportsProc_, gp.supportsBPP)
+	gp.procs[procSupportsExportType] = gp.callback(c.I32, _proc_FI_SupportsProc_, gp.supportsType)
+	if np, ok := p.(PluginNoPixels); ok && np.SupportsNoPixels() {
+		gp.procs[procSupportsNoPixels] = gp.callback(c.I32, _proc_FI_BoolProc_, func([]*c.Value) uintptr { return 1 })
+	}
+	initProc := gp.callback(c.Void, _proc_FI_InitProc_, gp.init)
+
+	registeredPluginsMu.Lock()
+	registeredPlugins = append(registeredPlugins, gp)
+	registeredPluginsMu.Unlock()
+
+	// the names go to FreeImage as strings, it prefers them to the procs
+	fif := RegisterLocalPlugin(initProc, name, p.Description(), strings.Join(p.Extensions(), ","), "")
+	if fif == FIF_UNKNOWN {
+		return FIF_UNKNOWN, fmt.Errorf("freeimage: registering plugin %s failed", name)
+	}
+	return fif, nil
+}
+
+// str returns a string proc returning s. goffi can't make closures without
+// parameters, the proc takes one it ignores and FreeImage doesn't pass,
+// harmless with the C calling convention.
+func (gp *goPlugin) str(s string) unsafe.Pointer {
+	if s == "" {
+		return nil
+	}
+	cs := c.CStr(s)
+	return gp.callback(c.Pointer, _proc_FI_StringProc_, func([]*c.Value) uintptr { return uintptr(cs) })
+}
+
+// callback returns the C function pointer of a libffi closure calling fn,
+// fn's result is stored as an ffi_arg. Panics return 0.
+func (gp *goPlugin) callback(out c.Type, in []c.Type, fn func(args []*c.Value) uintptr) unsafe.Pointer {
+	cb := c.NewCallback(c.AbiDefault, out, in)
+	cvt := func(_ *c.Callback, args []*c.Value, ret *c.Value) {
+		var r uintptr
+		defer func() {
+			recover()
+			if out != c.Void {
+				*(*uintptr)(unsafe.Pointer(ret)) = r
+			}
+		}()
+		r = fn(args)
+	}
+	cb.CallbackCvt = cvt
+	gp.cbs = append(gp.cbs, cb)
+	gp.cvts = append(gp.cvts, cvt)
+	return cb.FuncPtr()
+}
+
+func cbool(b bool) uintptr {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// handle returns the reader and writer of the FreeImageIO and fi_handle
+// arguments.
+func handle(fio, h *c.Value) *handleIO {
+	return &handleIO{io: (*freeImageIO)(fio.Ptr()), handle: h.Ptr()}
+}
+
+// FI_InitProc, fills the Plugin struct.
+func (gp *goPlugin) init(args []*c.Value) uintptr {
+	*(*[procCount]unsafe.Pointer)(args[0].Ptr()) = gp.procs
+	return 0
+}
+
+// FI_LoadProc(FreeImageIO *io, fi_handle handle, int page, int flags, void *data)
+func (gp *goPlugin) load(args []*c.Value) uintptr {
+	dib, err := gp.p.Load(handle(args[0], args[1]), int(args[2].I32()), args[3].I32())
+	if err != nil {
+		if dib != nil {
+			dib.Unload()
+		}
+		return 0
+	}
+	return uintptr(unsafe.Pointer(dib))
+}
+
+// FI_SaveProc(FreeImageIO *io, FIBITMAP *dib, fi_handle handle, int page, int flags, void *data)
+func (gp *goPlugin) save(args []*c.Value) uintptr {
+	dib := (*BitMap)(args[1].Ptr())
+	if dib == nil {
+		return 0
+	}
+	err := gp.p.Save(handle(args[0], args[2]), dib, int(args[3].I32()), args[4].I32())
+	return cbool(err == nil)
+}
+
+// FI_ValidateProc(FreeImageIO *io, fi_handle handle)
+func (gp *goPlugin) validate(args []*c.Value) uintptr {
+	return cbool(gp.p.Validate(handle(args[0], args[1])))
+}
+
+// FI_PageCountProc(FreeImageIO *io, fi_handle handle, void *data)
+func (gp *goPlugin) pageCount(args []*c.Value) uintptr {
+	return uintptr(uint32(int32(gp.p.PageCount(handle(args[0], args[1])))))
+}
+
+// FI_SupportsExportBPPProc(int bpp)
+func (gp *goPlugin) supportsBPP(args []*c.Value) uintptr {
+	bpp := int(args[0].I32())
+	if e, ok := gp.p.(PluginExporter); ok {
+		return cbool(e.SupportsExportBPP(bpp))
+	}
+	return cbool(bpp == 24 || bpp == 32)
+}
+
+// FI_SupportsExportTypeProc(FREE_IMAGE_TYPE type)
+func (gp *goPlugin) supportsType(args []*c.Value) uintptr {
+	t := FREE_IMAGE_TYPE(args[0].I32())
+	if e, ok := gp.p.(PluginExporter); ok {
+		return cbool(e.SupportsExportType(t))
+	}
+	return cbool(t == FIT_BITMAP)
+}
+
+// FreeImageIO ----------------------------------------------------------------
+
+// freeImageIO is FI_STRUCT (FreeImageIO).
+type freeImageIO struct {
+	read, write, seek, tell unsafe.Pointer
+}
+
+// handleIO reads, writes and seeks a fi_handle with its FreeImageIO.
+type handleIO struct {
+	io     *freeImageIO
+	handle unsafe.Pointer
+}
+
+// ioProc returns the prototype calling the FreeImageIO proc fn.
+func ioProc(fn unsafe.Pointer, out c.Type, in []c.Type) *c.FuncPrototype {
+	ioProcsMu.Lock()
+	defer ioProcsMu.Unlock()
+	p := ioProcs[fn]
+	if p == nil {
+		p = &c.FuncPrototype{Name: "FreeImageIO", OutType: out, InTypes: in, Ptr: fn}
+		if err := p.Create(nil); err != nil {
+			panic(fmt.Errorf("freeimage: FreeImageIO: %w", err))
+		}
+		ioProcs[fn] = p
+	}
+	return p
+}
+
+func (h *handleIO) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf, size, count := unsafe.Pointer(&p[0]), uint32(1), uint32(len(p))
+	n := ioProc(h.io.read, c.U32, _proc_FI_ReadWriteProc_).Call(inArgs{&buf, &size, &count, &h.handle}).U32Free()
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return int(n), nil
+}
+
+func (h *handleIO) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf, size, count := unsafe.Pointer(&p[0]), uint32(1), uint32(len(p))
+	n := ioProc(h.io.write, c.U32, _proc_FI_ReadWriteProc_).Call(inArgs{&buf, &size, &count, &h.handle}).U32Free()
+	if int(n) < len(p) {
+		return int(n), io.ErrShortWrite
+	}
+	return int(n), nil
+}
+
+func (h *handleIO) Seek(offset int64, whence int) (int64, error) {
+	if int64(cLong(offset)) != offset {
+		return 0, fmt.Errorf("freeimage: seek offset %d overflows C long", offset)
+	}
+	o, w := cLong(offset), int32(whence)
+	if ioProc(h.io.seek, c.I32, _proc_FI_SeekProc_).Call(inArgs{&h.handle, &o, &w}).I32Free() != 0 {
+		return 0, fmt.Errorf("freeimage: seek to %d from %d failed", offset, whence)
+	}
+	v := ioProc(h.io.tell, cLongType, _proc_FI_TellProc_).Call(inArgs{&h.handle})
+	if cLongType == c.I32 {
+		return int64(v.I32Free()), nil
+	}
+	return v.I64Free(), nil
+}
+
+// External plugins -----------------------------------------------------------
+
+// ExternalPlugin is a plugin library of WithPluginDir.
+type ExternalPlugin struct {
+	Path string
+	// Format is the FREE_IMAGE_FORMAT FreeImage assigned, FIF_UNKNOWN if
+	// registering failed.
+	Format FREE_IMAGE_FORMAT
+	Err    error
+}
+
+// pluginExtensions are the file extensions scanPluginDir picks up.
+var pluginExtensions = map[string]bool{".so": true, ".dylib": true, ".dll": true, ".fip": true}
+
+// scanPluginDir lists the plugin libraries in dir, sorted.
+func scanPluginDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("freeimage: plugin dir: %w", err)
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && pluginExtensions[strings.ToLower(filepath.Ext(e.Name()))] {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// ExternalPlugins returns the plugins of WithPluginDir registered by
+// Initialise, nil before Initialise and after the last DeInitialise.
+func (l *Library) ExternalPlugins() []ExternalPlugin {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]ExternalPlugin(nil), l.plugins...)
+}
+
+func (l *Library) registerPluginDir() {
+	if len(l.pluginPaths) == 0 {
+		return
+	}
+	plugins := make([]ExternalPlugin, 0, len(l.pluginPaths))
+	for _, path := range l.pluginPaths {
+		fif, err := l.registerExternal(path)
+		plugins = append(plugins, ExternalPlugin{Path: path, Format: fif, Err: err})
+	}
+	l.mu.Lock()
+	l.plugins = plugins
+	l.mu.Unlock()
+}
+
+// registerExternal registers the plugin library at path with
+// FreeImage_RegisterExternalPlugin, or where FreeImage doesn't implement
+// it, outside of Windows, loads it and registers its Init with
+// FreeImage_RegisterLocalPlugin as FreeImage does on Windows.
+func (l *Library) registerExternal(path string) (FREE_IMAGE_FORMAT, error) {
+	p := c.CStr(path)
+	defer c.Free(p)
+	var null unsafe.Pointer
+	fif := FREE_IMAGE_FORMAT(l.call(_func_FreeImage_RegisterExternalPlugin_, inArgs{&p, &null, &null, &null, &null}).I32Free())
+	if fif != FIF_UNKNOWN {
+		return fif, nil
+	}
+
+	lib, err := c.NewLib(path, c.ModeNow)
+	if err != nil {
+		return FIF_UNKNOWN, err
+	}
+	initProc, err := symbol(lib, "Init")
+	if err != nil {
+		lib.UnLoad()
+		return FIF_UNKNOWN, err
+	}
+	l.mu.Lock()
+	l.pluginLibs = append(l.pluginLibs, lib)
+	l.mu.Unlock()
+	fif = FREE_IMAGE_FORMAT(l.call(_func_FreeImage_RegisterLocalPlugin_, inArgs{&initProc, &null, &null, &null, &null}).I32Free())
+	if fif == FIF_UNKNOWN {
+		return FIF_UNKNOWN, fmt.Errorf("freeimage: FreeImage rejected the plugin %s", path)
+	}
+	return fif, nil
+}
+
+// symbol is lib.Symbol returning an error instead of panicking.
+func symbol(lib *c.Lib, name string) (p unsafe.Pointer, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("freeimage: %v", r)
+		}
+	}()
+	return lib.Symbol(name), nil
+}
@@ -0,0 +1,82 @@
+package freeimage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func TestPluginDir(t *testing.T) {
+	src, err := os.ReadFile("testdata/sensor_plugin.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	so := fitest.Compile(t, "sensor_plugin", src)
+	dir := t.TempDir()
+	data, err := os.ReadFile(so)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{
+		"sensor.so":  data,
+		"broken.so":  []byte("not a library"),
+		"README.txt": []byte("ignored"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := fitest.NewStub(t, fi.WithPluginDir(dir))
+	plugins := s.Library.ExternalPlugins()
+	if len(plugins) != 2 {
+		t.Fatalf("ExternalPlugins() = %+v, want broken.so and sensor.so", plugins)
+	}
+	if p := plugins[0]; p.Format != fi.FIF_UNKNOWN || p.Err == nil {
+		t.Errorf("broken.so registered: %+v", p)
+	}
+	fif := plugins[1].Format
+	if plugins[1].Err != nil || fif == fi.FIF_UNKNOWN {
+		t.Fatalf("sensor.so: %+v", plugins[1])
+	}
+
+	if got := fi.GetFIFFromFormat("SENSOR"); got != fif {
+		t.Errorf("GetFIFFromFormat = %d, want %d", got, fif)
+	}
+	if got := fi.GetFIFMimeType(fif); got != "image/x-sensor" {
+		t.Errorf("GetFIFMimeType = %q", got)
+	}
+	name := filepath.Join(t.TempDir(), "frame.snsr")
+	if err := os.WriteFile(name, []byte("SNSR...."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.GetFileType(name, 0); got != fif {
+		t.Errorf("GetFileType = %d, want %d", got, fif)
+	}
+
+	if was := fi.SetPluginEnabled(fif, false); was != 1 {
+		t.Errorf("SetPluginEnabled returned %d, want 1", was)
+	}
+	if fi.IsPluginEnabled(fif) != 0 {
+		t.Error("plugin still enabled")
+	}
+	if got := fi.GetFileType(name, 0); got != fi.FIF_UNKNOWN {
+		t.Errorf("GetFileType with the plugin disabled = %d", got)
+	}
+	fi.SetPluginEnabled(fif, true)
+	if fi.IsPluginEnabled(fif+1) != -1 {
+		t.Error("IsPluginEnabled of an unknown format is not -1")
+	}
+
+	if _, err := fi.Open(s.Library.Path(), fi.WithPluginDir(filepath.Join(dir, "missing"))); err == nil {
+		t.Error("Open with a missing plugin dir succeeded")
+	}
+
+	s.Library.DeInitialise()
+	if p := s.Library.ExternalPlugins(); p != nil {
+		t.Errorf("ExternalPlugins() after DeInitialise = %+v", p)
+	}
+}
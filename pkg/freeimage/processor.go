@@ -0,0 +1,381 @@
+package freeimage
+
+import (
+	"context"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// bitmapOverhead approximates the FreeImage header, info header and a full
+// palette allocated next to the pixels.
+const bitmapOverhead = 1024 + 256*4
+
+// EstimateSize returns the native memory FreeImage allocates for a
+// width x height bitmap with bpp bits per pixel, see GetMemorySize.
+func EstimateSize(width, height int32, bpp uint32) int64 {
+	if width <= 0 || height <= 0 {
+		return bitmapOverhead
+	}
+	return estimateSize(uint32(width), uint32(height), bpp)
+}
+
+// estimateSize is EstimateSize for the unsigned sizes of GetWidth and
+// GetHeight, saturating instead of overflowing.
+func estimateSize(width, height, bpp uint32) int64 {
+	pitch := (uint64(width)*uint64(bpp) + 31) / 32 * 4
+	return mulSat(pitch, uint64(height)) + bitmapOverhead
+}
+
+// ProcessorStats is a snapshot of a Processor.
+type ProcessorStats struct {
+	Workers       int
+	Queued        int64 // calls waiting for a worker or for budget
+	Running       int64
+	BytesInFlight int64 // native bytes reserved by running calls and held by results
+	Held          int   // results not released with Unload
+	Budget        int64
+	Completed     int64
+	Failed        int64
+}
+
+// Processor runs FreeImage work on a bounded number of workers within a
+// native memory budget.
+//
+// FreeImage allocates pixels in C, out of sight of the Go GC, so every call
+// reserves its estimated native memory first. Calls wait while the budget
+// is exhausted; a call larger than the whole budget runs alone.
+//
+// The bitmaps returned by Load, LoadFromMemory and Apply keep their
+// GetMemorySize charged to the budget until they are released with Unload.
+type Processor struct {
+	workers chan struct{}
+	budget  int64
+
+	mu       sync.Mutex
+	inFlight int64
+	held     map[*BitMap]int64
+	freed    chan struct{} // closed and replaced whenever budget is released
+
+	queued    atomic.Int64
+	running   atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+}
+
+// NewProcessor returns a processor with workers workers, NumCPU if <= 0,
+// and a native memory budget in bytes, unlimited if <= 0.
+func NewProcessor(workers int, budget int64) *Processor {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if budget < 0 {
+		budget = 0
+	}
+	return &Processor{
+		workers: make(chan struct{}, workers),
+		budget:  budget,
+		held:    make(map[*BitMap]int64),
+		freed:   make(chan struct{}),
+	}
+}
+
+func (p *Processor) Stats() ProcessorStats {
+	p.mu.Lock()
+	inFlight, held := p.inFlight, len(p.held)
+	p.mu.Unlock()
+	return ProcessorStats{
+		Workers:       cap(p.workers),
+		Queued:        p.queued.Load(),
+		Running:       p.running.Load(),
+		BytesInFlight: inFlight,
+		Held:          held,
+		Budget:        p.budget,
+		Completed:     p.completed.Load(),
+		Failed:        p.failed.Load(),
+	}
+}
+
+func (p *Processor) reserve(ctx context.Context, cost int64) (int64, error) {
+	if p.budget > 0 && cost > p.budget {
+		cost = p.budget
+	}
+	for {
+		p.mu.Lock()
+		if p.budget == 0 || p.inFlight+cost <= p.budget {
+			p.inFlight += cost
+			p.mu.Unlock()
+			return cost, nil
+		}
+		freed := p.freed
+		p.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// settle replaces the reservation of a finished call with the actual size
+// of its result dib, held until Unload. A nil dib releases it all.
+func (p *Processor) settle(cost int64, dib *BitMap) {
+	var size int64
+	if dib != nil {
+		size = int64(dib.GetMemorySize())
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight += size - cost
+	if dib != nil {
+		p.held[dib] += size
+	}
+	if size < cost {
+		close(p.freed)
+		p.freed = make(chan struct{})
+	}
+}
+
+// Unload unloads dib and returns its charge to the budget. dib must be a
+// bitmap returned by the processor, other bitmaps are only unloaded.
+func (p *Processor) Unload(dib *BitMap) {
+	if dib == nil {
+		return
+	}
+	p.mu.Lock()
+	size, ok := p.held[dib]
+	delete(p.held, dib)
+	p.mu.Unlock()
+	dib.Unload()
+	if ok {
+		p.settle(size, nil)
+	}
+}
+
+// Do runs fn on a worker once cost bytes of the budget are available. It
+// returns ctx.Err() if ctx is done before fn starts. The reservation is
+// released when fn returns.
+func (p *Processor) Do(ctx context.Context, cost int64, fn func(ctx context.Context) error) error {
+	_, err := p.do(ctx, cost, func(ctx context.Context) (*BitMap, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// acquire reserves cost bytes of the budget and a worker. The returned
+// function releases the worker and settles the reservation with dib.
+func (p *Processor) acquire(ctx context.Context, cost int64) (release func(dib *BitMap), err error) {
+	p.queued.Add(1)
+	defer p.queued.Add(-1)
+	if cost, err = p.reserve(ctx, cost); err != nil {
+		return nil, err
+	}
+	select {
+	case p.workers <- struct{}{}:
+	case <-ctx.Done():
+		p.settle(cost, nil)
+		return nil, ctx.Err()
+	}
+	if err := ctx.Err(); err != nil {
+		<-p.workers
+		p.settle(cost, nil)
+		return nil, err
+	}
+	return func(dib *BitMap) {
+		<-p.workers
+		p.settle(cost, dib)
+	}, nil
+}
+
+// do is Do for a call returning a bitmap, which stays charged on success.
+func (p *Processor) do(ctx context.Context, cost int64, fn func(ctx context.Context) (*BitMap, error)) (*BitMap, error) {
+	release, err := p.acquire(ctx, cost)
+	if err != nil {
+		return nil, err
+	}
+	var dib *BitMap
+	defer func() { release(dib) }()
+
+	p.running.Add(1)
+	defer p.running.Add(-1)
+	out, err := fn(ctx)
+	if err != nil {
+		p.failed.Add(1)
+		return nil, err
+	}
+	p.completed.Add(1)
+	dib = out
+	return dib, nil
+}
+
+// fallbackCost is reserved when the size of an image can't be probed: an
+// equal share of the budget.
+func (p *Processor) fallbackCost() int64 {
+	return p.budget / int64(cap(p.workers))
+}
+
+// probeCost loads the header of the image with FIF_LOAD_NOPIXELS on a
+// worker, charged as a header, to estimate the size of the decoded bitmap.
+func (p *Processor) probeCost(ctx context.Context, fif FREE_IMAGE_FORMAT, load func(flags int32) *BitMap) (int64, bool, error) {
+	if !FIFSupportsNoPixels(fif) {
+		return 0, false, nil
+	}
+	release, err := p.acquire(ctx, bitmapOverhead)
+	if err != nil {
+		return 0, false, err
+	}
+	defer release(nil)
+	hdr := load(FIF_LOAD_NOPIXELS)
+	if hdr == nil {
+		return 0, false, nil
+	}
+	defer hdr.Unload()
+	return estimateSize(hdr.GetWidth(), hdr.GetHeight(), hdr.GetBPP()), true, nil
+}
+
+// Load loads filename on a worker, see Load. FIF_UNKNOWN detects the format.
+// Release the bitmap with Unload.
+func (p *Processor) Load(ctx context.Context, fif FREE_IMAGE_FORMAT, filename string, flags int32) (*BitMap, error) {
+	if fif == FIF_UNKNOWN {
+		if fif = GetFileType(filename, 0); fif == FIF_UNKNOWN {
+			return nil, &StepError{Step: -1, Kind: "load", Err: ErrOpFailed}
+		}
+	}
+	cost, ok, err := p.probeCost(ctx, fif, func(flags int32) *BitMap { return Load(fif, filename, flags) })
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		cost = p.fallbackCost()
+		if st, err := os.Stat(filename); err == nil && st.Size() > cost {
+			cost = st.Size()
+		}
+	}
+
+	return p.do(ctx, cost, func(ctx context.Context) (*BitMap, error) {
+		if dib := Load(fif, filename, flags); dib != nil {
+			return dib, nil
+		}
+		return nil, &StepError{Step: -1, Kind: "load", Err: ErrOpFailed}
+	})
+}
+
+// LoadFromMemory decodes stream on a worker, see LoadFromMemory. FIF_UNKNOWN
+// detects the format. The stream is read from its start. Release the
+// bitmap with Unload.
+func (p *Processor) LoadFromMemory(ctx context.Context, fif FREE_IMAGE_FORMAT, stream *Memory, flags int32) (*BitMap, error) {
+	stream.SeekMemory(0, SEEK_SET)
+	if fif == FIF_UNKNOWN {
+		if fif = stream.GetFileType(); fif == FIF_UNKNOWN {
+			return nil, &StepError{Step: -1, Kind: "load", Err: ErrOpFailed}
+		}
+	}
+	cost, ok, err := p.probeCost(ctx, fif, func(flags int32) *BitMap {
+		defer stream.SeekMemory(0, SEEK_SET)
+		return LoadFromMemory(fif, stream, flags)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		cost = p.fallbackCost()
+	}
+
+	return p.do(ctx, cost, func(ctx context.Context) (*BitMap, error) {
+		if dib := LoadFromMemory(fif, stream, flags); dib != nil {
+			return dib, nil
+		}
+		return nil, &StepError{Step: -1, Kind: "load", Err: ErrOpFailed}
+	})
+}
+
+// Apply runs pipe on src on a worker, see Pipeline.ApplyContext. The
+// reservation covers the largest pair of bitmaps alive at the same time,
+// the result stays charged until Unload.
+func (p *Processor) Apply(ctx context.Context, pipe *Pipeline, src *BitMap) (*BitMap, error) {
+	return p.do(ctx, pipelineCost(pipe, src), func(ctx context.Context) (*BitMap, error) {
+		return pipe.ApplyContext(ctx, src)
+	})
+}
+
+// pipelineCost estimates the peak native memory pipe allocates on top of
+// src, following the geometry and depth changes of the known ops.
+func pipelineCost(pipe *Pipeline, src *BitMap) int64 {
+	w, h, bpp := int32(src.GetWidth()), int32(src.GetHeight()), src.GetBPP()
+	cur := int64(0) // src itself is owned by the caller
+	peak := EstimateSize(w, h, bpp)
+
+	for _, op := range pipe.Ops {
+		if op.InPlace() {
+			if cur == 0 {
+				cur = EstimateSize(w, h, bpp) // clone of src
+			}
+			peak = maxInt64(peak, cur)
+			continue
+		}
+
+		switch op := op.(type) {
+		case *RescaleOp:
+			w, h = op.Width, op.Height
+		case *ThumbnailOp:
+			if w > op.MaxPixelSize || h > op.MaxPixelSize {
+				w, h = op.MaxPixelSize, op.MaxPixelSize
+			}
+		case *CopyOp:
+			w, h = op.Right-op.Left, op.Bottom-op.Top
+		case *EnlargeCanvasOp:
+			w, h = w+op.Left+op.Right, h+op.Top+op.Bottom
+		case *RotateOp:
+			if math.Mod(op.Angle, 90) != 0 {
+				d := int32(math.Ceil(math.Hypot(float64(w), float64(h))))
+				w, h = d, d
+			} else if math.Mod(op.Angle, 180) != 0 {
+				w, h = h, w
+			}
+		case *ThresholdOp, *DitherOp:
+			bpp = 1
+		case *ToneMappingOp, *CompositeOp:
+			bpp = 24
+		case *ConvertOp:
+			bpp = convertBPP(op.To, bpp)
+		}
+		next := EstimateSize(w, h, bpp)
+		peak = maxInt64(peak, cur+next)
+		cur = next
+	}
+	return peak
+}
+
+func convertBPP(to string, bpp uint32) uint32 {
+	switch to {
+	case "4bits":
+		return 4
+	case "8bits", "greyscale":
+		return 8
+	case "16bits555", "16bits565", "uint16":
+		return 16
+	case "24bits":
+		return 24
+	case "32bits", "float", "standard":
+		return 32
+	case "rgb16":
+		return 48
+	case "rgba16":
+		return 64
+	case "rgbf":
+		return 96
+	case "rgbaf":
+		return 128
+	}
+	return bpp
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
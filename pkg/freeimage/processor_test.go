@@ -0,0 +1,240 @@
+package freeimage_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func stubFile(t *testing.T, w, h int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "a.stub")
+	if err := os.WriteFile(path, fitest.Bytes(w, h, 24, func(x, y, ch int) byte { return 1 }), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProcessorHoldsResults(t *testing.T) {
+	s := fitest.NewStub(t)
+	path := stubFile(t, 4, 3)
+	p := fi.NewProcessor(2, 0)
+	ctx := context.Background()
+
+	dib, err := p.Load(ctx, fi.FIF_UNKNOWN, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(dib.GetMemorySize())
+	if st := p.Stats(); st.BytesInFlight != size || st.Held != 1 || st.Completed != 1 {
+		t.Errorf("after Load: %+v, want %d bytes held", st, size)
+	}
+
+	mem := fi.OpenMemory(fitest.Bytes(4, 3, 24, func(x, y, ch int) byte { return 2 }))
+	defer mem.Close()
+	dib2, err := p.LoadFromMemory(ctx, fi.FIF_UNKNOWN, mem, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := p.Apply(ctx, fi.NewPipeline(widenOp{}), dib2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := size + int64(dib2.GetMemorySize()) + int64(out.GetMemorySize())
+	if st := p.Stats(); st.BytesInFlight != want || st.Held != 3 {
+		t.Errorf("3 results: %+v, want %d bytes", st, want)
+	}
+
+	for _, b := range []*fi.BitMap{dib, dib2, out} {
+		p.Unload(b)
+	}
+	p.Unload(nil)
+	if st := p.Stats(); st.BytesInFlight != 0 || st.Held != 0 {
+		t.Errorf("after Unload: %+v", st)
+	}
+	if s.Live() != 1 { // mem
+		t.Errorf("%d live", s.Live())
+	}
+
+	// a bitmap not from the processor is only unloaded
+	p.Unload(fi.Allocate(2, 2, 8, 0, 0, 0))
+	if st := p.Stats(); st.BytesInFlight != 0 || s.Live() != 1 {
+		t.Errorf("foreign Unload: %+v, %d live", st, s.Live())
+	}
+}
+
+func TestProcessorBudget(t *testing.T) {
+	fitest.NewStub(t)
+	path := stubFile(t, 4, 3)
+	// room for one load, not for a second next to a held result
+	p := fi.NewProcessor(4, fi.EstimateSize(4, 3, 24)+100)
+	ctx := context.Background()
+
+	dib, err := p.Load(ctx, fitest.FIF_STUB, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Load(short, fitest.FIF_STUB, path, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Load over the budget: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		dib, err := p.Load(ctx, fitest.FIF_STUB, path, 0)
+		p.Unload(dib)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Load ran with the budget held: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.Unload(dib)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if st := p.Stats(); st.BytesInFlight != 0 || st.Queued != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestProcessorProbe(t *testing.T) {
+	s := fitest.NewStub(t)
+	path := stubFile(t, 4, 3)
+	p := fi.NewProcessor(1, 1<<20)
+	ctx := context.Background()
+
+	// the header probe waits for a worker like the load
+	release := make(chan struct{})
+	go p.Do(ctx, 0, func(context.Context) error { <-release; return nil })
+	for p.Stats().Running == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan error)
+	go func() {
+		dib, err := p.Load(ctx, fitest.FIF_STUB, path, 0)
+		p.Unload(dib)
+		done <- err
+	}()
+	for p.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := s.Called("Load"); n != 0 {
+		t.Errorf("%d loads with the only worker busy", n)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// a 2^31 x 2^31 header doesn't wrap to a small cost: it reserves the
+	// whole budget and waits for the held result
+	held, err := p.Load(ctx, fitest.FIF_STUB, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bomb := stubImage(1, 1, 32)
+	copy(bomb[4:], []byte{0, 0, 0, 0x80, 0, 0, 0, 0x80})
+	bombPath := filepath.Join(t.TempDir(), "bomb.stub")
+	if err := os.WriteFile(bombPath, bomb, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		dib, err := p.Load(ctx, fitest.FIF_STUB, bombPath, 0)
+		p.Unload(dib)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Load of the huge header ran next to a held result: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.Unload(held)
+	<-done
+	if st := p.Stats(); st.BytesInFlight != 0 || st.Queued != 0 || st.Running != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestProcessorFailures(t *testing.T) {
+	s := fitest.NewStub(t)
+	p := fi.NewProcessor(1, 1<<20)
+	ctx := context.Background()
+
+	// the header probes fine, the pixels are missing
+	truncated := filepath.Join(t.TempDir(), "b.stub")
+	if err := os.WriteFile(truncated, fitest.Bytes(4, 3, 24, func(x, y, ch int) byte { return 1 })[:20], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var se *fi.StepError
+	if _, err := p.Load(ctx, fitest.FIF_STUB, truncated, 0); !errors.As(err, &se) || !errors.Is(err, fi.ErrOpFailed) {
+		t.Errorf("failed Load: %v", err)
+	}
+	if _, err := p.Load(ctx, fi.FIF_UNKNOWN, filepath.Join(t.TempDir(), "missing"), 0); !errors.Is(err, fi.ErrOpFailed) {
+		t.Errorf("unknown format: %v", err)
+	}
+
+	src := fi.Allocate(4, 3, 8, 0, 0, 0)
+	defer src.Unload()
+	if _, err := p.Apply(ctx, fi.NewPipeline(widenOp{}, failOp{}), src); !errors.Is(err, errStep) {
+		t.Errorf("failed Apply: %v", err)
+	}
+	func() {
+		defer func() { recover() }()
+		p.Do(ctx, 100, func(context.Context) error { panic("boom") })
+	}()
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := p.Do(canceled, 1, func(context.Context) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled Do: %v", err)
+	}
+
+	if st := p.Stats(); st.BytesInFlight != 0 || st.Held != 0 || st.Running != 0 || st.Failed != 2 || st.Completed != 0 {
+		t.Errorf("stats %+v", st)
+	}
+	if s.Live() != 1 {
+		t.Errorf("%d live bitmaps, want the source only", s.Live())
+	}
+}
+
+func TestProcessorWorkers(t *testing.T) {
+	p := fi.NewProcessor(2, 0)
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Do(context.Background(), 0, func(context.Context) error {
+				n := running.Add(1)
+				for {
+					old := peak.Load()
+					if n <= old || peak.CompareAndSwap(old, n) {
+						break
+					}
+				}
+				time.Sleep(2 * time.Millisecond)
+				running.Add(-1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if n := peak.Load(); n > 2 {
+		t.Errorf("%d calls ran at once on 2 workers", n)
+	}
+	if st := p.Stats(); st.Completed != 8 || st.Workers != 2 {
+		t.Errorf("stats %+v", st)
+	}
+}
@@ -0,0 +1,315 @@
+// Package qoi is a FreeImage plugin for the Quite OK Image format
+// (https://qoiformat.org), written in Go on top of freeimage.RegisterPlugin.
+//
+//	fif, err := qoi.Register()
+//	dib := freeimage.Load(fif, "image.qoi", 0)
+//
+// Decode and Encode work on pixel buffers without FreeImage.
+package qoi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+var (
+	ErrFormat  = errors.New("qoi: not a QOI image")
+	ErrCorrupt = errors.New("qoi: corrupt image")
+)
+
+const (
+	headerSize = 14
+	// MaxPixels is the largest image Decode accepts, as in the reference
+	// implementation.
+	MaxPixels = 400_000_000
+
+	opIndex = 0x00
+	opDiff  = 0x40
+	opLuma  = 0x80
+	opRun   = 0xc0
+	opRGB   = 0xfe
+	opRGBA  = 0xff
+	mask2   = 0xc0
+)
+
+var (
+	magic   = []byte("qoif")
+	padding = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+)
+
+// Header is the QOI file header.
+type Header struct {
+	Width, Height uint32
+	Channels      uint8 // 3 for RGB, 4 for RGBA
+	Colorspace    uint8 // 0 for sRGB with linear alpha, 1 for all linear
+}
+
+// DecodeHeader parses the header at the start of data.
+func DecodeHeader(data []byte) (Header, error) {
+	if len(data) < headerSize || !bytes.Equal(data[:4], magic) {
+		return Header{}, ErrFormat
+	}
+	h := Header{
+		Width:      binary.BigEndian.Uint32(data[4:]),
+		Height:     binary.BigEndian.Uint32(data[8:]),
+		Channels:   data[12],
+		Colorspace: data[13],
+	}
+	if h.Width == 0 || h.Height == 0 || (h.Channels != 3 && h.Channels != 4) || h.Colorspace > 1 {
+		return Header{}, fmt.Errorf("%w: header %+v", ErrCorrupt, h)
+	}
+	if uint64(h.Width)*uint64(h.Height) > MaxPixels {
+		return Header{}, fmt.Errorf("%w: %dx%d is over MaxPixels", ErrCorrupt, h.Width, h.Height)
+	}
+	return h, nil
+}
+
+func hash(px [4]byte) int {
+	return (int(px[0])*3 + int(px[1])*5 + int(px[2])*7 + int(px[3])*11) % 64
+}
+
+// maxRun is the most pixels a byte of QOI data can encode, with opRun.
+const maxRun = 62
+
+// Decode decodes a QOI image to its pixels, top row first, with
+// h.Channels bytes per pixel in RGB(A) order. Images whose data is too
+// short for their size are rejected before the pixels are allocated.
+func Decode(data []byte) (Header, []byte, error) {
+	h, err := DecodeHeader(data)
+	if err != nil {
+		return h, nil, err
+	}
+	n, ch := int(h.Width)*int(h.Height), int(h.Channels)
+	if ops := len(data) - headerSize - len(padding); ops <= 0 || uint64(n) > uint64(ops)*maxRun {
+		return h, nil, fmt.Errorf("%w: %d bytes can't hold %dx%d pixels", ErrCorrupt, len(data), h.Width, h.Height)
+	}
+	pix := make([]byte, 0, n*ch)
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	p, end := headerSize, len(data)-len(padding)
+	for run := 0; len(pix) < n*ch; {
+		if run > 0 {
+			run--
+		} else {
+			if p >= end {
+				return h, nil, fmt.Errorf("%w: data ends at pixel %d of %d", ErrCorrupt, len(pix)/ch, n)
+			}
+			b1 := data[p]
+			p++
+			switch {
+			case b1 == opRGB:
+				if p+3 > end {
+					return h, nil, ErrCorrupt
+				}
+				copy(px[:3], data[p:p+3])
+				p += 3
+			case b1 == opRGBA:
+				if p+4 > end {
+					return h, nil, ErrCorrupt
+				}
+				copy(px[:], data[p:p+4])
+				p += 4
+			case b1&mask2 == opIndex:
+				px = index[b1]
+			case b1&mask2 == opDiff:
+				px[0] += (b1>>4)&3 - 2
+				px[1] += (b1>>2)&3 - 2
+				px[2] += b1&3 - 2
+			case b1&mask2 == opLuma:
+				if p >= end {
+					return h, nil, ErrCorrupt
+				}
+				b2 := data[p]
+				p++
+				vg := b1&0x3f - 32
+				px[0] += vg - 8 + (b2>>4)&0x0f
+				px[1] += vg
+				px[2] += vg - 8 + b2&0x0f
+			case b1&mask2 == opRun:
+				run = int(b1 & 0x3f)
+			}
+			index[hash(px)] = px
+		}
+		pix = append(pix, px[:ch]...)
+	}
+	return h, pix, nil
+}
+
+// Encode encodes the pixels of a w x h image, top row first, with channels
+// (3 or 4) bytes per pixel in RGB(A) order.
+func Encode(w, h int, channels, colorspace uint8, pix []byte) ([]byte, error) {
+	if w <= 0 || h <= 0 || uint64(w)*uint64(h) > MaxPixels {
+		return nil, fmt.Errorf("qoi: bad size %dx%d", w, h)
+	}
+	if channels != 3 && channels != 4 {
+		return nil, fmt.Errorf("qoi: bad channel count %d", channels)
+	}
+	ch := int(channels)
+	if len(pix) < w*h*ch {
+		return nil, fmt.Errorf("qoi: %d bytes for %dx%d pixels of %d channels", len(pix), w, h, ch)
+	}
+
+	out := make([]byte, headerSize, headerSize+w*h*(ch+1)/2+len(padding))
+	copy(out, magic)
+	binary.BigEndian.PutUint32(out[4:], uint32(w))
+	binary.BigEndian.PutUint32(out[8:], uint32(h))
+	out[12], out[13] = channels, colorspace
+
+	var index [64][4]byte
+	prev := [4]byte{0, 0, 0, 255}
+	px := prev
+	run := 0
+	last := w*h*ch - ch
+	for off := 0; off <= last; off += ch {
+		copy(px[:ch], pix[off:off+ch])
+		if px == prev {
+			run++
+			if run == 62 || off == last {
+				out = append(out, opRun|byte(run-1))
+				run = 0
+			}
+			continue
+		}
+		if run > 0 {
+			out = append(out, opRun|byte(run-1))
+			run = 0
+		}
+		i := hash(px)
+		switch {
+		case index[i] == px:
+			out = append(out, opIndex|byte(i))
+		case px[3] != prev[3]:
+			index[i] = px
+			out = append(out, opRGBA, px[0], px[1], px[2], px[3])
+		default:
+			index[i] = px
+			vr, vg, vb := int8(px[0]-prev[0]), int8(px[1]-prev[1]), int8(px[2]-prev[2])
+			vgr, vgb := vr-vg, vb-vg
+			switch {
+			case vr > -3 && vr < 2 && vg > -3 && vg < 2 && vb > -3 && vb < 2:
+				out = append(out, opDiff|byte(vr+2)<<4|byte(vg+2)<<2|byte(vb+2))
+			case vgr > -9 && vgr < 8 && vg > -33 && vg < 32 && vgb > -9 && vgb < 8:
+				out = append(out, opLuma|byte(vg+32), byte(vgr+8)<<4|byte(vgb+8))
+			default:
+				out = append(out, opRGB, px[0], px[1], px[2])
+			}
+		}
+		prev = px
+	}
+	return append(out, padding...), nil
+}
+
+// Plugin implements freeimage.Plugin for QOI. It loads 24 bit bitmaps
+// from RGB images and 32 bit ones from RGBA images, header only with
+// FIF_LOAD_NOPIXELS, and saves 24 and 32 bit bitmaps, other ones are
+// converted to 32 bit.
+type Plugin struct{}
+
+func (Plugin) Format() string       { return "QOI" }
+func (Plugin) Description() string  { return "Quite OK Image format" }
+func (Plugin) Extensions() []string { return []string{"qoi"} }
+func (Plugin) MIME() string         { return "image/qoi" }
+
+func (Plugin) Validate(r io.ReadSeeker) bool {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return false
+	}
+	_, err := DecodeHeader(hdr[:])
+	return err == nil
+}
+
+func (Plugin) PageCount(r io.ReadSeeker) int { return 1 }
+func (Plugin) SupportsNoPixels() bool        { return true }
+
+func (Plugin) Load(r io.ReadSeeker, page int, flags int32) (*freeimage.BitMap, error) {
+	if page > 0 {
+		return nil, fmt.Errorf("qoi: no page %d", page)
+	}
+	if flags&freeimage.FIF_LOAD_NOPIXELS != 0 {
+		var hdr [headerSize]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, err
+		}
+		h, err := DecodeHeader(hdr[:])
+		if err != nil {
+			return nil, err
+		}
+		dib := freeimage.AllocateHeader(true, int32(h.Width), int32(h.Height), int32(8*h.Channels), 0, 0, 0)
+		if dib == nil {
+			return nil, fmt.Errorf("qoi: allocating a %dx%d header failed", h.Width, h.Height)
+		}
+		return dib, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	h, pix, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	w, ht, ch := int(h.Width), int(h.Height), int(h.Channels)
+	dib := freeimage.Allocate(int32(w), int32(ht), int32(8*ch), 0, 0, 0)
+	if dib == nil {
+		return nil, fmt.Errorf("qoi: allocating a %dx%d bitmap failed", w, ht)
+	}
+	colors := make([]freeimage.RGBQUAD, 0, w*ht)
+	for y := ht - 1; y >= 0; y-- { // FreeImage rows are bottom up
+		row := pix[y*w*ch : (y+1)*w*ch]
+		for i := 0; i < len(row); i += ch {
+			q := freeimage.RGBQUAD{row[i+2], row[i+1], row[i], 0xff}
+			if ch == 4 {
+				q[3] = row[i+3]
+			}
+			colors = append(colors, q)
+		}
+	}
+	if err := dib.WriteRegion(dib.Bounds(), colors); err != nil {
+		dib.Unload()
+		return nil, err
+	}
+	return dib, nil
+}
+
+func (Plugin) Save(w io.WriteSeeker, dib *freeimage.BitMap, page int, flags int32) error {
+	if bpp := dib.GetBPP(); (bpp != 24 && bpp != 32) || dib.GetImageType() != freeimage.FIT_BITMAP {
+		dib = dib.ConvertTo32Bits()
+		if dib == nil {
+			return errors.New("qoi: converting to 32 bit failed")
+		}
+		defer dib.Unload()
+	}
+	ch := int(dib.GetBPP() / 8)
+	r := dib.Bounds()
+	colors, err := dib.ReadRegion(r)
+	if err != nil {
+		return err
+	}
+	pix := make([]byte, 0, len(colors)*ch)
+	for y := r.Dy() - 1; y >= 0; y-- {
+		for _, q := range colors[y*r.Dx() : (y+1)*r.Dx()] {
+			pix = append(pix, q[2], q[1], q[0])
+			if ch == 4 {
+				pix = append(pix, q[3])
+			}
+		}
+	}
+	data, err := Encode(r.Dx(), r.Dy(), uint8(ch), 0, pix)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Register registers the plugin with the default library and returns its
+// format. Call it once per library, after Initialise.
+func Register() (freeimage.FREE_IMAGE_FORMAT, error) {
+	return freeimage.RegisterPlugin(Plugin{})
+}
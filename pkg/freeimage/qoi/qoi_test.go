@@ -0,0 +1,199 @@
+package qoi_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/qoi"
+)
+
+func header(w, h uint32, ch byte) []byte {
+	return []byte{'q', 'o', 'i', 'f', byte(w >> 24), byte(w >> 16), byte(w >> 8), byte(w), byte(h >> 24), byte(h >> 16), byte(h >> 8), byte(h), ch, 0}
+}
+
+var padding = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+func TestEncodeKnown(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		w    int
+		ch   uint8
+		pix  []byte
+		ops  []byte
+	}{
+		{"run", 2, 3, []byte{0, 0, 0, 0, 0, 0}, []byte{0xc1}},
+		{"luma", 1, 3, []byte{1, 2, 3}, []byte{0xa2, 0x79}},
+		{"diff", 2, 3, []byte{0, 0, 1, 1, 0, 0}, []byte{0x6b, 0x79}},
+		{"rgb index", 3, 3, []byte{200, 10, 10, 0, 0, 0, 200, 10, 10}, []byte{0xfe, 200, 10, 10, 0xfe, 0, 0, 0, 5}}, // (200*3+10*5+10*7+255*11) % 64,
+		{"rgba", 1, 4, []byte{1, 2, 3, 4}, []byte{0xff, 1, 2, 3, 4}},
+	} {
+		want := append(append(header(uint32(tc.w), 1, tc.ch), tc.ops...), padding...)
+		got, err := qoi.Encode(tc.w, 1, tc.ch, 0, tc.pix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: Encode = %x, want %x", tc.name, got, want)
+		}
+		_, pix, err := qoi.Decode(want)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(pix, tc.pix) {
+			t.Errorf("%s: Decode = %v, want %v", tc.name, pix, tc.pix)
+		}
+	}
+}
+
+func testImage(w, h, ch int) []byte {
+	pix := make([]byte, w*h*ch)
+	for i := range pix {
+		x, y := i/ch%w, i/ch/w
+		switch {
+		case y < h/4: // runs
+			pix[i] = 7
+		case y < h/2: // small steps
+			pix[i] = byte(x + i%ch)
+		default: // noise
+			pix[i] = byte(i * 2654435761 >> 13)
+		}
+	}
+	return pix
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, ch := range []uint8{3, 4} {
+		pix := testImage(37, 29, int(ch))
+		data, err := qoi.Encode(37, 29, ch, 1, pix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, got, err := qoi.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h != (qoi.Header{Width: 37, Height: 29, Channels: ch, Colorspace: 1}) {
+			t.Errorf("header %+v", h)
+		}
+		if !bytes.Equal(got, pix) {
+			t.Errorf("%d channels: pixels differ after a round trip", ch)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	good, _ := qoi.Encode(4, 4, 4, 0, testImage(4, 4, 4))
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("qoix"), good[4:]...),
+		"channels":  append(header(4, 4, 5), padding...),
+		"truncated": good[:len(good)-12],
+		"huge":      append(header(1<<20, 1<<20, 3), padding...),
+		// 63 pixels need two runs
+		"short":    append(append(header(63, 1, 3), 0xfd), padding...),
+		"no ops":   append(header(1, 1, 3), padding...),
+		"oversize": append(append(header(20000, 20000, 4), bytes.Repeat([]byte{0xfd}, 1000)...), padding...),
+	} {
+		if _, _, err := qoi.Decode(data); err == nil {
+			t.Errorf("%s: Decode succeeded", name)
+		}
+	}
+	// the longest run is the limit
+	if _, pix, err := qoi.Decode(append(append(header(62, 1, 3), 0xfd), padding...)); err != nil || len(pix) != 62*3 {
+		t.Errorf("a run of 62: %d bytes, %v", len(pix), err)
+	}
+	if _, _, err := qoi.Decode([]byte("GIF89a........")); !errors.Is(err, qoi.ErrFormat) {
+		t.Errorf("Decode of a GIF = %v, want ErrFormat", err)
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	fitest.Auto(t)
+	fif, err := qoi.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := freeimage.GetFIFFromFormat("QOI"); got != fif {
+		t.Errorf("GetFIFFromFormat = %d, want %d", got, fif)
+	}
+	if got := freeimage.GetFIFMimeType(fif); got != "image/qoi" {
+		t.Errorf("GetFIFMimeType = %q", got)
+	}
+	name := filepath.Join(t.TempDir(), "image.qoi")
+	if got := freeimage.GetFIFFromFilename(name); got != fif {
+		t.Errorf("GetFIFFromFilename = %d, want %d", got, fif)
+	}
+
+	dib := freeimage.Allocate(5, 3, 32, 0, 0, 0)
+	defer dib.Unload()
+	dib.SetPixelColor(1, 2, &freeimage.RGBQUAD{10, 20, 30, 40}) // top row
+	if !dib.Save(fif, name, 0) {
+		t.Fatal("Save failed")
+	}
+	if got := freeimage.GetFileType(name, 0); got != fif {
+		t.Errorf("GetFileType = %d, want %d", got, fif)
+	}
+	got := freeimage.Load(fif, name, 0)
+	if got == nil {
+		t.Fatal("Load returned nil")
+	}
+	defer got.Unload()
+	if c, _ := got.GetPixelColor(1, 2); c != (freeimage.RGBQUAD{10, 20, 30, 40}) {
+		t.Errorf("loaded pixel = %v", c)
+	}
+
+	// memory streams go through the plugin too
+	data, err := freeimage.Encode(dib, fif, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err := qoi.DecodeHeader(data); err != nil || h.Width != 5 || h.Channels != 4 {
+		t.Errorf("encoded header %+v, %v", h, err)
+	}
+	back, err := freeimage.Decode(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back.Unload()
+	if _, err := freeimage.Decode(data[:20], nil); err == nil {
+		t.Error("Decode of a truncated image succeeded")
+	}
+}
+
+func TestPluginNoPixels(t *testing.T) {
+	s := fitest.NewStub(t)
+	fif, err := qoi.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !freeimage.FIFSupportsNoPixels(fif) {
+		t.Error("FIFSupportsNoPixels = false")
+	}
+
+	// the header claims more pixels than the data holds, only it is read
+	data := append(append(header(3000, 2000, 4), 0xfd), padding...)
+	mem := freeimage.OpenMemory(data)
+	defer mem.Close()
+	dib := freeimage.LoadFromMemory(fif, mem, freeimage.FIF_LOAD_NOPIXELS)
+	if dib == nil {
+		t.Fatal("header only load returned nil")
+	}
+	if dib.HasPixels() || dib.GetWidth() != 3000 || dib.GetHeight() != 2000 || dib.GetBPP() != 32 {
+		t.Errorf("header %dx%dx%d, pixels %v", dib.GetWidth(), dib.GetHeight(), dib.GetBPP(), dib.HasPixels())
+	}
+	dib.Unload()
+
+	// limits reject it from the header
+	_, err = freeimage.Decode(data, &freeimage.DecodeOptions{Limits: &freeimage.LoadLimits{MaxWidth: 1000, RequireProbe: true}})
+	var le *freeimage.LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxWidth" {
+		t.Errorf("Decode over the limits = %v", err)
+	}
+	if s.Live() != 1 { // mem
+		t.Errorf("%d live", s.Live())
+	}
+}
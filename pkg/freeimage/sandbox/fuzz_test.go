@@ -0,0 +1,218 @@
+package sandbox_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/sandbox"
+)
+
+// The fuzz targets decode in helper processes, so a native crash or hang
+// fails the input instead of killing the fuzzer, and is minimized like any
+// other failure. They use the real library when it is installed, and the
+// stub otherwise.
+
+const fuzzTimeout = 10 * time.Second
+
+// fuzzLimits are DefaultLoadLimits without RequireProbe, so the formats
+// that can't load headers only are decoded, and checked afterwards, too.
+var fuzzLimits = func() freeimage.LoadLimits {
+	l := freeimage.DefaultLoadLimits
+	l.RequireProbe = false
+	return l
+}()
+
+// fuzzSeeds makes the library for the fuzz test the default library, and
+// returns it with a tiny image in every format and bit depth it writes,
+// made with AllocateEx and SaveToMemory, to seed the corpus.
+func fuzzSeeds(f *testing.F) (*freeimage.Library, []fuzzSeed) {
+	l, s := fitest.Auto(f)
+	var formats []freeimage.FREE_IMAGE_FORMAT
+	for fif := freeimage.FREE_IMAGE_FORMAT(0); int32(fif) < freeimage.GetFIFCount(); fif++ {
+		formats = append(formats, fif)
+	}
+	if s != nil {
+		formats = append(formats, fitest.FIF_STUB)
+	}
+
+	var seeds []fuzzSeed
+	color := freeimage.RGBQUAD{0x20, 0x80, 0xc0, 0xff}
+	for _, fif := range formats {
+		if !freeimage.FIFSupportsWriting(fif) {
+			continue
+		}
+		for _, bpp := range []int32{8, 24, 32} {
+			if !freeimage.FIFSupportsExportBPP(fif, bpp) {
+				continue
+			}
+			dib := freeimage.AllocateEx(4, 3, bpp, &color, 0, nil, 0, 0, 0)
+			if dib == nil {
+				f.Fatalf("AllocateEx(4, 3, %d) failed", bpp)
+			}
+			mem := freeimage.OpenMemory(nil)
+			if dib.SaveToMemory(fif, mem, 0) {
+				seeds = append(seeds, fuzzSeed{fif, mem.Bytes()})
+			}
+			mem.CloseMemory()
+			dib.Unload()
+		}
+	}
+	if len(seeds) == 0 {
+		f.Fatal("no format can write the seeds")
+	}
+	return l, seeds
+}
+
+type fuzzSeed struct {
+	fif  freeimage.FREE_IMAGE_FORMAT
+	data []byte
+}
+
+func FuzzLoadFromMemory(f *testing.F) {
+	l, seeds := fuzzSeeds(f)
+	for _, s := range seeds {
+		f.Add(int32(s.fif), s.data)
+		f.Add(int32(freeimage.FIF_UNKNOWN), s.data)
+	}
+	p := startPool(f, l, sandbox.Options{Size: 1, Timeout: fuzzTimeout})
+	f.Fuzz(func(t *testing.T, fif int32, data []byte) {
+		dib, err := p.Decode(data, &freeimage.DecodeOptions{Format: freeimage.FREE_IMAGE_FORMAT(fif), Force: true, Limits: &fuzzLimits})
+		if errors.Is(err, sandbox.ErrCrashed) || errors.Is(err, sandbox.ErrTimeout) {
+			t.Fatal(err)
+		}
+		if err == nil {
+			dib.Unload()
+		}
+	})
+}
+
+func FuzzMetadataIteration(f *testing.F) {
+	l, seeds := fuzzSeeds(f)
+	for _, s := range seeds {
+		f.Add(int32(s.fif), s.data)
+	}
+	f.Fuzz(func(t *testing.T, fif int32, data []byte) {
+		runIsolated(t, l, "metadata", fif, data)
+	})
+}
+
+func FuzzMultiPage(f *testing.F) {
+	l, seeds := fuzzSeeds(f)
+	for _, s := range seeds {
+		f.Add(int32(s.fif), s.data)
+		f.Add(int32(s.fif), append(append([]byte{}, s.data...), s.data...))
+	}
+	f.Fuzz(func(t *testing.T, fif int32, data []byte) {
+		runIsolated(t, l, "multipage", fif, data)
+	})
+}
+
+// runIsolated runs the fuzz target once in a child test binary using l,
+// with data on its stdin, and fails if it doesn't exit cleanly.
+func runIsolated(t *testing.T, l *freeimage.Library, target string, fif int32, data []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), fuzzTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, os.Args[0])
+	cmd.Env = append(os.Environ(), "SANDBOX_TEST_LIB="+l.Path(), "SANDBOX_TEST_FUZZ="+target, "SANDBOX_TEST_FIF="+strconv.Itoa(int(fif)))
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			t.Fatalf("%s hung: %v", target, ctx.Err())
+		}
+		t.Fatalf("%s: %v\n%s", target, err, stderr.Bytes())
+	}
+}
+
+// fuzzTarget is the child side of runIsolated.
+func fuzzTarget(target, lib string) error {
+	fif, err := strconv.Atoi(os.Getenv("SANDBOX_TEST_FIF"))
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if err := useLibrary(lib); err != nil {
+		return err
+	}
+	switch target {
+	case "metadata":
+		return iterateMetadata(freeimage.FREE_IMAGE_FORMAT(fif), data)
+	case "multipage":
+		return lockPages(freeimage.FREE_IMAGE_FORMAT(fif), data)
+	}
+	return fmt.Errorf("unknown fuzz target %q", target)
+}
+
+// iterateMetadata visits every tag of every model and reads its value.
+func iterateMetadata(fif freeimage.FREE_IMAGE_FORMAT, data []byte) error {
+	dib, err := freeimage.Decode(data, &freeimage.DecodeOptions{Format: fif, Force: true, Limits: &fuzzLimits})
+	if err != nil {
+		return nil
+	}
+	defer dib.Unload()
+	for model := freeimage.FIMD_COMMENTS; model <= freeimage.FIMD_EXIF_RAW; model++ {
+		count := dib.GetMetadataCount(model)
+		h, tag := dib.FindFirstMetadata(model)
+		if h == nil {
+			if count != 0 {
+				return fmt.Errorf("model %d: FindFirstMetadata found nothing, GetMetadataCount = %d", model, count)
+			}
+			continue
+		}
+		var n uint32
+		for ok := true; ok && tag != nil; tag, ok = h.FindNextMetadata() {
+			n++
+			tag.GetTagKey()
+			tag.GetTagDescription()
+			tag.GetTagType()
+			tag.GetTagCount()
+			if v := tag.GetTagValue(); v != nil {
+				crc32.ChecksumIEEE(unsafe.Slice((*byte)(v), tag.GetTagLength()))
+			}
+			freeimage.TagToString(model, tag)
+		}
+		h.FindCloseMetadata()
+		if n != count {
+			return fmt.Errorf("model %d: found %d tags, GetMetadataCount = %d", model, n, count)
+		}
+	}
+	return nil
+}
+
+// lockPages locks every page of a multipage image and reads its pixels.
+func lockPages(fif freeimage.FREE_IMAGE_FORMAT, data []byte) error {
+	mem := freeimage.OpenMemory(data)
+	defer mem.CloseMemory()
+	mb, err := fuzzLimits.LoadMultiBitmapFromMemory(fif, mem, 0)
+	if err != nil {
+		return nil
+	}
+	defer mb.Close(0)
+	for i := int32(0); i < mb.GetPageCount(); i++ {
+		page, err := fuzzLimits.LockPage(mb, i)
+		if err != nil {
+			continue
+		}
+		if page.HasPixels() {
+			crc32.ChecksumIEEE(unsafe.Slice((*byte)(page.GetBits()), page.GetPitch()*page.GetHeight()))
+		}
+		mb.UnlockPage(page, false)
+	}
+	return nil
+}
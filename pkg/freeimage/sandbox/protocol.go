@@ -0,0 +1,281 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+// The helper reads requests from stdin and answers each on stdout, one at
+// a time. Both are frames: a little endian uint32 length, then that many
+// bytes, the first of which is the op or status.
+//
+//	decode request: opDecode, format int32, flags int32, limits, encoded image
+//	encode request: opEncode, format int32, flags int32, bitmap
+//	response:       statusOK, bitmap or encoded image
+//	                or an error status and the error text
+//
+// Limits are a wireLimits followed by the allowed formats as int32.
+// A bitmap is a header of uint32 values, see bitmapHeader, followed by the
+// palette, the transparency table and the scanlines, bottom up.
+
+const (
+	opDecode byte = 1
+	opEncode byte = 2
+)
+
+const (
+	statusOK byte = iota
+	statusUnknownFormat
+	statusDecode
+	statusEncode
+	statusError
+	statusTooLarge
+	statusFormatNotAllowed
+)
+
+// bitmapHeader is the start of a bitmap on the wire.
+type bitmapHeader struct {
+	Type, Width, Height, BPP uint32
+	Red, Green, Blue         uint32 // color masks
+	DotsPerMeterX            uint32
+	DotsPerMeterY            uint32
+	Colors                   uint32 // palette entries
+	Transparency             uint32 // transparency table entries
+	Transparent              uint32 // 1 if IsTransparent
+	Line                     uint32 // bytes per scanline, without padding
+}
+
+var headerSize = binary.Size(bitmapHeader{})
+
+func writeFrame(w io.Writer, kind byte, parts ...[]byte) error {
+	n := uint64(1)
+	for _, p := range parts {
+		n += uint64(len(p))
+	}
+	if n > math.MaxUint32 {
+		return fmt.Errorf("sandbox: %d byte message is too large", n)
+	}
+	var hdr [5]byte
+	binary.LittleEndian.PutUint32(hdr[:], uint32(n))
+	hdr[4] = kind
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFrame returns the kind and the body of the next frame, io.EOF if the
+// stream ends before it.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[:])
+	if n == 0 {
+		return 0, nil, errors.New("sandbox: empty frame")
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return buf[0], buf[1:], nil
+}
+
+func request(format freeimage.FREE_IMAGE_FORMAT, flags int32) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, uint32(int32(format)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(flags))
+	return b
+}
+
+func parseRequest(body []byte) (freeimage.FREE_IMAGE_FORMAT, int32, []byte, error) {
+	if len(body) < 8 {
+		return 0, 0, nil, errors.New("sandbox: short request")
+	}
+	format := freeimage.FREE_IMAGE_FORMAT(int32(binary.LittleEndian.Uint32(body)))
+	flags := int32(binary.LittleEndian.Uint32(body[4:]))
+	return format, flags, body[8:], nil
+}
+
+// wireLimits is the start of freeimage.LoadLimits on the wire.
+type wireLimits struct {
+	Set                 uint32 // 0 for nil limits
+	MaxWidth, MaxHeight int32
+	MaxPixels           int64
+	MaxPages            int32
+	RequireProbe        uint32
+	MaxMemoryBytes      int64
+	Formats             int32 // -1 for nil AllowedFormats
+}
+
+var limitsSize = binary.Size(wireLimits{})
+
+func marshalLimits(l *freeimage.LoadLimits) []byte {
+	var buf bytes.Buffer
+	w := wireLimits{Formats: -1}
+	if l != nil {
+		w = wireLimits{
+			Set:            1,
+			MaxWidth:       l.MaxWidth,
+			MaxHeight:      l.MaxHeight,
+			MaxPixels:      l.MaxPixels,
+			MaxPages:       l.MaxPages,
+			MaxMemoryBytes: l.MaxMemoryBytes,
+			Formats:        -1,
+		}
+		if l.RequireProbe {
+			w.RequireProbe = 1
+		}
+		if l.AllowedFormats != nil {
+			w.Formats = int32(len(l.AllowedFormats))
+		}
+	}
+	binary.Write(&buf, binary.LittleEndian, &w)
+	if l != nil && l.AllowedFormats != nil {
+		binary.Write(&buf, binary.LittleEndian, l.AllowedFormats)
+	}
+	return buf.Bytes()
+}
+
+// parseLimits returns the limits at the start of b and the rest of b.
+func parseLimits(b []byte) (*freeimage.LoadLimits, []byte, error) {
+	var w wireLimits
+	if len(b) < limitsSize {
+		return nil, nil, errors.New("sandbox: short limits")
+	}
+	binary.Read(bytes.NewReader(b), binary.LittleEndian, &w)
+	b = b[limitsSize:]
+	if w.Formats > 0 && len(b) < 4*int(w.Formats) {
+		return nil, nil, errors.New("sandbox: short limits")
+	}
+	if w.Set == 0 {
+		return nil, b, nil
+	}
+	l := &freeimage.LoadLimits{
+		MaxWidth:       w.MaxWidth,
+		MaxHeight:      w.MaxHeight,
+		MaxPixels:      w.MaxPixels,
+		MaxPages:       w.MaxPages,
+		MaxMemoryBytes: w.MaxMemoryBytes,
+		RequireProbe:   w.RequireProbe == 1,
+	}
+	if w.Formats >= 0 {
+		l.AllowedFormats = make([]freeimage.FREE_IMAGE_FORMAT, w.Formats)
+		binary.Read(bytes.NewReader(b), binary.LittleEndian, l.AllowedFormats)
+		b = b[4*w.Formats:]
+	}
+	return l, b, nil
+}
+
+// marshalBitmap returns the wire form of dib: pixels, palette, transparency
+// and resolution. Metadata, ICC profiles and thumbnails are not carried.
+func marshalBitmap(dib *freeimage.BitMap) ([]byte, error) {
+	h := bitmapHeader{
+		Type:          uint32(dib.GetImageType()),
+		Width:         dib.GetWidth(),
+		Height:        dib.GetHeight(),
+		BPP:           dib.GetBPP(),
+		Red:           dib.GetRedMask(),
+		Green:         dib.GetGreenMask(),
+		Blue:          dib.GetBlueMask(),
+		DotsPerMeterX: dib.GetDotsPerMeterX(),
+		DotsPerMeterY: dib.GetDotsPerMeterY(),
+		Line:          dib.GetLine(),
+	}
+	var palette []byte
+	if n := dib.GetColorsUsed(); n > 0 {
+		if p := dib.GetPalette(); p != nil {
+			palette = unsafe.Slice((*byte)(unsafe.Pointer(p)), 4*n)
+			h.Colors = n
+		}
+	}
+	var table []byte
+	if n := dib.GetTransparencyCount(); n > 0 {
+		if p := dib.GetTransparencyTable(); p != nil {
+			table = unsafe.Slice((*byte)(p), n)
+			h.Transparency = n
+		}
+	}
+	if dib.IsTransparent() {
+		h.Transparent = 1
+	}
+
+	size := uint64(headerSize) + uint64(len(palette)) + uint64(len(table)) + uint64(h.Line)*uint64(h.Height)
+	if size >= math.MaxUint32 {
+		return nil, fmt.Errorf("sandbox: %dx%d %d bit bitmap is too large", h.Width, h.Height, h.BPP)
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(size))
+	binary.Write(&buf, binary.LittleEndian, &h)
+	buf.Write(palette)
+	buf.Write(table)
+	for y := uint32(0); y < h.Height; y++ {
+		line := dib.GetScanLine(int32(y))
+		if line == nil {
+			return nil, fmt.Errorf("sandbox: bitmap has no scanline %d", y)
+		}
+		buf.Write(unsafe.Slice((*byte)(line), h.Line))
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalBitmap allocates a bitmap in the default library from its wire
+// form.
+func unmarshalBitmap(b []byte) (*freeimage.BitMap, error) {
+	var h bitmapHeader
+	if len(b) < headerSize {
+		return nil, errors.New("sandbox: short bitmap")
+	}
+	binary.Read(bytes.NewReader(b), binary.LittleEndian, &h)
+	b = b[headerSize:]
+	if h.Colors > 256 || h.Transparency > 256 ||
+		uint64(len(b)) != 4*uint64(h.Colors)+uint64(h.Transparency)+uint64(h.Line)*uint64(h.Height) {
+		return nil, fmt.Errorf("sandbox: bad bitmap size %d for %+v", len(b), h)
+	}
+
+	dib := freeimage.AllocateT(freeimage.FREE_IMAGE_TYPE(h.Type), int32(h.Width), int32(h.Height), int32(h.BPP), h.Red, h.Green, h.Blue)
+	if dib == nil {
+		return nil, fmt.Errorf("sandbox: allocating a %dx%d %d bit bitmap failed", h.Width, h.Height, h.BPP)
+	}
+	if dib.GetLine() != h.Line {
+		dib.Unload()
+		return nil, fmt.Errorf("sandbox: scanline of %d bytes, want %d", dib.GetLine(), h.Line)
+	}
+	if h.Colors > 0 {
+		p := dib.GetPalette()
+		if p == nil || dib.GetColorsUsed() < h.Colors {
+			dib.Unload()
+			return nil, fmt.Errorf("sandbox: %d bit bitmap has no room for %d colors", h.BPP, h.Colors)
+		}
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(p)), 4*h.Colors), b)
+		b = b[4*h.Colors:]
+	}
+	if h.Transparency > 0 {
+		dib.SetTransparencyTable(b[:h.Transparency])
+		b = b[h.Transparency:]
+	}
+	dib.SetTransparent(h.Transparent == 1)
+	dib.SetDotsPerMeterX(h.DotsPerMeterX)
+	dib.SetDotsPerMeterY(h.DotsPerMeterY)
+	for y := uint32(0); y < h.Height; y++ {
+		line := dib.GetScanLine(int32(y))
+		copy(unsafe.Slice((*byte)(line), h.Line), b[y*h.Line:])
+	}
+	return dib, nil
+}
@@ -0,0 +1,58 @@
+package sandbox
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+func TestBitmapWire(t *testing.T) {
+	s := fitest.NewStub(t)
+	dib := freeimage.Allocate(3, 2, 8, 0, 0, 0)
+	defer dib.Unload()
+	if err := dib.WriteIndexRegion(dib.Bounds(), []byte{0, 1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	pal := unsafe.Slice(dib.GetPalette(), 256)
+	pal[1] = freeimage.RGBQUAD{1, 2, 3, 0}
+	dib.SetTransparencyTable([]byte{255, 0, 128})
+	dib.SetDotsPerMeterX(3937)
+	dib.SetDotsPerMeterY(1000)
+
+	b, err := marshalBitmap(dib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := unmarshalBitmap(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.Unload()
+	idx, _ := got.ReadIndexRegion(got.Bounds())
+	if !bytes.Equal(idx, []byte{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("indices %v", idx)
+	}
+	if p := unsafe.Slice(got.GetPalette(), 256); p[1] != pal[1] || p[200] != pal[200] {
+		t.Errorf("palette %v %v, want %v %v", p[1], p[200], pal[1], pal[200])
+	}
+	table := unsafe.Slice((*byte)(got.GetTransparencyTable()), got.GetTransparencyCount())
+	if !bytes.Equal(table, []byte{255, 0, 128}) || !got.IsTransparent() {
+		t.Errorf("transparency %v %v", table, got.IsTransparent())
+	}
+	if got.GetDotsPerMeterX() != 3937 || got.GetDotsPerMeterY() != 1000 {
+		t.Errorf("resolution %d x %d", got.GetDotsPerMeterX(), got.GetDotsPerMeterY())
+	}
+
+	for _, n := range []int{0, headerSize, len(b) - 1} {
+		if dib, err := unmarshalBitmap(b[:n]); err == nil {
+			dib.Unload()
+			t.Errorf("unmarshalBitmap of %d of %d bytes succeeded", n, len(b))
+		}
+	}
+	if s.Live() != 2 {
+		t.Errorf("Live() = %d, want 2", s.Live())
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package sandbox
+
+import (
+	"errors"
+	"runtime"
+)
+
+// LimitMemory limits the address space of the calling process to bytes,
+// so allocations past it fail instead of growing the process. It is only
+// supported on Linux and macOS.
+func LimitMemory(bytes uint64) error {
+	return errors.New("sandbox: memory limits are not supported on " + runtime.GOOS)
+}
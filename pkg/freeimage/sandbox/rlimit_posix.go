@@ -0,0 +1,12 @@
+//go:build linux || darwin
+
+package sandbox
+
+import "syscall"
+
+// LimitMemory limits the address space of the calling process to bytes,
+// so allocations past it fail instead of growing the process. The helper
+// calls it at startup with Options.MaxMemory.
+func LimitMemory(bytes uint64) error {
+	return syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: bytes, Max: bytes})
+}
@@ -0,0 +1,354 @@
+// Package sandbox decodes and encodes images in helper processes, so a
+// crash in a native plugin fails one request instead of the program, and a
+// stuck decode can be abandoned.
+//
+//	pool, err := sandbox.NewPool(sandbox.Options{Helper: "fihelper", Timeout: 10 * time.Second})
+//	dib, err := pool.Decode(data, nil)
+//
+// The helpers are cmd/fihelper processes, each running one request at a
+// time. A helper that crashes, or is killed when a request times out, is
+// replaced by a new one for the next request. Decoded bitmaps are copied
+// back into the default library of the calling process with their pixels,
+// palette, transparency and resolution; metadata, ICC profiles and
+// thumbnails are not carried.
+//
+// Unlike freeimage.Decode and Encode, the helpers only know the formats of
+// their library and plugin directory: Go plugins registered with
+// freeimage.RegisterPlugin in the calling process don't exist there. Run
+// fihelper with -qoi for the QOI plugin of package qoi. A Format forced in
+// DecodeOptions is sent as its number, so plugins registered in both
+// processes must be registered in the same order.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+var (
+	ErrCrashed = errors.New("sandbox: helper crashed")
+	ErrTimeout = errors.New("sandbox: request timed out")
+	ErrClosed  = errors.New("sandbox: pool closed")
+)
+
+// Options configures a Pool.
+type Options struct {
+	// Helper is the helper executable, "fihelper" searched in PATH if
+	// empty.
+	Helper string
+	// Args are passed to the helper, e.g. -lib to select the library.
+	Args []string
+	// Env is the environment of the helper, the one of the process if nil.
+	Env []string
+	// Size is the number of helpers, runtime.NumCPU() if 0.
+	Size int
+	// Timeout bounds each request, helpers still busy at the end are
+	// killed. 0 means no timeout besides the context.
+	Timeout time.Duration
+	// MaxMemory limits the address space of each helper in bytes, see
+	// LimitMemory. 0 means no limit. The Go runtime alone reserves about a
+	// GiB, so useful limits are a few GiB.
+	MaxMemory uint64
+}
+
+// Pool runs requests in up to Options.Size helper processes, started on
+// demand. It is safe for concurrent use.
+type Pool struct {
+	path string
+	opts Options
+
+	slots  chan struct{}
+	mu     sync.Mutex
+	idle   []*helper
+	closed bool
+}
+
+// NewPool returns a pool running opts.Helper. No helper is started until
+// the first request.
+func NewPool(opts Options) (*Pool, error) {
+	if opts.Helper == "" {
+		opts.Helper = "fihelper"
+	}
+	path, err := exec.LookPath(opts.Helper)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %w", err)
+	}
+	if opts.Size <= 0 {
+		opts.Size = runtime.NumCPU()
+	}
+	return &Pool{path: path, opts: opts, slots: make(chan struct{}, opts.Size)}, nil
+}
+
+// Decode is freeimage.Decode in a helper.
+func (p *Pool) Decode(data []byte, opts *freeimage.DecodeOptions) (*freeimage.BitMap, error) {
+	return p.DecodeContext(context.Background(), data, opts)
+}
+
+// DecodeContext is Decode with a context, the helper is killed if ctx is
+// done before the decode. Errors of opts.Limits match ErrTooLarge and
+// ErrFormatNotAllowed, but are not a *LimitError or *FormatError.
+func (p *Pool) DecodeContext(ctx context.Context, data []byte, opts *freeimage.DecodeOptions) (*freeimage.BitMap, error) {
+	if opts == nil {
+		opts = &freeimage.DecodeOptions{}
+	}
+	fif := freeimage.FIF_UNKNOWN // detected by the helper
+	if opts.Force {
+		fif = opts.Format
+	}
+	resp, err := p.do(ctx, opDecode, request(fif, opts.Flags), marshalLimits(opts.Limits), data)
+	if err != nil {
+		if errors.Is(err, ErrCrashed) {
+			err = fmt.Errorf("%w: %w", freeimage.ErrDecode, err)
+		}
+		return nil, err
+	}
+	return unmarshalBitmap(resp)
+}
+
+// Encode is freeimage.Encode in a helper.
+func (p *Pool) Encode(dib *freeimage.BitMap, fif freeimage.FREE_IMAGE_FORMAT, opts *freeimage.EncodeOptions) ([]byte, error) {
+	return p.EncodeContext(context.Background(), dib, fif, opts)
+}
+
+// EncodeContext is Encode with a context, the helper is killed if ctx is
+// done before the encode.
+func (p *Pool) EncodeContext(ctx context.Context, dib *freeimage.BitMap, fif freeimage.FREE_IMAGE_FORMAT, opts *freeimage.EncodeOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &freeimage.EncodeOptions{}
+	}
+	if dib == nil {
+		return nil, fmt.Errorf("%w: nil bitmap", freeimage.ErrEncode)
+	}
+	b, err := marshalBitmap(dib)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", freeimage.ErrEncode, err)
+	}
+	resp, err := p.do(ctx, opEncode, request(fif, opts.Flags), b)
+	if err != nil {
+		if errors.Is(err, ErrCrashed) {
+			err = fmt.Errorf("%w: %w", freeimage.ErrEncode, err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Close stops the idle helpers and makes later requests fail with
+// ErrClosed. Running requests finish, then their helpers stop.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+	for _, h := range idle {
+		h.stop()
+	}
+	return nil
+}
+
+func (p *Pool) do(ctx context.Context, op byte, parts ...[]byte) ([]byte, error) {
+	if p.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.Timeout)
+		defer cancel()
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, p.ctxErr(ctx)
+	}
+	defer func() { <-p.slots }()
+
+	h, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	status, resp, err := h.roundTrip(ctx, op, parts...)
+	p.put(h, err == nil)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return nil, p.ctxErr(ctx)
+	case err != nil:
+		return nil, err
+	case status != statusOK:
+		return nil, &remoteError{status: status, msg: string(resp)}
+	}
+	return resp, nil
+}
+
+func (p *Pool) ctxErr(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, ctx.Err())
+	}
+	return fmt.Errorf("sandbox: %w", ctx.Err())
+}
+
+// get returns an idle helper or starts one.
+func (p *Pool) get() (*helper, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(p.idle); n > 0 {
+		h := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return h, nil
+	}
+	p.mu.Unlock()
+
+	args := p.opts.Args
+	if p.opts.MaxMemory > 0 {
+		args = append(args[:len(args):len(args)], "-max-memory", strconv.FormatUint(p.opts.MaxMemory, 10))
+	}
+	return startHelper(p.path, args, p.opts.Env)
+}
+
+// put returns h to the idle helpers, or stops it if it is broken or the
+// pool is closed.
+func (p *Pool) put(h *helper, ok bool) {
+	p.mu.Lock()
+	if ok && !p.closed {
+		p.idle = append(p.idle, h)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	h.stop()
+}
+
+// helper is a running helper process.
+type helper struct {
+	cmd    *exec.Cmd
+	w, r   *os.File // its stdin and stdout
+	stderr *tail
+	exited chan struct{}
+	err    error // of Wait, set when exited is closed
+}
+
+func startHelper(path string, args, env []string) (*helper, error) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		return nil, err
+	}
+	h := &helper{w: inW, r: outR, stderr: &tail{}, exited: make(chan struct{})}
+	h.cmd = exec.Command(path, args...)
+	h.cmd.Env = env
+	h.cmd.Stdin, h.cmd.Stdout, h.cmd.Stderr = inR, outW, h.stderr
+	err = h.cmd.Start()
+	inR.Close()
+	outW.Close()
+	if err != nil {
+		inW.Close()
+		outR.Close()
+		return nil, fmt.Errorf("sandbox: starting helper: %w", err)
+	}
+	go func() {
+		h.err = h.cmd.Wait()
+		close(h.exited)
+	}()
+	return h, nil
+}
+
+// roundTrip sends a request and reads the response. The helper is killed
+// if ctx is done first, and must not be reused after an error.
+func (h *helper) roundTrip(ctx context.Context, op byte, parts ...[]byte) (byte, []byte, error) {
+	stop, watched := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+			h.cmd.Process.Kill()
+		case <-stop:
+		}
+	}()
+	status, resp, err := h.exchange(op, parts...)
+	close(stop)
+	<-watched
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err() // answered, but maybe killed since
+	}
+	return status, resp, err
+}
+
+func (h *helper) exchange(op byte, parts ...[]byte) (byte, []byte, error) {
+	if err := writeFrame(h.w, op, parts...); err != nil {
+		return 0, nil, h.crashed(err)
+	}
+	status, resp, err := readFrame(h.r)
+	if err != nil {
+		return 0, nil, h.crashed(err)
+	}
+	return status, resp, nil
+}
+
+// crashed returns the error of a helper that failed with err, with its
+// exit status and the end of its output once it has exited.
+func (h *helper) crashed(err error) error {
+	select {
+	case <-h.exited:
+	case <-time.After(time.Second):
+		h.cmd.Process.Kill()
+		<-h.exited
+	}
+	if h.err != nil {
+		err = h.err
+	}
+	if msg := h.stderr.String(); msg != "" {
+		return fmt.Errorf("%w: %v: %s", ErrCrashed, err, msg)
+	}
+	return fmt.Errorf("%w: %v", ErrCrashed, err)
+}
+
+// stop closes the stdin of the helper, which ends it, and kills it if it
+// doesn't exit soon.
+func (h *helper) stop() {
+	h.w.Close()
+	select {
+	case <-h.exited:
+	case <-time.After(time.Second):
+		h.cmd.Process.Kill()
+		<-h.exited
+	}
+	h.r.Close()
+}
+
+// tail keeps the last bytes written to it.
+type tail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+const tailSize = 1024
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > tailSize {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-tailSize:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
@@ -0,0 +1,238 @@
+package sandbox_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/qoi"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/sandbox"
+)
+
+// The test binary is its own helper when SANDBOX_TEST_LIB is set, doing
+// what cmd/fihelper does with the library at that path, or runs the fuzz
+// target named by SANDBOX_TEST_FUZZ once, see runIsolated.
+func TestMain(m *testing.M) {
+	if lib := os.Getenv("SANDBOX_TEST_LIB"); lib != "" {
+		run := helper
+		if target := os.Getenv("SANDBOX_TEST_FUZZ"); target != "" {
+			run = func(lib string) error { return fuzzTarget(target, lib) }
+		}
+		if err := run(lib); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func helper(lib string) error {
+	fs := flag.NewFlagSet("helper", flag.ContinueOnError)
+	maxMemory := fs.Uint64("max-memory", 0, "")
+	useQOI := fs.Bool("qoi", false, "")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+	if *maxMemory > 0 {
+		if err := sandbox.LimitMemory(*maxMemory); err != nil {
+			return err
+		}
+	}
+	if err := useLibrary(lib); err != nil {
+		return err
+	}
+	if *useQOI {
+		if _, err := qoi.Register(); err != nil {
+			return err
+		}
+	}
+	return sandbox.Serve(os.Stdin, os.Stdout)
+}
+
+func useLibrary(path string) error {
+	l, err := freeimage.Open(path)
+	if err != nil {
+		return err
+	}
+	freeimage.SetDefault(l)
+	l.Initialise(false)
+	return nil
+}
+
+// newPool returns a pool of stub helpers, with the stub as the default
+// library of the test too.
+func newPool(t *testing.T, opts sandbox.Options) (*sandbox.Pool, *fitest.Stub) {
+	t.Helper()
+	s := fitest.NewStub(t)
+	return startPool(t, s.Library, opts), s
+}
+
+// startPool returns a pool of helpers using l.
+func startPool(t testing.TB, l *freeimage.Library, opts sandbox.Options) *sandbox.Pool {
+	t.Helper()
+	opts.Helper = os.Args[0]
+	opts.Env = append(os.Environ(), "SANDBOX_TEST_LIB="+l.Path())
+	p, err := sandbox.NewPool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+var (
+	image = fitest.Bytes(5, 3, 24, func(x, y, ch int) byte { return byte(40*y + 8*x + ch) })
+	crash = fitest.Bytes(1, 1, -1, nil) // the stub crashes loading a bpp of -1
+	hang  = fitest.Bytes(1, 1, -2, nil) // and hangs with -2
+)
+
+func roundTrip(t *testing.T, p *sandbox.Pool) {
+	t.Helper()
+	dib, err := p.Decode(image, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dib.Unload()
+	if dib.GetWidth() != 5 || dib.GetHeight() != 3 || dib.GetBPP() != 24 {
+		t.Fatalf("decoded %dx%d %d bit", dib.GetWidth(), dib.GetHeight(), dib.GetBPP())
+	}
+	out, err := p.Encode(dib, fitest.FIF_STUB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, image) {
+		t.Errorf("Encode(Decode(image)) = %x, want %x", out, image)
+	}
+}
+
+func TestPool(t *testing.T) {
+	p, s := newPool(t, sandbox.Options{Size: 2})
+	roundTrip(t, p)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dib, err := p.Decode(image, &freeimage.DecodeOptions{Format: fitest.FIF_STUB, Force: true})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			dib.Unload()
+		}()
+	}
+	wg.Wait()
+
+	if _, err := p.Decode([]byte("not an image"), nil); !errors.Is(err, freeimage.ErrUnknownFormat) {
+		t.Errorf("Decode garbage = %v, want ErrUnknownFormat", err)
+	}
+	if _, err := p.Decode(nil, nil); !errors.Is(err, freeimage.ErrDecode) {
+		t.Errorf("Decode(nil) = %v, want ErrDecode", err)
+	}
+	dib := freeimage.Allocate(2, 2, 8, 0, 0, 0)
+	defer dib.Unload()
+	if _, err := p.Encode(dib, freeimage.FIF_PNG, nil); !errors.Is(err, freeimage.ErrEncode) {
+		t.Errorf("Encode to an unsupported format = %v, want ErrEncode", err)
+	}
+	if s.Live() != 1 {
+		t.Errorf("Live() = %d, want 1", s.Live())
+	}
+
+	p.Close()
+	if _, err := p.Decode(image, nil); !errors.Is(err, sandbox.ErrClosed) {
+		t.Errorf("Decode after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestGoPlugins(t *testing.T) {
+	data, err := qoi.Encode(4, 3, 3, 0, make([]byte, 4*3*3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the Go plugins of the caller don't exist in the helpers
+	p, _ := newPool(t, sandbox.Options{Size: 1})
+	if _, err := p.Decode(data, nil); !errors.Is(err, freeimage.ErrUnknownFormat) {
+		t.Errorf("Decode of QOI without -qoi = %v, want ErrUnknownFormat", err)
+	}
+
+	p = startPool(t, freeimage.Default(), sandbox.Options{Size: 1, Args: []string{"-qoi"}})
+	dib, err := p.Decode(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dib.Unload()
+	if dib.GetWidth() != 4 || dib.GetHeight() != 3 || dib.GetBPP() != 24 {
+		t.Errorf("decoded %dx%d %d bit", dib.GetWidth(), dib.GetHeight(), dib.GetBPP())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	p, _ := newPool(t, sandbox.Options{Size: 1})
+	for _, tc := range []struct {
+		limits *freeimage.LoadLimits
+		want   error
+	}{
+		{&freeimage.LoadLimits{MaxWidth: 4}, freeimage.ErrTooLarge},
+		{&freeimage.LoadLimits{AllowedFormats: []freeimage.FREE_IMAGE_FORMAT{}}, freeimage.ErrFormatNotAllowed},
+		{&freeimage.LoadLimits{MaxPixels: 15, AllowedFormats: []freeimage.FREE_IMAGE_FORMAT{fitest.FIF_STUB}}, nil},
+		{&freeimage.DefaultLoadLimits, nil},
+	} {
+		dib, err := p.Decode(image, &freeimage.DecodeOptions{Limits: tc.limits})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("Decode with %+v = %v, want %v", tc.limits, err, tc.want)
+		}
+		if err == nil {
+			dib.Unload()
+		}
+	}
+}
+
+func TestCrash(t *testing.T) {
+	p, _ := newPool(t, sandbox.Options{Size: 1})
+	_, err := p.Decode(crash, nil)
+	if !errors.Is(err, sandbox.ErrCrashed) || !errors.Is(err, freeimage.ErrDecode) {
+		t.Fatalf("Decode(crash) = %v, want ErrCrashed and ErrDecode", err)
+	}
+	roundTrip(t, p) // on a new helper
+}
+
+func TestTimeout(t *testing.T) {
+	p, _ := newPool(t, sandbox.Options{Size: 1, Timeout: 200 * time.Millisecond})
+	start := time.Now()
+	_, err := p.Decode(hang, nil)
+	if !errors.Is(err, sandbox.ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Decode(hang) = %v, want ErrTimeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("timed out after %v", d)
+	}
+	roundTrip(t, p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := p.DecodeContext(ctx, hang, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("DecodeContext(hang) = %v, want context.Canceled", err)
+	}
+	roundTrip(t, p)
+}
+
+func TestMaxMemory(t *testing.T) {
+	p, _ := newPool(t, sandbox.Options{Size: 1, MaxMemory: 4 << 30})
+	roundTrip(t, p)
+	big := fitest.Bytes(1, 1, 32, func(x, y, ch int) byte { return 0 })
+	copy(big[4:], []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x80, 0x00, 0x00}) // 65536x32768, 8 GiB
+	if _, err := p.Decode(big, nil); !errors.Is(err, freeimage.ErrDecode) {
+		t.Errorf("Decode of an 8 GiB bitmap = %v, want ErrDecode", err)
+	}
+	roundTrip(t, p)
+}
@@ -0,0 +1,115 @@
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+// Serve answers the requests of a Pool read from r on w until r ends, with
+// the default library. It is the loop of the helper command, cmd/fihelper,
+// and returns nil when r ends between requests.
+func Serve(r io.Reader, w io.Writer) error {
+	br, bw := bufio.NewReader(r), bufio.NewWriter(w)
+	for {
+		op, body, err := readFrame(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		status, resp := handle(op, body)
+		if err := writeFrame(bw, status, resp); err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func handle(op byte, body []byte) (byte, []byte) {
+	format, flags, data, err := parseRequest(body)
+	if err != nil {
+		return statusError, []byte(err.Error())
+	}
+	switch op {
+	case opDecode:
+		limits, data, err := parseLimits(data)
+		if err != nil {
+			return statusError, []byte(err.Error())
+		}
+		dib, err := freeimage.Decode(data, &freeimage.DecodeOptions{
+			Format: format,
+			Force:  format != freeimage.FIF_UNKNOWN,
+			Flags:  flags,
+			Limits: limits,
+		})
+		if err != nil {
+			return errorStatus(err), []byte(err.Error())
+		}
+		defer dib.Unload()
+		b, err := marshalBitmap(dib)
+		if err != nil {
+			return statusDecode, []byte(err.Error())
+		}
+		return statusOK, b
+	case opEncode:
+		dib, err := unmarshalBitmap(data)
+		if err != nil {
+			return statusEncode, []byte(err.Error())
+		}
+		defer dib.Unload()
+		b, err := freeimage.Encode(dib, format, &freeimage.EncodeOptions{Flags: flags})
+		if err != nil {
+			return errorStatus(err), []byte(err.Error())
+		}
+		return statusOK, b
+	}
+	return statusError, []byte(fmt.Sprintf("sandbox: unknown op %d", op))
+}
+
+func errorStatus(err error) byte {
+	switch {
+	case errors.Is(err, freeimage.ErrTooLarge):
+		return statusTooLarge
+	case errors.Is(err, freeimage.ErrFormatNotAllowed):
+		return statusFormatNotAllowed
+	case errors.Is(err, freeimage.ErrUnknownFormat):
+		return statusUnknownFormat
+	case errors.Is(err, freeimage.ErrDecode):
+		return statusDecode
+	case errors.Is(err, freeimage.ErrEncode):
+		return statusEncode
+	}
+	return statusError
+}
+
+// remoteError is an error returned by the helper. It matches the
+// freeimage error of its status with errors.Is.
+type remoteError struct {
+	status byte
+	msg    string
+}
+
+func (e *remoteError) Error() string { return e.msg }
+
+func (e *remoteError) Unwrap() error {
+	switch e.status {
+	case statusUnknownFormat:
+		return freeimage.ErrUnknownFormat
+	case statusDecode:
+		return freeimage.ErrDecode
+	case statusEncode:
+		return freeimage.ErrEncode
+	case statusTooLarge:
+		return freeimage.ErrTooLarge
+	case statusFormatNotAllowed:
+		return freeimage.ErrFormatNotAllowed
+	}
+	return nil
+}
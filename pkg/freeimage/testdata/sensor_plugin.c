@@ -0,0 +1,47 @@
+// A FreeImage plugin for TestPluginDir: the "SENSOR" format, files starting
+// with "SNSR", that it only recognizes.
+
+#include <string.h>
+
+typedef int BOOL;
+typedef void *fi_handle;
+
+typedef struct {
+	unsigned (*read_proc)(void *buffer, unsigned size, unsigned count, fi_handle handle);
+	unsigned (*write_proc)(void *buffer, unsigned size, unsigned count, fi_handle handle);
+	int (*seek_proc)(fi_handle handle, long offset, int origin);
+	long (*tell_proc)(fi_handle handle);
+} FreeImageIO;
+
+typedef struct {
+	const char *(*format_proc)(void);
+	const char *(*description_proc)(void);
+	const char *(*extension_proc)(void);
+	const char *(*regexpr_proc)(void);
+	void *(*open_proc)(FreeImageIO *io, fi_handle handle, BOOL read);
+	void (*close_proc)(FreeImageIO *io, fi_handle handle, void *data);
+	void *pagecount_proc, *pagecapability_proc, *load_proc, *save_proc;
+	BOOL (*validate_proc)(FreeImageIO *io, fi_handle handle);
+	const char *(*mime_proc)(void);
+	void *supports_export_bpp_proc, *supports_export_type_proc;
+	void *supports_icc_profiles_proc, *supports_no_pixels_proc;
+} Plugin;
+
+static const char *format(void) { return "SENSOR"; }
+static const char *description(void) { return "test sensor format"; }
+static const char *extension(void) { return "snsr"; }
+static const char *mime(void) { return "image/x-sensor"; }
+
+static BOOL validate(FreeImageIO *io, fi_handle handle) {
+	char magic[4];
+	return io->read_proc(magic, 1, 4, handle) == 4 && memcmp(magic, "SNSR", 4) == 0;
+}
+
+__attribute__((visibility("default"))) void Init(Plugin *plugin, int format_id) {
+	(void)format_id;
+	plugin->format_proc = format;
+	plugin->description_proc = description;
+	plugin->extension_proc = extension;
+	plugin->mime_proc = mime;
+	plugin->validate_proc = validate;
+}
@@ -0,0 +1,235 @@
+package freeimage
+
+import (
+	"expvar"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/jinzhongmin/goffi/pkg/c"
+	"github.com/jinzhongmin/usf"
+)
+
+// Kinds of native resources recorded by the tracker.
+const (
+	KindBitMap      = "BitMap"
+	KindMultiBitMap = "MultiBitMap"
+	KindMemory      = "Memory"
+	KindTag         = "Tag"
+)
+
+// trackCreate lists the functions returning a resource the caller owns.
+var trackCreate = map[string]string{
+	"FreeImage_Allocate":                  KindBitMap,
+	"FreeImage_AllocateT":                 KindBitMap,
+	"FreeImage_AllocateEx":                KindBitMap,
+	"FreeImage_AllocateExT":               KindBitMap,
+	"FreeImage_AllocateHeader":            KindBitMap,
+	"FreeImage_Clone":                     KindBitMap,
+	"FreeImage_Load":                      KindBitMap,
+	"FreeImage_LoadU":                     KindBitMap,
+	"FreeImage_LoadFromHandle":            KindBitMap,
+	"FreeImage_LoadFromMemory":            KindBitMap,
+	"FreeImage_ConvertTo4Bits":            KindBitMap,
+	"FreeImage_ConvertTo8Bits":            KindBitMap,
+	"FreeImage_ConvertToGreyscale":        KindBitMap,
+	"FreeImage_ConvertTo16Bits555":        KindBitMap,
+	"FreeImage_ConvertTo16Bits565":        KindBitMap,
+	"FreeImage_ConvertTo24Bits":           KindBitMap,
+	"FreeImage_ConvertTo32Bits":           KindBitMap,
+	"FreeImage_ColorQuantize":             KindBitMap,
+	"FreeImage_ColorQuantizeEx":           KindBitMap,
+	"FreeImage_Threshold":                 KindBitMap,
+	"FreeImage_Dither":                    KindBitMap,
+	"FreeImage_ConvertFromRawBits":        KindBitMap,
+	"FreeImage_ConvertFromRawBitsEx":      KindBitMap,
+	"FreeImage_ConvertToFloat":            KindBitMap,
+	"FreeImage_ConvertToRGBF":             KindBitMap,
+	"FreeImage_ConvertToRGBAF":            KindBitMap,
+	"FreeImage_ConvertToUINT16":           KindBitMap,
+	"FreeImage_ConvertToRGB16":            KindBitMap,
+	"FreeImage_ConvertToRGBA16":           KindBitMap,
+	"FreeImage_ConvertToStandardType":     KindBitMap,
+	"FreeImage_ConvertToType":             KindBitMap,
+	"FreeImage_ToneMapping":               KindBitMap,
+	"FreeImage_TmoDrago03":                KindBitMap,
+	"FreeImage_TmoReinhard05":             KindBitMap,
+	"FreeImage_TmoReinhard05Ex":           KindBitMap,
+	"FreeImage_TmoFattal02":               KindBitMap,
+	"FreeImage_Rotate":                    KindBitMap,
+	"FreeImage_RotateEx":                  KindBitMap,
+	"FreeImage_Rescale":                   KindBitMap,
+	"FreeImage_RescaleRect":               KindBitMap,
+	"FreeImage_MakeThumbnail":             KindBitMap,
+	"FreeImage_GetChannel":                KindBitMap,
+	"FreeImage_GetComplexChannel":         KindBitMap,
+	"FreeImage_Copy":                      KindBitMap,
+	"FreeImage_CreateView":                KindBitMap,
+	"FreeImage_Composite":                 KindBitMap,
+	"FreeImage_EnlargeCanvas":             KindBitMap,
+	"FreeImage_MultigridPoissonSolver":    KindBitMap,
+	"FreeImage_OpenMultiBitmap":           KindMultiBitMap,
+	"FreeImage_LoadMultiBitmapFromMemory": KindMultiBitMap,
+	"FreeImage_OpenMemory":                KindMemory,
+	"FreeImage_CreateTag":                 KindTag,
+	"FreeImage_CloneTag":                  KindTag,
+}
+
+// trackRelease lists the functions releasing their first argument.
+var trackRelease = map[string]string{
+	"FreeImage_Unload":           KindBitMap,
+	"FreeImage_CloseMultiBitmap": KindMultiBitMap,
+	"FreeImage_CloseMemory":      KindMemory,
+	"FreeImage_DeleteTag":        KindTag,
+}
+
+// Resource is a live native object created through the wrappers.
+type Resource struct {
+	Kind    string
+	Func    string // FreeImage function that created it
+	Ptr     uintptr
+	Size    int64 // GetMemorySize for bitmaps, 0 otherwise
+	Created time.Time
+	pcs     []uintptr
+}
+
+// Stack returns the Go stack that created the resource.
+func (r *Resource) Stack() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(r.pcs)
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+type tracker struct {
+	mu    sync.Mutex
+	live  map[unsafe.Pointer]*Resource
+	count map[string]int64
+	bytes int64
+}
+
+var (
+	tracking    atomic.Bool
+	resources   = &tracker{live: map[unsafe.Pointer]*Resource{}, count: map[string]int64{}}
+	publishOnce sync.Once
+)
+
+// EnableTracking turns the native resource tracker on or off. Tracking
+// costs a map update, a stack capture and for bitmaps a GetMemorySize call
+// per created object. The first call publishes the "freeimage" expvar.
+//
+// Resources created while tracking is off are unknown to the tracker, so
+// enable it before any image is created.
+func EnableTracking(on bool) {
+	publishOnce.Do(func() {
+		expvar.Publish("freeimage", expvar.Func(func() interface{} { return TrackingStats() }))
+	})
+	tracking.Store(on)
+}
+
+// TrackingStats reports the live resources per kind and the native bytes
+// held by live bitmaps.
+func TrackingStats() map[string]int64 {
+	resources.mu.Lock()
+	defer resources.mu.Unlock()
+	stats := map[string]int64{"bytes": resources.bytes}
+	for _, k := range []string{KindBitMap, KindMultiBitMap, KindMemory, KindTag} {
+		stats[k] = resources.count[k]
+	}
+	return stats
+}
+
+// LiveResources returns the tracked resources not released yet, oldest
+// first.
+func LiveResources() []Resource {
+	resources.mu.Lock()
+	list := make([]Resource, 0, len(resources.live))
+	for _, r := range resources.live {
+		list = append(list, *r)
+	}
+	resources.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// LeakCheck returns an error describing every live tracked resource, with
+// the stack that created it, or nil if there are none.
+//
+// e.g.,
+//
+//	freeimage.EnableTracking(true)
+//	defer func() {
+//		if err := freeimage.LeakCheck(); err != nil {
+//			t.Error(err)
+//		}
+//	}()
+func LeakCheck() error {
+	live := LiveResources()
+	if len(live) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "freeimage: %d native resources not released", len(live))
+	for i := range live {
+		r := &live[i]
+		fmt.Fprintf(&b, "\n%s %#x (%d bytes) from %s, created at:\n%s", r.Kind, r.Ptr, r.Size, r.Func, r.Stack())
+	}
+	return fmt.Errorf("%s", b.String())
+}
+
+// firstHandle returns the handle the first argument points to.
+func firstHandle(args []interface{}) unsafe.Pointer {
+	if len(args) == 0 || args[0] == nil {
+		return nil
+	}
+	return *(*unsafe.Pointer)(usf.AddrOf(args[0]))
+}
+
+func (l *Library) trackBefore(fp *c.FuncPrototype, args []interface{}) {
+	kind, ok := trackRelease[fp.Name]
+	if !ok {
+		return
+	}
+	p := firstHandle(args)
+	if p == nil {
+		return
+	}
+	resources.mu.Lock()
+	if r := resources.live[p]; r != nil && r.Kind == kind {
+		delete(resources.live, p)
+		resources.count[kind]--
+		resources.bytes -= r.Size
+	}
+	resources.mu.Unlock()
+}
+
+func (l *Library) trackAfter(fp *c.FuncPrototype, v *c.Value) {
+	kind, ok := trackCreate[fp.Name]
+	if !ok || v == nil || v.Ptr() == nil {
+		return
+	}
+	p := v.Ptr()
+	r := &Resource{Kind: kind, Func: strings.TrimPrefix(fp.Name, "FreeImage_"), Ptr: uintptr(p), Created: time.Now()}
+	if kind == KindBitMap {
+		r.Size = int64(l.call(_func_FreeImage_GetMemorySize_, inArgs{&p}).U32Free())
+	}
+	pcs := make([]uintptr, 32)
+	r.pcs = pcs[:runtime.Callers(4, pcs)]
+
+	resources.mu.Lock()
+	resources.live[p] = r
+	resources.count[kind]++
+	resources.bytes += r.Size
+	resources.mu.Unlock()
+}
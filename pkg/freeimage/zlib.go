@@ -0,0 +1,249 @@
+package freeimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"runtime"
+)
+
+var (
+	ErrZLib             = errors.New("freeimage: zlib error")
+	ErrUncompressedSize = errors.New("freeimage: uncompressed data exceeds the size limit")
+)
+
+// DefaultMaxUncompressed bounds Uncompress and GUnzip when they are given a
+// max size <= 0.
+const DefaultMaxUncompressed = 256 << 20
+
+// compressBound is the zlib worst case: 0.1% + 12 bytes, plus room for
+// the gzip header and trailer.
+func compressBound(n int) int {
+	return n + n/1000 + 12 + 32
+}
+
+type zlibFunc func(target []byte, target_size uint32, source []byte, source_size uint32) uint32
+
+func zlibCall(fn zlibFunc, target, source []byte) int {
+	n := fn(target, uint32(len(target)), source, uint32(len(source)))
+	runtime.KeepAlive(target)
+	runtime.KeepAlive(source)
+	return int(n)
+}
+
+func compress(fn zlibFunc, src []byte) ([]byte, error) {
+	if uint64(len(src)) > math.MaxUint32/2 {
+		return nil, fmt.Errorf("%w: source too large", ErrZLib)
+	}
+	dst := make([]byte, compressBound(len(src)))
+	n := zlibCall(fn, dst, src)
+	if n == 0 {
+		return nil, ErrZLib
+	}
+	return dst[:n], nil
+}
+
+// maxDeflateRatio bounds what deflate data can expand to, about 1032:1, so
+// a larger target never helps.
+const maxDeflateRatio = 1032
+
+// maxUncompressTries caps the targets uncompress tries.
+const maxUncompressTries = 6
+
+// uncompress retries with a larger target while the library fails, since
+// FreeImage reports a too small target and corrupt data the same way. The
+// first target is size if it's plausible for src, else a small multiple of
+// len(src), and no target exceeds max or the deflate ratio of src. pack is
+// the matching compressor, see emptyStream.
+func uncompress(fn, pack zlibFunc, src []byte, size, max int) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, fmt.Errorf("%w: empty source", ErrZLib)
+	}
+	if max <= 0 {
+		max = DefaultMaxUncompressed
+	}
+	limit := max
+	if len(src) < limit/maxDeflateRatio {
+		limit = len(src) * maxDeflateRatio
+	}
+	if size <= 0 || size > limit {
+		size = 4 * len(src)
+	}
+	if size < 1024 {
+		size = 1024
+	}
+	for try := 0; try < maxUncompressTries; try++ {
+		if size > limit {
+			size = limit
+		}
+		dst := make([]byte, size)
+		if n := zlibCall(fn, dst, src); n > 0 {
+			return dst[:n], nil
+		}
+		if size == limit {
+			break
+		}
+		size *= 8
+	}
+	if emptyStream(pack, src) {
+		return []byte{}, nil
+	}
+	if size < max {
+		return nil, fmt.Errorf("%w: corrupt data", ErrZLib)
+	}
+	return nil, fmt.Errorf("%w or the data is corrupt (limit %d bytes)", ErrUncompressedSize, max)
+}
+
+// emptyStream reports whether src is what pack makes of no data. FreeImage
+// returns 0 for an empty result as for an error.
+func emptyStream(pack zlibFunc, src []byte) bool {
+	if len(src) > compressBound(0) {
+		return false
+	}
+	empty, err := compress(pack, nil)
+	return err == nil && bytes.Equal(src, empty)
+}
+
+// Compress returns src compressed in the zlib format.
+func Compress(src []byte) ([]byte, error) {
+	return compress(ZLibCompress, src)
+}
+
+// Uncompress returns the zlib compressed src uncompressed. The output buffer
+// grows up to max bytes, DefaultMaxUncompressed if max <= 0.
+func Uncompress(src []byte, max int) ([]byte, error) {
+	return uncompress(ZLibUncompress, ZLibCompress, src, 0, max)
+}
+
+// GZip returns src compressed in the gzip format.
+func GZip(src []byte) ([]byte, error) {
+	return compress(ZLibGZip, src)
+}
+
+// GUnzip returns the gzip compressed src uncompressed, see Uncompress. The
+// size recorded in the gzip trailer is the first guess, if src can expand
+// to it.
+func GUnzip(src []byte, max int) ([]byte, error) {
+	size := 0
+	if len(src) >= 18 {
+		size = int(binary.LittleEndian.Uint32(src[len(src)-4:]))
+	}
+	return uncompress(ZLibGUnzip, ZLibGZip, src, size, max)
+}
+
+// streaming adapters -------------------------------------------------------
+
+// FreeImage only exposes one-shot zlib calls, the adapters below buffer
+// the whole stream in memory.
+
+type zlibWriter struct {
+	w      io.Writer
+	buf    bytes.Buffer
+	fn     func([]byte) ([]byte, error)
+	closed bool
+}
+
+// NewZLibWriter returns a writer compressing everything written to it in
+// the zlib format. The compressed data is written to w on Close.
+func NewZLibWriter(w io.Writer) io.WriteCloser {
+	return &zlibWriter{w: w, fn: Compress}
+}
+
+// NewGZipWriter is NewZLibWriter for the gzip format.
+func NewGZipWriter(w io.Writer) io.WriteCloser {
+	return &zlibWriter{w: w, fn: GZip}
+}
+
+func (z *zlibWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("freeimage: write to closed zlib writer")
+	}
+	return z.buf.Write(p)
+}
+
+func (z *zlibWriter) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	out, err := z.fn(z.buf.Bytes())
+	z.buf.Reset()
+	if err != nil {
+		return err
+	}
+	_, err = z.w.Write(out)
+	return err
+}
+
+// NewZLibReader reads all of r, uncompresses it and returns a reader over
+// the result, see Uncompress for max.
+func NewZLibReader(r io.Reader, max int) (io.Reader, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	out, err := Uncompress(src, max)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(out), nil
+}
+
+// NewGZipReader is NewZLibReader for the gzip format.
+func NewGZipReader(r io.Reader, max int) (io.Reader, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	out, err := GUnzip(src, max)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(out), nil
+}
+
+// crc32 ----------------------------------------------------------------------
+
+type crc32Hash struct {
+	crc uint32
+}
+
+// NewCRC32 returns a hash.Hash32 computing the IEEE CRC-32 with
+// ZLibCRC32, the checksums equal the ones of hash/crc32.NewIEEE.
+func NewCRC32() hash.Hash32 {
+	return &crc32Hash{}
+}
+
+// CRC32 returns the IEEE CRC-32 checksum of data.
+func CRC32(data []byte) uint32 {
+	h := crc32Hash{}
+	h.Write(data)
+	return h.crc
+}
+
+func (h *crc32Hash) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p
+		if uint64(len(chunk)) > math.MaxUint32 {
+			chunk = chunk[:math.MaxUint32]
+		}
+		h.crc = ZLibCRC32(h.crc, chunk, uint32(len(chunk)))
+		runtime.KeepAlive(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+func (h *crc32Hash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, h.crc)
+}
+
+func (h *crc32Hash) Sum32() uint32  { return h.crc }
+func (h *crc32Hash) Reset()         { h.crc = 0 }
+func (h *crc32Hash) Size() int      { return 4 }
+func (h *crc32Hash) BlockSize() int { return 1 }
@@ -0,0 +1,214 @@
+package freeimage_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	fi "github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage/fitest"
+)
+
+// zlibData is larger than a stored block.
+var zlibData = bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 2000)
+
+// stored compresses data with NoCompression, the stub only inflates stored
+// blocks.
+func stored(t *testing.T, gz bool, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.WriteCloser
+	if gz {
+		w, _ = gzip.NewWriterLevel(&b, gzip.NoCompression)
+	} else {
+		w, _ = zlib.NewWriterLevel(&b, zlib.NoCompression)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestCompress(t *testing.T) {
+	fitest.NewStub(t)
+	for _, tc := range []struct {
+		name   string
+		fn     func([]byte) ([]byte, error)
+		reader func(io.Reader) (io.Reader, error)
+	}{
+		{"zlib", fi.Compress, func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+		{"gzip", fi.GZip, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	} {
+		b, err := tc.fn(zlibData)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		r, err := tc.reader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if out, err := io.ReadAll(r); err != nil || !bytes.Equal(out, zlibData) {
+			t.Errorf("%s: read back %d bytes: %v", tc.name, len(out), err)
+		}
+	}
+}
+
+func TestUncompress(t *testing.T) {
+	s := fitest.NewStub(t)
+	for _, tc := range []struct {
+		name string
+		gz   bool
+		fn   func([]byte, int) ([]byte, error)
+		call string
+	}{
+		{"zlib", false, fi.Uncompress, "ZLibUncompress"},
+		{"gzip", true, fi.GUnzip, "ZLibGUnzip"},
+	} {
+		src := stored(t, tc.gz, zlibData)
+		s.Reset()
+		out, err := tc.fn(src, 0)
+		if err != nil || !bytes.Equal(out, zlibData) {
+			t.Errorf("%s: %d bytes: %v", tc.name, len(out), err)
+		}
+		if n := s.Called(tc.call); n != 1 {
+			t.Errorf("%s: %d tries", tc.name, n)
+		}
+
+		if _, err := tc.fn(src, len(zlibData)-1); !errors.Is(err, fi.ErrUncompressedSize) {
+			t.Errorf("%s over max: %v", tc.name, err)
+		}
+
+		// corrupt data fails after a few tries, bounded by the deflate ratio
+		corrupt := append([]byte(nil), src...)
+		corrupt[len(corrupt)-5] ^= 1
+		s.Reset()
+		if _, err := tc.fn(corrupt, 0); !errors.Is(err, fi.ErrZLib) {
+			t.Errorf("%s corrupt: %v", tc.name, err)
+		}
+		if n := s.Called(tc.call); n < 2 || n > 6 {
+			t.Errorf("%s corrupt: %d tries", tc.name, n)
+		}
+
+		if _, err := tc.fn(nil, 0); !errors.Is(err, fi.ErrZLib) {
+			t.Errorf("%s empty: %v", tc.name, err)
+		}
+	}
+}
+
+func TestGUnzipSize(t *testing.T) {
+	s := fitest.NewStub(t)
+	src := stored(t, true, []byte("hello"))
+
+	// a trailer claiming 4 GiB is not trusted for 30 bytes of input
+	huge := append([]byte(nil), src...)
+	binary.LittleEndian.PutUint32(huge[len(huge)-4:], 0xffffffff)
+	s.Reset()
+	if _, err := fi.GUnzip(huge, 1<<30); !errors.Is(err, fi.ErrZLib) || errors.Is(err, fi.ErrUncompressedSize) {
+		t.Errorf("lying trailer: %v", err)
+	}
+	if n := s.Called("ZLibGUnzip"); n > 6 {
+		t.Errorf("lying trailer: %d tries", n)
+	}
+
+	// with a plausible size, the first guess is exact
+	data := bytes.Repeat([]byte{1}, 5000)
+	out, err := fi.GUnzip(stored(t, true, data), 0)
+	if err != nil || !bytes.Equal(out, data) || cap(out) != len(data) {
+		t.Errorf("GUnzip: %d bytes, cap %d: %v", len(out), cap(out), err)
+	}
+}
+
+func TestZLibStreams(t *testing.T) {
+	fitest.NewStub(t)
+	var b bytes.Buffer
+	w := fi.NewGZipWriter(&b)
+	w.Write(zlibData[:100])
+	w.Write(zlibData[100:])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte{1}); err == nil {
+		t.Error("write after Close succeeded")
+	}
+	r, err := fi.NewGZipReader(&b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := io.ReadAll(r); !bytes.Equal(out, zlibData) {
+		t.Errorf("gzip stream: %d bytes", len(out))
+	}
+
+	b.Reset()
+	w = fi.NewZLibWriter(&b)
+	w.Write(zlibData)
+	w.Close()
+	if r, err = fi.NewZLibReader(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := io.ReadAll(r); !bytes.Equal(out, zlibData) {
+		t.Errorf("zlib stream: %d bytes", len(out))
+	}
+
+	// FreeImage returns 0 for an empty result as for an error
+	for _, tc := range []struct {
+		name   string
+		writer func(io.Writer) io.WriteCloser
+		reader func(io.Reader, int) (io.Reader, error)
+		pack   func([]byte) ([]byte, error)
+		unpack func([]byte, int) ([]byte, error)
+	}{
+		{"zlib", fi.NewZLibWriter, fi.NewZLibReader, fi.Compress, fi.Uncompress},
+		{"gzip", fi.NewGZipWriter, fi.NewGZipReader, fi.GZip, fi.GUnzip},
+	} {
+		b.Reset()
+		if err := tc.writer(&b).Close(); err != nil {
+			t.Fatalf("%s: empty stream: %v", tc.name, err)
+		}
+		r, err := tc.reader(&b, 0)
+		if err != nil {
+			t.Fatalf("%s: empty stream: %v", tc.name, err)
+		}
+		if out, err := io.ReadAll(r); err != nil || len(out) != 0 {
+			t.Errorf("%s: empty stream read %d bytes, %v", tc.name, len(out), err)
+		}
+
+		empty, err := tc.pack(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := tc.unpack(empty, 0); err != nil || out == nil || len(out) != 0 {
+			t.Errorf("%s: uncompressed empty data to %v, %v", tc.name, out, err)
+		}
+		// a truncated empty stream is still corrupt
+		if _, err := tc.unpack(empty[:len(empty)-1], 0); !errors.Is(err, fi.ErrZLib) {
+			t.Errorf("%s: truncated empty data: %v, want ErrZLib", tc.name, err)
+		}
+	}
+}
+
+func TestCRC32(t *testing.T) {
+	fitest.NewStub(t)
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	if got, want := fi.CRC32(data), crc32.ChecksumIEEE(data); got != want {
+		t.Errorf("CRC32 = %08x, want %08x", got, want)
+	}
+
+	h, want := fi.NewCRC32(), crc32.NewIEEE()
+	for _, chunk := range [][]byte{nil, data[:1], data[1:10], zlibData} {
+		h.Write(chunk)
+		want.Write(chunk)
+		if h.Sum32() != want.Sum32() || !bytes.Equal(h.Sum([]byte{9}), want.Sum([]byte{9})) {
+			t.Errorf("after %d bytes: %08x, want %08x", len(chunk), h.Sum32(), want.Sum32())
+		}
+	}
+	h.Reset()
+	if h.Sum32() != 0 || h.Size() != 4 || h.BlockSize() != 1 {
+		t.Errorf("reset to %08x, size %d, block size %d", h.Sum32(), h.Size(), h.BlockSize())
+	}
+}
@@ -0,0 +1,561 @@
+// Package transform parses compact, URL friendly transformation specs
+// (imgproxy/thumbor style) and maps them onto freeimage operations.
+//
+// A spec is a list of "/" separated options, each option is a name followed
+// by ":" separated arguments. Everything after the last option is the source.
+//
+// e.g.,
+//
+// /rs:fill:300:200/q:80/f:webp/images/cat.jpg
+//
+// options:
+//
+//	rs:<fit|fill|force>:<width>:<height>   resize (width or height may be 0 for fit)
+//	c:<left>:<top>:<width>:<height>        crop, applied before resizing
+//	rot:<degrees>                          rotate counter clockwise
+//	fl:<h|v|hv>                            flip
+//	br:<percentage>                        brightness, -100..100
+//	co:<percentage>                        contrast, -100..100
+//	g:<gamma>                              gamma, > 0
+//	tm:<drago|reinhard|fattal>[:p1[:p2]]   tone mapping
+//	fi:<box|bicubic|bilinear|bspline|catmullrom|lanczos3>  resize filter
+//	q:<1-100>                              output quality
+//	f:<format>                             output format
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+var (
+	ErrInvalid  = errors.New("transform: invalid spec")
+	ErrTooLarge = errors.New("transform: output dimensions exceed limits")
+)
+
+type ResizeMode string
+
+const (
+	ResizeFit   ResizeMode = "fit"
+	ResizeFill  ResizeMode = "fill"
+	ResizeForce ResizeMode = "force"
+)
+
+type Resize struct {
+	Mode   ResizeMode
+	Width  int32
+	Height int32
+}
+
+type Crop struct {
+	Left, Top, Width, Height int32
+}
+
+type ToneMap struct {
+	TMO         freeimage.FREE_IMAGE_TMO
+	FirstParam  float64
+	SecondParam float64
+}
+
+// Spec is a parsed transformation spec. Zero values mean "not requested".
+type Spec struct {
+	Resize     *Resize
+	Crop       *Crop
+	Rotate     float64
+	FlipH      bool
+	FlipV      bool
+	Brightness float64
+	Contrast   float64
+	Gamma      float64
+	ToneMap    *ToneMap
+	Filter     freeimage.FREE_IMAGE_FILTER
+	Quality    int
+	Format     freeimage.FREE_IMAGE_FORMAT
+}
+
+// Limits bounds the output of a spec, zero fields are unlimited.
+type Limits struct {
+	MaxWidth  int32
+	MaxHeight int32
+	MaxPixels int64
+}
+
+var DefaultLimits = Limits{MaxWidth: 8192, MaxHeight: 8192, MaxPixels: 40000000}
+
+var formatNames = map[string]freeimage.FREE_IMAGE_FORMAT{
+	"bmp":  freeimage.FIF_BMP,
+	"gif":  freeimage.FIF_GIF,
+	"jpeg": freeimage.FIF_JPEG,
+	"jpg":  freeimage.FIF_JPEG,
+	"jxr":  freeimage.FIF_JXR,
+	"j2k":  freeimage.FIF_J2K,
+	"jp2":  freeimage.FIF_JP2,
+	"png":  freeimage.FIF_PNG,
+	"tif":  freeimage.FIF_TIFF,
+	"tiff": freeimage.FIF_TIFF,
+	"webp": freeimage.FIF_WEBP,
+	"exr":  freeimage.FIF_EXR,
+	"hdr":  freeimage.FIF_HDR,
+	"tga":  freeimage.FIF_TARGA,
+}
+
+var filterNames = []string{
+	freeimage.FILTER_BOX:        "box",
+	freeimage.FILTER_BICUBIC:    "bicubic",
+	freeimage.FILTER_BILINEAR:   "bilinear",
+	freeimage.FILTER_BSPLINE:    "bspline",
+	freeimage.FILTER_CATMULLROM: "catmullrom",
+	freeimage.FILTER_LANCZOS3:   "lanczos3",
+}
+
+var tmoNames = []string{
+	freeimage.FITMO_DRAGO03:    "drago",
+	freeimage.FITMO_REINHARD05: "reinhard",
+	freeimage.FITMO_FATTAL02:   "fattal",
+}
+
+// FormatName returns the canonical spec name of fif, or "" if the format
+// can't be requested through a spec.
+func FormatName(fif freeimage.FREE_IMAGE_FORMAT) string {
+	switch fif {
+	case freeimage.FIF_JPEG:
+		return "jpeg"
+	case freeimage.FIF_TIFF:
+		return "tiff"
+	}
+	for name, f := range formatNames {
+		if f == fif {
+			return name
+		}
+	}
+	return ""
+}
+
+// ParseFormat returns the format for a spec format name.
+func ParseFormat(name string) (freeimage.FREE_IMAGE_FORMAT, bool) {
+	fif, ok := formatNames[strings.ToLower(name)]
+	return fif, ok
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// Parse parses path into a spec and the remaining source path. The spec is
+// validated against limits.
+func Parse(path string, limits Limits) (spec *Spec, source string, err error) {
+	spec = &Spec{Filter: freeimage.FILTER_CATMULLROM, Format: freeimage.FIF_UNKNOWN}
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	i := 0
+	seen := map[string]bool{}
+	for ; i < len(segs); i++ {
+		args := strings.Split(segs[i], ":")
+		name, ok := canonicalOption(args[0])
+		if !ok || len(args) < 2 {
+			break
+		}
+		if seen[name] {
+			return nil, "", invalid("duplicate option %q", name)
+		}
+		seen[name] = true
+		if err := spec.set(name, args[1:]); err != nil {
+			return nil, "", err
+		}
+	}
+	source = strings.Join(segs[i:], "/")
+
+	if err := spec.Validate(limits); err != nil {
+		return nil, "", err
+	}
+	return spec, source, nil
+}
+
+func canonicalOption(name string) (string, bool) {
+	switch name {
+	case "rs", "resize":
+		return "rs", true
+	case "c", "crop":
+		return "c", true
+	case "rot", "rotate":
+		return "rot", true
+	case "fl", "flip":
+		return "fl", true
+	case "br", "brightness":
+		return "br", true
+	case "co", "contrast":
+		return "co", true
+	case "g", "gamma":
+		return "g", true
+	case "tm", "tonemap":
+		return "tm", true
+	case "fi", "filter":
+		return "fi", true
+	case "q", "quality":
+		return "q", true
+	case "f", "format":
+		return "f", true
+	}
+	return "", false
+}
+
+func parseInt32(s string) (int32, error) {
+	v, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, invalid("bad integer %q", s)
+	}
+	return int32(v), nil
+}
+
+func parseFloat(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, invalid("bad number %q", s)
+	}
+	return v, nil
+}
+
+func (s *Spec) set(name string, args []string) (err error) {
+	switch name {
+	case "rs":
+		if len(args) != 3 {
+			return invalid("rs takes 3 arguments")
+		}
+		r := &Resize{Mode: ResizeMode(args[0])}
+		if r.Mode != ResizeFit && r.Mode != ResizeFill && r.Mode != ResizeForce {
+			return invalid("unknown resize mode %q", args[0])
+		}
+		if r.Width, err = parseInt32(args[1]); err != nil {
+			return err
+		}
+		if r.Height, err = parseInt32(args[2]); err != nil {
+			return err
+		}
+		s.Resize = r
+	case "c":
+		if len(args) != 4 {
+			return invalid("c takes 4 arguments")
+		}
+		c := &Crop{}
+		for i, p := range []*int32{&c.Left, &c.Top, &c.Width, &c.Height} {
+			if *p, err = parseInt32(args[i]); err != nil {
+				return err
+			}
+		}
+		s.Crop = c
+	case "rot":
+		if len(args) != 1 {
+			return invalid("rot takes 1 argument")
+		}
+		if s.Rotate, err = parseFloat(args[0]); err != nil {
+			return err
+		}
+		s.Rotate = math.Mod(s.Rotate, 360)
+		if s.Rotate < 0 {
+			s.Rotate += 360
+		}
+	case "fl":
+		if len(args) != 1 || args[0] == "" || strings.Trim(args[0], "hv") != "" {
+			return invalid("fl takes h, v or hv")
+		}
+		s.FlipH = strings.Contains(args[0], "h")
+		s.FlipV = strings.Contains(args[0], "v")
+	case "br", "co", "g":
+		if len(args) != 1 {
+			return invalid("%s takes 1 argument", name)
+		}
+		v, err := parseFloat(args[0])
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "br":
+			s.Brightness = v
+		case "co":
+			s.Contrast = v
+		case "g":
+			if v <= 0 {
+				return invalid("gamma must be positive")
+			}
+			s.Gamma = v
+		}
+	case "tm":
+		if len(args) > 3 {
+			return invalid("tm takes at most 3 arguments")
+		}
+		tm := &ToneMap{TMO: -1}
+		for i, n := range tmoNames {
+			if n == args[0] {
+				tm.TMO = freeimage.FREE_IMAGE_TMO(i)
+			}
+		}
+		if tm.TMO < 0 {
+			return invalid("unknown tone mapping operator %q", args[0])
+		}
+		if len(args) > 1 {
+			if tm.FirstParam, err = parseFloat(args[1]); err != nil {
+				return err
+			}
+		}
+		if len(args) > 2 {
+			if tm.SecondParam, err = parseFloat(args[2]); err != nil {
+				return err
+			}
+		}
+		s.ToneMap = tm
+	case "fi":
+		if len(args) != 1 {
+			return invalid("fi takes 1 argument")
+		}
+		s.Filter = -1
+		for i, n := range filterNames {
+			if n == args[0] {
+				s.Filter = freeimage.FREE_IMAGE_FILTER(i)
+			}
+		}
+		if s.Filter < 0 {
+			return invalid("unknown filter %q", args[0])
+		}
+	case "q":
+		if len(args) != 1 {
+			return invalid("q takes 1 argument")
+		}
+		q, err := strconv.Atoi(args[0])
+		if err != nil {
+			return invalid("bad quality %q", args[0])
+		}
+		if q < 1 || q > 100 {
+			return invalid("quality out of range")
+		}
+		s.Quality = q
+	case "f":
+		if len(args) != 1 {
+			return invalid("f takes 1 argument")
+		}
+		fif, ok := ParseFormat(args[0])
+		if !ok {
+			return invalid("unknown format %q", args[0])
+		}
+		s.Format = fif
+	}
+	return nil
+}
+
+// Validate checks argument ranges and the requested output size against
+// limits. Zero values mean "not requested", so g:0 and q:0 are rejected by
+// Parse. The final size of a fit resize depends on the source and is
+// checked again by Pipeline.
+func (s *Spec) Validate(limits Limits) error {
+	if r := s.Resize; r != nil {
+		if r.Width < 0 || r.Height < 0 {
+			return invalid("negative resize dimension")
+		}
+		if r.Width == 0 && r.Height == 0 {
+			return invalid("resize needs a width or a height")
+		}
+		if r.Mode != ResizeFit && (r.Width == 0 || r.Height == 0) {
+			return invalid("%s resize needs both width and height", r.Mode)
+		}
+		if err := limits.check(r.Width, r.Height); err != nil {
+			return err
+		}
+	}
+	if c := s.Crop; c != nil {
+		if c.Left < 0 || c.Top < 0 || c.Width <= 0 || c.Height <= 0 ||
+			int64(c.Left)+int64(c.Width) > math.MaxInt32 || int64(c.Top)+int64(c.Height) > math.MaxInt32 {
+			return invalid("bad crop rectangle")
+		}
+		if err := limits.check(c.Width, c.Height); err != nil {
+			return err
+		}
+	}
+	if s.Brightness < -100 || s.Brightness > 100 {
+		return invalid("brightness out of range")
+	}
+	if s.Contrast < -100 || s.Contrast > 100 {
+		return invalid("contrast out of range")
+	}
+	if s.Gamma < 0 {
+		return invalid("gamma must be positive")
+	}
+	if s.Quality < 0 || s.Quality > 100 {
+		return invalid("quality out of range")
+	}
+	return nil
+}
+
+func (l Limits) check(w, h int32) error {
+	if (l.MaxWidth > 0 && w > l.MaxWidth) || (l.MaxHeight > 0 && h > l.MaxHeight) ||
+		(l.MaxPixels > 0 && int64(w)*int64(h) > l.MaxPixels) {
+		return fmt.Errorf("%w: %dx%d", ErrTooLarge, w, h)
+	}
+	return nil
+}
+
+func formatFloat(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+// String returns the canonical form of the spec: options in a fixed order,
+// long names shortened, defaults omitted. Equal specs give equal strings, so
+// the result can be used in cache keys.
+func (s *Spec) String() string {
+	var opts []string
+	if r := s.Resize; r != nil {
+		opts = append(opts, fmt.Sprintf("rs:%s:%d:%d", r.Mode, r.Width, r.Height))
+	}
+	if c := s.Crop; c != nil {
+		opts = append(opts, fmt.Sprintf("c:%d:%d:%d:%d", c.Left, c.Top, c.Width, c.Height))
+	}
+	if s.Rotate != 0 {
+		opts = append(opts, "rot:"+formatFloat(s.Rotate))
+	}
+	if s.FlipH || s.FlipV {
+		fl := "fl:"
+		if s.FlipH {
+			fl += "h"
+		}
+		if s.FlipV {
+			fl += "v"
+		}
+		opts = append(opts, fl)
+	}
+	if s.Brightness != 0 {
+		opts = append(opts, "br:"+formatFloat(s.Brightness))
+	}
+	if s.Contrast != 0 {
+		opts = append(opts, "co:"+formatFloat(s.Contrast))
+	}
+	if s.Gamma != 0 && s.Gamma != 1 {
+		opts = append(opts, "g:"+formatFloat(s.Gamma))
+	}
+	if tm := s.ToneMap; tm != nil {
+		opts = append(opts, fmt.Sprintf("tm:%s:%s:%s", tmoNames[tm.TMO], formatFloat(tm.FirstParam), formatFloat(tm.SecondParam)))
+	}
+	if s.Resize != nil && s.Filter != freeimage.FILTER_CATMULLROM {
+		opts = append(opts, "fi:"+filterNames[s.Filter])
+	}
+	if s.Quality != 0 {
+		opts = append(opts, "q:"+strconv.Itoa(s.Quality))
+	}
+	if s.Format != freeimage.FIF_UNKNOWN {
+		opts = append(opts, "f:"+FormatName(s.Format))
+	}
+	return "/" + strings.Join(opts, "/")
+}
+
+// OutputSize returns the size of the image produced from a width x height
+// source.
+func (s *Spec) OutputSize(width, height int32) (w, h int32) {
+	w, h = width, height
+	if c := s.Crop; c != nil {
+		w, h = c.Width, c.Height
+	}
+	if r := s.Resize; r != nil {
+		switch r.Mode {
+		case ResizeForce, ResizeFill:
+			w, h = r.Width, r.Height
+		case ResizeFit:
+			w, h = fitSize(w, h, r.Width, r.Height)
+		}
+	}
+	if a := math.Mod(s.Rotate, 180); a != 0 {
+		if a == 90 {
+			w, h = h, w
+		} else {
+			rad := s.Rotate * math.Pi / 180
+			sin, cos := math.Abs(math.Sin(rad)), math.Abs(math.Cos(rad))
+			w, h = int32(math.Ceil(float64(w)*cos+float64(h)*sin)), int32(math.Ceil(float64(w)*sin+float64(h)*cos))
+		}
+	}
+	return
+}
+
+func fitSize(w, h, maxW, maxH int32) (int32, int32) {
+	scale := math.Inf(1)
+	if maxW > 0 {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 {
+		scale = math.Min(scale, float64(maxH)/float64(h))
+	}
+	return int32(math.Max(1, math.Round(float64(w)*scale))), int32(math.Max(1, math.Round(float64(h)*scale)))
+}
+
+// Pipeline builds the freeimage pipeline for a width x height source.
+func (s *Spec) Pipeline(width, height int32, limits Limits) (*freeimage.Pipeline, error) {
+	if width <= 0 || height <= 0 {
+		return nil, invalid("bad source size %dx%d", width, height)
+	}
+	if w, h := s.OutputSize(width, height); limits.check(w, h) != nil {
+		return nil, limits.check(w, h)
+	}
+
+	p := freeimage.NewPipeline()
+	if tm := s.ToneMap; tm != nil {
+		p.Then(&freeimage.ToneMappingOp{TMO: tm.TMO, FirstParam: tm.FirstParam, SecondParam: tm.SecondParam})
+	}
+
+	w, h := width, height
+	if c := s.Crop; c != nil {
+		if int64(c.Left)+int64(c.Width) > int64(width) || int64(c.Top)+int64(c.Height) > int64(height) {
+			return nil, invalid("crop rectangle outside of the %dx%d source", width, height)
+		}
+		p.Then(&freeimage.CopyOp{Left: c.Left, Top: c.Top, Right: c.Left + c.Width, Bottom: c.Top + c.Height})
+		w, h = c.Width, c.Height
+	}
+
+	if r := s.Resize; r != nil {
+		switch r.Mode {
+		case ResizeForce:
+			p.Then(&freeimage.RescaleOp{Width: r.Width, Height: r.Height, Filter: s.Filter})
+		case ResizeFit:
+			fw, fh := fitSize(w, h, r.Width, r.Height)
+			p.Then(&freeimage.RescaleOp{Width: fw, Height: fh, Filter: s.Filter})
+		case ResizeFill:
+			scale := math.Max(float64(r.Width)/float64(w), float64(r.Height)/float64(h))
+			sw := int32(math.Max(float64(r.Width), math.Round(float64(w)*scale)))
+			sh := int32(math.Max(float64(r.Height), math.Round(float64(h)*scale)))
+			p.Then(&freeimage.RescaleOp{Width: sw, Height: sh, Filter: s.Filter})
+			if sw != r.Width || sh != r.Height {
+				left, top := (sw-r.Width)/2, (sh-r.Height)/2
+				p.Then(&freeimage.CopyOp{Left: left, Top: top, Right: left + r.Width, Bottom: top + r.Height})
+			}
+		}
+	}
+
+	if s.Rotate != 0 {
+		p.Then(&freeimage.RotateOp{Angle: s.Rotate})
+	}
+	if s.FlipH {
+		p.Then(&freeimage.FlipHorizontalOp{})
+	}
+	if s.FlipV {
+		p.Then(&freeimage.FlipVerticalOp{})
+	}
+	if s.Brightness != 0 {
+		p.Then(&freeimage.AdjustBrightnessOp{Percentage: s.Brightness})
+	}
+	if s.Contrast != 0 {
+		p.Then(&freeimage.AdjustContrastOp{Percentage: s.Contrast})
+	}
+	if s.Gamma != 0 && s.Gamma != 1 {
+		p.Then(&freeimage.AdjustGammaOp{Gamma: s.Gamma})
+	}
+	return p, nil
+}
+
+// SaveFlags returns the SaveToMemory flags for the output format and quality.
+func (s *Spec) SaveFlags(fif freeimage.FREE_IMAGE_FORMAT) int32 {
+	if s.Quality == 0 {
+		return 0
+	}
+	switch fif {
+	case freeimage.FIF_JPEG, freeimage.FIF_WEBP, freeimage.FIF_JXR:
+		return int32(s.Quality)
+	}
+	return 0
+}
@@ -0,0 +1,223 @@
+package transform
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/jinzhongmin/gofreeimage/pkg/freeimage"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		path   string
+		spec   Spec
+		source string
+	}{
+		{"/images/cat.jpg", Spec{}, "images/cat.jpg"},
+		{"/rs:fill:300:200/q:80/f:webp/images/cat.jpg", Spec{
+			Resize:  &Resize{ResizeFill, 300, 200},
+			Quality: 80,
+			Format:  freeimage.FIF_WEBP,
+		}, "images/cat.jpg"},
+		{"/c:10:20:30:40/rot:-90/fl:hv/x.png", Spec{
+			Crop:   &Crop{10, 20, 30, 40},
+			Rotate: 270,
+			FlipH:  true,
+			FlipV:  true,
+		}, "x.png"},
+		{"/br:-10/co:25.5/g:2.2/tm:reinhard:0.5:1/x.hdr", Spec{
+			Brightness: -10,
+			Contrast:   25.5,
+			Gamma:      2.2,
+			ToneMap:    &ToneMap{freeimage.FITMO_REINHARD05, 0.5, 1},
+		}, "x.hdr"},
+		{"/rs:fit:100:0/fi:lanczos3/rot:720/x", Spec{
+			Resize: &Resize{ResizeFit, 100, 0},
+			Filter: freeimage.FILTER_LANCZOS3,
+		}, "x"},
+		// the source starts at the first segment that isn't an option
+		{"/q:80/dir/f:png", Spec{Quality: 80}, "dir/f:png"},
+		{"/f/x", Spec{}, "f/x"},
+	} {
+		spec, source, err := Parse(tc.path, DefaultLimits)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		want := tc.spec
+		if want.Filter == 0 {
+			want.Filter = freeimage.FILTER_CATMULLROM
+		}
+		if want.Format == 0 {
+			want.Format = freeimage.FIF_UNKNOWN
+		}
+		if !reflect.DeepEqual(*spec, want) || source != tc.source {
+			t.Errorf("%s: got %+v %q, want %+v %q", tc.path, *spec, source, want, tc.source)
+		}
+	}
+}
+
+func TestParseAliases(t *testing.T) {
+	for long, short := range map[string]string{
+		"/resize:fit:10:10/x":         "/rs:fit:10:10/x",
+		"/crop:0:0:5:5/x":             "/c:0:0:5:5/x",
+		"/rotate:90/flip:h/x":         "/rot:90/fl:h/x",
+		"/brightness:5/contrast:6/x":  "/br:5/co:6/x",
+		"/gamma:1.5/tonemap:drago/x":  "/g:1.5/tm:drago/x",
+		"/rs:fit:5:5/filter:box/x":    "/rs:fit:5:5/fi:box/x",
+		"/quality:90/format:jpg/x":    "/q:90/f:jpeg/x",
+		"/format:TIF/x":               "/f:tiff/x",
+		"/rs:force:1:1/rot:450/q:1/x": "/q:1/rot:90/rs:force:1:1/x",
+		"/g:1/fi:catmullrom/br:0/x":   "/x",
+		"/fi:lanczos3/x":              "/x",
+		"/tonemap:fattal:0.5/fl:vh/x": "/tm:fattal:0.5:0/fl:hv/x",
+		"/rs:fill:10:10/fi:bicubic/x": "/fi:bicubic/rs:fill:10:10/x",
+	} {
+		a, _, err := Parse(long, DefaultLimits)
+		if err != nil {
+			t.Fatalf("%s: %v", long, err)
+		}
+		b, _, err := Parse(short, DefaultLimits)
+		if err != nil {
+			t.Fatalf("%s: %v", short, err)
+		}
+		if a.String() != b.String() {
+			t.Errorf("%s is %s, %s is %s", long, a, short, b)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, path := range []string{
+		"/",
+		"/rs:fill:300:200/q:80/f:webp",
+		"/rs:fit:100:0/c:1:2:3:4/rot:45.5/fl:v/br:-100/co:100/g:0.5/tm:drago:1:2/fi:box/q:100/f:png",
+		"/tm:fattal:0:0/f:jpeg",
+	} {
+		spec, _, err := Parse(path+"/x", DefaultLimits)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		s := spec.String()
+		if s != path {
+			t.Errorf("String() of %s = %s", path, s)
+		}
+		again, _, err := Parse(s+"/x", DefaultLimits)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if !reflect.DeepEqual(again, spec) {
+			t.Errorf("%s parsed to %+v, want %+v", s, again, spec)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	maxInt := strconv.Itoa(math.MaxInt32)
+	for _, path := range []string{
+		"/rs:fit:100/x",
+		"/rs:zoom:1:1/x",
+		"/rs:fit:0:0/x",
+		"/rs:fill:100:0/x",
+		"/rs:fit:-1:10/x",
+		"/rs:fit:a:10/x",
+		"/rs:fit:99999999999:10/x",
+		"/c:0:0:0:10/x",
+		"/c:-1:0:10:10/x",
+		"/c:" + maxInt + ":0:10:10/x",
+		"/c:0:" + maxInt + ":10:10/x",
+		"/rot:NaN/x",
+		"/rot:Inf/x",
+		"/fl:x/x",
+		"/fl:/x",
+		"/br:101/x",
+		"/co:-101/x",
+		"/g:0/x",
+		"/g:-1/x",
+		"/tm:aces/x",
+		"/tm:drago:1:2:3/x",
+		"/fi:nearest/x",
+		"/q:0/x",
+		"/q:101/x",
+		"/q:high/x",
+		"/f:psd/x",
+		"/q:80/quality:90/x",
+		"/rs:fit:10:10/resize:fit:20:20/x",
+	} {
+		if spec, _, err := Parse(path, DefaultLimits); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %+v, %v, want ErrInvalid", path, spec, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, path := range []string{
+		"/rs:force:8193:1/x",
+		"/rs:fit:0:8193/x",
+		"/rs:force:8000:8000/x",
+		"/c:0:0:10000:1/x",
+	} {
+		if _, _, err := Parse(path, DefaultLimits); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: %v, want ErrTooLarge", path, err)
+		}
+	}
+	if _, _, err := Parse("/rs:force:8000:8000/x", Limits{}); err != nil {
+		t.Errorf("unlimited: %v", err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	spec, _, err := Parse("/c:10:0:200:100/rs:fill:50:40/rot:90/fl:h/g:2/x", DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := spec.OutputSize(1000, 1000); w != 40 || h != 50 {
+		t.Errorf("OutputSize = %dx%d, want 40x50", w, h)
+	}
+	p, err := spec.Pipeline(1000, 1000, DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, op := range p.Ops {
+		kinds = append(kinds, op.Kind())
+	}
+	if want := []string{"copy", "rescale", "copy", "rotate", "flip_horizontal", "gamma"}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("ops %v, want %v", kinds, want)
+	}
+	if op := p.Ops[1].(*freeimage.RescaleOp); op.Width != 80 || op.Height != 40 {
+		t.Errorf("fill rescales to %dx%d, want 80x40", op.Width, op.Height)
+	}
+
+	// the crop must fit the source, without overflowing
+	spec, _, err = Parse("/c:2147483600:0:40:10/x", DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spec.Pipeline(100, 100, DefaultLimits); !errors.Is(err, ErrInvalid) {
+		t.Errorf("crop outside the source: %v", err)
+	}
+	if _, err := spec.Pipeline(0, 100, DefaultLimits); !errors.Is(err, ErrInvalid) {
+		t.Errorf("empty source: %v", err)
+	}
+	spec, _, _ = Parse("/rs:fit:8000:0/x", DefaultLimits)
+	if _, err := spec.Pipeline(10, 100, DefaultLimits); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("fit over the limits: %v", err)
+	}
+}
+
+func TestSaveFlags(t *testing.T) {
+	spec, _, _ := Parse("/q:75/x", DefaultLimits)
+	for fif, want := range map[freeimage.FREE_IMAGE_FORMAT]int32{
+		freeimage.FIF_JPEG: 75,
+		freeimage.FIF_WEBP: 75,
+		freeimage.FIF_PNG:  0,
+	} {
+		if got := spec.SaveFlags(fif); got != want {
+			t.Errorf("SaveFlags(%d) = %d, want %d", fif, got, want)
+		}
+	}
+}